@@ -0,0 +1,35 @@
+package castore
+
+import (
+	"os"
+)
+
+// acquirePut waits until another Put may proceed, if MaxConcurrentPuts is set,
+// and returns a function that must be called once it has finished.
+func (s *CAStore) acquirePut() func() {
+	if s.puts == nil {
+		return func() {}
+	}
+	s.puts <- struct{}{}
+	return func() { <-s.puts }
+}
+
+// pacedWriter writes to a file, waiting for the data to reach the disk every
+// time limit bytes have been written.  Since the writer is not returned to
+// until then, reads from the source of the data are slowed to the speed of
+// the disk.
+type pacedWriter struct {
+	f        *os.File
+	limit    int64
+	unsynced int64
+}
+
+func (w *pacedWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.unsynced += int64(n)
+	if err == nil && w.unsynced >= w.limit {
+		err = w.f.Sync()
+		w.unsynced = 0
+	}
+	return n, err
+}
@@ -0,0 +1,85 @@
+package castore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingReader records whether it has been read from.
+type countingReader struct {
+	r    io.Reader
+	read int32
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&r.read, 1)
+	return r.r.Read(p)
+}
+
+func TestMaxConcurrentPuts(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-backpressure"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:          tdir,
+		MaxConcurrentPuts: 1,
+	})
+	assert.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	first := make(chan error)
+	go func() {
+		_, err := s.Put(pr)
+		first <- err
+	}()
+
+	// Wait for the first Put to start reading.
+	_, err = pw.Write([]byte("foo"))
+	assert.NoError(t, err)
+
+	second := &countingReader{r: strings.NewReader(TEST_VALUE)}
+	done := make(chan string)
+	go func() {
+		key, _ := s.Put(second)
+		done <- key
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&second.read), "second Put should not read yet")
+
+	_, err = pw.Write([]byte("bar"))
+	assert.NoError(t, err)
+	assert.NoError(t, pw.Close())
+	assert.NoError(t, <-first)
+	assert.Equal(t, TEST_KEY, <-done)
+}
+
+func TestMaxUnsynced(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-backpressure"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:    tdir,
+		MaxUnsynced: 4096,
+	})
+	assert.NoError(t, err)
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	key, err := s.PutBytes(data)
+	assert.NoError(t, err)
+
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -0,0 +1,156 @@
+package castore
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// PutAll will insert the data from each of the given readers into the store,
+// as with Put, using a pool of goroutines (one per CPU) so that many small
+// objects can be ingested at once.  It returns the key of each input, in the
+// same order, along with the error, if any, from storing it; the key of an
+// input that could not be stored is empty.  Different readers are read from
+// concurrently, so they must not share any state.
+//
+// The number of Puts in progress at once is still subject to
+// MaxConcurrentPuts.
+func (s *CAStore) PutAll(rs []io.Reader) ([]string, []error) {
+	keys := make([]string, len(rs))
+	errs := make([]error, len(rs))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(rs) {
+		workers = len(rs)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				keys[i], errs[i] = s.Put(rs[i])
+			}
+		}()
+	}
+	for i := range rs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return keys, errs
+}
+
+// MultiGetter is implemented by Stores that can retrieve several objects more
+// efficiently than by calling Get for each of them, such as clients for
+// remote stores that can fetch them all in a single round trip.
+type MultiGetter interface {
+	// GetMulti returns a reader for each of the given keys, in the same
+	// order, along with the error, if any, from retrieving it.  As with Get,
+	// the reader for a key that does not exist is nil.
+	GetMulti(keys []string) ([]io.ReadCloser, []error)
+}
+
+var _ MultiGetter = (*CAStore)(nil)
+
+// GetMulti will retrieve the objects with the given keys from s, using its
+// GetMulti method if it is a MultiGetter, and calling Get for each key
+// otherwise.  The caller must close every non-nil reader.
+func GetMulti(s Store, keys []string) ([]io.ReadCloser, []error) {
+	if mg, ok := s.(MultiGetter); ok {
+		return mg.GetMulti(keys)
+	}
+	return getEach(s, keys)
+}
+
+// GetMulti will return a reader for the data stored with each of the given
+// keys, in the same order, along with the error, if any, from retrieving it,
+// as with Get.  The caller must close every non-nil reader.
+//
+// Each object is only opened when its reader is first read from, and closed
+// again once all of its data has been read, so that asking for more keys
+// than MaxOpenFiles allows doesn't wait forever for files to be closed.  If
+// an object is deleted in the meantime, reading it fails with an error
+// satisfying os.IsNotExist.
+func (s *CAStore) GetMulti(keys []string) ([]io.ReadCloser, []error) {
+	rs := make([]io.ReadCloser, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		size, err := s.Size(key)
+		if err == nil && size < 0 && s.seed != nil {
+			// Get fetches missing objects from the seed.
+			var r io.ReadCloser
+			if r, err = s.Get(key); r != nil {
+				err = r.Close()
+				size = 0
+			}
+		}
+		if err != nil {
+			errs[i] = err
+		} else if size >= 0 {
+			rs[i] = &lazyReader{s: s, key: key}
+		}
+	}
+	return rs, errs
+}
+
+// lazyReader is a reader for an object that is only opened when it is first
+// read from.
+type lazyReader struct {
+	s   *CAStore
+	key string
+
+	r    io.ReadCloser
+	err  error
+	done bool
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	if l.r == nil {
+		if l.done {
+			return 0, io.EOF
+		}
+		if l.r, l.err = l.s.Get(l.key); l.err == nil && l.r == nil {
+			l.err = &os.PathError{Op: "open", Path: l.key, Err: os.ErrNotExist}
+		}
+		if l.err != nil {
+			return 0, l.err
+		}
+	}
+
+	n, err := l.r.Read(p)
+	if err == io.EOF {
+		// Give back the file as soon as possible.
+		if cerr := l.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (l *lazyReader) Close() error {
+	l.done = true
+	if l.r == nil {
+		return nil
+	}
+	err := l.r.Close()
+	l.r = nil
+	return err
+}
+
+// getEach implements GetMulti by calling Get for each key in turn.
+func getEach(s Store, keys []string) ([]io.ReadCloser, []error) {
+	rs := make([]io.ReadCloser, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		rs[i], errs[i] = s.Get(key)
+	}
+	return rs, errs
+}
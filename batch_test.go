@@ -0,0 +1,104 @@
+package castore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutAll(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-batch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, MaxSize: 16})
+	assert.NoError(t, err)
+
+	var rs []io.Reader
+	for i := 0; i < 100; i++ {
+		rs = append(rs, strings.NewReader(fmt.Sprintf("value %d", i)))
+	}
+	rs[0] = strings.NewReader(TEST_VALUE)
+	rs[50] = strings.NewReader("this is too large for the store")
+
+	keys, errs := s.PutAll(rs)
+	assert.Len(t, keys, 100)
+	assert.Len(t, errs, 100)
+	assert.Equal(t, TEST_KEY, keys[0])
+	assert.Equal(t, "", keys[50])
+	assert.Equal(t, ErrSizeExceeded, errs[50])
+	for i, key := range keys {
+		if i == 50 {
+			continue
+		}
+		assert.NoError(t, errs[i])
+		exists, err := s.Exists(key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	keys, errs = s.PutAll(nil)
+	assert.Empty(t, keys)
+	assert.Empty(t, errs)
+}
+
+func TestGetMulti(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-batch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	// Without its GetMulti method, Get is called for each key instead.
+	for _, store := range []Store{s, struct{ Store }{s}} {
+		rs, errs := GetMulti(store, []string{missing, TEST_KEY})
+		assert.Equal(t, []error{nil, nil}, errs)
+		assert.Nil(t, rs[0])
+		data, err := ioutil.ReadAll(rs[1])
+		rs[1].Close()
+		assert.NoError(t, err)
+		assert.Equal(t, TEST_VALUE, string(data))
+	}
+}
+
+func TestGetMultiOpenFiles(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-batch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, MaxOpenFiles: 2})
+	assert.NoError(t, err)
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key, err := s.PutString(fmt.Sprintf("value-%d", i))
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+
+	// More objects than can be open at once are still returned, and each
+	// can be read in turn while the others are still unclosed.
+	rs, errs := s.GetMulti(keys)
+	for i, r := range rs {
+		assert.NoError(t, errs[i])
+		data, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(data))
+	}
+	for _, r := range rs {
+		assert.NoError(t, r.Close())
+	}
+
+	// Objects deleted before they are read report an error.
+	rs, errs = s.GetMulti(keys[:1])
+	assert.NoError(t, errs[0])
+	assert.NoError(t, s.Delete(keys[0]))
+	_, err = ioutil.ReadAll(rs[0])
+	assert.True(t, os.IsNotExist(err))
+	rs[0].Close()
+}
@@ -0,0 +1,128 @@
+package castore
+
+import (
+	"hash"
+	"math/bits"
+	"runtime"
+	"sync"
+
+	"lukechampine.com/blake3/guts"
+)
+
+const (
+	// blake3BufSize is the amount of data compressed into a single subtree at
+	// a time.
+	blake3BufSize = guts.MaxSIMD * guts.ChunkSize
+
+	// blake3Batch is the number of subtrees that are buffered before being
+	// compressed in parallel.  Inputs smaller than this are hashed on a
+	// single core.
+	blake3Batch = 64
+)
+
+// NewBLAKE3 returns a new hash.Hash computing the 256-bit BLAKE3 checksum,
+// for use as Options.Hash.  BLAKE3 is several times faster than SHA-256, and
+// inputs larger than a megabyte are hashed using all available cores.
+//
+// Keys produced with the default HexEncoding do not identify the hash function
+// that produced them; stores that need to tell keys from different hash
+// functions apart should use MultihashEncoding or CIDEncoding with
+// MultihashBLAKE3.
+func NewBLAKE3() hash.Hash {
+	return &blake3Hasher{}
+}
+
+// blake3Hasher is an unkeyed BLAKE3 hasher that compresses large inputs in
+// parallel.  It builds the same tree as lukechampine.com/blake3, but since
+// each subtree at the bottom of the tree can be compressed independently, it
+// does so for a batch of subtrees at once.
+type blake3Hasher struct {
+	// stack holds at most one subtree root per height, and counter is the
+	// number of subtrees compressed so far, whose bits indicate which stack
+	// entries are occupied.
+	stack   [64 - (guts.MaxSIMD + 10)][8]uint32
+	counter uint64
+
+	// pending is data that has not yet been compressed.  The final subtree
+	// must be compressed as the root, so it is never compressed until more
+	// data arrives.
+	pending []byte
+}
+
+func (h *blake3Hasher) Write(p []byte) (int, error) {
+	h.pending = append(h.pending, p...)
+	if len(h.pending) > blake3Batch*blake3BufSize {
+		h.flush()
+	}
+	return len(p), nil
+}
+
+// flush compresses all but the last subtree of pending data.
+func (h *blake3Hasher) flush() {
+	n := (len(h.pending) - 1) / blake3BufSize
+	if n <= 0 {
+		return
+	}
+
+	cvs := make([][8]uint32, n)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < n; i += workers {
+				buf := (*[blake3BufSize]byte)(h.pending[i*blake3BufSize:])
+				node := guts.CompressBuffer(buf, blake3BufSize, &guts.IV, (h.counter+uint64(i))*guts.MaxSIMD, 0)
+				cvs[i] = guts.ChainingValue(node)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, cv := range cvs {
+		h.push(cv)
+	}
+	h.pending = h.pending[:copy(h.pending, h.pending[n*blake3BufSize:])]
+}
+
+// push adds the root of the next subtree to the stack, merging it with any
+// subtrees of the same height.
+func (h *blake3Hasher) push(cv [8]uint32) {
+	i := 0
+	for h.counter&(1<<i) != 0 {
+		cv = guts.ChainingValue(guts.ParentNode(h.stack[i], cv, &guts.IV, 0))
+		i++
+	}
+	h.stack[i] = cv
+	h.counter++
+}
+
+func (h *blake3Hasher) Sum(b []byte) []byte {
+	h.flush()
+
+	var buf [blake3BufSize]byte
+	buflen := copy(buf[:], h.pending)
+	n := guts.CompressBuffer(&buf, buflen, &guts.IV, h.counter*guts.MaxSIMD, 0)
+	for i := bits.TrailingZeros64(h.counter); i < bits.Len64(h.counter); i++ {
+		if h.counter&(1<<i) != 0 {
+			n = guts.ParentNode(h.stack[i], guts.ChainingValue(n), &guts.IV, 0)
+		}
+	}
+	n.Flags |= guts.FlagRoot
+
+	out := guts.WordsToBytes(guts.CompressNode(n))
+	return append(b, out[:h.Size()]...)
+}
+
+func (h *blake3Hasher) Reset() {
+	h.counter = 0
+	h.pending = h.pending[:0]
+}
+
+func (h *blake3Hasher) Size() int { return 32 }
+
+func (h *blake3Hasher) BlockSize() int { return guts.BlockSize }
@@ -0,0 +1,61 @@
+package castore
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"lukechampine.com/blake3"
+)
+
+func TestBLAKE3(t *testing.T) {
+	h := NewBLAKE3()
+	assert.Equal(t, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", hex.EncodeToString(h.Sum(nil)))
+
+	data := make([]byte, 5*blake3Batch*blake3BufSize+12345)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for _, n := range []int{1, 1024, blake3BufSize, blake3BufSize + 1, blake3Batch * blake3BufSize, blake3Batch*blake3BufSize + 1, len(data)} {
+		want := blake3.Sum256(data[:n])
+
+		// Write in uneven pieces to exercise buffering.
+		h.Reset()
+		for p := data[:n]; len(p) > 0; {
+			w := 7777
+			if w > len(p) {
+				w = len(p)
+			}
+			h.Write(p[:w])
+			p = p[w:]
+		}
+		assert.Equal(t, want[:], h.Sum(nil), "size %d", n)
+	}
+}
+
+func TestBLAKE3Store(t *testing.T) {
+	dir := must_s(ioutil.TempDir("", "castore"))
+	defer os.RemoveAll(dir)
+
+	s, err := New(Options{
+		BasePath:    dir,
+		Hash:        NewBLAKE3,
+		KeyEncoding: MultihashEncoding(MultihashBLAKE3),
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	key, err := s.PutBytes([]byte(TEST_VALUE))
+	assert.NoError(t, err)
+	sum := blake3.Sum256([]byte(TEST_VALUE))
+	assert.Equal(t, base58Encode(appendMultihash(nil, MultihashBLAKE3, sum[:])), key)
+
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+}
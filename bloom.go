@@ -0,0 +1,264 @@
+package castore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+const (
+	// bloomFile is the name of the file in the store's internal state
+	// directory in which the Bloom filter (see Options.BloomFilter) is saved
+	// when the store is closed.
+	bloomFile = "bloom"
+
+	// bloomDirtyFile is the name of the file in the store's internal state
+	// directory that exists while the store is using the Bloom filter, so that
+	// if it is not closed cleanly, the filter is rebuilt when it is next
+	// opened.
+	bloomDirtyFile = "bloom.dirty"
+
+	// Parameters of the Bloom filter: the smallest number of keys it is sized
+	// for, and the number of hash functions, which gives a false positive
+	// rate of about 1% when it holds that many keys.
+	minBloomCapacity = 64 * 1024
+	bloomHashes      = 7
+)
+
+// errBadBloom is returned when the saved Bloom filter can't be read.
+var errBadBloom = errors.New("castore: malformed bloom filter")
+
+// bloomFilter is a Bloom filter of the keys in the store.  It cannot have keys
+// removed, so deleted keys remain as false positives until it is rebuilt.
+type bloomFilter struct {
+	capacity int64
+	count    int64
+	bits     []uint64
+}
+
+// newBloomFilter returns an empty Bloom filter sized for the given number of
+// keys.
+func newBloomFilter(capacity int64) *bloomFilter {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	m := int64(math.Ceil(float64(capacity) * bloomHashes / math.Ln2))
+	return &bloomFilter{
+		capacity: capacity,
+		bits:     make([]uint64, (m+63)/64),
+	}
+}
+
+// positions calls fn with each of the bit positions for the given key.
+func (b *bloomFilter) positions(key string, fn func(i uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		fn((h1 + i*h2) % m)
+	}
+}
+
+func (b *bloomFilter) add(key string) {
+	b.positions(key, func(i uint64) {
+		b.bits[i/64] |= 1 << (i % 64)
+	})
+	b.count++
+}
+
+func (b *bloomFilter) mayContain(key string) bool {
+	found := true
+	b.positions(key, func(i uint64) {
+		if b.bits[i/64]&(1<<(i%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// MarshalBinary encodes the filter as its capacity and count, followed by its
+// bits.
+func (b *bloomFilter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 16+8*len(b.bits))
+	binary.BigEndian.PutUint64(data[0:], uint64(b.capacity))
+	binary.BigEndian.PutUint64(data[8:], uint64(b.count))
+	for i, word := range b.bits {
+		binary.BigEndian.PutUint64(data[16+8*i:], word)
+	}
+	return data, nil
+}
+
+func (b *bloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return errBadBloom
+	}
+	*b = *newBloomFilter(int64(binary.BigEndian.Uint64(data[0:])))
+	b.count = int64(binary.BigEndian.Uint64(data[8:]))
+	if len(data) != 16+8*len(b.bits) {
+		return errBadBloom
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.BigEndian.Uint64(data[16+8*i:])
+	}
+	return nil
+}
+
+// openBloom loads the saved Bloom filter, or builds a new one if it may be
+// out of date.
+func (s *CAStore) openBloom() error {
+	dirty, err := s.metaPath(bloomDirtyFile)
+	if err != nil {
+		return err
+	}
+	p, err := s.metaPath(bloomFile)
+	if err != nil {
+		return err
+	}
+
+	var filter bloomFilter
+	data, err := ioutil.ReadFile(p)
+	if err == nil {
+		err = filter.UnmarshalBinary(data)
+	}
+	if _, derr := os.Stat(dirty); err == nil && derr == nil {
+		s.log.Warn("store was not closed cleanly; rebuilding bloom filter")
+		err = s.rebuildBloom(0)
+	} else if err != nil {
+		err = s.rebuildBloom(0)
+	} else {
+		s.bloomMu.Lock()
+		s.bloom = &filter
+		s.bloomMu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	return s.markBloomDirty()
+}
+
+// markBloomDirty records that the saved Bloom filter is out of date.
+func (s *CAStore) markBloomDirty() error {
+	dirty, err := s.metaPath(bloomDirtyFile)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dirty, nil)
+}
+
+// rebuildBloom replaces the Bloom filter with one built from the keys in the
+// store, sized for at least the given number of keys.  Keys added while it is
+// running are carried over to the new filter.
+func (s *CAStore) rebuildBloom(capacity int64) error {
+	s.bloomMu.Lock()
+	s.bloomGrowing = true
+	s.bloomAdded = nil
+	s.bloomMu.Unlock()
+
+	var keys []string
+	err := s.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	})
+
+	s.bloomMu.Lock()
+	defer s.bloomMu.Unlock()
+	added := s.bloomAdded
+	s.bloomGrowing = false
+	s.bloomAdded = nil
+	if err != nil {
+		return err
+	}
+
+	if n := 2 * int64(len(keys)); n > capacity {
+		capacity = n
+	}
+	filter := newBloomFilter(capacity)
+	for _, key := range keys {
+		filter.add(key)
+	}
+	for _, key := range added {
+		filter.add(key)
+	}
+	s.bloom = filter
+	s.log.Debug("built bloom filter", "keys", filter.count, "capacity", filter.capacity)
+	return nil
+}
+
+// bloomAdd adds a newly-stored key to the Bloom filter, if there is one.  Once
+// the filter holds more keys than it was sized for, and so would give too many
+// false positives, it is rebuilt with twice the capacity.
+func (s *CAStore) bloomAdd(key string) {
+	if !s.opts.BloomFilter {
+		return
+	}
+	s.bloomMu.Lock()
+	s.bloom.add(key)
+	if s.bloomGrowing {
+		s.bloomAdded = append(s.bloomAdded, key)
+	}
+	full := s.bloom.count > s.bloom.capacity && !s.bloomGrowing
+	capacity := 2 * s.bloom.capacity
+	saved := s.bloomSaved
+	s.bloomSaved = false
+	s.bloomMu.Unlock()
+
+	if saved {
+		// The store is being used after Close, so the saved filter is now out
+		// of date.
+		if err := s.markBloomDirty(); err != nil {
+			s.log.Warn("could not mark bloom filter as in use", "err", err)
+		}
+	}
+
+	if full {
+		if err := s.rebuildBloom(capacity); err != nil {
+			s.log.Warn("could not rebuild bloom filter", "err", err)
+		}
+	}
+}
+
+// mayExist returns whether the given key might be in the store; if it returns
+// false, the key is definitely not.
+func (s *CAStore) mayExist(key string) bool {
+	if !s.opts.BloomFilter {
+		return true
+	}
+	s.bloomMu.Lock()
+	defer s.bloomMu.Unlock()
+	return s.bloom.mayContain(key)
+}
+
+// closeBloom saves the Bloom filter, and marks it as up to date.
+func (s *CAStore) closeBloom() error {
+	if !s.opts.BloomFilter {
+		return nil
+	}
+	s.bloomMu.Lock()
+	defer s.bloomMu.Unlock()
+	data, err := s.bloom.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	p, err := s.metaPath(bloomFile)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(p, data); err != nil {
+		return err
+	}
+	dirty, err := s.metaPath(bloomDirtyFile)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(dirty); err != nil {
+		return err
+	}
+	s.bloomSaved = true
+	return nil
+}
@@ -0,0 +1,143 @@
+package castore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(0)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("key-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.mayContain(fmt.Sprintf("key-%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if filter.mayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.True(t, falsePositives < 10)
+
+	data, err := filter.MarshalBinary()
+	assert.NoError(t, err)
+	var decoded bloomFilter
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, filter, &decoded)
+	assert.Equal(t, errBadBloom, decoded.UnmarshalBinary(data[:100]))
+}
+
+func TestBloomStore(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-bloom"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, BloomFilter: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// The filter is loaded when the store is reopened, so an object added
+	// behind the store's back isn't seen.
+	plain, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	other, err := plain.PutString("other")
+	assert.NoError(t, err)
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+	exists, err = s.Exists(other)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	size, err := s.Size(other)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), size)
+	r, err := s.Get(other)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	// Storing it again adds it to the filter.
+	_, err = s.PutString("other")
+	assert.NoError(t, err)
+	exists, err = s.Exists(other)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestBloomNotClosed(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-bloom"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, BloomFilter: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// Puts made after Close mean the saved filter must be rebuilt.
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestBloomGrow(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-bloom"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, BloomFilter: true})
+	assert.NoError(t, err)
+	s.bloom = &bloomFilter{capacity: 2, bits: make([]uint64, 1)}
+
+	var keys []string
+	for i := 0; i < 3; i++ {
+		key, err := s.PutString(fmt.Sprintf("value-%d", i))
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, int64(minBloomCapacity), s.bloom.capacity)
+	assert.Equal(t, int64(3), s.bloom.count)
+	for _, key := range keys {
+		assert.True(t, s.mayExist(key))
+	}
+}
+
+func TestBloomWatchExternal(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-bloom"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, BloomFilter: true, WatchExternal: true})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// An object added by another store is added to the filter once the
+	// watcher sees it.
+	plain, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	key, err := plain.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.mayExist(key) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+}
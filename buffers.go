@@ -0,0 +1,31 @@
+package castore
+
+import (
+	"io"
+)
+
+// defaultCopyBufferSize is the size of the buffers used to copy data if
+// Options.CopyBufferSize is not set.
+const defaultCopyBufferSize = 32 * 1024
+
+// getBuffer returns a buffer of the store's CopyBufferSize from its pool.  It
+// should be given back with putBuffer once it is no longer used.
+func (s *CAStore) getBuffer() *[]byte {
+	if b, ok := s.buffers.Get().(*[]byte); ok {
+		return b
+	}
+	b := make([]byte, s.opts.CopyBufferSize)
+	return &b
+}
+
+// putBuffer returns a buffer from getBuffer to the pool.
+func (s *CAStore) putBuffer(b *[]byte) {
+	s.buffers.Put(b)
+}
+
+// copyBuffer is like io.Copy, but uses a buffer from the pool.
+func (s *CAStore) copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	b := s.getBuffer()
+	defer s.putBuffer(b)
+	return io.CopyBuffer(dst, src, *b)
+}
@@ -0,0 +1,42 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyBufferSize(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-buffers"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, CopyBufferSize: 7})
+	assert.NoError(t, err)
+	b := s.getBuffer()
+	assert.Equal(t, 7, len(*b))
+	s.putBuffer(b)
+
+	// Data many times the buffer size is copied correctly.
+	val := strings.Repeat("0123456789", 100)
+	key, err := s.PutString(val)
+	assert.NoError(t, err)
+	assert.Equal(t, val, readKey(t, s, key))
+	report, err := s.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Corrupt)
+
+	// The MaxSize is still enforced exactly.
+	s, err = New(Options{BasePath: tdir, CopyBufferSize: 7, MaxSize: 10})
+	assert.NoError(t, err)
+	_, err = s.PutString("0123456789A")
+	assert.Equal(t, ErrSizeExceeded, err)
+
+	s, err = New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultCopyBufferSize, len(*s.getBuffer()))
+}
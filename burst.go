@@ -0,0 +1,69 @@
+package castore
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// addPending records that the file at the given path has been written in
+// burst mode but not yet synced, and triggers a Flush if we've exceeded our
+// limits.
+func (s *CAStore) addPending(p string) error {
+	s.burstMu.Lock()
+	if len(s.pending) == 0 {
+		s.pendingSince = time.Now()
+	}
+	s.pending = append(s.pending, p)
+	needFlush := len(s.pending) >= s.opts.BurstMaxPending ||
+		time.Since(s.pendingSince) >= s.opts.BurstMaxDelay
+	s.burstMu.Unlock()
+
+	if needFlush {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush is a durability barrier for burst mode: once it returns without error,
+// all data inserted by Put before the call to Flush is on stable storage.  It
+// syncs all pending files and their containing directories as one group, so
+// that each directory is only synced once per batch.  Flush is a no-op when not
+// in burst mode.
+func (s *CAStore) Flush() error {
+	s.burstMu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.burstMu.Unlock()
+
+	var (
+		firstErr error
+		dirs     = make(map[string]bool)
+	)
+	for _, p := range pending {
+		if err := syncPath(p); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+		dirs[filepath.Dir(p)] = true
+	}
+	for dir := range dirs {
+		if err := syncPath(dir); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// syncPath is a helper function that will fsync the file or directory at the
+// given path.
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBurstMode(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-burst"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:        tdir,
+		Transform:       DepthTransformFunc(1),
+		BurstMode:       true,
+		BurstMaxPending: 3,
+		BurstMaxDelay:   time.Hour,
+	})
+	assert.NoError(t, err)
+
+	for _, v := range []string{"a", "b"} {
+		_, err = s.PutString(v)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, s.pending, 2)
+
+	// Hitting the limit triggers a flush.
+	_, err = s.PutString("c")
+	assert.NoError(t, err)
+	assert.Len(t, s.pending, 0)
+
+	// Data is readable before an explicit flush.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Len(t, s.pending, 1)
+
+	size, err := s.Size(TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	assert.NoError(t, s.Flush())
+	assert.Len(t, s.pending, 0)
+}
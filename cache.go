@@ -0,0 +1,205 @@
+package castore
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCacheBytes is the default CacheOptions.MaxBytes.
+	defaultCacheBytes = 64 * 1024 * 1024
+
+	// defaultCacheTTL is the default CacheOptions.TTL.
+	defaultCacheTTL = 5 * time.Minute
+)
+
+// CacheOptions controls the behaviour of a Cache.
+type CacheOptions struct {
+	// TTL is how long an object is served from the cache before the
+	// underlying store is consulted again.  Since data for a key can never
+	// change, this only limits how long an object deleted by something other
+	// than the cache remains visible.  If not positive, a default of five
+	// minutes is used.
+	TTL time.Duration
+
+	// MaxBytes is the total size of the objects held in memory.  When it would
+	// be exceeded, the least recently used objects are dropped.  If not
+	// positive, a default of 64MiB is used.
+	MaxBytes int64
+
+	// MaxObjectSize is the size of the largest object that will be cached;
+	// larger ones are always read from the underlying store.  If not
+	// positive, or larger than MaxBytes, a sixteenth of MaxBytes is used.
+	MaxObjectSize int64
+}
+
+// Cache wraps a Store - typically a remote one - and keeps recently read
+// objects in memory, so that hot keys aren't fetched from the store every
+// time.  Unlike Tiered, nothing is written to disk.
+type Cache struct {
+	s    Store
+	opts CacheOptions
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	bytes   int64
+}
+
+var _ Store = (*Cache)(nil)
+
+// cacheEntry is a single cached object.
+type cacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+// NewCache returns a Cache for the given store.  If the store is a CAStore,
+// keys deleted through it are dropped from the cache, as are keys deleted by
+// other processes if it was opened with Options.WatchExternal.
+func NewCache(s Store, opts CacheOptions) *Cache {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultCacheTTL
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultCacheBytes
+	}
+	if opts.MaxObjectSize <= 0 || opts.MaxObjectSize > opts.MaxBytes {
+		opts.MaxObjectSize = opts.MaxBytes / 16
+	}
+	c := &Cache{
+		s:       s,
+		opts:    opts,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if cs, ok := s.(*CAStore); ok {
+		cs.forgetOnChange(c)
+	}
+	return c
+}
+
+// Put will insert data into the underlying store.  It is not cached until it
+// is first read.
+func (c *Cache) Put(r io.Reader) (string, error) {
+	return c.s.Put(r)
+}
+
+// Get will return a reader for the data stored with the given key, from
+// memory if it was read recently.
+func (c *Cache) Get(key string) (io.ReadCloser, error) {
+	if data := c.lookup(key); data != nil {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	r, err := c.s.Get(key)
+	if err != nil || r == nil {
+		return r, err
+	}
+
+	// Read just enough to tell whether the object is small enough to cache.
+	data, err := ioutil.ReadAll(io.LimitReader(r, c.opts.MaxObjectSize+1))
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if int64(len(data)) > c.opts.MaxObjectSize {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r), r}, nil
+	}
+	if err = r.Close(); err != nil {
+		return nil, err
+	}
+	c.add(key, data)
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Size will return the size of the data stored with the given key, from
+// memory if it was read recently.
+func (c *Cache) Size(key string) (int64, error) {
+	if data := c.lookup(key); data != nil {
+		return int64(len(data)), nil
+	}
+	return c.s.Size(key)
+}
+
+// Exists will return whether the given key exists, without consulting the
+// underlying store if it was read recently.
+func (c *Cache) Exists(key string) (bool, error) {
+	if data := c.lookup(key); data != nil {
+		return true, nil
+	}
+	return c.s.Exists(key)
+}
+
+// Delete will delete the given key from the underlying store and the cache.
+func (c *Cache) Delete(key string) error {
+	c.Forget(key)
+	return c.s.Delete(key)
+}
+
+// Walk will call fn for every object in the underlying store.
+func (c *Cache) Walk(fn WalkFunc) error {
+	return c.s.Walk(fn)
+}
+
+// Forget drops the given key from the cache.  It should be called when the
+// key is known to have been deleted by something other than this cache; see
+// Invalidate.
+func (c *Cache) Forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// lookup returns the cached data for the given key, or nil if there is none
+// or it has expired.
+func (c *Cache) lookup(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	ent := elem.Value.(*cacheEntry)
+	if time.Now().After(ent.expires) {
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return ent.data
+}
+
+// add caches the data for the given key, dropping the least recently used
+// entries to make room.
+func (c *Cache) add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.bytes+int64(len(data)) > c.opts.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+	ent := &cacheEntry{key, data, time.Now().Add(c.opts.TTL)}
+	c.entries[key] = c.lru.PushFront(ent)
+	c.bytes += int64(len(data))
+}
+
+// remove drops the given entry.  It must be called with mu held.
+func (c *Cache) remove(elem *list.Element) {
+	ent := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, ent.key)
+	c.bytes -= int64(len(ent.data))
+}
@@ -0,0 +1,106 @@
+package castore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getCountingStore counts the calls to Get on a Store.
+type getCountingStore struct {
+	Store
+	gets int
+}
+
+func (s *getCountingStore) Get(key string) (io.ReadCloser, error) {
+	s.gets++
+	return s.Store.Get(key)
+}
+
+func readKey(t *testing.T, s Store, key string) string {
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	if r == nil {
+		return ""
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestCache(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-cache"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	gs := &getCountingStore{Store: s}
+	c := NewCache(gs, CacheOptions{MaxBytes: 16, MaxObjectSize: 8})
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	big, err := s.PutString("too big to cache")
+	assert.NoError(t, err)
+
+	// Only the first read of a small object reaches the store.
+	assert.Equal(t, TEST_VALUE, readKey(t, c, key))
+	assert.Equal(t, TEST_VALUE, readKey(t, c, key))
+	assert.Equal(t, 1, gs.gets)
+	size, err := c.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	// Large objects are always read from the store.
+	assert.Equal(t, "too big to cache", readKey(t, c, big))
+	assert.Equal(t, "too big to cache", readKey(t, c, big))
+	assert.Equal(t, 3, gs.gets)
+
+	// Older objects are dropped to make room.
+	other, err := s.PutString("12345678")
+	assert.NoError(t, err)
+	third, err := s.PutString("abcdefgh")
+	assert.NoError(t, err)
+	readKey(t, c, other)
+	readKey(t, c, third)
+	gs.gets = 0
+	readKey(t, c, key)
+	assert.Equal(t, 1, gs.gets)
+
+	// Deletes through the cache are seen immediately.
+	assert.NoError(t, c.Delete(key))
+	exists, err := c.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, "", readKey(t, c, key))
+}
+
+func TestCacheTTL(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-cache"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	c := NewCache(s, CacheOptions{TTL: 10 * time.Millisecond})
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, c, key))
+
+	// A deletion made elsewhere is hidden until the entry expires.
+	other, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	assert.NoError(t, other.Delete(key))
+	exists, err := c.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	time.Sleep(20 * time.Millisecond)
+	exists, err = c.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
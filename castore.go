@@ -1,7 +1,9 @@
 package castore
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -9,15 +11,23 @@ import (
 	"hash"
 	"io"
 	"io/ioutil"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // TransformFunction transforms a key into a slice of strings, each of which
 // represents a directory where the data will be stored.  For example, if a
 // TransformFunction turns "abcdef" into `[]string{"ab", "cd", "ef"}`, the
 // final location of the data on-disk will be `<BasePath>/ab/cd/ef/abcdef`.
+// If the store uses a KeyEncoding other than HexEncoding, the function is
+// given the hex encoding of the key's digest rather than the key itself.
 type TransformFunction func(key string) []string
 
 // Options contains the options that control the behavior of a CAStore.
@@ -29,29 +39,507 @@ type Options struct {
 	// not specified, it will default to crypto/sha256.
 	Hash func() hash.Hash
 
+	// TempDir is the directory in which incoming data is written before it is
+	// moved into place.  It should be on the same filesystem as the BasePath,
+	// so that the move is an atomic rename; otherwise, the data must be copied
+	// again.  If not specified, a directory within the store's internal state
+	// directory is used.
+	TempDir string
+
+	// SecondaryHashes lists additional hash functions, by name, that will be
+	// computed for data as it is inserted.  The digests are recorded in an
+	// index, so that objects can be found by digests other than their key -
+	// for example, an MD5 or SHA-1 supplied by a legacy system - using
+	// LookupHash.  Names must not contain slashes or start with a dot.
+	SecondaryHashes map[string]func() hash.Hash
+
+	// KeyEncoding determines how the hash of each piece of data is encoded to
+	// form its key.  Like Hash, this must not be changed for an existing store.
+	// If this is not specified, it will default to HexEncoding.
+	KeyEncoding KeyEncoding
+
 	// Transform is the TransformFunction that will be used for the CAStore.  If
 	// this is not specified, it will default to FlatTransformFunc.
 	Transform TransformFunction
 
+	// LegacyTransforms lists TransformFunctions that were previously used for
+	// this store.  If data cannot be found at the location given by Transform,
+	// each of these will be tried in order before failing.  This allows changing
+	// the Transform of an existing store without making old data unreachable;
+	// see RewriteLegacy for converting old data to the new layout.
+	LegacyTransforms []TransformFunction
+
 	// MaxSize specifies the upper limit on the size of values that can be
 	// inserted into the CAStore.  If not specified or negative, this will default
-	// to 10 MiB.
+	// to 10 MiB; use NoLimit to allow values of any size.
 	MaxSize int64
+
+	// MinSize specifies the lower limit on the size of values that can be
+	// inserted into the CAStore; smaller values are rejected with
+	// ErrSizeTooSmall.  If not specified, there is no lower limit.
+	MinSize int64
+
+	// CopyBufferSize is the size of the buffers used to copy data into and
+	// out of the store, for example by Put, Verify and Copy.  Buffers are
+	// pooled and reused between calls.  If not specified, this will default
+	// to 32 KiB.
+	CopyBufferSize int
+
+	// BurstMode enables burst ingestion.  In this mode, Put does not wait for
+	// data to reach stable storage; instead, written objects are synced to disk
+	// in batches (see Flush).  This trades a bounded window in which recently
+	// written data may be lost on a crash for much higher ingest throughput of
+	// small objects.
+	BurstMode bool
+
+	// BurstMaxPending is the maximum number of objects that may be waiting to be
+	// synced in burst mode before a Put will trigger a Flush.  If not specified
+	// or negative, this will default to 1024.
+	BurstMaxPending int
+
+	// BurstMaxDelay is the maximum amount of time that an object may be waiting
+	// to be synced in burst mode before a Put will trigger a Flush.  If not
+	// specified or negative, this will default to one second.
+	BurstMaxDelay time.Duration
+
+	// Preverify enables background re-verification of newly inserted data.
+	// Shortly after each Put, the data is read back from disk and re-hashed in
+	// order to catch problems on the write path (e.g. failing hardware) early.
+	// Since it happens in the background, this does not slow down Put; if the
+	// verification queue is full, objects are skipped rather than blocking.
+	Preverify bool
+
+	// PreverifyDelay is how long after a Put the data will be re-verified.  If
+	// not specified or negative, this will default to one second.
+	PreverifyDelay time.Duration
+
+	// PreverifyRate is the maximum number of objects per second that will be
+	// re-verified.  If not specified or negative, this will default to 100.
+	PreverifyRate int
+
+	// PreverifyFailed, if set, is called for each object that fails background
+	// re-verification.  The error will be ErrCorrupt if the data did not match
+	// its key, or the error encountered while trying to read it.
+	PreverifyFailed func(key string, err error)
+
+	// VerifyOnRead causes the data returned by Get to be checked against its
+	// key, so that corruption is never silently passed on to callers.
+	// CorruptReads determines what happens if a problem is found.
+	VerifyOnRead bool
+
+	// CorruptReads is the policy applied when VerifyOnRead is set and data
+	// turns out to be corrupt or unreadable.  If not specified, this will
+	// default to CorruptReadFail.
+	CorruptReads CorruptReadPolicy
+
+	// Replica is the Source that the rest of an object is read from when
+	// reading the local copy fails under the CorruptReadRetry policy.  If it
+	// is not set, CorruptReadRetry behaves like CorruptReadPartial.
+	Replica Source
+
+	// Observer, if set, is called after every Put, Get, Delete, eviction and
+	// Undelete with the details of the operation.  It is intended for instrumentation such as
+	// metrics collection, and is called synchronously, so it should be fast.
+	Observer func(op Operation)
+
+	// SnapshotHistory is the maximum number of snapshots (see Snapshot) that
+	// will be retained; once exceeded, the oldest snapshots are removed.  If not
+	// specified or negative, all snapshots are retained.
+	SnapshotHistory int
+
+	// MaxOpenFiles is the maximum number of files that the store will hold open
+	// at once, including the readers returned by Get.  Once the limit is
+	// reached, operations wait for a file to be closed rather than failing with
+	// "too many open files".  If not specified, this will default to three
+	// quarters of the process's RLIMIT_NOFILE soft limit, leaving headroom for
+	// the rest of the application.  If negative, or if the limit cannot be
+	// determined, there is no limit.
+	MaxOpenFiles int
+
+	// Ledger causes the store to keep count of its objects and their total
+	// size, persisted within the store, so that Usage, and the Quota when the
+	// store is opened, don't need to walk every object.  Like the Index, it
+	// only sees Puts and Deletes made through this CAStore, so Reconcile must
+	// be called if anything else modifies the BasePath; if the store is not
+	// closed with Close, it is reconciled when the store is next opened.
+	Ledger bool
+
+	// Index causes the store to keep an index of its objects, persisted
+	// within the store, so that Exists, Size, Walk and Usage don't need to
+	// stat or list millions of files.  The index is updated by every Put and
+	// Delete made through this CAStore, and by deletions made by others if
+	// WatchExternal is set; if anything else adds objects to the BasePath,
+	// RebuildIndex must be called afterwards.  If the store is not
+	// closed with Close, the index is rebuilt when it is next opened.
+	Index bool
+
+	// AccessTimes causes the index to record when each object was last
+	// retrieved by Get, to within a minute, for LastAccess, eviction and
+	// WalkOptions.NotAccessedSince.  This doesn't rely on the filesystem's
+	// atime, which is often disabled.  It has no effect unless Index is also
+	// set, and only loose objects are tracked.
+	AccessTimes bool
+
+	// BloomFilter causes the store to keep a Bloom filter of its keys in
+	// memory, persisted within the store, so that Exists, Size and Get can
+	// usually report that a key is missing without touching the disk.  It
+	// only sees Puts made through this CAStore unless WatchExternal is set,
+	// and if the store is not closed with Close, it is rebuilt when the store
+	// is next opened.
+	BloomFilter bool
+
+	// WatchExternal causes the store to watch BasePath, as Watch does, for
+	// objects added or deleted by other processes.  Added objects are put in
+	// the Bloom filter, so that they aren't reported as missing; deleted ones
+	// are removed from the Index; and any Cache or ExistsCache wrapping the
+	// store forgets about both.
+	WatchExternal bool
+
+	// RejectEmpty causes Put to fail with ErrEmpty when given zero bytes of
+	// data, rather than storing an empty object.
+	RejectEmpty bool
+
+	// InlineMaxSize is the size of the largest object that is appended to a
+	// shared pack file as it is stored, rather than being given a file of its
+	// own, so that metadata-sized values don't each cost an inode.  Such
+	// objects are folded into an ordinary pack by Repack.  Since the pack is
+	// appended to in place, only one CAStore may inline objects into a given
+	// BasePath at a time.  If not specified or negative, no objects are
+	// inlined.
+	InlineMaxSize int64
+
+	// PackMaxSize is the size of the largest object that Repack will move
+	// into a pack file.  If not specified, this will default to 4KiB.
+	PackMaxSize int64
+
+	// Logger, if set, receives log messages about the operation of the store:
+	// debug messages for individual operations and temporary file cleanup,
+	// info messages for deletions and snapshot pruning, and warnings for
+	// corruption that is detected.  If not specified, nothing is logged.
+	Logger *slog.Logger
+
+	// Quota is the maximum total size, in bytes, of all objects in the store.
+	// A Put that would exceed it fails with ErrQuotaExceeded.  The current size
+	// is calculated when the store is created and then tracked as objects are
+	// added and removed, so changes made by other processes sharing the
+	// BasePath are not accounted for.  If not specified or negative, there is
+	// no quota.
+	Quota int64
+
+	// UsageThresholds are fractions (e.g. 0.8 and 0.95) of the Quota and of
+	// the capacity of the filesystem that the store is on; whenever usage of
+	// either crosses one of them, Hooks.OnThreshold is called and a warning
+	// logged, so that applications can raise an alert before Puts start
+	// failing.  Usage is checked after objects are added or removed, and the
+	// filesystem at most once a second.
+	UsageThresholds []float64
+
+	// SniffContentType enables detection of the MIME type of data as it is
+	// inserted, using http.DetectContentType on its first 512 bytes.  The
+	// result is available from ContentType.
+	SniffContentType bool
+
+	// WriteOnce guarantees that objects in the store are never modified once
+	// written: a Put of data that is already present leaves the existing
+	// file's data untouched - only its modification time is updated, as for
+	// any other Put - and Delete fails with ErrWriteOnce.  Both are logged, so that
+	// attempts can be audited.
+	WriteOnce bool
+
+	// EvictScore is used by Evict to choose which objects to remove.  If not
+	// specified, this will default to OldestFirst, or to LeastRecentlyUsed if
+	// CacheSize is set.
+	EvictScore EvictScoreFunc
+
+	// CacheSize, if positive, makes the store a cache of at most this many
+	// bytes: whenever a Put takes its total size above CacheSize, objects are
+	// evicted with Evict until it fits again.  Pinned objects, and those in a
+	// snapshot, are never evicted, so the store can stay larger than this.
+	// Like the Quota, the size is calculated when the store is created and
+	// then tracked.  Set Index and AccessTimes so that objects are evicted by
+	// when they were last retrieved, rather than when they were written.
+	CacheSize int64
+
+	// CacheLowWater is a fraction (e.g. 0.9) of the CacheSize.  If set, once
+	// the store grows larger than CacheSize, which acts as the high
+	// watermark, objects are evicted until it is no larger than this fraction
+	// of it, so that eviction happens in batches rather than on every Put.  If
+	// not specified, or not between 0 and 1, eviction stops as soon as the
+	// store fits within CacheSize.
+	CacheLowWater float64
+
+	// CacheEvictInterval, if positive, causes the CacheSize to be enforced by
+	// a background goroutine this often, rather than by each Put, so that
+	// Puts never wait for eviction; in between, the store may grow larger
+	// than CacheSize.
+	CacheEvictInterval time.Duration
+
+	// Durability determines how much care Put takes to ensure that data has
+	// reached stable storage before returning.  If not specified, this will
+	// default to DurabilityNone.  In burst mode, syncing is always deferred
+	// until the next Flush.
+	Durability Durability
+
+	// MaxConcurrentPuts is the maximum number of Puts that may be in progress
+	// at once; any more wait, without reading from their io.Reader, until an
+	// earlier one has finished.  For data read from network connections, this
+	// leaves it in the senders' buffers rather than in memory.  If not
+	// specified or negative, there is no limit.
+	MaxConcurrentPuts int
+
+	// MaxUnsynced limits the number of bytes of a Put that may be written to
+	// the operating system's cache but not yet to disk.  Whenever this many
+	// bytes have been written, the Put waits for them to reach the disk before
+	// reading more, so that data is read from its source no faster than the
+	// disk can accept it.  If not specified or negative, the operating system
+	// is left to decide when to write data to disk.
+	MaxUnsynced int64
+
+	// ReadRate limits the total rate, in bytes per second, at which data is
+	// read from the store by Gets and by Verify, so that background work such
+	// as replication or scrubbing doesn't saturate the disk or network.  If
+	// not specified or negative, there is no limit.
+	ReadRate int64
+
+	// WriteRate limits the total rate, in bytes per second, at which data is
+	// written to the store by Puts.  If not specified or negative, there is
+	// no limit.
+	WriteRate int64
+
+	// OpReadRate limits the rate, in bytes per second, at which each Get may
+	// read data, in addition to ReadRate.  If not specified or negative,
+	// there is no limit.
+	OpReadRate int64
+
+	// OpWriteRate limits the rate, in bytes per second, at which each Put may
+	// write data, in addition to WriteRate.  If not specified or negative,
+	// there is no limit.
+	OpWriteRate int64
+
+	// DirMode is the permission bits of the directories in which data is
+	// stored, including the BasePath if it does not already exist.  If not
+	// specified, this will default to 0700.
+	DirMode os.FileMode
+
+	// FileMode is the permission bits of the files in which data is stored.
+	// If not specified, this will default to 0600.  Together with DirMode,
+	// this allows the data to be read by other users - for example, a web
+	// server serving files directly from the store.  The store's internal
+	// state (labels, refs, snapshots and so on) is always private.
+	FileMode os.FileMode
+
+	// Owner, if set, is the user and group that the files and directories in
+	// which data is stored will be changed to belong to.  This usually
+	// requires the process to be privileged.
+	Owner *Owner
+
+	// Evictions, if set, receives an Eviction for every object that the store
+	// removes of its own accord, along with the reason, so that applications
+	// can update their own indexes or fetch important objects again.  As with
+	// signal.Notify, the store will not block sending to the channel: the
+	// caller must ensure that it has enough buffer space, and notifications
+	// that do not fit are dropped (and logged).
+	Evictions chan<- Eviction
+
+	// ProcessLocking, if set, makes the store take advisory locks (using
+	// flock) on a file in its internal state directory, so that several
+	// processes can safely share a BasePath.  Puts, Gets and Deletes take a
+	// shared lock, while Compact, Evict and RewriteLegacy take an exclusive
+	// one, so that they never remove data that a concurrent Put has just
+	// found to be present.  Since Hooks.OnEvict is called while the exclusive
+	// lock is held, it must not use the store.  This has no effect on Windows
+	// and Plan 9.
+	ProcessLocking bool
+
+	// Hooks contains callbacks that are fired after objects are added to or
+	// removed from the store.
+	Hooks Hooks
 }
 
+// NoLimit can be given as Options.MaxSize to allow values of any size to be
+// inserted, for workloads such as backups that store multi-gigabyte objects.
+const NoLimit int64 = math.MaxInt64
+
 var (
 	// ErrSizeExceeded is the error returned when an attempt is made to store data
 	// that exceeds the MaxSize specified when creating the CAStore.
 	ErrSizeExceeded = errors.New("castore: size exceeded")
 
+	// ErrSizeTooSmall is the error returned when an attempt is made to store
+	// data that is smaller than the MinSize specified when creating the
+	// CAStore.
+	ErrSizeTooSmall = errors.New("castore: size below minimum")
+
 	// ErrNoBasePath is the error returned when attempting to construct a CAStore
 	// with no BasePath specified.
 	ErrNoBasePath = errors.New("castore: base path cannot be empty")
+
+	// ErrNotFound is the error returned by operations that require a key to
+	// exist in the store when it does not.
+	ErrNotFound = errors.New("castore: key not found")
+
+	// ErrCorrupt is the error returned when data in the store no longer
+	// matches its key.
+	ErrCorrupt = errors.New("castore: data is corrupt")
+
+	// ErrKeyMismatch is the error returned when data was expected to have a
+	// certain key, but the data's actual key was different.
+	ErrKeyMismatch = errors.New("castore: key mismatch")
+
+	// ErrEmpty is the error returned when an attempt is made to store
+	// zero-length data while the RejectEmpty option is set.
+	ErrEmpty = errors.New("castore: empty object")
+
+	// ErrWriteOnce is the error returned when attempting to delete data from a
+	// store that was created with the WriteOnce option.
+	ErrWriteOnce = errors.New("castore: store is write-once")
 )
 
 // CAStore implements a content-addressable storage for arbitrary inputs.
 type CAStore struct {
 	opts Options
+	log  *slog.Logger
+
+	// Multihash code of opts.Hash, or zero if it is unknown
+	hashCode uint64
+
+	// Total size of the store, when a Quota or CacheSize is set; accessed
+	// atomically
+	used int64
+
+	// State for enforcing the CacheSize; cacheEvicting is accessed atomically
+	cacheEvicting int32
+	cacheStop     chan struct{}
+	cacheDone     chan struct{}
+
+	// State for burst mode
+	burstMu      sync.Mutex
+	pending      []string
+	pendingSince time.Time
+
+	// State for background re-verification
+	preverifyCh   chan preverifyItem
+	preverifyStop chan struct{}
+	preverifyDone chan struct{}
+
+	// State for Options.WatchExternal
+	watchCancel context.CancelFunc
+	watchDone   chan struct{}
+
+	// Caches wrapping the store, which are told about deleted keys; see
+	// forgetOnChange
+	forgetMu   sync.Mutex
+	forgetters []Forgetter
+
+	// Source that missing data is fetched from by Get; see LazySeed
+	seed Source
+
+	// Limits the number of open files; nil if unlimited
+	fds chan struct{}
+
+	// Limits the number of concurrent Puts; nil if unlimited
+	puts chan struct{}
+
+	// Limit the total rate of reads and writes; nil if unlimited
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+
+	// Number of UsageThresholds exceeded by each resource, and when disk
+	// usage was last checked
+	thresholdMu     sync.Mutex
+	thresholdLevels map[UsageResource]int
+	diskChecked     time.Time
+
+	// Keys that are being moved into place by a Put; see claimKey
+	inflightMu sync.Mutex
+	inflight   map[string]chan struct{}
+
+	// Index of the objects in pack files, and the deleted entries in them;
+	// see pack.go
+	packMu   sync.RWMutex
+	packed   map[string]packEntry
+	packDead []packTombstone
+
+	// The objects stored as deltas by PutDelta; see delta.go
+	deltaMu sync.RWMutex
+	deltas  map[string]deltaEntry
+
+	// Buffers of Options.CopyBufferSize for copying data; see buffers.go
+	buffers sync.Pool
+
+	// Held for reading while objects are removed, and for writing by Repack
+	repackMu sync.RWMutex
+
+	// The index of loose objects, if Options.Index is set, and the log it is
+	// persisted in; see index.go
+	indexMu      sync.RWMutex
+	index        map[string]indexEntry
+	indexFile    *os.File
+	indexLog     *bufio.Writer
+	indexRecords int
+	indexBroken  bool
+
+	// The Bloom filter of keys, if Options.BloomFilter is set, and the keys
+	// added to it while it is being rebuilt; see bloom.go
+	bloomMu      sync.Mutex
+	bloom        *bloomFilter
+	bloomGrowing bool
+	bloomAdded   []string
+	bloomSaved   bool
+
+	// The number and total size of the objects in the store, if
+	// Options.Ledger is set, and whether they have been saved by Close; see
+	// ledger.go
+	ledgerMu      ledgerLock
+	ledgerObjects int64
+	ledgerBytes   int64
+	ledgerSaved   int32
+
+	// The open files of the inline pack, if any; see inline.go
+	inlineMu    sync.Mutex
+	inlineName  string
+	inlineData  *os.File
+	inlineIndex *os.File
+	inlineSize  int64
+
+	// Keys that Evict must not remove, and how many times each was pinned
+	pinnedMu sync.Mutex
+	pinned   map[string]int
+
+	// The TransformFunction given to Relayout, if it has been called
+	relayoutMu sync.Mutex
+	relayout   atomic.Value
+
+	// Protects the labels file
+	labelsMu sync.Mutex
+
+	// Protects the per-object metadata files
+	metadataMu sync.Mutex
+
+	// Number of Gets of each key, if EvictScore is set
+	hitsMu sync.Mutex
+	hits   map[string]int64
+
+	// Protects the snapshot history, and caches the set of keys that it
+	// references
+	snapMu        sync.Mutex
+	protected     map[string]bool
+	protectedFrom string
+
+	// The directories of the transactions that are being committed by this
+	// process
+	txnMu     sync.Mutex
+	txnActive map[string]bool
+
+	// Serializes Snapshot, and holds the keys of the snapshot being taken
+	// until it is recorded in the history
+	snapTakeMu  sync.Mutex
+	snapPending map[string]bool
+
+	// Operation counters
+	stats storeStats
+
+	closeOnce sync.Once
 }
 
 // New will create a new CAStore with the given options.  It will attempt to
@@ -63,30 +551,181 @@ func New(opts Options) (*CAStore, error) {
 		return nil, ErrNoBasePath
 	}
 
+	if opts.DirMode == 0 {
+		opts.DirMode = 0700
+	}
+	if opts.FileMode == 0 {
+		opts.FileMode = 0600
+	}
+
 	// Try creating the base path
-	err := os.MkdirAll(opts.BasePath, 0700)
+	err := os.MkdirAll(opts.BasePath, opts.DirMode)
 	if err != nil {
 		return nil, fmt.Errorf("castore: could not create the base path: %s", err)
 	}
 
+	if opts.TempDir == "" {
+		opts.TempDir = filepath.Join(opts.BasePath, metaDir, tempDir)
+	}
+	if err = os.MkdirAll(opts.TempDir, 0700); err != nil {
+		return nil, fmt.Errorf("castore: could not create the temporary directory: %s", err)
+	}
+
+	for name := range opts.SecondaryHashes {
+		if !validHashName(name) {
+			return nil, fmt.Errorf("castore: invalid secondary hash name %q", name)
+		}
+	}
+
 	// Set default options
 	if opts.Hash == nil {
 		opts.Hash = sha256.New
 	}
+	if opts.KeyEncoding == nil {
+		opts.KeyEncoding = HexEncoding
+	}
 	if opts.Transform == nil {
 		opts.Transform = FlatTransformFunc
 	}
 	if opts.MaxSize <= 0 {
 		opts.MaxSize = 10 * 1024 * 1024
 	}
+	if opts.CopyBufferSize <= 0 {
+		opts.CopyBufferSize = defaultCopyBufferSize
+	}
+	if len(opts.UsageThresholds) > 0 {
+		opts.UsageThresholds = append([]float64(nil), opts.UsageThresholds...)
+		sort.Float64s(opts.UsageThresholds)
+	}
+	if opts.BurstMaxPending <= 0 {
+		opts.BurstMaxPending = 1024
+	}
+	if opts.BurstMaxDelay <= 0 {
+		opts.BurstMaxDelay = time.Second
+	}
+	if opts.PreverifyDelay <= 0 {
+		opts.PreverifyDelay = time.Second
+	}
+	if opts.PreverifyRate <= 0 {
+		opts.PreverifyRate = 100
+	}
 
 	// Ready!
+	if opts.MaxOpenFiles == 0 {
+		opts.MaxOpenFiles = defaultMaxOpenFiles()
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = slog.New(discardHandler{})
+	}
+	if opts.PackMaxSize <= 0 {
+		opts.PackMaxSize = defaultPackMaxSize
+	}
+
 	ret := &CAStore{
-		opts: opts,
+		opts:     opts,
+		log:      opts.Logger,
+		hashCode: detectHashCode(opts.Hash, opts.KeyEncoding),
+	}
+	if err = ret.checkManifest(); err != nil {
+		return nil, err
+	}
+	if opts.MaxOpenFiles > 0 {
+		ret.fds = make(chan struct{}, opts.MaxOpenFiles)
+	}
+	if opts.ReadRate > 0 {
+		ret.readLimiter = newRateLimiter(opts.ReadRate)
+	}
+	if opts.WriteRate > 0 {
+		ret.writeLimiter = newRateLimiter(opts.WriteRate)
+	}
+	if opts.MaxConcurrentPuts > 0 {
+		ret.puts = make(chan struct{}, opts.MaxConcurrentPuts)
+	}
+	if opts.EvictScore != nil {
+		ret.hits = make(map[string]int64)
+	}
+	if err = ret.loadPacks(); err != nil {
+		return nil, err
+	}
+	if err = ret.loadDeltas(); err != nil {
+		return nil, err
+	}
+	if opts.Index {
+		if err = ret.openIndex(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Ledger {
+		if err = ret.openLedger(); err != nil {
+			return nil, err
+		}
+	}
+	var events <-chan Event
+	if opts.WatchExternal {
+		// Start watching before the filter is loaded, so that nothing added
+		// in between is missed.
+		if events, err = ret.startWatch(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BloomFilter {
+		if err = ret.openBloom(); err != nil {
+			ret.stopWatch()
+			return nil, err
+		}
+	}
+	if events != nil {
+		ret.watchDone = make(chan struct{})
+		go ret.watchLoop(events)
+	}
+	if err = ret.initQuota(); err != nil {
+		ret.stopWatch()
+		return nil, err
+	}
+	if ret.relayoutInterrupted() {
+		ret.log.Warn("an earlier Relayout did not complete; it should be run again")
+	}
+	if opts.Preverify {
+		ret.startPreverify()
+	}
+	if opts.CacheSize > 0 && opts.CacheEvictInterval > 0 {
+		ret.startCacheLoop()
 	}
 	return ret, nil
 }
 
+// Close will stop any background work being done by the store, and flush any
+// data that is pending in burst mode.
+func (s *CAStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.preverifyStop != nil {
+			close(s.preverifyStop)
+			<-s.preverifyDone
+		}
+		if s.cacheStop != nil {
+			close(s.cacheStop)
+			<-s.cacheDone
+		}
+		s.stopWatch()
+		err = s.Flush()
+		if cerr := s.closeInline(); err == nil {
+			err = cerr
+		}
+		if cerr := s.closeIndex(); err == nil {
+			err = cerr
+		}
+		if cerr := s.closeBloom(); err == nil {
+			err = cerr
+		}
+		if cerr := s.closeLedger(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
 // copyLimited is a helper function that will copy from an io.Reader to an
 // io.Writer, but limited to a certain number of bytes.  It will return the
 // number of bytes written, whether we exceeded the limit, and any error.
@@ -94,7 +733,8 @@ func (s *CAStore) copyLimited(dst io.Writer, src io.Reader, limit int64) (int64,
 	var (
 		remaining = limit
 		written   int64
-		buffer    = make([]byte, 32*1024)
+		pooled    = s.getBuffer()
+		buffer    = *pooled
 		tooLarge  bool
 		err       error
 	)
@@ -141,61 +781,372 @@ func (s *CAStore) copyLimited(dst io.Writer, src io.Reader, limit int64) (int64,
 		}
 	}
 
+	s.putBuffer(pooled)
 	return written, tooLarge, err
 }
 
 // Put will insert the data from the given io.Reader into the store, and return
 // the key that was used to insert
 func (s *CAStore) Put(r io.Reader) (string, error) {
-	// Create a temporary file to stream the data to.
-	tfile, err := ioutil.TempFile("", "castore")
+	return s.put(r, "")
+}
+
+// PutVerified will insert the data from the given io.Reader into the store,
+// like Put, but only if its key is expectedKey.  If the data has a different
+// key, for example because it was corrupted in transit, ErrKeyMismatch is
+// returned and nothing is stored.
+func (s *CAStore) PutVerified(expectedKey string, r io.Reader) error {
+	if expectedKey == "" {
+		return ErrKeyMismatch
+	}
+	_, err := s.put(r, expectedKey)
+	return err
+}
+
+// put is the implementation of Put.  If expected is non-empty, the data will
+// only be stored if its key matches, and ErrKeyMismatch is returned otherwise.
+func (s *CAStore) put(r io.Reader, expected string) (string, error) {
+	return s.putFrom(r, expected, "")
+}
+
+// putFrom is like put, but if src is non-empty, r reads the file at that path,
+// which is moved into the store (see PutFile).
+func (s *CAStore) putFrom(r io.Reader, expected, src string) (string, error) {
+	res, err := s.putReserved(r, expected, src, false)
 	if err != nil {
 		return "", err
 	}
+	return res.key, nil
+}
+
+// putReserved is like putFrom, but if reserved is true, the caller has already
+// reserved quota for the data, so none is reserved for it here.  The caller
+// keeps the reservation if the data was already stored, or if an error is
+// returned.
+func (s *CAStore) putReserved(r io.Reader, expected, src string, reserved bool) (putResult, error) {
+	start := time.Now()
+	res, err := s.limitPut(func() (putResult, error) {
+		return s.ingest(r, expected, src, reserved)
+	})
+	return s.donePut(start, res, err)
+}
+
+// limitPut calls fn, which stores an object, subject to the limits and locks
+// that apply to every Put: MaxConcurrentPuts, MaxOpenFiles and
+// ProcessLocking.
+func (s *CAStore) limitPut(fn func() (putResult, error)) (putResult, error) {
+	releasePut := s.acquirePut()
+	defer releasePut()
+	release := s.acquireFD()
+	defer release()
+	unlock, err := s.lockShared()
+	if err != nil {
+		return putResult{}, err
+	}
+	defer unlock()
+	return fn()
+}
+
+// donePut records the outcome of a Put that started at the given time - in
+// the stats, the log and the Observer - and runs the work that follows a
+// successful one.  It returns the result of the Put.
+func (s *CAStore) donePut(start time.Time, res putResult, err error) (putResult, error) {
+	if err != nil {
+		res.size = -1
+	}
+	s.observe(Operation{
+		Op:       OpPut,
+		Key:      res.key,
+		Size:     res.size,
+		Dedup:    res.dedup,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	if err != nil {
+		s.log.Debug("put failed", "err", err)
+		s.stats.putErrors.add(1)
+		return putResult{}, err
+	}
+
+	s.log.Debug("put", "key", res.key, "size", res.size, "dedup", res.dedup)
+	s.stats.puts.add(1)
+	s.stats.putBytes.add(res.size)
+	s.stats.putSizes[sizeBucket(res.size)].add(1)
+	if res.dedup {
+		s.stats.dedups.add(1)
+		s.stats.dedupBytes.add(res.size)
+	}
+	s.forgetCached(res.key)
+	s.opts.Hooks.onPut(res.key, res.size)
+	s.checkUsage()
+	s.afterPut()
+	return res, nil
+}
+
+// putResult contains the details of a successful call to ingest.
+type putResult struct {
+	key   string
+	size  int64
+	dedup bool
+}
+
+// ingest does the work of put.  If src is non-empty, r reads the file at that
+// path, which is moved into place rather than being copied to a temporary
+// file.  If reserved is true, quota has already been reserved for the data.
+func (s *CAStore) ingest(r io.Reader, expected, src string, reserved bool) (putResult, error) {
+	var (
+		tfile *tempFile
+		tname = src
+		fw    = ioutil.Discard
+		err   error
+	)
+	if src == "" {
+		// Create a temporary file to stream the data to.
+		tfile, err = s.createTemp()
+		if err != nil {
+			return putResult{}, err
+		}
+		tname = tfile.Name()
+		fw = tfile
+		if s.opts.MaxUnsynced > 0 {
+			fw = &pacedWriter{f: tfile.File, limit: s.opts.MaxUnsynced}
+		}
+	}
+	anonymous := tfile != nil && tfile.anonymous
+
+	// The caller's file is left alone if we fail, and an anonymous file
+	// disappears by itself once closed.
+	discard := func() {
+		if src == "" && !anonymous {
+			s.removeTemp(tname)
+		}
+	}
 
 	// Create a new instance of the hash.
 	hasher := s.opts.Hash()
 
 	// We use a writer that writes to both the temporary file and the hasher.
-	w := io.MultiWriter(tfile, hasher)
+	w := io.MultiWriter(fw, hasher)
+	var head *headWriter
+	if s.opts.SniffContentType {
+		head = &headWriter{limit: sniffLen}
+		w = io.MultiWriter(w, head)
+	}
+	var inline *headWriter
+	if s.opts.InlineMaxSize > 0 {
+		inline = &headWriter{limit: int(s.opts.InlineMaxSize)}
+		w = io.MultiWriter(w, inline)
+	}
+	secondary := s.newSecondaryHashers()
+	for _, h := range secondary {
+		w = io.MultiWriter(w, h)
+	}
 
 	// Copy up to the maximum amount of data.
-	_, tooLarge, err := s.copyLimited(w, r, s.opts.MaxSize)
+	if src == "" {
+		r = s.throttleWrites(r)
+	}
+	written, tooLarge, err := s.copyLimited(w, r, s.opts.MaxSize)
+
+	// Make sure the data is on disk before it is given its final name, so that
+	// a crash can never leave a truncated object in the store.
+	if err == nil && !tooLarge && s.syncData() {
+		if tfile != nil {
+			err = tfile.Sync()
+		} else {
+			err = syncPath(src)
+		}
+	}
 
-	// We're done with our temporary file here, regardless of success/failure.
-	tfile.Close()
+	// We're done writing to our temporary file here, regardless of
+	// success/failure.  An anonymous file must stay open until it is linked
+	// into place.
+	if anonymous {
+		defer tfile.Close()
+	} else if tfile != nil {
+		tfile.Close()
+	}
 
 	// If we're too large, return that.
 	if tooLarge {
-		os.Remove(tfile.Name())
-		return "", ErrSizeExceeded
+		discard()
+		return putResult{}, ErrSizeExceeded
 	}
 
 	// err should be non-nil here if there was an error copying, so we handle it.
 	if err != nil {
-		os.Remove(tfile.Name())
-		return "", err
+		discard()
+		return putResult{}, err
+	}
+
+	if written == 0 && s.opts.RejectEmpty {
+		discard()
+		return putResult{}, ErrEmpty
+	}
+	if written < s.opts.MinSize {
+		discard()
+		return putResult{}, ErrSizeTooSmall
 	}
 
 	// Everything was successful!  Get the final key from our hasher.
 	sum := hasher.Sum(nil)
-	key := hex.EncodeToString(sum)
+	key := s.opts.KeyEncoding.Encode(sum)
 
-	// Ensure the directory exists.
-	dirPath := s.transform(key)
-	if err = os.MkdirAll(dirPath, 0700); err != nil {
-		os.Remove(tfile.Name())
-		return "", err
+	if expected != "" && key != expected {
+		discard()
+		return putResult{}, ErrKeyMismatch
 	}
 
-	// Move the file to the directory.
-	if err = os.Rename(tfile.Name(), filepath.Join(dirPath, key)); err != nil {
-		os.Remove(tfile.Name())
-		return "", err
+	// Data that has been packed by Repack, or stored as a delta, is already
+	// stored.
+	if s.isPacked(key) || s.isDelta(key) {
+		s.log.Debug("data already packed", "key", key)
+		if !anonymous {
+			s.removeTemp(tname)
+		}
+		s.touch(key, written)
+		return putResult{key, written, true}, nil
+	}
+
+	// If another Put of the same data is already moving it into place, wait
+	// for it rather than making a second copy; if it fails, try again
+	// ourselves.
+	finalPath := s.blobPath(key)
+	for {
+		wait := s.claimKey(key)
+		if wait == nil {
+			break
+		}
+		<-wait
+		if _, err = os.Stat(finalPath); err == nil {
+			s.log.Debug("data stored by concurrent put", "key", key)
+			if !anonymous {
+				s.removeTemp(tname)
+			}
+			return putResult{key, written, true}, nil
+		}
+	}
+	defer s.releaseKey(key)
+
+	// Move the file to its final location, noting whether the data was
+	// already present.
+	_, err = os.Stat(finalPath)
+	dedup := err == nil
+	if dedup && s.opts.WriteOnce {
+		s.log.Info("not rewriting object in write-once store", "key", key)
+		if !anonymous {
+			s.removeTemp(tname)
+		}
+		s.touch(key, written)
+		return putResult{key, written, dedup}, nil
+	}
+	// unreserve gives back the quota reserved here if we fail.
+	unreserve := func() {
+		if !dedup && !reserved {
+			s.releaseQuota(written)
+		}
+	}
+	if !dedup {
+		// The ledger is updated by finishPut.
+		defer s.holdLedger()()
+		if !reserved {
+			if err = s.reserveQuota(written); err != nil {
+				discard()
+				return putResult{}, err
+			}
+		}
+	}
+	if inline != nil && !dedup && written <= s.opts.InlineMaxSize {
+		// Tiny objects are appended to the inline pack rather than being
+		// given a file of their own.
+		err = s.appendInline(key, inline.buf)
+		discard()
+		if err != nil {
+			unreserve()
+			return putResult{}, err
+		}
+		return s.finishPut(key, written, dedup, head, secondary)
+	}
+	if anonymous {
+		err = s.setFilePerms(tfile.File, s.opts.FileMode)
+	} else {
+		err = s.setPerms(tname, s.opts.FileMode)
+	}
+	if err != nil {
+		unreserve()
+		discard()
+		return putResult{}, err
+	}
+	if anonymous {
+		err = s.linkInto(tfile.File, finalPath)
+	} else {
+		err = s.moveInto(tname, finalPath)
+	}
+	if err != nil {
+		unreserve()
+		discard()
+		return putResult{}, err
+	}
+	s.indexAdd(key, written)
+	if s.syncDirs() {
+		if err = syncPath(filepath.Dir(finalPath)); err != nil {
+			return putResult{}, err
+		}
+	}
+
+	if s.opts.BurstMode {
+		if err = s.addPending(finalPath); err != nil {
+			return putResult{}, err
+		}
+	}
+	if s.opts.Preverify {
+		s.queuePreverify(key)
+	}
+	return s.finishPut(key, written, dedup, head, secondary)
+}
+
+// finishPut records the content type and secondary hashes of newly-stored
+// data, as the last step of ingest.
+func (s *CAStore) finishPut(key string, written int64, dedup bool, head *headWriter, secondary map[string]hash.Hash) (putResult, error) {
+	s.bloomAdd(key)
+	if !dedup {
+		s.ledgerAdd(written)
+	}
+	if head != nil {
+		if err := s.recordContentType(key, head.buf); err != nil {
+			return putResult{}, err
+		}
+	}
+	if secondary != nil {
+		if err := s.indexHashes(key, secondary); err != nil {
+			return putResult{}, err
+		}
 	}
 
 	// All done!
-	return key, nil
+	return putResult{key, written, dedup}, nil
+}
+
+// touch records that the object with the given key and size has been written
+// again, by a Put of data that was already stored without it being moved into
+// place afresh, so that WalkOptions' time filters - such as the grace period
+// of a prune - treat it as new.  Packed objects share the time of their pack,
+// so the whole pack is treated as new.  Failures are only logged, since the
+// data is stored either way.
+func (s *CAStore) touch(key string, size int64) {
+	p, _, err := s.locate(key)
+	if os.IsNotExist(err) && (s.isPacked(key) || s.isDelta(key)) {
+		p, err = s.retainedPath(key), nil
+	} else if err == nil {
+		s.indexAdd(key, size)
+	}
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(p, now, now)
+	}
+	if err != nil {
+		s.log.Warn("could not update the time an object was written", "key", key, "err", err)
+	}
 }
 
 // PutBytes is a helper function to put a byte array into the store.
@@ -210,26 +1161,99 @@ func (s *CAStore) PutString(val string) (string, error) {
 
 // Get will return an io.ReadCloser that represents the data stored with the
 // given key.  If the key does not exist in the store, then `nil` will be
-// returned instead.
+// returned instead.  Unless the data is being verified or rate limited as it
+// is read, the returned reader implements io.WriterTo, so that io.Copy to a
+// socket or file can use sendfile or splice rather than copying the data
+// through userspace.
 func (s *CAStore) Get(key string) (io.ReadCloser, error) {
+	start := time.Now()
+
+	unlock, err := s.lockShared()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if s.seed == nil && !s.mayExist(key) {
+		s.stats.getMisses.add(1)
+		s.observe(Operation{Op: OpGet, Key: key, Size: -1, Duration: time.Since(start)})
+		return nil, nil
+	}
+
 	// Try opening the file.
-	f, err := os.Open(filepath.Join(s.transform(key), key))
+	p, info, err := s.locate(key)
+	if os.IsNotExist(err) && s.seed != nil && s.validKey(key) && !s.isPacked(key) && !s.isDelta(key) {
+		if ferr := s.fetchFrom(s.seed, key); ferr == nil {
+			s.log.Debug("fetched object from seed", "key", key)
+			p, info, err = s.locate(key)
+		} else if ferr != ErrNotFound {
+			err = ferr
+		}
+	}
+	var f io.ReadCloser
+	var size int64
+	if err == nil {
+		var lf *limitedFile
+		size = info.Size()
+		if lf, err = s.openFile(p); err == nil && s.opts.VerifyOnRead {
+			f, err = s.verifyOnRead(key, lf)
+		} else if err == nil {
+			f = lf
+		}
+	} else if os.IsNotExist(err) {
+		if f, size, err = s.openPacked(key); os.IsNotExist(err) {
+			f, size, err = s.openDelta(key)
+		}
+	}
+	if err == nil {
+		f = s.throttleReads(f)
+		s.recordHit(key)
+		s.indexAccess(key)
+		s.stats.gets.add(1)
+		s.observe(Operation{Op: OpGet, Key: key, Size: size, Duration: time.Since(start)})
+		return f, nil
+	}
 	if os.IsNotExist(err) {
+		s.stats.getMisses.add(1)
+		s.observe(Operation{Op: OpGet, Key: key, Size: -1, Duration: time.Since(start)})
 		return nil, nil
 	}
-	if err != nil {
-		return nil, err
-	}
 
-	return f, err
+	s.stats.getErrors.add(1)
+	s.observe(Operation{Op: OpGet, Key: key, Size: -1, Duration: time.Since(start), Err: err})
+	return nil, err
 }
 
 // Size will return the size of the data stored with the given key.  If the key
 // does not exist in the store, then the returned value will be negative.
 func (s *CAStore) Size(key string) (int64, error) {
+	if !s.mayExist(key) {
+		return -1, nil
+	}
+
+	// The index can answer without touching the disk.
+	if s.opts.Index {
+		if ent, ok := s.indexLookup(key); ok {
+			return ent.size, nil
+		}
+		if ent, ok := s.packedEntry(key); ok {
+			return ent.size, nil
+		}
+		if ent, ok := s.deltaFor(key); ok {
+			return ent.size, nil
+		}
+		return -1, nil
+	}
+
 	// Try opening the file.
-	inf, err := os.Stat(filepath.Join(s.transform(key), key))
+	_, inf, err := s.locate(key)
 	if os.IsNotExist(err) {
+		if ent, ok := s.packedEntry(key); ok {
+			return ent.size, nil
+		}
+		if ent, ok := s.deltaFor(key); ok {
+			return ent.size, nil
+		}
 		return -1, nil
 	}
 	if err != nil {
@@ -239,13 +1263,213 @@ func (s *CAStore) Size(key string) (int64, error) {
 	return inf.Size(), nil
 }
 
+// Exists returns whether the given key exists in the store.
+func (s *CAStore) Exists(key string) (bool, error) {
+	if !s.mayExist(key) {
+		return false, nil
+	}
+
+	if s.opts.Index {
+		_, ok := s.indexLookup(key)
+		return ok || s.isPacked(key) || s.isDelta(key), nil
+	}
+
+	_, _, err := s.locate(key)
+	if os.IsNotExist(err) {
+		return s.isPacked(key) || s.isDelta(key), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete will remove the data stored with the given key from the store.  No
+// error is returned if the key does not exist.  If the key is part of a
+// retained snapshot, its data is kept aside so that it can later be restored
+// with Undelete.
+func (s *CAStore) Delete(key string) error {
+	start := time.Now()
+	size, err := s.delete(key)
+	if size >= 0 || err != nil {
+		s.observe(Operation{Op: OpDelete, Key: key, Size: size, Duration: time.Since(start), Err: err})
+	}
+	if size >= 0 {
+		s.checkUsage()
+	}
+	return err
+}
+
+// delete is the implementation of Delete.  It returns the size of the deleted
+// data, or a negative value if the key did not exist.
+func (s *CAStore) delete(key string) (int64, error) {
+	unlock, err := s.lockShared()
+	if err != nil {
+		return -1, err
+	}
+	defer unlock()
+
+	// Objects stored as deltas against this one must not lose their base.
+	if err = s.rehydrateDependents(key); err != nil {
+		return -1, err
+	}
+
+	p, info, err := s.locate(key)
+	if os.IsNotExist(err) {
+		if ent, ok := s.packedEntry(key); ok {
+			return s.deletePacked(key, ent)
+		}
+		if ent, ok := s.deltaFor(key); ok {
+			return s.deleteDelta(key, ent)
+		}
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	if s.opts.WriteOnce {
+		s.log.Warn("refusing to delete object from write-once store", "key", key)
+		return -1, ErrWriteOnce
+	}
+
+	protected, removed, err := s.remove(key, p, info)
+	if err != nil {
+		return -1, err
+	}
+	if !removed {
+		// Repack may have moved the object into a pack since we found it.
+		if ent, ok := s.packedEntry(key); ok {
+			return s.deletePacked(key, ent)
+		}
+		return -1, nil
+	}
+
+	s.log.Info("deleted object", "key", key, "size", info.Size(), "snapshotted", protected)
+	s.stats.deletes.add(1)
+	s.opts.Hooks.onDelete(key, info.Size())
+	return info.Size(), nil
+}
+
+// remove is a helper function that removes the given key's data, found at the
+// given path, from the store.  It returns whether the data was kept in the
+// attic because a snapshot refers to it, and whether it was still at the
+// given path to be removed.
+func (s *CAStore) remove(key, p string, info os.FileInfo) (bool, bool, error) {
+	defer s.holdLedger()()
+	s.repackMu.RLock()
+	defer s.repackMu.RUnlock()
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	protected, err := s.protectedKeys()
+	if err != nil {
+		return false, false, err
+	}
+	if protected[key] {
+		err = s.moveToAttic(key, p)
+	} else {
+		err = os.Remove(p)
+	}
+	if os.IsNotExist(err) {
+		// Something else got there first, so there's nothing to account for.
+		return false, false, nil
+	}
+	if err == nil && !protected[key] {
+		// Snapshotted data keeps its metadata, in case it is undeleted.
+		err = s.removeMetadata(key)
+	}
+	if err != nil {
+		return false, false, err
+	}
+	s.removeEmptyDirs(filepath.Dir(p))
+	s.indexRemove(key)
+	s.ledgerRemove(info.Size())
+	s.releaseQuota(info.Size())
+	s.forgetHits(key)
+	s.forgetCached(key)
+	return protected[key], true, nil
+}
+
 // transform is a helper function that will take the given key and return the
 // containing directory's path on-disk (including the BaseDir).
 func (s *CAStore) transform(key string) string {
-	dirs := s.opts.Transform(key)
+	dirs := s.currentTransform()(s.shardKey(key))
 	return filepath.Join(s.opts.BasePath, filepath.Join(dirs...))
 }
 
+// blobPath is a helper function that will return the full on-disk path of the
+// data stored with the given key.
+func (s *CAStore) blobPath(key string) string {
+	return filepath.Join(s.transform(key), key)
+}
+
+// metaDir is the name of the directory, relative to the BasePath, in which the
+// store keeps its own internal state.  It is never treated as containing data.
+const metaDir = ".castore"
+
+// metaPath is a helper function that returns the on-disk path of the given
+// file within the store's internal state directory, creating the directory if
+// necessary.
+func (s *CAStore) metaPath(name string) (string, error) {
+	dir := filepath.Join(s.opts.BasePath, metaDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// locate is a helper function that will find the on-disk location of the data
+// stored with the given key, trying the current Transform first and then any
+// LegacyTransforms.  If the data can't be found, the returned error will
+// satisfy os.IsNotExist.  Strings that are not valid keys are never found, so
+// that a caller-supplied key can't name a path outside the store.
+func (s *CAStore) locate(key string) (string, os.FileInfo, error) {
+	if !s.validKey(key) {
+		return "", nil, &os.PathError{Op: "stat", Path: key, Err: os.ErrNotExist}
+	}
+
+	p := s.blobPath(key)
+	inf, err := os.Stat(p)
+	if err == nil || !os.IsNotExist(err) {
+		return p, inf, err
+	}
+
+	for _, t := range s.legacyTransforms() {
+		lp := filepath.Join(s.opts.BasePath, filepath.Join(t(s.shardKey(key))...), key)
+		linf, lerr := os.Stat(lp)
+		if lerr == nil || !os.IsNotExist(lerr) {
+			return lp, linf, lerr
+		}
+	}
+
+	return p, nil, err
+}
+
+// validKey returns whether the given string could be a key produced by this
+// store - that is, the canonical encoding of a digest of the same length as
+// our hash's output.
+func (s *CAStore) validKey(key string) bool {
+	digest, ok := s.opts.KeyEncoding.Decode(key)
+	if !ok || len(digest) != s.opts.Hash().Size() {
+		return false
+	}
+	return s.opts.KeyEncoding == HexEncoding || s.opts.KeyEncoding.Encode(digest) == key
+}
+
+// shardKey returns the string that is given to the Transform for the given
+// key.  Encodings other than hex often give every key the same prefix, so
+// the hex encoding of the key's digest is used instead to keep directories
+// evenly balanced.
+func (s *CAStore) shardKey(key string) string {
+	if s.opts.KeyEncoding == HexEncoding {
+		return key
+	}
+	if digest, ok := s.opts.KeyEncoding.Decode(key); ok {
+		return hex.EncodeToString(digest)
+	}
+	return key
+}
+
 // FlatTransformFunc will place all files in the same directory.
 func FlatTransformFunc(key string) []string {
 	return []string{}
@@ -268,3 +1492,19 @@ func DepthTransformFunc(depth int) TransformFunction {
 		return ret
 	}
 }
+
+// ShardTransformFunc is a generalisation of DepthTransformFunc that will split
+// the input key into the given number of strings of the given width, and use
+// those as directories.  For example, for the input key "abcdef",
+// ShardTransformFunc(2, 3) will return []string{"abc", "def"}, and
+// ShardTransformFunc(depth, 2) is the same as DepthTransformFunc(depth).
+func ShardTransformFunc(depth, width int) TransformFunction {
+	return func(key string) []string {
+		ret := []string{}
+		for i := 0; i < depth; i++ {
+			ret = append(ret, key[i*width:(i+1)*width])
+		}
+
+		return ret
+	}
+}
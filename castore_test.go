@@ -85,6 +85,72 @@ func TestMaxSize(t *testing.T) {
 	assert.Equal(t, "fc189cc673eef6d7ecee4da629f1ed1386479b238dba2ba444e1c7cdde5419b6", key)
 }
 
+func TestNoLimit(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-2"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		MaxSize:  NoLimit,
+	})
+	assert.NoError(t, err)
+
+	// Larger than the default limit.
+	const size = 11 * 1024 * 1024
+	key, err := s.Put(io.LimitReader(infiniteReader{'A'}, size))
+	assert.NoError(t, err)
+	n, err := s.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(size), n)
+}
+
+func TestMinSize(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-9"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		MinSize:  int64(len(TEST_VALUE)),
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString("short")
+	assert.Equal(t, ErrSizeTooSmall, err)
+	assert.Equal(t, "", key)
+
+	// A write with the exact size of the limit should succeed
+	key, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+}
+
+func TestRejectEmpty(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-8"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// By default, empty objects are allowed.
+	key, err := s.PutString("")
+	assert.NoError(t, err)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", key)
+	assert.NoError(t, s.Delete(key))
+
+	s, err = New(Options{BasePath: tdir, RejectEmpty: true})
+	assert.NoError(t, err)
+
+	key, err = s.PutString("")
+	assert.Equal(t, ErrEmpty, err)
+	assert.Equal(t, "", key)
+	exists, err := s.Exists("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+}
+
 func TestBadOpts(t *testing.T) {
 	_, err := New(Options{
 		BasePath: "",
@@ -162,3 +228,145 @@ func TestSize(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, size < 0)
 }
+
+func TestDelete(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-5"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Delete(key))
+
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	// Deleting again is not an error.
+	assert.NoError(t, s.Delete(key))
+}
+
+func TestExists(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-6"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = s.Exists("not exist")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestObserver(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-7"))
+	defer os.RemoveAll(tdir)
+
+	var ops []Operation
+	s, err := New(Options{
+		BasePath: tdir,
+		Observer: func(op Operation) {
+			op.Duration = 0
+			ops = append(ops, op)
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	r.Close()
+
+	assert.NoError(t, s.Delete(TEST_KEY))
+
+	// Deleting a nonexistent key is not reported.
+	assert.NoError(t, s.Delete(TEST_KEY))
+
+	size := int64(len(TEST_VALUE))
+	assert.Equal(t, []Operation{
+		{Op: OpPut, Key: TEST_KEY, Size: size},
+		{Op: OpPut, Key: TEST_KEY, Size: size, Dedup: true},
+		{Op: OpGet, Key: TEST_KEY, Size: size},
+		{Op: OpDelete, Key: TEST_KEY, Size: size},
+	}, ops)
+}
+
+func TestPutVerified(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.PutVerified(TEST_KEY, strings.NewReader(TEST_VALUE)))
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Nothing is stored if the data doesn't match.
+	other := "0000000000000000000000000000000000000000000000000000000000000000"
+	assert.Equal(t, ErrKeyMismatch, s.PutVerified(other, strings.NewReader("corrupted")))
+	assert.Equal(t, ErrKeyMismatch, s.PutVerified("", strings.NewReader("corrupted")))
+	var keys []string
+	assert.NoError(t, s.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{TEST_KEY}, keys)
+}
+
+func TestInvalidKeysNotFound(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-invalid"))
+	defer os.RemoveAll(tdir)
+
+	base := filepath.Join(tdir, "store")
+	victim := filepath.Join(tdir, "victim.txt")
+	assert.NoError(t, ioutil.WriteFile(victim, []byte("secret"), 0644))
+
+	s, err := New(Options{
+		BasePath:  base,
+		Transform: DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+
+	for _, key := range []string{"../victim.txt", "../../victim.txt", "ab", ""} {
+		r, err := s.Get(key)
+		assert.NoError(t, err, key)
+		assert.Nil(t, r, key)
+
+		size, err := s.Size(key)
+		assert.NoError(t, err, key)
+		assert.True(t, size < 0, key)
+
+		exists, err := s.Exists(key)
+		assert.NoError(t, err, key)
+		assert.False(t, exists, key)
+
+		m, err := s.GetMapped(key)
+		assert.NoError(t, err, key)
+		assert.Nil(t, m, key)
+
+		assert.NoError(t, s.Delete(key), key)
+	}
+
+	_, err = os.Stat(victim)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,202 @@
+package castorecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/andrew-d/castore"
+)
+
+// KeySize is the size of the secret key, in bytes.
+const KeySize = 32
+
+// ivSize is the size of the synthetic IV at the start of each object.
+const ivSize = aes.BlockSize
+
+var (
+	// ErrKeySize is returned by New if the key is not KeySize bytes long.
+	ErrKeySize = errors.New("castorecrypt: key must be 32 bytes")
+
+	// ErrAuthentication is returned when reading an object whose ciphertext
+	// has been modified, or which was written with a different key.
+	ErrAuthentication = errors.New("castorecrypt: message authentication failed")
+)
+
+// Store is a castore.Store that encrypts data before writing it to an
+// underlying store, and decrypts it when reading it back.
+//
+// Each object is encrypted with AES-256 in CTR mode, using as its IV an
+// HMAC-SHA256 of the plaintext (truncated to 16 bytes), which is stored at
+// the start of the object.  This is a deterministic authenticated encryption
+// scheme in the style of SIV: the IV doubles as the authentication tag, and
+// is checked once the whole object has been decrypted.
+type Store struct {
+	s      castore.Store
+	encKey []byte
+	macKey []byte
+
+	// TempDir is the directory used to hold plaintext while it is being
+	// encrypted, since each object must be read twice; callers may change it
+	// before using the Store.  If empty, the default directory for temporary
+	// files is used.
+	TempDir string
+}
+
+var _ castore.Store = (*Store)(nil)
+
+// New returns a Store that encrypts data stored in s with the given secret
+// key, which must be KeySize bytes long.
+func New(s castore.Store, key []byte) (*Store, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	return &Store{
+		s:      s,
+		encKey: deriveKey(key, "castorecrypt encryption"),
+		macKey: deriveKey(key, "castorecrypt authentication"),
+	}, nil
+}
+
+// deriveKey derives a subkey for the given purpose from the secret key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	io.WriteString(mac, purpose)
+	return mac.Sum(nil)
+}
+
+// Put will encrypt the data from the given io.Reader, insert it into the
+// underlying store, and return its key.  The plaintext is staged in a
+// temporary file in TempDir while its IV is computed.
+func (e *Store) Put(r io.Reader) (string, error) {
+	tfile, err := ioutil.TempFile(e.TempDir, "castorecrypt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tfile.Name())
+	defer tfile.Close()
+
+	mac := hmac.New(sha256.New, e.macKey)
+	if _, err = io.Copy(io.MultiWriter(tfile, mac), r); err != nil {
+		return "", err
+	}
+	iv := mac.Sum(nil)[:ivSize]
+	if _, err = tfile.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	stream, err := e.stream(iv)
+	if err != nil {
+		return "", err
+	}
+	return e.s.Put(io.MultiReader(
+		bytes.NewReader(iv),
+		cipher.StreamReader{S: stream, R: tfile},
+	))
+}
+
+// Get will return a reader for the decrypted data stored with the given key,
+// or nil if it does not exist.  Since the data can only be authenticated once
+// it has all been read, the reader returns ErrAuthentication in place of the
+// final io.EOF if it has been tampered with; callers must not trust the data
+// until they have seen io.EOF.
+func (e *Store) Get(key string) (io.ReadCloser, error) {
+	rc, err := e.s.Get(key)
+	if err != nil || rc == nil {
+		return rc, err
+	}
+
+	iv := make([]byte, ivSize)
+	if _, err = io.ReadFull(rc, iv); err != nil {
+		rc.Close()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrAuthentication
+		}
+		return nil, err
+	}
+	stream, err := e.stream(iv)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &reader{
+		rc:  rc,
+		r:   cipher.StreamReader{S: stream, R: rc},
+		iv:  iv,
+		mac: hmac.New(sha256.New, e.macKey),
+	}, nil
+}
+
+// Size will return the size of the plaintext stored with the given key, or
+// -1 if it does not exist.
+func (e *Store) Size(key string) (int64, error) {
+	size, err := e.s.Size(key)
+	if err != nil || size < 0 {
+		return size, err
+	}
+	return plainSize(size), nil
+}
+
+// Exists will return whether the given key exists in the underlying store.
+func (e *Store) Exists(key string) (bool, error) {
+	return e.s.Exists(key)
+}
+
+// Delete will delete the given key from the underlying store.
+func (e *Store) Delete(key string) error {
+	return e.s.Delete(key)
+}
+
+// Walk will call fn for every object in the underlying store, with the size
+// of its plaintext.
+func (e *Store) Walk(fn castore.WalkFunc) error {
+	return e.s.Walk(func(key string, size int64) error {
+		return fn(key, plainSize(size))
+	})
+}
+
+// stream returns the keystream for an object with the given IV.
+func (e *Store) stream(iv []byte) (cipher.Stream, error) {
+	block, err := aes.NewCipher(e.encKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewCTR(block, iv), nil
+}
+
+// plainSize returns the size of the plaintext of an object of the given
+// size.
+func plainSize(size int64) int64 {
+	if size < ivSize {
+		return 0
+	}
+	return size - ivSize
+}
+
+// reader decrypts an object, and authenticates it once it reaches the end.
+type reader struct {
+	rc  io.ReadCloser
+	r   io.Reader
+	iv  []byte
+	mac hash.Hash
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.mac.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(r.mac.Sum(nil)[:ivSize], r.iv) {
+		err = ErrAuthentication
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,131 @@
+package castorecrypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const TEST_VALUE = "foobar"
+
+func must_s(s string, err error) string {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// newRemote returns a store to use as the remote store, and its base path.
+func newRemote(t *testing.T) (*castore.CAStore, string) {
+	tdir := must_s(ioutil.TempDir("", "castorecrypt-test"))
+	remote, err := castore.New(castore.Options{BasePath: tdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return remote, tdir
+}
+
+func TestRoundTrip(t *testing.T) {
+	remote, tdir := newRemote(t)
+	defer os.RemoveAll(tdir)
+
+	s, err := New(remote, bytes.Repeat([]byte{1}, KeySize))
+	assert.NoError(t, err)
+
+	key, err := s.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+
+	// The remote store only has the ciphertext.
+	r, err := remote.Get(key)
+	assert.NoError(t, err)
+	ciphertext, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Len(t, ciphertext, len(TEST_VALUE)+ivSize)
+	assert.NotContains(t, string(ciphertext), TEST_VALUE)
+
+	r, err = s.Get(key)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	size, err := s.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	var sizes []int64
+	assert.NoError(t, s.Walk(func(key string, size int64) error {
+		sizes = append(sizes, size)
+		return nil
+	}))
+	assert.Equal(t, []int64{int64(len(TEST_VALUE))}, sizes)
+
+	r, err = s.Get("0000000000000000000000000000000000000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
+
+func TestDedup(t *testing.T) {
+	remote, tdir := newRemote(t)
+	defer os.RemoveAll(tdir)
+
+	a, err := New(remote, bytes.Repeat([]byte{1}, KeySize))
+	assert.NoError(t, err)
+	b, err := New(remote, bytes.Repeat([]byte{2}, KeySize))
+	assert.NoError(t, err)
+
+	// The same data under the same key is deduplicated.
+	k1, err := a.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	k2, err := a.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, k1, k2)
+
+	// Under another key it is unrelated, and can't be read.
+	k3, err := b.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k3)
+
+	r, err := b.Get(k1)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, ErrAuthentication, err)
+}
+
+func TestTampering(t *testing.T) {
+	remote, tdir := newRemote(t)
+	defer os.RemoveAll(tdir)
+
+	s, err := New(remote, bytes.Repeat([]byte{1}, KeySize))
+	assert.NoError(t, err)
+
+	key, err := s.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+
+	// Modify the ciphertext in place, bypassing the remote store.
+	path := filepath.Join(tdir, key)
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 1
+	assert.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, ErrAuthentication, err)
+}
+
+func TestKeySize(t *testing.T) {
+	_, err := New(nil, []byte("short"))
+	assert.Equal(t, ErrKeySize, err)
+}
@@ -0,0 +1,28 @@
+/*
+Package castorecrypt provides client-side encryption for a castore.Store, so
+that a remote store never sees the plaintext of the data it holds.
+
+Data is encrypted deterministically under a secret key held by the client:
+the same plaintext always produces the same ciphertext for the same key, so
+the remote store still deduplicates data from clients sharing a key, but
+cannot read it, and data from clients with different keys is unrelated.  The
+keys returned by Put are those of the ciphertext, as computed by the remote
+store.
+
+Usage:
+
+	remote := castoregrpc.NewClient(conn)
+	s, err := castorecrypt.New(remote, key)
+	if err != nil {
+		return err
+	}
+	k, err := s.Put(strings.NewReader("secret"))
+	...
+	r, err := s.Get(k)
+
+The remote store learns the size of each object (which is 16 bytes larger
+than the plaintext), and whether two objects written with the same key have
+the same contents, but nothing else.  Data is authenticated, so tampering is
+detected when an object is read back.
+*/
+package castorecrypt
@@ -0,0 +1,462 @@
+package castoregrpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/andrew-d/castore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Client is a castore.Store that is backed by a remote store, accessed over
+// gRPC.
+type Client struct {
+	c    CastoreClient
+	opts ClientOptions
+}
+
+// ClientOptions configures a Client created with NewClientWithOptions.
+type ClientOptions struct {
+	// Tenant, if not empty, is the tenant whose store is accessed on a
+	// server created with NewTenantServer.  It is sent in the
+	// TenantMetadataKey metadata of each call.
+	Tenant string
+
+	// PartSize is the size of the parts that Put splits large objects into,
+	// so that they can be sent over several streams at once.  Objects no
+	// larger than this are sent with a single stream, as are all objects if
+	// the server's store doesn't support multipart uploads.  Defaults to
+	// 8MiB.
+	PartSize int64
+
+	// Parallelism is the number of parts that Put sends at once.  Each part
+	// in flight is held in memory.  Defaults to 4.
+	Parallelism int
+}
+
+const (
+	defaultPartSize    = 8 * 1024 * 1024
+	defaultParallelism = 4
+)
+
+var (
+	_ castore.Store       = (*Client)(nil)
+	_ castore.MultiGetter = (*Client)(nil)
+)
+
+// NewClient returns a Client that uses the given gRPC connection.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return NewClientWithOptions(cc, ClientOptions{})
+}
+
+// NewTenantClient returns a Client that uses the given gRPC connection to
+// access the given tenant's store on a server created with NewTenantServer.
+// The tenant is sent in the TenantMetadataKey metadata of each call.
+func NewTenantClient(cc grpc.ClientConnInterface, tenant string) *Client {
+	return NewClientWithOptions(cc, ClientOptions{Tenant: tenant})
+}
+
+// NewClientWithOptions returns a Client that uses the given gRPC connection,
+// configured with the given options.
+func NewClientWithOptions(cc grpc.ClientConnInterface, opts ClientOptions) *Client {
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultPartSize
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = defaultParallelism
+	}
+	return &Client{c: NewCastoreClient(cc), opts: opts}
+}
+
+// context returns the context used for calls to the server.
+func (c *Client) context() context.Context {
+	ctx := context.Background()
+	if c.opts.Tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, TenantMetadataKey, c.opts.Tenant)
+	}
+	return ctx
+}
+
+// Put will insert the data from the given io.Reader into the remote store,
+// and return its key.  Data larger than the PartSize option is uploaded in
+// parts, several at a time, and the server hashes the parts together once
+// they have all arrived.
+func (c *Client) Put(r io.Reader) (string, error) {
+	first, err := readPart(r, c.opts.PartSize)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(first)) < c.opts.PartSize {
+		return c.putStream(bytes.NewReader(first))
+	}
+
+	key, err := c.putParts(first, r)
+	if err == errNoParts {
+		return c.putStream(io.MultiReader(bytes.NewReader(first), r))
+	}
+	return key, err
+}
+
+// readPart reads up to size bytes from the given io.Reader.  It only returns
+// fewer if the end of the data is reached.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	return ioutil.ReadAll(io.LimitReader(r, size))
+}
+
+// putParts uploads the given first part, and the rest of the data from the
+// given io.Reader, as a multipart upload.  If the server doesn't support
+// multipart uploads, it returns errNoParts before reading anything.
+func (c *Client) putParts(first []byte, r io.Reader) (string, error) {
+	ctx, cancel := context.WithCancel(c.context())
+	defer cancel()
+
+	begin, err := c.c.BeginUpload(ctx, &BeginUploadRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return "", errNoParts
+	}
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	id := begin.Id
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+		mu.Unlock()
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr
+	}
+
+	// Each slot in sem is a part being uploaded.
+	sem := make(chan struct{}, c.opts.Parallelism)
+	var parts []int32
+	data := first
+	for part := int32(1); len(data) > 0 && failed() == nil; part++ {
+		parts = append(parts, part)
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(part int32, data []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := c.uploadPart(ctx, id, part, data); err != nil {
+				fail(err)
+			}
+		}(part, data)
+
+		if data, err = readPart(r, c.opts.PartSize); err != nil {
+			fail(err)
+		}
+	}
+	wg.Wait()
+
+	if err = failed(); err == nil {
+		var resp *CommitUploadResponse
+		if resp, err = c.c.CommitUpload(ctx, &CommitUploadRequest{Id: id, Parts: parts}); err == nil {
+			return resp.Key, nil
+		}
+		err = fromStatus(err)
+	}
+
+	// Don't leave the parts that did arrive behind on the server.
+	c.c.AbortUpload(c.context(), &AbortUploadRequest{Id: id})
+	return "", err
+}
+
+// uploadPart sends the given data as the given part of a multipart upload.
+func (c *Client) uploadPart(ctx context.Context, id string, part int32, data []byte) error {
+	stream, err := c.c.UploadPart(ctx)
+	if err != nil {
+		return fromStatus(err)
+	}
+
+	req := &UploadPartRequest{Id: id, Part: part}
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunkSize {
+			n = chunkSize
+		}
+		req.Data, data = data[:n], data[n:]
+		if err = stream.Send(req); err == io.EOF {
+			// The server has aborted the stream; the real error is
+			// returned from CloseAndRecv.
+			break
+		} else if err != nil {
+			return fromStatus(err)
+		}
+		req = &UploadPartRequest{}
+	}
+
+	_, err = stream.CloseAndRecv()
+	return fromStatus(err)
+}
+
+// putStream sends the data from the given io.Reader to the server with a
+// single Put stream.
+func (c *Client) putStream(r io.Reader) (string, error) {
+	ctx, cancel := context.WithCancel(c.context())
+	defer cancel()
+
+	stream, err := c.c.Put(ctx)
+	if err != nil {
+		return "", fromStatus(err)
+	}
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if err = stream.Send(&PutRequest{Data: buf[:n]}); err == io.EOF {
+				// The server has aborted the stream; the real error is
+				// returned from CloseAndRecv.
+				break
+			} else if err != nil {
+				return "", fromStatus(err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return "", rerr
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return "", fromStatus(err)
+	}
+	return resp.Key, nil
+}
+
+// getReader adapts a Get stream into an io.ReadCloser.
+type getReader struct {
+	stream grpc.ServerStreamingClient[GetResponse]
+	cancel context.CancelFunc
+	buf    []byte
+	err    error
+}
+
+func (r *getReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		resp, err := r.stream.Recv()
+		if err == io.EOF {
+			r.err = io.EOF
+			continue
+		}
+		if err != nil {
+			r.err = fromStatus(err)
+			continue
+		}
+		r.buf = resp.Data
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *getReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Get will return an io.ReadCloser that streams the data stored with the given
+// key from the remote store.  If the key does not exist, then `nil` will be
+// returned instead.
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(c.context())
+
+	stream, err := c.c.Get(ctx, &GetRequest{Key: key})
+	if err != nil {
+		cancel()
+		return nil, fromStatus(err)
+	}
+
+	// Receive the first message to find out whether the key exists.
+	r := &getReader{stream: stream, cancel: cancel}
+	resp, err := stream.Recv()
+	switch {
+	case err == io.EOF:
+		r.err = io.EOF
+	case status.Code(err) == codes.NotFound:
+		cancel()
+		return nil, nil
+	case err != nil:
+		cancel()
+		return nil, fromStatus(err)
+	default:
+		r.buf = resp.Data
+	}
+	return r, nil
+}
+
+// GetMulti will return a reader for the data stored with each of the given
+// keys in the remote store, in the same order, along with the error, if any,
+// from retrieving it.  All of the objects are fetched with a single call, so
+// this takes far fewer round trips than calling Get for each key, but their
+// data is held in memory until the readers are closed.
+func (c *Client) GetMulti(keys []string) ([]io.ReadCloser, []error) {
+	rs := make([]io.ReadCloser, len(keys))
+	errs := make([]error, len(keys))
+
+	ctx, cancel := context.WithCancel(c.context())
+	defer cancel()
+
+	// Data for each key that exists, and whether we've heard about each key.
+	bufs := make([]*bytes.Buffer, len(keys))
+	seen := make([]bool, len(keys))
+
+	stream, err := c.c.GetMulti(ctx, &GetMultiRequest{Keys: keys})
+	for err == nil {
+		var resp *GetMultiResponse
+		if resp, err = stream.Recv(); err != nil {
+			break
+		}
+		i := int(resp.Index)
+		if i < 0 || i >= len(keys) {
+			err = fmt.Errorf("castoregrpc: invalid index %d in GetMulti response", i)
+			break
+		}
+		seen[i] = true
+		switch {
+		case resp.Code != 0:
+			bufs[i] = nil
+			errs[i] = fromStatus(status.Error(codes.Code(resp.Code), resp.Error))
+		case !resp.Missing:
+			if bufs[i] == nil {
+				bufs[i] = new(bytes.Buffer)
+			}
+			bufs[i].Write(resp.Data)
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	err = fromStatus(err)
+
+	for i := range keys {
+		switch {
+		case !seen[i]:
+			if err == nil {
+				err = fmt.Errorf("castoregrpc: no GetMulti response for key %q", keys[i])
+			}
+			errs[i] = err
+		case bufs[i] != nil:
+			rs[i] = ioutil.NopCloser(bytes.NewReader(bufs[i].Bytes()))
+		}
+	}
+	return rs, errs
+}
+
+// Size will return the size of the data stored with the given key in the
+// remote store.  If the key does not exist, the returned value will be
+// negative.
+func (c *Client) Size(key string) (int64, error) {
+	resp, err := c.c.Exists(c.context(), &ExistsRequest{Key: key})
+	if err != nil {
+		return 0, fromStatus(err)
+	}
+	if !resp.Exists {
+		return -1, nil
+	}
+	return resp.Size, nil
+}
+
+// Exists returns whether the given key exists in the remote store.
+func (c *Client) Exists(key string) (bool, error) {
+	resp, err := c.c.Exists(c.context(), &ExistsRequest{Key: key})
+	if err != nil {
+		return false, fromStatus(err)
+	}
+	return resp.Exists, nil
+}
+
+// Delete will remove the data stored with the given key from the remote store.
+func (c *Client) Delete(key string) error {
+	_, err := c.c.Delete(c.context(), &DeleteRequest{Key: key})
+	return fromStatus(err)
+}
+
+// Walk will call fn for every object in the remote store.
+func (c *Client) Walk(fn castore.WalkFunc) error {
+	ctx, cancel := context.WithCancel(c.context())
+	defer cancel()
+
+	stream, err := c.c.List(ctx, &ListRequest{})
+	if err != nil {
+		return fromStatus(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		if err = fn(resp.Key, resp.Size); err != nil {
+			return err
+		}
+	}
+}
+
+// fromStatus converts gRPC status errors back into the errors returned by the
+// store, where possible.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		if status.Convert(err).Message() == castore.ErrNoSuchUpload.Error() {
+			return castore.ErrNoSuchUpload
+		}
+	case codes.FailedPrecondition:
+		if status.Convert(err).Message() == castore.ErrWriteOnce.Error() {
+			return castore.ErrWriteOnce
+		}
+	case codes.ResourceExhausted:
+		if status.Convert(err).Message() == castore.ErrQuotaExceeded.Error() {
+			return castore.ErrQuotaExceeded
+		}
+		return castore.ErrSizeExceeded
+	case codes.InvalidArgument:
+		switch status.Convert(err).Message() {
+		case castore.ErrKeyMismatch.Error():
+			return castore.ErrKeyMismatch
+		case castore.ErrEmpty.Error():
+			return castore.ErrEmpty
+		case castore.ErrSizeTooSmall.Error():
+			return castore.ErrSizeTooSmall
+		case castore.ErrInvalidKey.Error():
+			return castore.ErrInvalidKey
+		case castore.ErrInvalidPart.Error():
+			return castore.ErrInvalidPart
+		}
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+/*
+Package castoregrpc exposes a castore.Store over gRPC.  It contains both a
+server implementation, which wraps any castore.Store, and a client which itself
+implements castore.Store - so a remote store can be used anywhere a local one
+can.  NewTenantServer and NewTenantClient do the same for a set of per-tenant
+stores (see the castoretenant package).
+
+The service definition is in castore.proto; regenerate the .pb.go files with:
+
+	protoc --go_out=paths=source_relative:. --go-grpc_out=paths=source_relative:. castore.proto
+*/
+package castoregrpc
@@ -0,0 +1,306 @@
+package castoregrpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/andrew-d/castore/castoretenant"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func newClient(t *testing.T, opts castore.Options) (*Client, func()) {
+	tdir, err := ioutil.TempDir("", "castoregrpc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.BasePath = tdir
+
+	s, err := castore.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, stop := serve(t, s, ClientOptions{})
+	return c, func() {
+		stop()
+		os.RemoveAll(tdir)
+	}
+}
+
+// serve serves the given store, and returns a client for it with the given
+// options.
+func serve(t *testing.T, s castore.Store, opts ClientOptions) (*Client, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	Register(gs, s)
+	go gs.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewClientWithOptions(conn, opts), func() {
+		conn.Close()
+		gs.Stop()
+	}
+}
+
+func TestClientServer(t *testing.T) {
+	c, cleanup := newClient(t, castore.Options{MaxSize: 256 * 1024})
+	defer cleanup()
+
+	key, err := c.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	r, err := c.Get(TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	exists, err := c.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	size, err := c.Size(TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	var keys []string
+	assert.NoError(t, c.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{TEST_KEY}, keys)
+
+	// Multi-chunk data
+	big := strings.Repeat("a", 3*chunkSize+1)
+	key, err = c.Put(strings.NewReader(big))
+	assert.NoError(t, err)
+	r, err = c.Get(key)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, big, string(data))
+
+	// Too large
+	_, err = c.Put(strings.NewReader(strings.Repeat("a", 256*1024+1)))
+	assert.Equal(t, castore.ErrSizeExceeded, err)
+
+	// Delete and not-found
+	assert.NoError(t, c.Delete(TEST_KEY))
+	r, err = c.Get(TEST_KEY)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+	size, err = c.Size(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, size < 0)
+}
+
+func TestInvalidKeys(t *testing.T) {
+	c, cleanup := newClient(t, castore.Options{Transform: castore.DepthTransformFunc(2)})
+	defer cleanup()
+
+	for _, key := range []string{"../../../../../../etc/hostname", "../../../../../../etc/passwd", "ab", ""} {
+		_, err := c.Get(key)
+		assert.Equal(t, castore.ErrInvalidKey, err, key)
+		_, err = c.Size(key)
+		assert.Equal(t, castore.ErrInvalidKey, err, key)
+		_, err = c.Exists(key)
+		assert.Equal(t, castore.ErrInvalidKey, err, key)
+		assert.Equal(t, castore.ErrInvalidKey, c.Delete(key), key)
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	c, cleanup := newClient(t, castore.Options{})
+	defer cleanup()
+
+	var keys []string
+	for _, val := range []string{"one", "two", "three"} {
+		key, err := c.Put(strings.NewReader(val))
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	keys = append(keys, strings.Repeat("0", 64))
+
+	rs, errs := castore.GetMulti(c, keys)
+	assert.Len(t, rs, 4)
+	for i, val := range []string{"one", "two", "three"} {
+		assert.NoError(t, errs[i])
+		data, err := ioutil.ReadAll(rs[i])
+		rs[i].Close()
+		assert.NoError(t, err)
+		assert.Equal(t, val, string(data))
+	}
+	assert.NoError(t, errs[3])
+	assert.Nil(t, rs[3])
+
+	// Empty and multi-chunk objects, and keys that can't be read.
+	empty, err := c.Put(strings.NewReader(""))
+	assert.NoError(t, err)
+	large := strings.Repeat("x", 3*chunkSize+1)
+	largeKey, err := c.Put(strings.NewReader(large))
+	assert.NoError(t, err)
+	rs, errs = c.GetMulti([]string{largeKey, "../escape", empty, keys[0]})
+	assert.Len(t, rs, 4)
+	for i, val := range []string{large, "", "", "one"} {
+		if i == 1 {
+			assert.Equal(t, castore.ErrInvalidKey, errs[i])
+			assert.Nil(t, rs[i])
+			continue
+		}
+		assert.NoError(t, errs[i])
+		if assert.NotNil(t, rs[i]) {
+			data, err := ioutil.ReadAll(rs[i])
+			rs[i].Close()
+			assert.NoError(t, err)
+			assert.Equal(t, val, string(data))
+		}
+	}
+}
+
+func TestTenantServer(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoregrpc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tdir)
+
+	tenants, err := castoretenant.New(castoretenant.Options{
+		BasePath: tdir,
+		Store:    castore.Options{Quota: 10},
+	})
+	assert.NoError(t, err)
+	defer tenants.Close()
+	assert.NoError(t, tenants.Create("acme"))
+	assert.NoError(t, tenants.Create("other"))
+
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	RegisterCastoreServer(gs, NewTenantServer(tenants, nil))
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	acme := NewTenantClient(conn, "acme")
+	other := NewTenantClient(conn, "other")
+
+	key, err := acme.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	exists, err := acme.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = other.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = acme.Put(strings.NewReader("too much"))
+	assert.Equal(t, castore.ErrQuotaExceeded, err)
+	otherKey, err := other.Put(strings.NewReader("too much"))
+	assert.NoError(t, err)
+
+	// Keys that escape the tenant's store are rejected.
+	escape := "../other/" + otherKey
+	_, err = acme.Get(escape)
+	assert.Equal(t, castore.ErrInvalidKey, err)
+	_, err = acme.Exists(escape)
+	assert.Equal(t, castore.ErrInvalidKey, err)
+	assert.Equal(t, castore.ErrInvalidKey, acme.Delete(escape))
+	exists, err = other.Exists(otherKey)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = NewClient(conn).Exists(TEST_KEY)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = NewTenantClient(conn, "../escape").Exists(TEST_KEY)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = NewTenantClient(conn, "unknown").Put(strings.NewReader(TEST_VALUE))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestPutParts(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoregrpc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{BasePath: tdir, MaxSize: 10 * 1024})
+	assert.NoError(t, err)
+	c, stop := serve(t, s, ClientOptions{PartSize: 1024, Parallelism: 3})
+	defer stop()
+
+	data := make([]byte, 5*1024+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	sum := sha256.Sum256(data)
+
+	for _, size := range []int{0, 1000, 1024, 2048, len(data)} {
+		key, err := c.Put(bytes.NewReader(data[:size]))
+		assert.NoError(t, err, size)
+		sum := sha256.Sum256(data[:size])
+		assert.Equal(t, hex.EncodeToString(sum[:]), key, size)
+
+		r, err := c.Get(key)
+		if assert.NoError(t, err) && assert.NotNil(t, r) {
+			got, err := ioutil.ReadAll(r)
+			r.Close()
+			assert.NoError(t, err)
+			assert.Equal(t, data[:size], got, size)
+		}
+	}
+
+	// The size limit applies to the whole object, not just each part, and a
+	// failed upload is discarded.
+	_, err = c.Put(bytes.NewReader(make([]byte, 10*1024+1)))
+	assert.Equal(t, castore.ErrSizeExceeded, err)
+	n, err := s.PruneUploads(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Stores that don't support multipart uploads are sent a single stream.
+	c2, stop2 := serve(t, struct{ castore.Store }{s}, ClientOptions{PartSize: 1024})
+	defer stop2()
+	key, err := c2.Put(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), key)
+}
@@ -0,0 +1,389 @@
+package castoregrpc
+
+import (
+	"context"
+	"io"
+
+	"github.com/andrew-d/castore"
+	"github.com/andrew-d/castore/castoretenant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// chunkSize is the size of the data chunks sent in streaming messages.
+const chunkSize = 64 * 1024
+
+// TenantMetadataKey is the gRPC metadata key that identifies the tenant of a
+// call to a server created by NewTenantServer, by default.
+const TenantMetadataKey = "castore-tenant"
+
+// server implements CastoreServer on top of a castore.Store.
+type server struct {
+	UnimplementedCastoreServer
+
+	// store returns the store that a call operates on, and a function that
+	// must be called once the call has finished with it.
+	store func(ctx context.Context) (castore.Store, func(), error)
+}
+
+// NewServer returns a CastoreServer that serves the given store.
+func NewServer(s castore.Store) CastoreServer {
+	return &server{store: func(context.Context) (castore.Store, func(), error) {
+		return s, func() {}, nil
+	}}
+}
+
+// NewTenantServer returns a CastoreServer that serves each tenant in the given
+// set from its own store.  The tenant of each call is determined by the given
+// function - for example, from the claims in an authentication token - and
+// if it returns an error, the call fails with that error.  If the function
+// is nil, the tenant is taken from the TenantMetadataKey metadata of the call
+// (see NewTenantClient).  Calls for tenants that have not been created with
+// castoretenant.Tenants.Create fail with a NotFound status.
+func NewTenantServer(t *castoretenant.Tenants, tenant func(ctx context.Context) (string, error)) CastoreServer {
+	if tenant == nil {
+		tenant = tenantFromMetadata
+	}
+	return &server{store: func(ctx context.Context) (castore.Store, func(), error) {
+		name, err := tenant(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, release, err := t.Acquire(name)
+		if err == castoretenant.ErrInvalidTenant {
+			return nil, nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if err == castoretenant.ErrUnknownTenant {
+			return nil, nil, status.Error(codes.NotFound, err.Error())
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, release, nil
+	}}
+}
+
+// tenantFromMetadata returns the tenant given in the metadata of a call.
+func tenantFromMetadata(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vals := md.Get(TenantMetadataKey); len(vals) == 1 {
+		return vals[0], nil
+	}
+	return "", status.Error(codes.PermissionDenied, "castoregrpc: no tenant specified")
+}
+
+// Register is a helper function that will register a server for the given
+// store with the given gRPC server.
+func Register(gs grpc.ServiceRegistrar, s castore.Store) {
+	RegisterCastoreServer(gs, NewServer(s))
+}
+
+// streamReader adapts a client stream of data chunks, such as a Put stream,
+// into an io.Reader.
+type streamReader struct {
+	recv func() ([]byte, error)
+	buf  []byte
+}
+
+func (r *streamReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		data, err := r.recv()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = data
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (s *server) Put(stream grpc.ClientStreamingServer[PutRequest, PutResponse]) error {
+	st, release, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	key, err := st.Put(&streamReader{recv: func() ([]byte, error) {
+		req, err := stream.Recv()
+		return req.GetData(), err
+	}})
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&PutResponse{Key: key})
+}
+
+func (s *server) Get(req *GetRequest, stream grpc.ServerStreamingServer[GetResponse]) error {
+	st, release, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	if err := checkKey(st, req.Key); err != nil {
+		return err
+	}
+	r, err := st.Get(req.Key)
+	if err != nil {
+		return toStatus(err)
+	}
+	if r == nil {
+		return status.Error(codes.NotFound, "key not found")
+	}
+	defer r.Close()
+
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if serr := stream.Send(&GetResponse{Data: buf[:n]}); serr != nil {
+				return serr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+func (s *server) GetMulti(req *GetMultiRequest, stream grpc.ServerStreamingServer[GetMultiResponse]) error {
+	st, release, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+
+	buf := make([]byte, chunkSize)
+	for i, key := range req.Keys {
+		if err := sendOne(st, int32(i), key, buf, stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendOne sends the responses for a single key of a GetMulti call, including
+// any error from reading it.  It only returns an error if sending fails.
+func sendOne(st castore.Store, index int32, key string, buf []byte, stream grpc.ServerStreamingServer[GetMultiResponse]) error {
+	fail := func(err error) error {
+		stat := status.Convert(toStatus(err))
+		return stream.Send(&GetMultiResponse{Index: index, Code: int32(stat.Code()), Error: stat.Message()})
+	}
+
+	if err := checkKey(st, key); err != nil {
+		return fail(err)
+	}
+	r, err := st.Get(key)
+	if err != nil {
+		return fail(err)
+	}
+	if r == nil {
+		return stream.Send(&GetMultiResponse{Index: index, Missing: true})
+	}
+	defer r.Close()
+
+	sent := false
+	for {
+		n, err := r.Read(buf)
+		if n > 0 || (err == io.EOF && !sent) {
+			if serr := stream.Send(&GetMultiResponse{Index: index, Data: buf[:n]}); serr != nil {
+				return serr
+			}
+			sent = true
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+}
+
+func (s *server) Exists(ctx context.Context, req *ExistsRequest) (*ExistsResponse, error) {
+	st, release, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer release()
+	if err := checkKey(st, req.Key); err != nil {
+		return nil, err
+	}
+	size, err := st.Size(req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if size < 0 {
+		return &ExistsResponse{}, nil
+	}
+	return &ExistsResponse{Exists: true, Size: size}, nil
+}
+
+func (s *server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	st, release, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer release()
+	if err := checkKey(st, req.Key); err != nil {
+		return nil, err
+	}
+	if err := st.Delete(req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *server) List(req *ListRequest, stream grpc.ServerStreamingServer[ListResponse]) error {
+	st, release, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	err = st.Walk(func(key string, size int64) error {
+		return stream.Send(&ListResponse{Key: key, Size: size})
+	})
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// partUploader is implemented by stores that support multipart uploads, such
+// as castore.CAStore.
+type partUploader interface {
+	BeginUpload() (string, error)
+	UploadPart(id string, part int, r io.Reader) (int64, error)
+	CommitParts(id string, parts []int, expectedKey string) (string, error)
+	AbortUpload(id string) error
+}
+
+// errNoParts is returned by the multipart upload calls when the store
+// doesn't support them.
+var errNoParts = status.Error(codes.Unimplemented, "castoregrpc: store does not support multipart uploads")
+
+func (s *server) BeginUpload(ctx context.Context, req *BeginUploadRequest) (*BeginUploadResponse, error) {
+	st, release, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer release()
+	up, ok := st.(partUploader)
+	if !ok {
+		return nil, errNoParts
+	}
+	id, err := up.BeginUpload()
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BeginUploadResponse{Id: id}, nil
+}
+
+func (s *server) UploadPart(stream grpc.ClientStreamingServer[UploadPartRequest, UploadPartResponse]) error {
+	st, release, err := s.store(stream.Context())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer release()
+	up, ok := st.(partUploader)
+	if !ok {
+		return errNoParts
+	}
+
+	// The first message says which part the data is for.
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "castoregrpc: empty UploadPart stream")
+	}
+	if err != nil {
+		return err
+	}
+	r := &streamReader{buf: first.Data, recv: func() ([]byte, error) {
+		req, err := stream.Recv()
+		return req.GetData(), err
+	}}
+	size, err := up.UploadPart(first.Id, int(first.Part), r)
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&UploadPartResponse{Size: size})
+}
+
+func (s *server) CommitUpload(ctx context.Context, req *CommitUploadRequest) (*CommitUploadResponse, error) {
+	st, release, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer release()
+	up, ok := st.(partUploader)
+	if !ok {
+		return nil, errNoParts
+	}
+	parts := make([]int, len(req.Parts))
+	for i, part := range req.Parts {
+		parts[i] = int(part)
+	}
+	key, err := up.CommitParts(req.Id, parts, "")
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &CommitUploadResponse{Key: key}, nil
+}
+
+func (s *server) AbortUpload(ctx context.Context, req *AbortUploadRequest) (*AbortUploadResponse, error) {
+	st, release, err := s.store(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	defer release()
+	up, ok := st.(partUploader)
+	if !ok {
+		return nil, errNoParts
+	}
+	if err := up.AbortUpload(req.Id); err != nil {
+		return nil, toStatus(err)
+	}
+	return &AbortUploadResponse{}, nil
+}
+
+// keyParser is implemented by stores that can tell whether a string is a key
+// they could have produced, such as castore.CAStore.
+type keyParser interface {
+	ParseKey(key string) (castore.Key, error)
+}
+
+// checkKey returns an InvalidArgument error if the given store can tell that
+// the given key is not valid, so that a request can never name anything but
+// an object in the store.
+func checkKey(st castore.Store, key string) error {
+	if kp, ok := st.(keyParser); ok {
+		if _, err := kp.ParseKey(key); err != nil {
+			return toStatus(err)
+		}
+	}
+	return nil
+}
+
+// toStatus converts errors from the store into gRPC status errors.
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch err {
+	case castore.ErrSizeExceeded, castore.ErrQuotaExceeded:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case castore.ErrKeyMismatch, castore.ErrEmpty, castore.ErrSizeTooSmall, castore.ErrInvalidKey, castore.ErrInvalidPart:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case castore.ErrNoSuchUpload:
+		return status.Error(codes.NotFound, err.Error())
+	case castore.ErrWriteOnce:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,5 @@
+/*
+Package castorehttp contains helpers for serving data from a castore.CAStore
+over HTTP.
+*/
+package castorehttp
@@ -0,0 +1,103 @@
+package castorehttp
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrew-d/castore"
+)
+
+// handler is the http.Handler returned by Handler.
+type handler struct {
+	s  *castore.CAStore
+	fs fs.StatFS
+}
+
+// Handler returns an http.Handler that serves `GET /<key>` and `HEAD /<key>`
+// requests from the given store.  Since data in the store can never change,
+// the key itself is used as a strong ETag and responses are marked as
+// immutable.  If the store has recorded the content type of an object (see
+// castore.Options.SniffContentType), it is used for the Content-Type header.
+// Conditional (If-None-Match, If-Modified-Since) and Range requests are
+// supported.
+//
+// Objects are read with Get, so reads made through the handler are verified,
+// rate limited and counted in the same way as any other.  If the store's
+// CorruptReadPolicy streams data as it is verified, Range requests are
+// answered with the whole object.
+//
+// To serve the store under a prefix, wrap the returned handler with
+// http.StripPrefix.
+func Handler(s *castore.CAStore) http.Handler {
+	return handler{s, s.FS().(fs.StatFS)}
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	rc, err := h.s.Get(key)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if rc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer rc.Close()
+
+	// The modification time is only used for Last-Modified, so it doesn't
+	// matter if the object can't be found on disk.
+	var modtime time.Time
+	if inf, err := h.fs.Stat(key); err == nil {
+		modtime = inf.ModTime()
+	}
+
+	// http.ServeContent handles If-None-Match, Range and HEAD for us once the
+	// ETag header has been set.
+	etag := `"` + key + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if w.Header().Get("Content-Type") == "" {
+		ctype, err := h.s.ContentType(key)
+		if err != nil || ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, modtime, rs)
+		return
+	}
+
+	// Readers that verify the data as it is streamed can't seek, so we can
+	// only send the whole object.
+	if inm := r.Header.Get("If-None-Match"); inm == etag || inm == "*" {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	size, err := h.s.Size(key)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" {
+		io.Copy(w, rc)
+	}
+}
@@ -0,0 +1,229 @@
+package castorehttp
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func newStore(t *testing.T) (*castore.CAStore, func()) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := castore.New(castore.Options{
+		BasePath:  tdir,
+		Transform: castore.DepthTransformFunc(2),
+	})
+	if err != nil {
+		os.RemoveAll(tdir)
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(tdir) }
+}
+
+func do(h http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	_, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	h := Handler(s)
+
+	// Plain GET
+	w := do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+	assert.Equal(t, `"`+TEST_KEY+`"`, w.Header().Get("ETag"))
+	assert.Equal(t, "application/octet-stream", w.Header().Get("Content-Type"))
+
+	// HEAD
+	w = do(h, "HEAD", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+	assert.Equal(t, 0, w.Body.Len())
+
+	// Conditional
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{
+		"If-None-Match": `"` + TEST_KEY + `"`,
+	})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// Range
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{
+		"Range": "bytes=1-3",
+	})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "oob", w.Body.String())
+
+	// Errors
+	w = do(h, "GET", "/nonexistent", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(h, "POST", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestHandlerContentType(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:         tdir,
+		SniffContentType: true,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString("<html><body>hello</body></html>")
+	assert.NoError(t, err)
+
+	w := do(Handler(s), "GET", "/"+key, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestHandlerInline(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:      tdir,
+		InlineMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	h := Handler(s)
+	w := do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{
+		"Range": "bytes=1-3",
+	})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "oob", w.Body.String())
+}
+
+func TestHandlerRepack(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:    tdir,
+		PackMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+
+	w := do(Handler(s), "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+}
+
+func TestHandlerDelta(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(base)
+	target := append([]byte(nil), base...)
+	copy(target[1000:], "changed")
+
+	bkey, err := s.PutBytes(base)
+	assert.NoError(t, err)
+	key, err := s.PutDelta(bytes.NewReader(target), bkey)
+	assert.NoError(t, err)
+
+	h := Handler(s)
+	w := do(h, "GET", "/"+key, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, target, w.Body.Bytes())
+
+	w = do(h, "GET", "/"+key, map[string]string{
+		"Range": "bytes=1000-1006",
+	})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "changed", w.Body.String())
+}
+
+func TestHandlerUsesGet(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	opts := castore.Options{
+		BasePath:     tdir,
+		VerifyOnRead: true,
+	}
+	s, err := castore.New(opts)
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	w := do(Handler(s), "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1), s.Stats().Gets)
+
+	// Corrupt data is never served.
+	p := filepath.Join(tdir, TEST_KEY)
+	assert.NoError(t, os.Chmod(p, 0644))
+	assert.NoError(t, ioutil.WriteFile(p, []byte("fooba!"), 0644))
+	w = do(Handler(s), "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "fooba!")
+
+	// Data that is verified as it is streamed is sent whole.
+	assert.NoError(t, ioutil.WriteFile(p, []byte(TEST_VALUE), 0644))
+	opts.CorruptReads = castore.CorruptReadPartial
+	s, err = castore.New(opts)
+	assert.NoError(t, err)
+
+	h := Handler(s)
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{
+		"Range": "bytes=1-3",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{
+		"If-None-Match": `"` + TEST_KEY + `"`,
+	})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
@@ -0,0 +1,161 @@
+package castorehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/andrew-d/castore"
+)
+
+// ServerOptions contains the options that control the behavior of the handler
+// returned by NewServer.
+type ServerOptions struct {
+	// MaxBodySize is the maximum size of a request body that will be accepted
+	// by a PUT request.  Larger bodies are rejected with a 413 status.  If not
+	// specified or negative, only the store's own MaxSize is enforced.
+	MaxBodySize int64
+
+	// ReadOnly disables the PUT and DELETE endpoints.
+	ReadOnly bool
+}
+
+// server is the http.Handler returned by NewServer.
+type server struct {
+	s    *castore.CAStore
+	get  http.Handler
+	opts ServerOptions
+}
+
+// listEntry is a single entry in the response to a list request.
+type listEntry struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+}
+
+// NewServer returns an http.Handler that exposes a simple REST API over the
+// given store:
+//
+//	PUT /             stores the request body, and responds with its key
+//	PUT /<key>        stores the request body only if it has the given key
+//	GET /             lists all objects as a JSON array of {"key", "size"}
+//	GET /<key>        retrieves an object (see Handler)
+//	HEAD /<key>       retrieves an object's headers (see Handler)
+//	DELETE /<key>     deletes an object
+//
+// Attempting to store a body that exceeds either the store's MaxSize or
+// ServerOptions.MaxBodySize results in a 413 status, and storing a body that
+// does not match the key it was sent to results in a 400 status.
+func NewServer(s *castore.CAStore, opts ServerOptions) http.Handler {
+	return &server{
+		s:    s,
+		get:  Handler(s),
+		opts: opts,
+	}
+}
+
+func (h *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+
+	switch {
+	case r.Method == "PUT" && !h.opts.ReadOnly:
+		h.put(w, r, key)
+	case key == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		h.list(w, r)
+	case key != "" && (r.Method == "GET" || r.Method == "HEAD"):
+		h.get.ServeHTTP(w, r)
+	case key != "" && r.Method == "DELETE" && !h.opts.ReadOnly:
+		h.delete(w, r, key)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// put stores the request body.  If expected is non-empty, the body is only
+// stored if that is its key.
+func (h *server) put(w http.ResponseWriter, r *http.Request, expected string) {
+	body := r.Body
+	if h.opts.MaxBodySize > 0 {
+		body = http.MaxBytesReader(w, body, h.opts.MaxBodySize)
+	}
+
+	var key string
+	var err error
+	if expected != "" {
+		key = expected
+		err = h.s.PutVerified(expected, body)
+	} else {
+		key, err = h.s.Put(body)
+	}
+	if err != nil {
+		if err == castore.ErrKeyMismatch {
+			http.Error(w, "request body does not match key", http.StatusBadRequest)
+			return
+		}
+		var mbe *http.MaxBytesError
+		if err == castore.ErrSizeExceeded || errors.As(err, &mbe) {
+			http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err == castore.ErrQuotaExceeded {
+			http.Error(w, "quota exceeded", http.StatusInsufficientStorage)
+			return
+		}
+		if err == castore.ErrEmpty || err == castore.ErrSizeTooSmall {
+			http.Error(w, "request body is too small", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", "/"+key)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(key + "\n"))
+}
+
+func (h *server) list(w http.ResponseWriter, r *http.Request) {
+	entries := []listEntry{}
+	err := h.s.Walk(func(key string, size int64) error {
+		entries = append(entries, listEntry{key, size})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (h *server) delete(w http.ResponseWriter, r *http.Request, key string) {
+	if _, err := h.s.ParseKey(key); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	size, err := h.s.Size(key)
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if size < 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err = h.s.Delete(key); err == castore.ErrWriteOnce {
+		http.Error(w, "store is write-once", http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package castorehttp
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	h := NewServer(s, ServerOptions{MaxBodySize: 10})
+
+	// PUT
+	req := httptest.NewRequest("PUT", "/", strings.NewReader(TEST_VALUE))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, TEST_KEY+"\n", w.Body.String())
+	assert.Equal(t, "/"+TEST_KEY, w.Header().Get("Location"))
+
+	// Too large
+	req = httptest.NewRequest("PUT", "/", strings.NewReader(strings.Repeat("a", 11)))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// PUT with the expected key
+	req = httptest.NewRequest("PUT", "/"+TEST_KEY, strings.NewReader(TEST_VALUE))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, TEST_KEY+"\n", w.Body.String())
+
+	req = httptest.NewRequest("PUT", "/"+TEST_KEY, strings.NewReader("corrupted"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// GET
+	w = do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	// List
+	w = do(h, "GET", "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []listEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Equal(t, []listEntry{{TEST_KEY, int64(len(TEST_VALUE))}}, entries)
+
+	// DELETE
+	w = do(h, "DELETE", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(h, "DELETE", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Read-only
+	h = NewServer(s, ServerOptions{ReadOnly: true})
+	req = httptest.NewRequest("PUT", "/", strings.NewReader(TEST_VALUE))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestServerDeleteInvalidKey(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	victim := filepath.Join(tdir, "victim.txt")
+	assert.NoError(t, ioutil.WriteFile(victim, []byte("secret"), 0644))
+
+	s, err := castore.New(castore.Options{
+		BasePath:  filepath.Join(tdir, "store"),
+		Transform: castore.DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+
+	h := NewServer(s, ServerOptions{})
+	for _, path := range []string{"/../victim.txt", "/ab"} {
+		w := do(h, "DELETE", path, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+
+	_, err = os.Stat(victim)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,89 @@
+package castorehttp
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andrew-d/castore/castoretenant"
+)
+
+// TenantServerOptions contains the options that control the behavior of the
+// handler returned by NewTenantServer.
+type TenantServerOptions struct {
+	ServerOptions
+
+	// Tenant, if set, determines the tenant that a request belongs to - for
+	// example, from the claims in an authentication token - and the request
+	// is served from that tenant's store using its full path.  If it returns
+	// an error, the request is rejected with a 403 status.
+	//
+	// If not specified, the tenant is instead taken from the first component
+	// of the request's path, which is removed before the request is served:
+	// `GET /acme/<key>` retrieves an object from tenant "acme".
+	Tenant func(r *http.Request) (string, error)
+}
+
+// tenantServer is the http.Handler returned by NewTenantServer.
+type tenantServer struct {
+	t    *castoretenant.Tenants
+	opts TenantServerOptions
+}
+
+// NewTenantServer returns an http.Handler that serves the API described in
+// NewServer for each tenant in the given set, so that a single server can
+// serve many tenants.  Each tenant only has access to its own store, and a
+// PUT that would take a tenant over its quota results in a 507 status.
+// Requests for tenants that have not been created with
+// castoretenant.Tenants.Create result in a 404 status.
+func NewTenantServer(t *castoretenant.Tenants, opts TenantServerOptions) http.Handler {
+	return &tenantServer{t, opts}
+}
+
+func (h *tenantServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var tenant string
+	if h.opts.Tenant != nil {
+		var err error
+		if tenant, err = h.opts.Tenant(r); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	} else {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		tenant = path[:i]
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path[i:]
+		r2.URL.RawPath = ""
+		r = r2
+	}
+
+	s, release, err := h.t.Acquire(tenant)
+	if err == castoretenant.ErrInvalidTenant || err == castoretenant.ErrUnknownTenant {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	// A key that isn't valid for the tenant's store could name a path outside
+	// it, such as another tenant's store.
+	if key := strings.TrimPrefix(r.URL.Path, "/"); key != "" {
+		if _, err := s.ParseKey(key); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	NewServer(s, h.opts.ServerOptions).ServeHTTP(w, r)
+}
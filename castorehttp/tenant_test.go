@@ -0,0 +1,139 @@
+package castorehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/andrew-d/castore/castoretenant"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTenants(t *testing.T) (*castoretenant.Tenants, func()) {
+	tdir, err := ioutil.TempDir("", "castorehttp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenants, err := castoretenant.New(castoretenant.Options{
+		BasePath: tdir,
+		Store:    castore.Options{Quota: 10},
+	})
+	if err != nil {
+		os.RemoveAll(tdir)
+		t.Fatal(err)
+	}
+	for _, name := range []string{"acme", "other"} {
+		if err = tenants.Create(name); err != nil {
+			os.RemoveAll(tdir)
+			t.Fatal(err)
+		}
+	}
+	return tenants, func() {
+		tenants.Close()
+		os.RemoveAll(tdir)
+	}
+}
+
+func put(h http.Handler, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestTenantServer(t *testing.T) {
+	tenants, cleanup := newTenants(t)
+	defer cleanup()
+
+	h := NewTenantServer(tenants, TenantServerOptions{})
+
+	w := put(h, "/acme/", TEST_VALUE, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, TEST_KEY+"\n", w.Body.String())
+
+	w = do(h, "GET", "/acme/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	// Other tenants can't see the object.
+	w = do(h, "GET", "/other/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(h, "GET", "/other/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var entries []listEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Empty(t, entries)
+
+	// Quotas are per-tenant.
+	w = put(h, "/acme/", "too much", nil)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	w = put(h, "/other/", "too much", nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(h, "GET", "/bad.name/", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Tenants that haven't been created aren't created by requests.
+	w = do(h, "GET", "/unknown/", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = put(h, "/unknown/", TEST_VALUE, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	_, err := tenants.Store("unknown")
+	assert.Equal(t, castoretenant.ErrUnknownTenant, err)
+}
+
+func TestTenantServerCrossTenant(t *testing.T) {
+	tenants, cleanup := newTenants(t)
+	defer cleanup()
+
+	h := NewTenantServer(tenants, TenantServerOptions{})
+
+	w := put(h, "/other/", TEST_VALUE, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Keys that escape the tenant's store are rejected.
+	for _, method := range []string{"GET", "HEAD", "DELETE"} {
+		w = do(h, method, "/acme/../other/"+TEST_KEY, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+	w = put(h, "/acme/../other/"+TEST_KEY, TEST_VALUE, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(h, "GET", "/other/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTenantServerClaims(t *testing.T) {
+	tenants, cleanup := newTenants(t)
+	defer cleanup()
+
+	h := NewTenantServer(tenants, TenantServerOptions{
+		Tenant: func(r *http.Request) (string, error) {
+			if tenant := r.Header.Get("X-Tenant"); tenant != "" {
+				return tenant, nil
+			}
+			return "", errors.New("no tenant")
+		},
+	})
+
+	w := put(h, "/", TEST_VALUE, map[string]string{"X-Tenant": "acme"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{"X-Tenant": "acme"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do(h, "GET", "/"+TEST_KEY, map[string]string{"X-Tenant": "other"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do(h, "GET", "/"+TEST_KEY, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
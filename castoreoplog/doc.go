@@ -0,0 +1,26 @@
+/*
+Package castoreoplog provides a persistent, append-only log of the operations
+performed on a castore.CAStore, for auditing and forensic investigation.
+
+Unlike the debug messages sent to Options.Logger, the log is a stable,
+machine-readable record - one JSON object per line - that can be replayed
+later, for example to work out which keys should be present in a damaged
+store, or who deleted a particular object.
+
+Usage:
+
+	l, err := castoreoplog.Open("/var/log/blobs/ops.log", castoreoplog.Options{})
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	s, err := castore.New(castore.Options{
+		BasePath: "/var/lib/blobs",
+		Observer: l.Observe,
+	})
+
+The log is rotated once it reaches Options.MaxSize; Replay reads the rotated
+files as well as the current one, oldest first.
+*/
+package castoreoplog
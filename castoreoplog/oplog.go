@@ -0,0 +1,316 @@
+package castoreoplog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/andrew-d/castore"
+)
+
+// The results recorded for operations that did not fail with an error.
+const (
+	ResultOK       = "ok"
+	ResultNotFound = "not found"
+)
+
+// Options contains the options that control what is logged, and how the log
+// is rotated.
+type Options struct {
+	// MaxSize is the size, in bytes, at which the log file is rotated.  If not
+	// specified or negative, this will default to 64 MiB.
+	MaxSize int64
+
+	// MaxFiles is the number of rotated log files that are kept, in addition
+	// to the current one; older files are removed.  If not specified, this
+	// will default to 10.  If negative, all rotated files are kept.
+	MaxFiles int
+
+	// Gets causes successful and failed Gets to be logged, as well as Puts and
+	// Deletes.
+	Gets bool
+
+	// Principal returns the identity responsible for an operation, such as a
+	// user or tenant name.  Since operations on a CAStore do not carry an
+	// identity, this is useful when each principal has its own store.  If not
+	// specified, the name of the user running the process is recorded.
+	Principal func(op castore.Operation) string
+}
+
+// Record is a single entry in the log.
+type Record struct {
+	// Time is when the operation completed.
+	Time time.Time `json:"time"`
+
+	// Op is the type of operation.
+	Op castore.Op `json:"op"`
+
+	// Key is the key that was operated on.  It is empty for a failed Put.
+	Key string `json:"key,omitempty"`
+
+	// Size is the size of the data, as reported in castore.Operation.
+	Size int64 `json:"size"`
+
+	// Dedup is set for a Put whose data was already present in the store.
+	Dedup bool `json:"dedup,omitempty"`
+
+	// Principal is the identity responsible for the operation.
+	Principal string `json:"principal,omitempty"`
+
+	// Result is ResultOK, ResultNotFound, or the message of the error
+	// returned by the operation.
+	Result string `json:"result"`
+}
+
+// Log is an append-only operation log.  Its Observe method is intended to be
+// set as a store's Options.Observer.
+type Log struct {
+	path string
+	opts Options
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	err  error
+}
+
+// Open will open the log at the given path for appending, creating it if it
+// does not exist.
+func Open(path string, opts Options) (*Log, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 64 * 1024 * 1024
+	}
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = 10
+	}
+	if opts.Principal == nil {
+		name := ""
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		opts.Principal = func(castore.Operation) string { return name }
+	}
+
+	l := &Log{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the current log file.  It must be called with mu held, or
+// before the Log is shared.
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = info.Size()
+	return nil
+}
+
+// Observe records a single operation.  It is intended to be used as the
+// store's Options.Observer.  Since observers cannot fail, errors writing the
+// log are reported by Err and Close instead.
+func (l *Log) Observe(op castore.Operation) {
+	if op.Op == castore.OpGet && !l.opts.Gets {
+		return
+	}
+
+	rec := Record{
+		Time:      time.Now().UTC(),
+		Op:        op.Op,
+		Key:       op.Key,
+		Size:      op.Size,
+		Dedup:     op.Dedup,
+		Principal: l.opts.Principal(op),
+		Result:    ResultOK,
+	}
+	switch {
+	case op.Err != nil:
+		rec.Result = op.Err.Error()
+	case op.Size < 0:
+		rec.Result = ResultNotFound
+	}
+
+	if err := l.Append(rec); err != nil {
+		l.mu.Lock()
+		if l.err == nil {
+			l.err = err
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Append adds a record to the log, rotating it first if necessary.  Each
+// record is written with a single write, so that records from concurrent
+// writers are never interleaved.
+func (l *Log) Append(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && l.size+int64(len(data)) > l.opts.MaxSize {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the current log file aside and starts a new one.  It must be
+// called with mu held.
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+
+	// Find the oldest rotated file, removing any beyond the limit.
+	n := 1
+	for ; ; n++ {
+		if _, err := os.Stat(rotatedPath(l.path, n)); os.IsNotExist(err) {
+			break
+		}
+	}
+	for ; l.opts.MaxFiles > 0 && n > l.opts.MaxFiles; n-- {
+		if err := os.Remove(rotatedPath(l.path, n)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Shift the remaining files along to make room.
+	for i := n - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Err returns the first error encountered while writing operations reported
+// to Observe, if any.
+func (l *Log) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Close closes the log file.  It returns the first error encountered while
+// writing operations reported to Observe, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		if err := l.f.Close(); err != nil && l.err == nil {
+			l.err = err
+		}
+		l.f = nil
+	}
+	return l.err
+}
+
+// rotatedPath returns the path of the n'th most recently rotated log file.
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Replay will call fn for every record in the log at the given path, oldest
+// first, including those in rotated files that have not yet been removed.  If
+// fn returns an error, replay stops and the error is returned.
+func Replay(path string, fn func(rec Record) error) error {
+	n := 0
+	for {
+		if _, err := os.Stat(rotatedPath(path, n+1)); err != nil {
+			break
+		}
+		n++
+	}
+
+	for i := n; i >= 0; i-- {
+		p := path
+		if i > 0 {
+			p = rotatedPath(path, i)
+		}
+		if err := replayFile(p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayFile calls fn for every record in a single log file.
+func replayFile(p string, fn func(rec Record) error) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("castoreoplog: %s:%d: %s", p, line, err)
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Keys replays the log at the given path and returns the keys that it says
+// should be present in the store, along with their sizes: those that were
+// successfully Put or Undeleted and not subsequently Deleted or evicted.  This
+// is only complete if no rotated files have been removed since the store was
+// created.
+func Keys(path string) (map[string]int64, error) {
+	keys := make(map[string]int64)
+	err := Replay(path, func(rec Record) error {
+		if rec.Result != ResultOK {
+			return nil
+		}
+		switch rec.Op {
+		case castore.OpPut, castore.OpUndelete:
+			keys[rec.Key] = rec.Size
+		case castore.OpDelete, castore.OpEvict:
+			delete(keys, rec.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,142 @@
+package castoreoplog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func TestLog(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreoplog-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	logPath := filepath.Join(tdir, "ops.log")
+	l, err := Open(logPath, Options{
+		Principal: func(castore.Operation) string { return "alice" },
+	})
+	assert.NoError(t, err)
+
+	s, err := castore.New(castore.Options{
+		BasePath: filepath.Join(tdir, "store"),
+		MaxSize:  16,
+		Observer: l.Observe,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	_, err = s.PutString("this is far too large")
+	assert.Equal(t, castore.ErrSizeExceeded, err)
+	_, err = s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(other))
+	assert.NoError(t, l.Close())
+
+	var recs []Record
+	assert.NoError(t, Replay(logPath, func(rec Record) error {
+		recs = append(recs, rec)
+		return nil
+	}))
+	if assert.Len(t, recs, 4) {
+		assert.Equal(t, castore.OpPut, recs[0].Op)
+		assert.Equal(t, TEST_KEY, recs[0].Key)
+		assert.Equal(t, int64(len(TEST_VALUE)), recs[0].Size)
+		assert.Equal(t, "alice", recs[0].Principal)
+		assert.Equal(t, ResultOK, recs[0].Result)
+		assert.False(t, recs[0].Time.IsZero())
+
+		assert.Equal(t, castore.ErrSizeExceeded.Error(), recs[2].Result)
+		assert.Equal(t, castore.OpDelete, recs[3].Op)
+		assert.Equal(t, other, recs[3].Key)
+	}
+
+	keys, err := Keys(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{TEST_KEY: int64(len(TEST_VALUE))}, keys)
+}
+
+func TestKeysEvictUndelete(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreoplog-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	logPath := filepath.Join(tdir, "ops.log")
+	l, err := Open(logPath, Options{})
+	assert.NoError(t, err)
+
+	s, err := castore.New(castore.Options{
+		BasePath:        filepath.Join(tdir, "store"),
+		SnapshotHistory: 1,
+		Observer:        l.Observe,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	snap, err := s.Snapshot("")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(TEST_KEY))
+	assert.NoError(t, s.Undelete(snap.Name, TEST_KEY))
+
+	// The snapshotted key can't be evicted, but the other one can.
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	evicted, err := s.Evict(context.Background(), 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), evicted.Objects)
+	assert.NoError(t, l.Close())
+
+	var ops []castore.Op
+	assert.NoError(t, Replay(logPath, func(rec Record) error {
+		if rec.Key == TEST_KEY || rec.Key == other {
+			ops = append(ops, rec.Op)
+		}
+		return nil
+	}))
+	assert.Equal(t, []castore.Op{castore.OpPut, castore.OpDelete, castore.OpUndelete, castore.OpPut, castore.OpEvict}, ops)
+
+	// The snapshot's manifest is stored like any other object.
+	keys, err := Keys(logPath)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, int64(len(TEST_VALUE)), keys[TEST_KEY])
+	assert.Contains(t, keys, snap.Key)
+}
+
+func TestRotation(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreoplog-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	logPath := filepath.Join(tdir, "ops.log")
+	l, err := Open(logPath, Options{MaxSize: 1, MaxFiles: 2})
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, l.Append(Record{Op: castore.OpPut, Key: key, Result: ResultOK}))
+	}
+	assert.NoError(t, l.Close())
+
+	// Each record is in its own file, and only the newest three remain.
+	_, err = os.Stat(rotatedPath(logPath, 3))
+	assert.True(t, os.IsNotExist(err))
+
+	var keys []string
+	assert.NoError(t, Replay(logPath, func(rec Record) error {
+		keys = append(keys, rec.Key)
+		return nil
+	}))
+	assert.Equal(t, []string{"b", "c", "d"}, keys)
+}
@@ -0,0 +1,15 @@
+/*
+Package castoreotel provides OpenTelemetry tracing for a castore.CAStore.
+
+Usage:
+
+	t := castoreotel.NewTracer(castoreotel.Options{})
+	s, err := castore.New(castore.Options{
+		BasePath: "/var/lib/blobs",
+		Observer: t.Observe,
+	})
+
+To export both traces and Prometheus metrics, combine the observers with
+castore.Observers.
+*/
+package castoreotel
@@ -0,0 +1,87 @@
+package castoreotel
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrew-d/castore"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer used to create spans.
+const instrumentationName = "github.com/andrew-d/castore"
+
+// The attributes that are recorded on each span.
+const (
+	KeyAttribute   = attribute.Key("castore.key")
+	SizeAttribute  = attribute.Key("castore.size")
+	DedupAttribute = attribute.Key("castore.dedup")
+)
+
+// Options contains the options that control how spans are created.
+type Options struct {
+	// TracerProvider is used to create spans.  If not specified, the global
+	// provider from otel.GetTracerProvider will be used.
+	TracerProvider trace.TracerProvider
+
+	// Context returns the context that spans are created in, which determines
+	// their parent.  Since operations on a CAStore are not passed a context,
+	// this can be used to attach spans to a trace that is stored elsewhere.
+	// If not specified, spans will be created as the root of a new trace.
+	Context func() context.Context
+}
+
+// Tracer creates an OpenTelemetry span for each operation on a CAStore.  Its
+// Observe method must be set as the store's Options.Observer.
+type Tracer struct {
+	opts   Options
+	tracer trace.Tracer
+}
+
+// NewTracer creates a new Tracer with the given options.
+func NewTracer(opts Options) *Tracer {
+	if opts.TracerProvider == nil {
+		opts.TracerProvider = otel.GetTracerProvider()
+	}
+	if opts.Context == nil {
+		opts.Context = context.Background
+	}
+
+	return &Tracer{
+		opts:   opts,
+		tracer: opts.TracerProvider.Tracer(instrumentationName),
+	}
+}
+
+// Observe records a span for a single operation.  It is intended to be used
+// as the store's Options.Observer.
+//
+// Operations are only reported once they have completed, so the span's start
+// time is calculated from the duration of the operation.
+func (t *Tracer) Observe(op castore.Operation) {
+	end := time.Now()
+
+	attrs := []attribute.KeyValue{
+		SizeAttribute.Int64(op.Size),
+	}
+	if op.Key != "" {
+		attrs = append(attrs, KeyAttribute.String(op.Key))
+	}
+	if op.Op == castore.OpPut {
+		attrs = append(attrs, DedupAttribute.Bool(op.Dedup))
+	}
+
+	_, span := t.tracer.Start(t.opts.Context(), "castore."+string(op.Op),
+		trace.WithTimestamp(end.Add(-op.Duration)),
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attrs...),
+	)
+	if op.Err != nil {
+		span.RecordError(op.Err)
+		span.SetStatus(codes.Error, op.Err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
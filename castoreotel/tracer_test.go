@@ -0,0 +1,73 @@
+package castoreotel
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func TestTracer(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreotel-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	rec := tracetest.NewSpanRecorder()
+	tr := NewTracer(Options{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)),
+	})
+	s, err := castore.New(castore.Options{
+		BasePath: tdir,
+		MaxSize:  16,
+		Observer: tr.Observe,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString("this is too large for the store")
+	assert.Equal(t, castore.ErrSizeExceeded, err)
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	r.Close()
+	assert.NoError(t, s.Delete(TEST_KEY))
+
+	spans := rec.Ended()
+	if !assert.Len(t, spans, 5) {
+		return
+	}
+
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+		assert.False(t, span.EndTime().Before(span.StartTime()))
+	}
+	assert.Equal(t, []string{
+		"castore.put", "castore.put", "castore.put", "castore.get", "castore.delete",
+	}, names)
+
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		SizeAttribute.Int64(int64(len(TEST_VALUE))),
+		KeyAttribute.String(TEST_KEY),
+		DedupAttribute.Bool(false),
+	}, spans[0].Attributes())
+	assert.Contains(t, spans[1].Attributes(), DedupAttribute.Bool(true))
+
+	// Failed operations are marked as errors.
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+	assert.Equal(t, castore.ErrSizeExceeded.Error(), spans[2].Status().Description)
+	assert.Equal(t, codes.Unset, spans[3].Status().Code)
+}
@@ -0,0 +1,14 @@
+/*
+Package castoreprom provides Prometheus instrumentation for a castore.CAStore.
+
+Usage:
+
+	m := castoreprom.NewMetrics(castoreprom.Options{Namespace: "myapp"})
+	s, err := castore.New(castore.Options{
+		BasePath: "/var/lib/blobs",
+		Observer: m.Observe,
+	})
+	...
+	registry.MustRegister(m.Collector(s))
+*/
+package castoreprom
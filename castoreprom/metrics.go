@@ -0,0 +1,181 @@
+package castoreprom
+
+import (
+	"sync"
+	"time"
+
+	"github.com/andrew-d/castore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Options contains the options that control the metrics that are exported.
+type Options struct {
+	// Namespace and Subsystem are used as prefixes for all metric names.  If
+	// Subsystem is not specified, it will default to "castore".
+	Namespace string
+	Subsystem string
+
+	// SizeBuckets are the histogram buckets used for object sizes, in bytes.
+	// If not specified, buckets from 64 bytes to 1 GiB will be used.
+	SizeBuckets []float64
+
+	// LatencyBuckets are the histogram buckets used for operation latency, in
+	// seconds.  If not specified, prometheus.DefBuckets will be used.
+	LatencyBuckets []float64
+
+	// UsageInterval is the minimum time between recalculations of the total
+	// object count and size, which requires walking the store.  If not
+	// specified, this will default to one minute.
+	UsageInterval time.Duration
+}
+
+// Metrics collects Prometheus metrics for a CAStore.  Its Observe method must
+// be set as the store's Options.Observer.
+type Metrics struct {
+	opts Options
+
+	ops       *prometheus.CounterVec
+	errors    *prometheus.CounterVec
+	dedupHits prometheus.Counter
+	sizes     *prometheus.HistogramVec
+	latency   *prometheus.HistogramVec
+}
+
+// NewMetrics creates a new set of metrics with the given options.
+func NewMetrics(opts Options) *Metrics {
+	if opts.Subsystem == "" {
+		opts.Subsystem = "castore"
+	}
+	if opts.SizeBuckets == nil {
+		opts.SizeBuckets = prometheus.ExponentialBuckets(64, 4, 13)
+	}
+	if opts.LatencyBuckets == nil {
+		opts.LatencyBuckets = prometheus.DefBuckets
+	}
+	if opts.UsageInterval <= 0 {
+		opts.UsageInterval = time.Minute
+	}
+
+	return &Metrics{
+		opts: opts,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "operations_total",
+			Help:      "Number of operations performed on the store.",
+		}, []string{"op"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "errors_total",
+			Help:      "Number of operations on the store that failed.",
+		}, []string{"op"}),
+		dedupHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "dedup_hits_total",
+			Help:      "Number of puts whose data was already in the store.",
+		}),
+		sizes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "object_size_bytes",
+			Help:      "Size of the objects that were stored, retrieved or deleted.",
+			Buckets:   opts.SizeBuckets,
+		}, []string{"op"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: opts.Namespace,
+			Subsystem: opts.Subsystem,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of operations performed on the store.",
+			Buckets:   opts.LatencyBuckets,
+		}, []string{"op"}),
+	}
+}
+
+// Observe records a single operation.  It is intended to be used as the
+// store's Options.Observer.
+func (m *Metrics) Observe(op castore.Operation) {
+	name := string(op.Op)
+
+	m.ops.WithLabelValues(name).Inc()
+	m.latency.WithLabelValues(name).Observe(op.Duration.Seconds())
+	if op.Err != nil {
+		m.errors.WithLabelValues(name).Inc()
+		return
+	}
+	if op.Size >= 0 {
+		m.sizes.WithLabelValues(name).Observe(float64(op.Size))
+	}
+	if op.Dedup {
+		m.dedupHits.Inc()
+	}
+}
+
+// Collector returns a prometheus.Collector that exports all metrics for the
+// given store, which should be the same store whose Observer is set to this
+// Metrics' Observe method.
+func (m *Metrics) Collector(s *castore.CAStore) prometheus.Collector {
+	return &collector{
+		m: m,
+		s: s,
+		objectsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(m.opts.Namespace, m.opts.Subsystem, "objects"),
+			"Number of objects in the store.",
+			nil, nil,
+		),
+		bytesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(m.opts.Namespace, m.opts.Subsystem, "bytes"),
+			"Total size of all objects in the store.",
+			nil, nil,
+		),
+	}
+}
+
+// collector implements prometheus.Collector for a Metrics and its store.
+type collector struct {
+	m *Metrics
+	s *castore.CAStore
+
+	objectsDesc *prometheus.Desc
+	bytesDesc   *prometheus.Desc
+
+	// The usage of the store is cached, since calculating it is expensive.
+	mu        sync.Mutex
+	usage     castore.Usage
+	usageTime time.Time
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	c.m.ops.Describe(ch)
+	c.m.errors.Describe(ch)
+	c.m.dedupHits.Describe(ch)
+	c.m.sizes.Describe(ch)
+	c.m.latency.Describe(ch)
+	ch <- c.objectsDesc
+	ch <- c.bytesDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.m.ops.Collect(ch)
+	c.m.errors.Collect(ch)
+	c.m.dedupHits.Collect(ch)
+	c.m.sizes.Collect(ch)
+	c.m.latency.Collect(ch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.usageTime) >= c.m.opts.UsageInterval {
+		usage, err := c.s.Usage()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.objectsDesc, err)
+			ch <- prometheus.NewInvalidMetric(c.bytesDesc, err)
+			return
+		}
+		c.usage = usage
+		c.usageTime = time.Now()
+	}
+	ch <- prometheus.MustNewConstMetric(c.objectsDesc, prometheus.GaugeValue, float64(c.usage.Objects))
+	ch <- prometheus.MustNewConstMetric(c.bytesDesc, prometheus.GaugeValue, float64(c.usage.Bytes))
+}
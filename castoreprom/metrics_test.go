@@ -0,0 +1,70 @@
+package castoreprom
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreprom-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	m := NewMetrics(Options{Namespace: "test"})
+	s, err := castore.New(castore.Options{
+		BasePath: tdir,
+		MaxSize:  16,
+		Observer: m.Observe,
+	})
+	assert.NoError(t, err)
+
+	reg := prometheus.NewPedanticRegistry()
+	assert.NoError(t, reg.Register(m.Collector(s)))
+
+	key, err := s.PutString("foobar")
+	assert.NoError(t, err)
+	_, err = s.PutString("foobar")
+	assert.NoError(t, err)
+	_, err = s.PutString("this is too large for the store")
+	assert.Equal(t, castore.ErrSizeExceeded, err)
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	r.Close()
+
+	expected := `
+# HELP test_castore_bytes Total size of all objects in the store.
+# TYPE test_castore_bytes gauge
+test_castore_bytes 6
+# HELP test_castore_dedup_hits_total Number of puts whose data was already in the store.
+# TYPE test_castore_dedup_hits_total counter
+test_castore_dedup_hits_total 1
+# HELP test_castore_errors_total Number of operations on the store that failed.
+# TYPE test_castore_errors_total counter
+test_castore_errors_total{op="put"} 1
+# HELP test_castore_objects Number of objects in the store.
+# TYPE test_castore_objects gauge
+test_castore_objects 1
+# HELP test_castore_operations_total Number of operations performed on the store.
+# TYPE test_castore_operations_total counter
+test_castore_operations_total{op="get"} 1
+test_castore_operations_total{op="put"} 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"test_castore_bytes",
+		"test_castore_dedup_hits_total",
+		"test_castore_errors_total",
+		"test_castore_objects",
+		"test_castore_operations_total",
+	))
+
+	n, err := testutil.GatherAndCount(reg, "test_castore_object_size_bytes", "test_castore_operation_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
@@ -0,0 +1,17 @@
+/*
+Package castoreregistry implements a storage driver for the Docker registry
+(github.com/distribution/distribution) on top of a castore.CAStore.
+
+The registry expects a mutable, hierarchical filesystem, so the driver keeps a
+tree of small "link" files - one per registry path - each of which contains the
+key of the data stored at that path.  The data itself lives in the CAStore, so
+identical content (such as a layer shared between repositories, or a blob and
+the upload it was created from) is only stored once.
+
+The driver registers itself with the registry's driver factory under the name
+"castore", and accepts the following parameters:
+
+	rootdirectory   the directory in which all state is kept (required)
+	maxthreads      the maximum number of concurrent filesystem operations
+*/
+package castoreregistry
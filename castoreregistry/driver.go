@@ -0,0 +1,573 @@
+package castoreregistry
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrew-d/castore"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+)
+
+const (
+	driverName        = "castore"
+	defaultMaxThreads = uint64(100)
+	minThreads        = uint64(25)
+)
+
+// Parameters contains the options that control the behavior of the driver.
+type Parameters struct {
+	// RootDirectory is the directory in which all of the driver's state is
+	// kept.  This must be provided.
+	RootDirectory string
+
+	// MaxThreads is the maximum number of concurrent filesystem operations.
+	// If not specified, this will default to 100.
+	MaxThreads uint64
+}
+
+func init() {
+	factory.Register(driverName, &driverFactory{})
+}
+
+// driverFactory implements factory.StorageDriverFactory.
+type driverFactory struct{}
+
+func (f *driverFactory) Create(ctx context.Context, parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// driver is the actual implementation of the storage driver, which is wrapped
+// by base.Base to provide path validation and error decoration.
+type driver struct {
+	store   *castore.CAStore
+	links   string
+	staging string
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver backed by a castore.CAStore.
+type Driver struct {
+	baseEmbed
+	d *driver
+}
+
+// FromParameters constructs a new Driver from the parameters given in the
+// registry's configuration file.
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	var params Parameters
+
+	if rootDir, ok := parameters["rootdirectory"]; ok {
+		params.RootDirectory = fmt.Sprint(rootDir)
+	}
+
+	maxThreads, err := base.GetLimitFromParameter(parameters["maxthreads"], minThreads, defaultMaxThreads)
+	if err != nil {
+		return nil, fmt.Errorf("maxthreads config error: %s", err)
+	}
+	params.MaxThreads = maxThreads
+
+	return New(params)
+}
+
+// New constructs a new Driver with the given parameters.  The data is stored
+// in a CAStore in the "blobs" subdirectory of the root directory.
+func New(params Parameters) (*Driver, error) {
+	if params.RootDirectory == "" {
+		return nil, errors.New("castoreregistry: rootdirectory must be provided")
+	}
+	if params.MaxThreads == 0 {
+		params.MaxThreads = defaultMaxThreads
+	}
+
+	store, err := castore.New(castore.Options{
+		BasePath:  filepath.Join(params.RootDirectory, "blobs"),
+		Transform: castore.DepthTransformFunc(2),
+
+		// Layers can be arbitrarily large.
+		MaxSize: castore.NoLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &driver{
+		store:   store,
+		links:   filepath.Join(params.RootDirectory, "paths"),
+		staging: filepath.Join(params.RootDirectory, "staging"),
+	}
+	for _, dir := range []string{d.links, d.staging} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: base.NewRegulator(d, params.MaxThreads),
+			},
+		},
+		d: d,
+	}, nil
+}
+
+// PruneOptions controls the behaviour of PruneWithOptions.
+type PruneOptions struct {
+	// DryRun causes PruneWithOptions to report the data that it would delete,
+	// without deleting anything.
+	DryRun bool
+
+	// GracePeriod is the minimum age of an object before it can be pruned.
+	// A blob is written to the store before the link that references it, so
+	// if Prune may run while the registry is accepting pushes, this should
+	// be longer than a push can take, so that data isn't deleted just before
+	// it is linked.  An object's age is counted from the last time it was
+	// written, including by a push of data that was already stored.  If not
+	// specified, objects can be pruned at any age.
+	GracePeriod time.Duration
+}
+
+// PruneReport describes the data deleted by PruneWithOptions.
+type PruneReport struct {
+	// Keys contains the keys of the unreferenced objects that were deleted,
+	// or in a dry run that would be deleted, in sorted order.
+	Keys []string
+
+	// Bytes is the total size of those objects.
+	Bytes int64
+}
+
+// Prune will delete all data in the underlying store that is no longer
+// referenced by any registry path, and return the number of objects deleted.
+// Deleting a path only removes its link, so this should be run after the
+// registry's own garbage collection.
+func (d *Driver) Prune(ctx context.Context) (int, error) {
+	report, err := d.PruneWithOptions(ctx, PruneOptions{})
+	if report == nil {
+		return 0, err
+	}
+	return len(report.Keys), err
+}
+
+// PruneWithOptions is like Prune, but reports what was deleted, and can be
+// asked to make no changes so that what would be reclaimed can be reviewed
+// first.  If deleting an object fails, the report contains those deleted
+// before it.
+func (d *Driver) PruneWithOptions(ctx context.Context, opts PruneOptions) (*PruneReport, error) {
+	referenced := make(map[string]bool)
+	err := filepath.Walk(d.d.links, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		key, err := readLink(p)
+		if err != nil {
+			return err
+		}
+		referenced[key] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type object struct {
+		key  string
+		size int64
+	}
+	var unreferenced []object
+	walkOpts := castore.WalkOptions{Sorted: true}
+	if opts.GracePeriod > 0 {
+		walkOpts.OlderThan = time.Now().Add(-opts.GracePeriod)
+	}
+	err = d.d.store.WalkWithOptions(walkOpts, func(key string, size int64) error {
+		if !referenced[key] {
+			unreferenced = append(unreferenced, object{key, size})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PruneReport{}
+	for _, obj := range unreferenced {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		if !opts.DryRun {
+			if err = d.d.store.Delete(obj.key); err != nil {
+				return report, err
+			}
+		}
+		report.Keys = append(report.Keys, obj.key)
+		report.Bytes += obj.size
+	}
+	return report, nil
+}
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// linkPath returns the on-disk path of the link file for a registry path.
+func (d *driver) linkPath(subPath string) string {
+	return filepath.Join(d.links, filepath.FromSlash(subPath))
+}
+
+// stagingPath returns the on-disk path of the uncommitted data for a registry
+// path.
+func (d *driver) stagingPath(subPath string) string {
+	return filepath.Join(d.staging, filepath.FromSlash(subPath))
+}
+
+// readLink returns the key stored in the link file at the given path.
+func readLink(p string) (string, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeLink atomically points the link file at the given path to a key.
+func writeLink(p, key string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	tfile, err := ioutil.TempFile(filepath.Dir(p), ".link")
+	if err != nil {
+		return err
+	}
+	_, err = tfile.WriteString(key)
+	if cerr := tfile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tfile.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tfile.Name())
+	}
+	return err
+}
+
+// open returns the data stored at the given registry path.
+func (d *driver) open(subPath string) (io.ReadCloser, string, error) {
+	key, err := readLink(d.linkPath(subPath))
+	if os.IsNotExist(err) || isDirErr(err) {
+		return nil, "", storagedriver.PathNotFoundError{Path: subPath}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	r, err := d.store.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if r == nil {
+		return nil, "", fmt.Errorf("castoreregistry: data for %s is missing from the store", subPath)
+	}
+	return r, key, nil
+}
+
+// isDirErr returns whether the error is the result of reading a directory as
+// if it were a file.
+func isDirErr(err error) bool {
+	var pe *os.PathError
+	return errors.As(err, &pe) && strings.Contains(pe.Err.Error(), "is a directory")
+}
+
+func (d *driver) GetContent(ctx context.Context, subPath string) ([]byte, error) {
+	r, err := d.Reader(ctx, subPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (d *driver) PutContent(ctx context.Context, subPath string, content []byte) error {
+	key, err := d.store.PutBytes(content)
+	if err != nil {
+		return err
+	}
+	if err = writeLink(d.linkPath(subPath), key); err != nil {
+		return err
+	}
+
+	// Any uncommitted writes are superseded.
+	os.Remove(d.stagingPath(subPath))
+	return nil
+}
+
+func (d *driver) Reader(ctx context.Context, subPath string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: subPath, Offset: offset}
+	}
+
+	r, _, err := d.open(subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if seeker, ok := r.(io.Seeker); ok {
+			_, err = seeker.Seek(offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, r, offset)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+	sp := d.stagingPath(subPath)
+	if err := os.MkdirAll(filepath.Dir(sp), 0700); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if !append {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(sp, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// If we're appending to committed content, the staged data has to start
+	// with it.
+	if append && size == 0 {
+		r, _, err := d.open(subPath)
+		if err == nil {
+			size, err = io.Copy(f, r)
+			r.Close()
+		} else if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			err = nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &fileWriter{
+		d:    d,
+		path: subPath,
+		file: f,
+		bw:   bufio.NewWriter(f),
+		size: size,
+	}, nil
+}
+
+func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	lp := d.linkPath(subPath)
+	info, err := os.Stat(lp)
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: subPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := storagedriver.FileInfoFields{
+		Path:    subPath,
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+	if !info.IsDir() {
+		key, err := readLink(lp)
+		if err != nil {
+			return nil, err
+		}
+		if fields.Size, err = d.store.Size(key); err != nil {
+			return nil, err
+		}
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: fields}, nil
+}
+
+func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
+	entries, err := ioutil.ReadDir(d.linkPath(subPath))
+	if os.IsNotExist(err) {
+		return nil, storagedriver.PathNotFoundError{Path: subPath}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(entries))
+	for _, ent := range entries {
+		if strings.HasPrefix(ent.Name(), ".link") {
+			continue
+		}
+		ret = append(ret, path.Join(subPath, ent.Name()))
+	}
+	return ret, nil
+}
+
+func (d *driver) Move(ctx context.Context, sourcePath, destPath string) error {
+	src := d.linkPath(sourcePath)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: sourcePath}
+	}
+
+	dest := d.linkPath(destPath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return os.Rename(src, dest)
+}
+
+func (d *driver) Delete(ctx context.Context, subPath string) error {
+	lp := d.linkPath(subPath)
+	if _, err := os.Stat(lp); os.IsNotExist(err) {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(d.stagingPath(subPath)); err != nil {
+		return err
+	}
+	return os.RemoveAll(lp)
+}
+
+func (d *driver) RedirectURL(r *http.Request, subPath string) (string, error) {
+	return "", nil
+}
+
+func (d *driver) Walk(ctx context.Context, subPath string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, subPath, f, options...)
+}
+
+// fileWriter implements storagedriver.FileWriter.  Data is written to a
+// staging file, and only inserted into the store on Commit.
+type fileWriter struct {
+	d    *driver
+	path string
+	file *os.File
+	bw   *bufio.Writer
+	size int64
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("already closed")
+	} else if w.committed {
+		return 0, errors.New("already committed")
+	} else if w.cancelled {
+		return 0, errors.New("already cancelled")
+	}
+
+	n, err := w.bw.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *fileWriter) Size() int64 {
+	return w.size
+}
+
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return errors.New("already closed")
+	}
+	w.closed = true
+
+	if w.committed || w.cancelled {
+		return nil
+	}
+	if err := w.bw.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *fileWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return errors.New("already closed")
+	}
+	w.cancelled = true
+
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+func (w *fileWriter) Commit(ctx context.Context) error {
+	if w.closed {
+		return errors.New("already closed")
+	} else if w.committed {
+		return errors.New("already committed")
+	} else if w.cancelled {
+		return errors.New("already cancelled")
+	}
+
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+
+	// The staging file was opened write-only, so re-open it for the Put.
+	f, err := os.Open(w.file.Name())
+	if err != nil {
+		return err
+	}
+	key, err := w.d.store.Put(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = writeLink(w.d.linkPath(w.path), key); err != nil {
+		return err
+	}
+
+	w.committed = true
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
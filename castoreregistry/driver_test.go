@@ -0,0 +1,116 @@
+package castoreregistry
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriverSuite(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreregistry-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tdir)
+
+	testsuites.Driver(t, func() (storagedriver.StorageDriver, error) {
+		return New(Parameters{RootDirectory: tdir})
+	}, false)
+}
+
+func TestPrune(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreregistry-test-prune")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	d, err := New(Parameters{RootDirectory: tdir})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, d.PutContent(ctx, "/a/data", []byte("shared")))
+	assert.NoError(t, d.PutContent(ctx, "/b/data", []byte("shared")))
+	assert.NoError(t, d.PutContent(ctx, "/c/data", []byte("only")))
+
+	// Removing one of two references keeps the data.
+	assert.NoError(t, d.Delete(ctx, "/a"))
+	n, err := d.Prune(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	data, err := d.GetContent(ctx, "/b/data")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("shared"), data)
+
+	assert.NoError(t, d.Delete(ctx, "/c"))
+
+	// Recently written data isn't eligible.
+	report, err := d.PruneWithOptions(ctx, PruneOptions{DryRun: true, GracePeriod: time.Hour})
+	assert.NoError(t, err)
+	if assert.NotNil(t, report) {
+		assert.Empty(t, report.Keys)
+	}
+
+	// A dry run reports what would be deleted, and leaves it alone.
+	report, err = d.PruneWithOptions(ctx, PruneOptions{DryRun: true})
+	assert.NoError(t, err)
+	if !assert.NotNil(t, report) || !assert.Len(t, report.Keys, 1) {
+		return
+	}
+	assert.Equal(t, int64(len("only")), report.Bytes)
+	exists, err := d.d.store.Exists(report.Keys[0])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	n, err = d.Prune(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	exists, err = d.d.store.Exists(report.Keys[0])
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPruneGracePeriod(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoreregistry-test-prune")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	d, err := New(Parameters{RootDirectory: tdir})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, d.PutContent(ctx, "/a/data", []byte("layer")))
+	assert.NoError(t, d.Delete(ctx, "/a"))
+
+	// Make the data look as though it was written long ago.
+	old := time.Now().Add(-48 * time.Hour)
+	err = filepath.Walk(filepath.Join(tdir, "blobs"), func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			err = os.Chtimes(p, old, old)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+
+	opts := PruneOptions{DryRun: true, GracePeriod: time.Hour}
+	report, err := d.PruneWithOptions(ctx, opts)
+	assert.NoError(t, err)
+	if assert.NotNil(t, report) {
+		assert.Len(t, report.Keys, 1)
+	}
+
+	// Pushing the same data again, but not yet linking it, protects it.
+	assert.NoError(t, d.PutContent(ctx, "/b/data", []byte("layer")))
+	assert.NoError(t, d.Delete(ctx, "/b"))
+	report, err = d.PruneWithOptions(ctx, opts)
+	assert.NoError(t, err)
+	if assert.NotNil(t, report) {
+		assert.Empty(t, report.Keys)
+	}
+}
@@ -0,0 +1,97 @@
+package castores3
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// errBadChunk is the error returned when an aws-chunked body is malformed.
+var errBadChunk = errors.New("castores3: malformed aws-chunked body")
+
+// chunkedReader decodes a body sent with the "aws-chunked" content encoding,
+// which SDKs use for streaming signed uploads.  Each chunk looks like:
+//
+//	<hex size>;chunk-signature=<sig>\r\n<data>\r\n
+//
+// and the body is terminated by a zero-sized chunk, optionally followed by
+// trailing headers.  The chunk signatures are not verified.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+	err       error
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+		c.err = c.nextChunk()
+	}
+
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		err = c.readCRLF()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk reads the next chunk header.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+
+	size, err := strconv.ParseInt(line, 16, 64)
+	if err != nil || size < 0 {
+		return errBadChunk
+	}
+	if size == 0 {
+		// Final chunk; ignore any trailers.
+		c.done = true
+		return nil
+	}
+	c.remaining = size
+	return nil
+}
+
+// readCRLF consumes the line ending that follows each chunk's data.
+func (c *chunkedReader) readCRLF() error {
+	var buf [2]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		return err
+	}
+	if buf != [2]byte{'\r', '\n'} {
+		return errBadChunk
+	}
+	return nil
+}
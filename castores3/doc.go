@@ -0,0 +1,17 @@
+/*
+Package castores3 exposes a castore.CAStore through a minimal subset of the
+Amazon S3 API, so that existing S3 clients and tools can read from and write to
+it.
+
+The gateway serves a single bucket using path-style addressing
+("/<bucket>/<key>"), and every object's key is the content hash of its data.
+The supported operations are ListBuckets, HeadBucket, GetBucketLocation,
+ListObjectsV2, GetObject, HeadObject and PutObject, and multipart uploads
+(CreateMultipartUpload, UploadPart, CompleteMultipartUpload and
+AbortMultipartUpload), which let clients upload the parts of a large object
+concurrently.  The parts of a multipart upload are hashed together when it is
+completed, and as with PutObject, the object is only stored if its key
+matches.  Request signatures are not verified, so the gateway should only be
+exposed on a trusted network or behind an authenticating proxy.
+*/
+package castores3
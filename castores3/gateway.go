@@ -0,0 +1,316 @@
+package castores3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrew-d/castore"
+	"github.com/andrew-d/castore/castorehttp"
+)
+
+// maxListKeys is the largest number of keys returned by a single
+// ListObjectsV2 request.
+const maxListKeys = 1000
+
+// gateway is the http.Handler returned by Handler.
+type gateway struct {
+	s      *castore.CAStore
+	fs     fs.StatFS
+	bucket string
+	get    http.Handler
+}
+
+// Handler returns an http.Handler that serves the given store as an S3 bucket
+// with the given name.
+func Handler(s *castore.CAStore, bucket string) http.Handler {
+	return &gateway{
+		s:      s,
+		fs:     s.FS().(fs.StatFS),
+		bucket: bucket,
+		get:    http.StripPrefix("/"+bucket, castorehttp.Handler(s)),
+	}
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+
+	switch {
+	case bucket == "" && r.Method == "GET":
+		g.listBuckets(w, r)
+	case bucket != g.bucket:
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case key == "" && r.Method == "HEAD":
+		w.WriteHeader(http.StatusOK)
+	case key == "" && r.Method == "GET":
+		if hasQuery(r, "location") {
+			writeXML(w, http.StatusOK, locationConstraint{})
+			return
+		}
+		g.listObjects(w, r)
+	case key != "" && (r.Method == "GET" || r.Method == "HEAD"):
+		g.getObject(w, r, key)
+	case key != "" && r.Method == "POST" && hasQuery(r, "uploads"):
+		g.createMultipartUpload(w, r, key)
+	case key != "" && r.Method == "PUT" && hasQuery(r, "uploadId"):
+		g.uploadPart(w, r)
+	case key != "" && r.Method == "POST" && hasQuery(r, "uploadId"):
+		g.completeMultipartUpload(w, r, key)
+	case key != "" && r.Method == "DELETE" && hasQuery(r, "uploadId"):
+		g.abortMultipartUpload(w, r)
+	case key != "" && r.Method == "PUT":
+		g.putObject(w, r, key)
+	default:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported")
+	}
+}
+
+// hasQuery returns whether the given request has the given query parameter,
+// even if it has no value.
+func hasQuery(r *http.Request, name string) bool {
+	_, ok := r.URL.Query()[name]
+	return ok
+}
+
+// splitPath splits a path-style request path into a bucket and key.
+func splitPath(p string) (string, string) {
+	p = strings.TrimPrefix(p, "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func (g *gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	var created time.Time
+	if inf, err := g.fs.Stat("."); err == nil {
+		created = inf.ModTime()
+	}
+
+	writeXML(w, http.StatusOK, listAllMyBucketsResult{
+		Owner:   owner{ID: "castore", DisplayName: "castore"},
+		Buckets: []bucketEntry{{Name: g.bucket, CreationDate: formatTime(created)}},
+	})
+}
+
+func (g *gateway) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported")
+		return
+	}
+
+	maxKeys := maxListKeys
+	if mk := q.Get("max-keys"); mk != "" {
+		n, err := strconv.Atoi(mk)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys")
+			return
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+
+	res := listBucketResult{
+		Name:              g.bucket,
+		Prefix:            q.Get("prefix"),
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+
+	// Keys are returned in lexicographic order, so we need to gather them all
+	// before we can paginate.
+	var keys []string
+	after := res.StartAfter
+	if res.ContinuationToken > after {
+		after = res.ContinuationToken
+	}
+	err := g.s.Walk(func(key string, size int64) error {
+		if strings.HasPrefix(key, res.Prefix) && key > after {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	sort.Strings(keys)
+
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		res.IsTruncated = true
+		if maxKeys > 0 {
+			res.NextContinuationToken = keys[len(keys)-1]
+		}
+	}
+
+	for _, key := range keys {
+		inf, err := g.fs.Stat(key)
+		if err != nil {
+			// Deleted while listing.
+			continue
+		}
+		res.Contents = append(res.Contents, object{
+			Key:          key,
+			LastModified: formatTime(inf.ModTime()),
+			ETag:         `"` + key + `"`,
+			Size:         inf.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	res.KeyCount = len(res.Contents)
+
+	writeXML(w, http.StatusOK, res)
+}
+
+func (g *gateway) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	if _, err := g.fs.Stat(key); err != nil {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	g.get.ServeHTTP(w, r)
+}
+
+func (g *gateway) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	// Objects are named by their content, so the client has to give us the
+	// right name.  If it didn't, nothing is stored.
+	if err := g.s.PutVerified(key, requestBody(r)); err != nil {
+		writePutError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id, err := g.s.BeginUpload()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{
+		Bucket:   g.bucket,
+		Key:      key,
+		UploadID: id,
+	})
+}
+
+func (g *gateway) uploadPart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	part, err := strconv.Atoi(q.Get("partNumber"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive.")
+		return
+	}
+
+	// Clients expect the ETag of a part to be its MD5 hash.
+	h := md5.New()
+	if _, err = g.s.UploadPart(q.Get("uploadId"), part, io.TeeReader(requestBody(r), h)); err != nil {
+		writePutError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", `"`+hex.EncodeToString(h.Sum(nil))+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		return
+	}
+	parts := make([]int, len(req.Parts))
+	for i, part := range req.Parts {
+		parts[i] = part.PartNumber
+	}
+
+	// As with PutObject, the parts are only stored if they hash to the key.
+	if _, err := g.s.CommitParts(r.URL.Query().Get("uploadId"), parts, key); err != nil {
+		writePutError(w, r, err)
+		return
+	}
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: r.URL.Path,
+		Bucket:   g.bucket,
+		Key:      key,
+		ETag:     `"` + key + `"`,
+	})
+}
+
+func (g *gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request) {
+	if err := g.s.AbortUpload(r.URL.Query().Get("uploadId")); err != nil {
+		writePutError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requestBody returns the data uploaded by the given request, decoding it if
+// it was sent with aws-chunked encoding.
+func requestBody(r *http.Request) io.Reader {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return newChunkedReader(r.Body)
+	}
+	return r.Body
+}
+
+// writePutError writes the S3 error response for an error from storing data.
+func writePutError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case castore.ErrSizeExceeded:
+		writeError(w, r, http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size.")
+	case castore.ErrKeyMismatch:
+		writeError(w, r, http.StatusBadRequest, "BadDigest", "The object key must be the content hash.")
+	case castore.ErrQuotaExceeded:
+		writeError(w, r, http.StatusInsufficientStorage, "QuotaExceeded", "Storing the object would exceed the store's quota.")
+	case castore.ErrWriteOnce:
+		writeError(w, r, http.StatusForbidden, "AccessDenied", "Objects in this store cannot be modified.")
+	case castore.ErrEmpty, castore.ErrSizeTooSmall:
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "Your proposed upload is smaller than the minimum allowed object size.")
+	case castore.ErrNoSuchUpload:
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+	case castore.ErrInvalidPart:
+		writeError(w, r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+	default:
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// formatTime formats a time in the format used by S3 responses.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// writeXML is a helper function that writes an XML response.
+func writeXML(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+// writeError is a helper function that writes an S3 error response.
+func writeError(w http.ResponseWriter, r *http.Request, code int, s3code, msg string) {
+	if r.Method == "HEAD" {
+		w.WriteHeader(code)
+		return
+	}
+	writeXML(w, code, errorResponse{
+		Code:     s3code,
+		Message:  msg,
+		Resource: r.URL.Path,
+	})
+}
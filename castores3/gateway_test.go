@@ -0,0 +1,312 @@
+package castores3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func do(h http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestGateway(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:  tdir,
+		Transform: castore.DepthTransformFunc(1),
+	})
+	assert.NoError(t, err)
+
+	h := Handler(s, "blobs")
+
+	// PutObject, both plain and aws-chunked
+	w := do(h, "PUT", "/blobs/"+TEST_KEY, TEST_VALUE, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"`+TEST_KEY+`"`, w.Header().Get("ETag"))
+
+	chunked := "3;chunk-signature=abc\r\nfoo\r\n3;chunk-signature=def\r\nbar\r\n0;chunk-signature=ghi\r\n\r\n"
+	w = do(h, "PUT", "/blobs/"+TEST_KEY, chunked, map[string]string{
+		"X-Amz-Content-Sha256": "STREAMING-AWS4-HMAC-SHA256-PAYLOAD",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(h, "PUT", "/blobs/wrong", TEST_VALUE, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "BadDigest")
+
+	// Data uploaded under the wrong key isn't stored at all.
+	w = do(h, "PUT", "/blobs/"+TEST_KEY, "mismatched", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "BadDigest")
+	objects := 0
+	assert.NoError(t, s.Walk(func(key string, size int64) error {
+		objects++
+		return nil
+	}))
+	assert.Equal(t, 1, objects)
+
+	otherKey, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	// GetObject / HeadObject
+	w = do(h, "GET", "/blobs/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	w = do(h, "HEAD", "/blobs/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+
+	w = do(h, "GET", "/blobs/"+otherKey[1:]+"0", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "NoSuchKey")
+
+	w = do(h, "GET", "/other/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// ListObjectsV2, with pagination
+	var res listBucketResult
+	w = do(h, "GET", "/blobs?list-type=2&max-keys=1", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.KeyCount)
+	assert.True(t, res.IsTruncated)
+
+	first := res.Contents[0].Key
+	token := res.NextContinuationToken
+
+	res = listBucketResult{}
+	w = do(h, "GET", "/blobs?list-type=2&continuation-token="+token, "", nil)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.KeyCount)
+	assert.False(t, res.IsTruncated)
+	assert.True(t, first < res.Contents[0].Key)
+	assert.Equal(t, map[string]bool{TEST_KEY: true, otherKey: true},
+		map[string]bool{first: true, res.Contents[0].Key: true})
+
+	res = listBucketResult{}
+	w = do(h, "GET", "/blobs?list-type=2&prefix="+TEST_KEY[:4], "", nil)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.KeyCount)
+	assert.Equal(t, TEST_KEY, res.Contents[0].Key)
+
+	// Bucket-level operations
+	w = do(h, "GET", "/", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<Name>blobs</Name>")
+
+	w = do(h, "HEAD", "/blobs", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGatewayInline(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:      tdir,
+		InlineMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	h := Handler(s, "blobs")
+	w := do(h, "GET", "/blobs/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	var res listBucketResult
+	w = do(h, "GET", "/blobs?list-type=2", "", nil)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.KeyCount)
+	assert.Equal(t, TEST_KEY, res.Contents[0].Key)
+	assert.Equal(t, int64(len(TEST_VALUE)), res.Contents[0].Size)
+}
+
+func TestGatewayRepack(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{
+		BasePath:    tdir,
+		PackMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+
+	h := Handler(s, "blobs")
+	w := do(h, "GET", "/blobs/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, TEST_VALUE, w.Body.String())
+
+	w = do(h, "HEAD", "/blobs/"+TEST_KEY, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "6", w.Header().Get("Content-Length"))
+
+	var res listBucketResult
+	w = do(h, "GET", "/blobs?list-type=2", "", nil)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.KeyCount)
+	assert.Equal(t, TEST_KEY, res.Contents[0].Key)
+}
+
+func TestGatewayDelta(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(base)
+	target := append([]byte(nil), base...)
+	copy(target[1000:], "changed")
+
+	bkey, err := s.PutBytes(base)
+	assert.NoError(t, err)
+	key, err := s.PutDelta(bytes.NewReader(target), bkey)
+	assert.NoError(t, err)
+
+	h := Handler(s, "blobs")
+	w := do(h, "GET", "/blobs/"+key, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, target, w.Body.Bytes())
+
+	var res listBucketResult
+	w = do(h, "GET", "/blobs?list-type=2", "", nil)
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 2, res.KeyCount)
+}
+
+func TestGatewayMultipart(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	s, err := castore.New(castore.Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	h := Handler(s, "blobs")
+	begin := func() string {
+		var res initiateMultipartUploadResult
+		w := do(h, "POST", "/blobs/"+TEST_KEY+"?uploads", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, TEST_KEY, res.Key)
+		return res.UploadID
+	}
+	complete := `<CompleteMultipartUpload><Part><PartNumber>1</PartNumber></Part><Part><PartNumber>2</PartNumber></Part></CompleteMultipartUpload>`
+
+	// Parts can arrive in any order.
+	id := begin()
+	w := do(h, "PUT", "/blobs/"+TEST_KEY+"?partNumber=2&uploadId="+id, "bar", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"37b51d194a7513e45b56f6524f2d51f2"`, w.Header().Get("ETag"))
+	w = do(h, "PUT", "/blobs/"+TEST_KEY+"?partNumber=1&uploadId="+id, "foo", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = do(h, "POST", "/blobs/"+TEST_KEY+"?uploadId="+id, complete, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<Key>"+TEST_KEY+"</Key>")
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	w = do(h, "POST", "/blobs/"+TEST_KEY+"?uploadId="+id, complete, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "NoSuchUpload")
+
+	// Parts that don't hash to the key aren't stored, and missing parts are
+	// rejected.
+	id = begin()
+	do(h, "PUT", "/blobs/"+TEST_KEY+"?partNumber=1&uploadId="+id, "bar", nil)
+	w = do(h, "POST", "/blobs/"+TEST_KEY+"?uploadId="+id, complete, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "InvalidPart")
+	do(h, "PUT", "/blobs/"+TEST_KEY+"?partNumber=2&uploadId="+id, "foo", nil)
+	w = do(h, "POST", "/blobs/"+TEST_KEY+"?uploadId="+id, complete, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "BadDigest")
+
+	w = do(h, "DELETE", "/blobs/"+TEST_KEY+"?uploadId="+id, "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do(h, "PUT", "/blobs/"+TEST_KEY+"?partNumber=1&uploadId="+id, "foo", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	n, err := s.PruneUploads(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestGatewayPutErrors(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castores3-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	const emptyKey = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	testCases := []struct {
+		name   string
+		opts   castore.Options
+		key    string
+		body   string
+		code   int
+		s3code string
+	}{
+		{"quota", castore.Options{Quota: 4}, TEST_KEY, TEST_VALUE, http.StatusInsufficientStorage, "QuotaExceeded"},
+		{"empty", castore.Options{RejectEmpty: true}, emptyKey, "", http.StatusBadRequest, "InvalidRequest"},
+		{"small", castore.Options{MinSize: 16}, TEST_KEY, TEST_VALUE, http.StatusBadRequest, "InvalidRequest"},
+	}
+	for _, tc := range testCases {
+		tc.opts.BasePath = filepath.Join(tdir, tc.name)
+		s, err := castore.New(tc.opts)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+
+		w := do(Handler(s, "blobs"), "PUT", "/blobs/"+tc.key, tc.body, nil)
+		assert.Equal(t, tc.code, w.Code, tc.name)
+		assert.Contains(t, w.Body.String(), tc.s3code, tc.name)
+		s.Close()
+	}
+
+	// Put never modifies an object, but anything that would is refused.
+	w := httptest.NewRecorder()
+	writePutError(w, httptest.NewRequest("PUT", "/blobs/"+TEST_KEY, nil), castore.ErrWriteOnce)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "AccessDenied")
+}
@@ -0,0 +1,29 @@
+/*
+Package castoresql helps applications keep large values out of their SQL
+database by storing them in a castore.Store, and keeping only a small
+reference in the database column.
+
+Columns hold a Value, which implements sql.Scanner and driver.Valuer, so it
+can be used directly as a query argument or scan destination.  A Value is
+either the data itself, for data small enough to be worth keeping inline, or a
+reference to a blob in the store.  Columns should be of a binary type, such as
+BLOB or BYTEA.
+
+Usage:
+
+	o := castoresql.New(store, castoresql.Options{InlineSize: 256})
+
+	v, err := o.Put(body)
+	...
+	_, err = db.Exec("INSERT INTO attachments (id, body) VALUES (?, ?)", id, v)
+
+	var v castoresql.Value
+	err = db.QueryRow("SELECT body FROM attachments WHERE id = ?", id).Scan(&v)
+	...
+	r, err := o.Open(v)
+
+Since identical data is only stored once, a blob may be referenced by several
+rows.  When a row is removed, pass its value to Release, which deletes the blob
+unless Options.Referenced reports that another row still refers to it.
+*/
+package castoresql
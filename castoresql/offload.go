@@ -0,0 +1,165 @@
+package castoresql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/andrew-d/castore"
+)
+
+// refPrefix starts every Value that refers to a blob in the store, rather than
+// containing the data itself.  Data that happens to start with it is always
+// stored in the store, so that it can't be mistaken for a reference.
+const refPrefix = "\x00castore:"
+
+// Value is the contents of a column managed by an Offloader.
+type Value []byte
+
+var (
+	_ sql.Scanner   = (*Value)(nil)
+	_ driver.Valuer = Value(nil)
+)
+
+// Key returns the key of the blob that the Value refers to, or false if the
+// Value contains the data itself.
+func (v Value) Key() (string, bool) {
+	if !bytes.HasPrefix(v, []byte(refPrefix)) {
+		return "", false
+	}
+	return string(v[len(refPrefix):]), true
+}
+
+// Value implements driver.Valuer.
+func (v Value) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return []byte(v), nil
+}
+
+// Scan implements sql.Scanner.
+func (v *Value) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*v = nil
+	case []byte:
+		*v = append(Value(nil), src...)
+	case string:
+		*v = Value(src)
+	default:
+		return fmt.Errorf("castoresql: cannot scan %T into a Value", src)
+	}
+	return nil
+}
+
+// Options controls the behaviour of an Offloader.
+type Options struct {
+	// InlineSize is the largest value, in bytes, that will be kept in the
+	// column itself rather than being stored in the store.  The default, 0,
+	// stores every non-empty value in the store.
+	InlineSize int
+
+	// Referenced, if set, is called by Release to check whether any remaining
+	// row refers to the given key - for example, with a query such as
+	// "SELECT 1 FROM attachments WHERE body = ? LIMIT 1", passing
+	// RefValue(key) as the argument.  If it is not set, Release always
+	// deletes the blob, which is only safe if values are never shared.
+	Referenced func(ctx context.Context, key string) (bool, error)
+}
+
+// Offloader stores values in a castore.Store in place of a database.
+type Offloader struct {
+	s    castore.Store
+	opts Options
+}
+
+// New returns an Offloader that stores values in the given store.
+func New(s castore.Store, opts Options) *Offloader {
+	return &Offloader{s: s, opts: opts}
+}
+
+// RefValue returns the Value that refers to the blob with the given key.
+func RefValue(key string) Value {
+	return Value(refPrefix + key)
+}
+
+// Put will read all of the data from r and return the Value to store in the
+// database for it, storing the data in the store unless it is small enough
+// to be kept inline.
+func (o *Offloader) Put(r io.Reader) (Value, error) {
+	// Read just enough to tell whether the data fits inline.
+	head, err := ioutil.ReadAll(io.LimitReader(r, int64(o.opts.InlineSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(head) <= o.opts.InlineSize && !bytes.HasPrefix(head, []byte(refPrefix)) {
+		if head == nil {
+			head = []byte{}
+		}
+		return Value(head), nil
+	}
+
+	key, err := o.s.Put(io.MultiReader(bytes.NewReader(head), r))
+	if err != nil {
+		return nil, err
+	}
+	return RefValue(key), nil
+}
+
+// PutBytes is like Put, but stores the given byte slice.
+func (o *Offloader) PutBytes(data []byte) (Value, error) {
+	return o.Put(bytes.NewReader(data))
+}
+
+// Open will return a reader for the data that the given Value holds or refers
+// to.  If the blob it refers to is missing from the store, castore.ErrNotFound
+// is returned.
+func (o *Offloader) Open(v Value) (io.ReadCloser, error) {
+	key, ok := v.Key()
+	if !ok {
+		return ioutil.NopCloser(bytes.NewReader(v)), nil
+	}
+	r, err := o.s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return nil, castore.ErrNotFound
+	}
+	return r, nil
+}
+
+// Bytes is like Open, but returns all of the data at once.
+func (o *Offloader) Bytes(v Value) ([]byte, error) {
+	r, err := o.Open(v)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Release should be called with the Value of a row that has been removed, or
+// whose value has been replaced.  If the Value refers to a blob that no
+// remaining row refers to, as determined by Options.Referenced, the blob is
+// deleted from the store.  It should be called after the transaction that
+// removed the row has been committed, so that a rollback can't leave a row
+// referring to a deleted blob.
+func (o *Offloader) Release(ctx context.Context, v Value) error {
+	key, ok := v.Key()
+	if !ok {
+		return nil
+	}
+	if o.opts.Referenced != nil {
+		used, err := o.opts.Referenced(ctx, key)
+		if err != nil || used {
+			return err
+		}
+	}
+	return o.s.Delete(key)
+}
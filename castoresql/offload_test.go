@@ -0,0 +1,118 @@
+package castoresql
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func newStore(t *testing.T) (*castore.CAStore, func()) {
+	tdir, err := ioutil.TempDir("", "castoresql-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := castore.New(castore.Options{BasePath: tdir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(tdir) }
+}
+
+func TestOffload(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	o := New(s, Options{InlineSize: 4})
+
+	// Small values are kept inline.
+	v, err := o.PutBytes([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, Value("abc"), v)
+	_, ok := v.Key()
+	assert.False(t, ok)
+	data, err := o.Bytes(v)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+
+	// Large ones are stored in the store.
+	v, err = o.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	key, ok := v.Key()
+	assert.True(t, ok)
+	assert.Equal(t, TEST_KEY, key)
+	data, err = o.Bytes(v)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	// Data that looks like a reference is never kept inline.
+	tricky, err := o.PutBytes([]byte(refPrefix))
+	assert.NoError(t, err)
+	_, ok = tricky.Key()
+	assert.True(t, ok)
+	data, err = o.Bytes(tricky)
+	assert.NoError(t, err)
+	assert.Equal(t, refPrefix, string(data))
+
+	// Missing blobs are an error.
+	_, err = o.Open(RefValue(strings.Repeat("0", 64)))
+	assert.Equal(t, castore.ErrNotFound, err)
+}
+
+func TestRelease(t *testing.T) {
+	s, cleanup := newStore(t)
+	defer cleanup()
+
+	refs := map[string]int{}
+	o := New(s, Options{
+		Referenced: func(ctx context.Context, key string) (bool, error) {
+			return refs[key] > 0, nil
+		},
+	})
+
+	v, err := o.PutBytes([]byte(TEST_VALUE))
+	assert.NoError(t, err)
+
+	// Still referenced by another row.
+	refs[TEST_KEY] = 1
+	assert.NoError(t, o.Release(context.Background(), v))
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	refs[TEST_KEY] = 0
+	assert.NoError(t, o.Release(context.Background(), v))
+	exists, err = s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Inline values have nothing to release.
+	assert.NoError(t, o.Release(context.Background(), Value("x")))
+}
+
+func TestScanValue(t *testing.T) {
+	var v Value
+	assert.NoError(t, v.Scan([]byte("abc")))
+	assert.Equal(t, Value("abc"), v)
+	assert.NoError(t, v.Scan("def"))
+	assert.Equal(t, Value("def"), v)
+	assert.NoError(t, v.Scan(nil))
+	assert.Nil(t, v)
+	assert.Error(t, v.Scan(42))
+
+	dv, err := RefValue(TEST_KEY).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(refPrefix+TEST_KEY), dv)
+	dv, err = Value(nil).Value()
+	assert.NoError(t, err)
+	assert.Nil(t, dv)
+}
@@ -0,0 +1,26 @@
+/*
+Package castoretenant manages a set of isolated castore.CAStores, one per
+tenant, sharing a common base directory.
+
+Each tenant's store lives in its own subdirectory, so tenants never see each
+other's objects, and each has its own quota (see castore.Options.Quota).
+Tenants must be created explicitly with Create; their stores are opened on
+first use, and closed again when too many are open and idle.
+
+Usage:
+
+	t, err := castoretenant.New(castoretenant.Options{
+		BasePath: "/var/lib/blobs",
+		Store:    castore.Options{Quota: 1 << 30},
+	})
+	...
+	err = t.Create("acme")
+	...
+	s, release, err := t.Acquire("acme")
+	...
+	defer release()
+
+See castorehttp.NewTenantServer and castoregrpc.NewTenantServer for serving
+tenants over the network.
+*/
+package castoretenant
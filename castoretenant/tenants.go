@@ -0,0 +1,253 @@
+package castoretenant
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/andrew-d/castore"
+)
+
+var (
+	// ErrInvalidTenant is the error returned when a tenant name is not valid.
+	ErrInvalidTenant = errors.New("castoretenant: invalid tenant name")
+
+	// ErrUnknownTenant is the error returned when a tenant has not been
+	// created with Create.
+	ErrUnknownTenant = errors.New("castoretenant: unknown tenant")
+)
+
+// defaultMaxIdle is the default Options.MaxIdle.
+const defaultMaxIdle = 64
+
+// Options contains the options that control how tenants' stores are created.
+type Options struct {
+	// BasePath is the directory that contains each tenant's store.  It must
+	// be specified.
+	BasePath string
+
+	// Store contains the options used for every tenant's store.  Its BasePath
+	// is ignored.
+	Store castore.Options
+
+	// Configure, if set, is called before each tenant's store is created, and
+	// may modify its options - for example, to give it a different quota.
+	Configure func(tenant string, opts *castore.Options) error
+
+	// MaxIdle is the number of tenants' stores that are kept open while
+	// nothing is using them (see Acquire).  Once it is exceeded, the least
+	// recently used are closed, and reopened when they are next needed.  If
+	// not positive, a default of 64 is used.
+	MaxIdle int
+}
+
+// Tenants is a set of per-tenant stores.  It is safe for concurrent use.
+type Tenants struct {
+	opts Options
+
+	mu     sync.Mutex
+	stores map[string]*openStore
+}
+
+// openStore is a tenant's store that is currently open.
+type openStore struct {
+	s *castore.CAStore
+
+	// The number of callers using the store, and when the last one finished;
+	// a pinned store is never closed before the Tenants are
+	refs     int
+	released time.Time
+	pinned   bool
+}
+
+// New creates a new set of tenants with the given options.
+func New(opts Options) (*Tenants, error) {
+	if opts.BasePath == "" {
+		return nil, castore.ErrNoBasePath
+	}
+	if err := os.MkdirAll(opts.BasePath, 0700); err != nil {
+		return nil, err
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = defaultMaxIdle
+	}
+
+	return &Tenants{
+		opts:   opts,
+		stores: make(map[string]*openStore),
+	}, nil
+}
+
+// ValidTenant returns whether the given string is a valid tenant name: one to
+// 64 characters from the set [a-zA-Z0-9_-].
+func ValidTenant(name string) bool {
+	if len(name) == 0 || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Create creates the store for the given tenant, if it doesn't already exist.
+// Tenants are never created implicitly, so that clients can't use up
+// resources by naming tenants that nobody has set up.
+func (t *Tenants) Create(tenant string) error {
+	if !ValidTenant(tenant) {
+		return ErrInvalidTenant
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.stores[tenant]; ok {
+		return nil
+	}
+	if err := os.MkdirAll(t.dir(tenant), 0700); err != nil {
+		return err
+	}
+	ent, err := t.open(tenant)
+	if err != nil {
+		return err
+	}
+	ent.released = time.Now()
+	t.stores[tenant] = ent
+	t.closeIdle()
+	return nil
+}
+
+// Store returns the store for the given tenant, which must have been created
+// with Create.  The store is kept open until the Tenants are closed.
+func (t *Tenants) Store(tenant string) (*castore.CAStore, error) {
+	ent, err := t.acquire(tenant)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	ent.pinned = true
+	ent.refs--
+	t.mu.Unlock()
+	return ent.s, nil
+}
+
+// Acquire returns the store for the given tenant, which must have been
+// created with Create, along with a function that must be called once the
+// caller has finished using it.  Until then, the store is kept open; after
+// that, it may be closed if too many stores are open (see Options.MaxIdle).
+func (t *Tenants) Acquire(tenant string) (*castore.CAStore, func(), error) {
+	ent, err := t.acquire(tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return ent.s, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			ent.refs--
+			ent.released = time.Now()
+			t.closeIdle()
+		})
+	}, nil
+}
+
+// acquire opens the store for the given tenant if necessary, and adds a
+// reference to it.
+func (t *Tenants) acquire(tenant string) (*openStore, error) {
+	if !ValidTenant(tenant) {
+		return nil, ErrInvalidTenant
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ent, ok := t.stores[tenant]
+	if !ok {
+		info, err := os.Stat(t.dir(tenant))
+		if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+			return nil, ErrUnknownTenant
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ent, err = t.open(tenant); err != nil {
+			return nil, err
+		}
+		t.stores[tenant] = ent
+	}
+	ent.refs++
+	return ent, nil
+}
+
+// dir returns the directory that holds the given tenant's store.
+func (t *Tenants) dir(tenant string) string {
+	return filepath.Join(t.opts.BasePath, tenant)
+}
+
+// open opens the store for the given tenant.  It must be called with mu held.
+func (t *Tenants) open(tenant string) (*openStore, error) {
+	opts := t.opts.Store
+	opts.BasePath = t.dir(tenant)
+	if t.opts.Configure != nil {
+		if err := t.opts.Configure(tenant, &opts); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := castore.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &openStore{s: s}, nil
+}
+
+// closeIdle closes the least recently used stores that nothing is using, until
+// no more than MaxIdle remain.  It must be called with mu held.
+func (t *Tenants) closeIdle() {
+	for {
+		var (
+			idle   int
+			oldest string
+		)
+		for name, ent := range t.stores {
+			if ent.refs > 0 || ent.pinned {
+				continue
+			}
+			idle++
+			if oldest == "" || ent.released.Before(t.stores[oldest].released) {
+				oldest = name
+			}
+		}
+		if idle <= t.opts.MaxIdle {
+			return
+		}
+
+		if err := t.stores[oldest].s.Close(); err != nil && t.opts.Store.Logger != nil {
+			t.opts.Store.Logger.Warn("error closing idle tenant store", "tenant", oldest, "err", err)
+		}
+		delete(t.stores, oldest)
+	}
+}
+
+// Close closes every tenant's store, returning the first error encountered.
+func (t *Tenants) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ret error
+	for name, ent := range t.stores {
+		if err := ent.s.Close(); err != nil && ret == nil {
+			ret = err
+		}
+		delete(t.stores, name)
+	}
+	return ret
+}
@@ -0,0 +1,114 @@
+package castoretenant
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-d/castore"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_KEY   = "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2"
+	TEST_VALUE = "foobar"
+)
+
+func TestTenants(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoretenant-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	tenants, err := New(Options{
+		BasePath: tdir,
+		Store:    castore.Options{Quota: 6},
+		Configure: func(tenant string, opts *castore.Options) error {
+			if tenant == "big" {
+				opts.Quota = 0
+			}
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	defer tenants.Close()
+
+	// Tenants must be created before they can be used.
+	_, err = tenants.Store("a")
+	assert.Equal(t, ErrUnknownTenant, err)
+	_, err = os.Stat(filepath.Join(tdir, "a"))
+	assert.True(t, os.IsNotExist(err))
+	for _, name := range []string{"a", "b", "big"} {
+		assert.NoError(t, tenants.Create(name))
+	}
+
+	a, err := tenants.Store("a")
+	assert.NoError(t, err)
+	again, err := tenants.Store("a")
+	assert.NoError(t, err)
+	assert.True(t, a == again)
+
+	b, err := tenants.Store("b")
+	assert.NoError(t, err)
+	big, err := tenants.Store("big")
+	assert.NoError(t, err)
+
+	// Tenants are isolated from each other.
+	_, err = a.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	exists, err := b.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// ... and have their own quotas.
+	_, err = a.PutString("more")
+	assert.Equal(t, castore.ErrQuotaExceeded, err)
+	_, err = b.PutString("more")
+	assert.NoError(t, err)
+	_, err = big.PutString("this is larger than the default quota")
+	assert.NoError(t, err)
+
+	for _, name := range []string{"", "..", "a/b", ".castore"} {
+		_, err = tenants.Store(name)
+		assert.Equal(t, ErrInvalidTenant, err, name)
+		assert.Equal(t, ErrInvalidTenant, tenants.Create(name), name)
+	}
+}
+
+func TestTenantsMaxIdle(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "castoretenant-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	tenants, err := New(Options{BasePath: tdir, MaxIdle: 1})
+	assert.NoError(t, err)
+	defer tenants.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, tenants.Create(name))
+	}
+	assert.Len(t, tenants.stores, 1)
+
+	// Stores that are in use are never closed.
+	a, releaseA, err := tenants.Acquire("a")
+	assert.NoError(t, err)
+	_, releaseB, err := tenants.Acquire("b")
+	assert.NoError(t, err)
+	assert.Len(t, tenants.stores, 3)
+	releaseB()
+	releaseB()
+	assert.Len(t, tenants.stores, 2)
+	_, err = a.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	releaseA()
+	assert.Len(t, tenants.stores, 1)
+	_, ok := tenants.stores["a"]
+	assert.True(t, ok)
+
+	// Closed stores are reopened when needed.
+	b, releaseB, err := tenants.Acquire("b")
+	assert.NoError(t, err)
+	defer releaseB()
+	exists, err := b.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,30 @@
+// Command castore-inventory writes an inventory of a castore.CAStore to
+// standard output, as newline-delimited JSON.  See castore.Inventory for a
+// description of the output.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/andrew-d/castore"
+)
+
+func main() {
+	basePath := flag.String("path", "", "base path of the store (required)")
+	flag.Parse()
+
+	if *basePath == "" {
+		log.Fatal("castore-inventory: the -path flag is required")
+	}
+
+	// The layout of the store doesn't matter, since every object is visited.
+	s, err := castore.New(castore.Options{BasePath: *basePath})
+	if err != nil {
+		log.Fatalf("castore-inventory: %s", err)
+	}
+	if err = s.Inventory(os.Stdout); err != nil {
+		log.Fatalf("castore-inventory: %s", err)
+	}
+}
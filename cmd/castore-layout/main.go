@@ -0,0 +1,74 @@
+// Command castore-layout examines a castore.CAStore and recommends a layout
+// for it - the depth and width of ShardTransformFunc - that suits the number
+// of objects it contains and the filesystem it is on.  With -apply, the store
+// is migrated to the recommended layout using castore.Relayout.  See
+// castore.RecommendLayout for how the recommendation is made.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/andrew-d/castore"
+)
+
+func main() {
+	var (
+		basePath = flag.String("path", "", "base path of the store (required)")
+		depth    = flag.Int("depth", 0, "number of directory levels currently used")
+		width    = flag.Int("width", 2, "number of key characters per directory level currently used")
+		growth   = flag.Float64("growth", 2, "factor by which the store is expected to grow")
+		apply    = flag.Bool("apply", false, "migrate the store to the recommended layout")
+	)
+	flag.Parse()
+
+	if *basePath == "" {
+		log.Fatal("castore-layout: the -path flag is required")
+	}
+
+	opts := castore.Options{BasePath: *basePath}
+	if *depth > 0 {
+		opts.Transform = castore.ShardTransformFunc(*depth, *width)
+	}
+	s, err := castore.New(opts)
+	if err != nil {
+		log.Fatalf("castore-layout: %s", err)
+	}
+
+	rec, err := s.RecommendLayout(*growth)
+	if err != nil {
+		log.Fatalf("castore-layout: %s", err)
+	}
+
+	fs := rec.Filesystem
+	if fs == "" {
+		fs = "unknown"
+	}
+	fmt.Printf("objects:             %d\n", rec.Objects)
+	fmt.Printf("filesystem:          %s\n", fs)
+	fmt.Printf("target entries/dir:  %d\n", rec.MaxEntries)
+	if rec.HardLimit > 0 {
+		fmt.Printf("hard limit:          %d\n", rec.HardLimit)
+	}
+	fmt.Printf("largest directory:   %d\n", rec.CurrentMaxEntries)
+	fmt.Printf("recommended layout:  -depth %d -width %d\n", rec.Depth, rec.Width)
+	fmt.Printf("projected largest:   %d\n", rec.ProjectedMaxEntries)
+	if !rec.Fits() {
+		fmt.Println("warning: no layout keeps every directory below the target")
+	}
+
+	if !*apply {
+		return
+	}
+	if rec.Depth == *depth && (rec.Depth == 0 || rec.Width == *width) {
+		fmt.Println("the store already uses the recommended layout")
+		return
+	}
+	moved, err := s.Relayout(context.Background(), rec.Transform)
+	if err != nil {
+		log.Fatalf("castore-layout: %s (moved %d objects; run again to resume)", err, moved)
+	}
+	fmt.Printf("moved %d objects; open the store with -depth %d -width %d from now on\n", moved, rec.Depth, rec.Width)
+}
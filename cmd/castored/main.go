@@ -0,0 +1,62 @@
+// Command castored serves a castore.CAStore over HTTP.  See
+// castorehttp.NewServer for a description of the API.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/andrew-d/castore"
+	"github.com/andrew-d/castore/castorehttp"
+)
+
+func main() {
+	var (
+		addr     = flag.String("addr", ":8080", "address to listen on")
+		basePath = flag.String("path", "", "base path of the store (required)")
+		depth    = flag.Int("depth", 0, "number of directory levels to use")
+		width    = flag.Int("width", 2, "number of key characters per directory level")
+		maxSize  = flag.Int64("max-size", 0, "maximum size of a stored object in bytes, or -1 for no limit (default 10 MiB)")
+		readOnly = flag.Bool("read-only", false, "disable PUT and DELETE")
+		sniff    = flag.Bool("sniff", false, "detect and serve the content type of stored objects")
+		hashName = flag.String("hash", "sha256", "hash function used to generate keys (sha256 or blake3)")
+	)
+	flag.Parse()
+
+	if *basePath == "" {
+		log.Fatal("castored: the -path flag is required")
+	}
+
+	opts := castore.Options{
+		BasePath:         *basePath,
+		MaxSize:          *maxSize,
+		SniffContentType: *sniff,
+	}
+	if *maxSize < 0 {
+		opts.MaxSize = castore.NoLimit
+	}
+	if *depth > 0 {
+		opts.Transform = castore.ShardTransformFunc(*depth, *width)
+	}
+	switch *hashName {
+	case "sha256":
+	case "blake3":
+		opts.Hash = castore.NewBLAKE3
+	default:
+		log.Fatalf("castored: unknown hash function %q", *hashName)
+	}
+
+	s, err := castore.New(opts)
+	if err != nil {
+		log.Fatalf("castored: %s", err)
+	}
+
+	h := castorehttp.NewServer(s, castorehttp.ServerOptions{
+		MaxBodySize: *maxSize,
+		ReadOnly:    *readOnly,
+	})
+
+	log.Printf("castored: serving %s on %s", *basePath, *addr)
+	log.Fatal(http.ListenAndServe(*addr, h))
+}
@@ -0,0 +1,170 @@
+package castore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// moveRetries is the number of times that moveInto will retry if the
+// destination directory is removed from underneath it.
+const moveRetries = 3
+
+// moveInto is a helper function that will move the file at src to dest,
+// creating dest's parent directories as necessary.  Since empty directories
+// are removed concurrently (see Compact), the directory may disappear between
+// creating it and moving the file into it, in which case we try again.  If
+// the file cannot be renamed because it is on a different filesystem, it is
+// copied instead; any other error is returned as is.
+func (s *CAStore) moveInto(src, dest string) error {
+	for i := 0; ; i++ {
+		if err := s.mkdirData(filepath.Dir(dest)); err != nil {
+			return err
+		}
+		err := os.Rename(src, dest)
+		if err == nil {
+			return nil
+		}
+
+		// The source may be the file that has gone missing.
+		if _, serr := os.Lstat(src); serr != nil {
+			return err
+		}
+		if crossDevice(err) {
+			return s.copyInto(src, dest)
+		}
+		if !os.IsNotExist(err) || i == moveRetries {
+			return err
+		}
+	}
+}
+
+// copyInto is a helper function that will atomically replace dest with a copy
+// of the file at src, and then remove src.  dest's parent directory must
+// exist.
+func (s *CAStore) copyInto(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tfile, err := ioutil.TempFile(filepath.Dir(dest), ".tmp-"+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	_, err = s.copyBuffer(tfile, in)
+	if err == nil {
+		err = tfile.Sync()
+	}
+	if cerr := tfile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.setPerms(tfile.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tfile.Name(), dest)
+	}
+	if err != nil {
+		s.removeTemp(tfile.Name())
+		return err
+	}
+
+	s.log.Debug("copied file across filesystems", "src", src, "dest", dest)
+	if err = os.Remove(src); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeEmptyDirs is a helper function that will remove the given directory
+// and each of its parents, stopping at the first one that is not empty or at
+// the store's BasePath.
+func (s *CAStore) removeEmptyDirs(dir string) {
+	base := filepath.Clean(s.opts.BasePath)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, base+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// Compact will remove every empty directory in the store, such as those left
+// behind once all of the objects in them have been deleted.  Directories are
+// also cleaned up as objects are deleted, so this is only necessary for stores
+// that were written by older versions of this package, or that have been
+// modified externally.  It is safe to call while other operations are in
+// progress, and returns the number of directories removed.
+func (s *CAStore) Compact() (int, error) {
+	unlock, err := s.lockExclusive()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var dirs []string
+	err = filepath.Walk(s.opts.BasePath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if p == filepath.Join(s.opts.BasePath, metaDir) {
+			return filepath.SkipDir
+		}
+		if p != s.opts.BasePath {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Walk visits parents before their children, so going backwards removes
+	// the children first.
+	removed := 0
+	for i := len(dirs) - 1; i >= 0; i-- {
+		empty, err := isEmptyDir(dirs[i])
+		if err != nil || !empty {
+			continue
+		}
+		if err = os.Remove(dirs[i]); err == nil {
+			removed++
+			continue
+		}
+
+		// A concurrent Put may have added something in the meantime, which
+		// is fine; anything else is a real error.
+		if empty, _ = isEmptyDir(dirs[i]); empty {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// isEmptyDir returns whether the given directory contains no entries.
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,80 @@
+package castore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-compact"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+
+	// Deleting removes the directories that held the object.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(TEST_KEY))
+	_, err = os.Stat(filepath.Join(tdir, TEST_KEY[:2]))
+	assert.True(t, os.IsNotExist(err))
+
+	// ... but not those that still hold something else.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(filepath.Join(tdir, TEST_KEY[:2], "zz"), 0700))
+	assert.NoError(t, s.Delete(TEST_KEY))
+	_, err = os.Stat(filepath.Join(tdir, TEST_KEY[:2], "zz"))
+	assert.NoError(t, err)
+
+	// Anything left over is removed by Compact.
+	assert.NoError(t, os.MkdirAll(filepath.Join(tdir, "aa", "bb"), 0700))
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	n, err := s.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	entries, err := ioutil.ReadDir(tdir)
+	assert.NoError(t, err)
+	var names []string
+	for _, ent := range entries {
+		names = append(names, ent.Name())
+	}
+	assert.ElementsMatch(t, []string{other[:2], metaDir, manifestFile}, names)
+
+	r, err := s.Get(other)
+	assert.NoError(t, err)
+	r.Close()
+}
+
+func TestMoveIntoError(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-compact"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// Renaming onto a non-empty directory fails, and isn't retried as a copy.
+	src := filepath.Join(tdir, "src")
+	dest := filepath.Join(tdir, "dest")
+	assert.NoError(t, ioutil.WriteFile(src, []byte(TEST_VALUE), 0600))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dest, "child"), 0700))
+
+	err = s.moveInto(src, dest)
+	var lerr *os.LinkError
+	if assert.True(t, errors.As(err, &lerr)) {
+		assert.Equal(t, src, lerr.Old)
+	}
+	_, err = os.Stat(src)
+	assert.NoError(t, err)
+}
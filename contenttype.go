@@ -0,0 +1,54 @@
+package castore
+
+import (
+	"net/http"
+)
+
+// ContentTypeMetadata is the name of the metadata (see GetMetadata) in which
+// the content type of an object is recorded.
+const ContentTypeMetadata = "content-type"
+
+// sniffLen is the number of bytes used to detect the content type of data,
+// which is the most that http.DetectContentType will consider.
+const sniffLen = 512
+
+// headWriter is an io.Writer that keeps the first limit bytes written to it,
+// and discards the rest.
+type headWriter struct {
+	buf   []byte
+	limit int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := w.limit - len(w.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+// recordContentType saves the content type of a newly-stored object, unless it
+// has already been recorded.
+func (s *CAStore) recordContentType(key string, head []byte) error {
+	md, err := s.GetMetadata(key)
+	if err != nil || md[ContentTypeMetadata] != "" {
+		return err
+	}
+	return s.SetMetadata(key, map[string]string{
+		ContentTypeMetadata: http.DetectContentType(head),
+	})
+}
+
+// ContentType will return the MIME type of the data stored with the given key,
+// as detected when it was inserted with the SniffContentType option set, or
+// set explicitly as its ContentTypeMetadata.  An empty string is returned if
+// the content type is unknown or the key does not exist.
+func (s *CAStore) ContentType(key string) (string, error) {
+	md, err := s.GetMetadata(key)
+	if err != nil {
+		return "", err
+	}
+	return md[ContentTypeMetadata], nil
+}
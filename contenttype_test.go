@@ -0,0 +1,50 @@
+package castore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentType(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-contenttype"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// Nothing is recorded by default.
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	ctype, err := s.ContentType(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "", ctype)
+
+	s, err = New(Options{BasePath: tdir, SniffContentType: true})
+	assert.NoError(t, err)
+
+	// Only the start of the data is considered.
+	png := append([]byte("\x89PNG\x0D\x0A\x1A\x0A"), bytes.Repeat([]byte{0}, 1024)...)
+	key, err = s.PutBytes(png)
+	assert.NoError(t, err)
+	ctype, err = s.ContentType(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", ctype)
+
+	key, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	ctype, err = s.ContentType(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", ctype)
+
+	// An explicitly-set type is not replaced.
+	assert.NoError(t, s.SetMetadata(key, map[string]string{ContentTypeMetadata: "text/x-foo"}))
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	ctype, err = s.ContentType(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/x-foo", ctype)
+}
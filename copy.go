@@ -0,0 +1,84 @@
+package castore
+
+import (
+	"fmt"
+	"io"
+)
+
+// Copy will copy the objects with the given keys from src to dst, streaming
+// each one, and return the number copied.  Keys that dst already has are
+// skipped, so an interrupted Copy can simply be run again.  If no keys are
+// given, every object in src is copied, which makes Copy suitable for
+// migrating between backends (e.g. from disk to a remote store).
+//
+// Both stores must use the same Hash and KeyEncoding: if dst computes a
+// different key for an object, Copy fails with ErrKeyMismatch (though unless
+// dst is a CAStore, it will already have stored the object under its own
+// key).  Copy stops at
+// the first error, which names the key concerned; a key that does not exist
+// in src is an error wrapping ErrNotFound.
+func Copy(dst, src Store, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		err := src.Walk(func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	copied := 0
+	for _, key := range keys {
+		ok, err := copyOne(dst, src, key)
+		if err != nil {
+			return copied, fmt.Errorf("castore: copying %s: %w", key, err)
+		}
+		if ok {
+			copied++
+		}
+	}
+	return copied, nil
+}
+
+// Copy will copy the objects with the given keys, or every object if none are
+// given, from this store to dst.  See the Copy function for details.
+func (s *CAStore) Copy(dst Store, keys ...string) (int, error) {
+	return Copy(dst, s, keys...)
+}
+
+// copyOne copies a single object, returning false if dst already had it.
+func copyOne(dst, src Store, key string) (bool, error) {
+	if exists, err := dst.Exists(key); err != nil || exists {
+		return false, err
+	}
+
+	r, err := src.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if r == nil {
+		return false, ErrNotFound
+	}
+	defer r.Close()
+	err = putChecked(dst, key, r)
+	return err == nil, err
+}
+
+// putChecked stores the data from r in dst, failing with ErrKeyMismatch if it
+// doesn't have the given key.
+func putChecked(dst Store, key string, r io.Reader) error {
+	// A local destination can check the key before storing anything.
+	if s, ok := dst.(*CAStore); ok {
+		_, err := s.put(r, key)
+		return err
+	}
+	got, err := dst.Put(r)
+	if err != nil {
+		return err
+	}
+	if got != key {
+		return ErrKeyMismatch
+	}
+	return nil
+}
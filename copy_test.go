@@ -0,0 +1,54 @@
+package castore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopy(t *testing.T) {
+	sdir := must_s(ioutil.TempDir("", "castore-test-copy"))
+	defer os.RemoveAll(sdir)
+	ddir := must_s(ioutil.TempDir("", "castore-test-copy"))
+	defer os.RemoveAll(ddir)
+
+	src, err := New(Options{BasePath: sdir})
+	assert.NoError(t, err)
+	dst, err := New(Options{BasePath: ddir})
+	assert.NoError(t, err)
+
+	_, err = src.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := src.PutString("other")
+	assert.NoError(t, err)
+
+	n, err := src.Copy(dst, TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Everything, skipping what's already there.
+	n, err = Copy(struct{ Store }{dst}, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	exists, err := dst.Exists(other)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	missing := "0000000000000000000000000000000000000000000000000000000000000000"
+	_, err = src.Copy(dst, missing)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Contains(t, err.Error(), missing)
+
+	// Stores with different hash functions can't be copied between.
+	bdir := must_s(ioutil.TempDir("", "castore-test-copy"))
+	defer os.RemoveAll(bdir)
+	b3, err := New(Options{BasePath: bdir, Hash: NewBLAKE3})
+	assert.NoError(t, err)
+	_, err = src.Copy(b3, TEST_KEY)
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+	_, err = Copy(struct{ Store }{b3}, src, TEST_KEY)
+	assert.True(t, errors.Is(err, ErrKeyMismatch))
+}
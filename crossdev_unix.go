@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// crossDevice returns whether the given error from os.Rename means that the
+// source and destination are on different filesystems.
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
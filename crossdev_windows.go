@@ -0,0 +1,16 @@
+package castore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is the Windows ERROR_NOT_SAME_DEVICE error code, which
+// the syscall package doesn't define.
+const errorNotSameDevice = syscall.Errno(17)
+
+// crossDevice returns whether the given error from os.Rename means that the
+// source and destination are on different volumes.
+func crossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
@@ -0,0 +1,50 @@
+package castore
+
+// DedupAnalysis describes how much of the data stored by a PutDir was
+// duplicated, as calculated by AnalyzeDedup.
+type DedupAnalysis struct {
+	// Files is the number of files in the manifest, and TotalBytes is their
+	// total size.
+	Files      int
+	TotalBytes int64
+
+	// Objects is the number of distinct keys in the manifest, and
+	// UniqueBytes is their total size: the space needed to store the files.
+	Objects     int
+	UniqueBytes int64
+}
+
+// SavedBytes returns the number of bytes that did not need to be stored
+// because they duplicated other files.
+func (a DedupAnalysis) SavedBytes() int64 {
+	return a.TotalBytes - a.UniqueBytes
+}
+
+// Ratio returns the deduplication ratio: the total size of the files, divided
+// by the space needed to store them.  It is 1 if there were no files.
+func (a DedupAnalysis) Ratio() float64 {
+	if a.UniqueBytes == 0 {
+		return 1
+	}
+	return float64(a.TotalBytes) / float64(a.UniqueBytes)
+}
+
+// AnalyzeDedup will calculate how much of the data in a manifest returned by
+// PutDir was duplicated within it.  Unlike the Dedups and DedupBytes counters
+// in Stats, which count Puts of data that was already in the store, this only
+// considers the files in the manifest, so it can be run later, or on a
+// manifest that was saved elsewhere.
+func AnalyzeDedup(entries []DirEntry) DedupAnalysis {
+	var a DedupAnalysis
+	seen := make(map[string]bool, len(entries))
+	for _, ent := range entries {
+		a.Files++
+		a.TotalBytes += ent.Size
+		if !seen[ent.Key] {
+			seen[ent.Key] = true
+			a.Objects++
+			a.UniqueBytes += ent.Size
+		}
+	}
+	return a
+}
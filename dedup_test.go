@@ -0,0 +1,21 @@
+package castore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeDedup(t *testing.T) {
+	a := AnalyzeDedup([]DirEntry{
+		{Path: "a", Key: TEST_KEY, Size: 6},
+		{Path: "b", Key: "other", Size: 5},
+		{Path: "c", Key: TEST_KEY, Size: 6},
+		{Path: "d", Key: TEST_KEY, Size: 6},
+	})
+	assert.Equal(t, DedupAnalysis{Files: 4, TotalBytes: 23, Objects: 2, UniqueBytes: 11}, a)
+	assert.Equal(t, int64(12), a.SavedBytes())
+	assert.InDelta(t, 23.0/11, a.Ratio(), 1e-9)
+
+	assert.Equal(t, 1.0, AnalyzeDedup(nil).Ratio())
+}
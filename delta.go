@@ -0,0 +1,604 @@
+package castore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// deltasDir is the name of the directory in the store's internal state
+	// directory that holds the objects stored by PutDelta.  Each is a file
+	// named by its key, containing a "<base> <size>" line followed by the
+	// delta's instructions.
+	deltasDir = "deltas"
+
+	// deltaBlock is the length of the runs of bytes that are matched between
+	// the base and the new data.
+	deltaBlock = 16
+
+	// maxDeltaChain is the greatest number of deltas that may have to be
+	// applied to reconstruct an object.  Data whose base is at the end of a
+	// chain this long is stored in full instead.
+	maxDeltaChain = 8
+
+	// The instructions in a delta: insert the uvarint-prefixed bytes that
+	// follow, or copy the run of the base with the given uvarint offset and
+	// length.
+	deltaInsert = 0
+	deltaCopy   = 1
+)
+
+// deltaEntry is the information kept about an object stored as a delta.
+type deltaEntry struct {
+	base string
+	size int64
+}
+
+// loadDeltas reads the header of every object stored as a delta.
+func (s *CAStore) loadDeltas() error {
+	deltas := make(map[string]deltaEntry)
+	s.deltas = deltas
+
+	dir := filepath.Join(s.opts.BasePath, metaDir, deltasDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, ent := range entries {
+		if !s.validKey(ent.Name()) {
+			// Including the temporary files from writeFileAtomic.
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, ent.Name()))
+		if err != nil {
+			return err
+		}
+		de, err := readDeltaHeader(bufio.NewReader(f))
+		f.Close()
+		if err != nil {
+			s.log.Warn("ignoring malformed delta", "key", ent.Name(), "err", err)
+			continue
+		}
+		deltas[ent.Name()] = de
+	}
+	return nil
+}
+
+// readDeltaHeader reads the first line of a delta file.
+func readDeltaHeader(r *bufio.Reader) (deltaEntry, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return deltaEntry{}, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return deltaEntry{}, ErrCorrupt
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return deltaEntry{}, ErrCorrupt
+	}
+	return deltaEntry{fields[0], size}, nil
+}
+
+// PutDelta will insert the data from the given io.Reader into the store, as
+// with Put, but if it is similar to the data stored with the given base key,
+// such as a new revision of a document, only the differences are stored.  The
+// data is stored in full if it doesn't share enough with the base to halve
+// its size, or if the base does not exist.  Either way, the data is read back
+// with Get as usual, by reconstructing it from the base if necessary.  It is
+// subject to the same limits as Put, and is reported to the Observer, Hooks
+// and Stats in the same way.
+//
+// The whole of the data, and of the base, are held in memory while doing so.
+// Deleting a base doesn't affect the objects stored against it: they are
+// first stored in full, and Evict leaves bases alone.  Like packed objects,
+// objects stored as deltas are never evicted, and can only be opened through
+// FS by their key.
+func (s *CAStore) PutDelta(r io.Reader, base string) (string, error) {
+	var buf bytes.Buffer
+	_, tooLarge, err := s.copyLimited(&buf, r, s.opts.MaxSize)
+	if err != nil {
+		return "", err
+	}
+	if tooLarge {
+		return "", ErrSizeExceeded
+	}
+	data := buf.Bytes()
+
+	var delta []byte
+	if int64(len(data)) >= s.opts.MinSize && s.deltaChain(base) < maxDeltaChain {
+		if baseData, err := s.readObject(base); err == nil {
+			delta = computeDelta(baseData, data)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if delta == nil || len(delta) > len(data)/2 {
+		return s.Put(bytes.NewReader(data))
+	}
+
+	start := time.Now()
+	res, err := s.limitPut(func() (putResult, error) {
+		return s.putDelta(data, base, delta)
+	})
+	res, err = s.donePut(start, res, err)
+	return res.key, err
+}
+
+// putDelta stores data as the given delta against base.
+func (s *CAStore) putDelta(data []byte, base string, delta []byte) (putResult, error) {
+	// The data is charged to WriteRate as it is hashed, just as a Put's data
+	// is as it is written.
+	hasher := s.opts.Hash()
+	if _, err := io.Copy(hasher, s.throttleWrites(bytes.NewReader(data))); err != nil {
+		return putResult{}, err
+	}
+	key := s.opts.KeyEncoding.Encode(hasher.Sum(nil))
+	size := int64(len(data))
+
+	for {
+		wait := s.claimKey(key)
+		if wait == nil {
+			break
+		}
+		<-wait
+	}
+	defer s.releaseKey(key)
+	if exists, err := s.Exists(key); err != nil {
+		return putResult{}, err
+	} else if exists {
+		s.touch(key, size)
+		return putResult{key, size, true}, nil
+	}
+
+	defer s.holdLedger()()
+	if err := s.reserveQuota(size); err != nil {
+		return putResult{}, err
+	}
+	p, err := s.deltaPath(key)
+	if err == nil {
+		header := fmt.Sprintf("%s %d\n", base, size)
+		err = writeFileAtomic(p, append([]byte(header), delta...))
+	}
+	if err != nil {
+		s.releaseQuota(size)
+		return putResult{}, err
+	}
+	s.deltaMu.Lock()
+	s.deltas[key] = deltaEntry{base, size}
+	s.deltaMu.Unlock()
+
+	var head *headWriter
+	if s.opts.SniffContentType {
+		head = &headWriter{limit: sniffLen}
+		head.Write(data)
+	}
+	secondary := s.newSecondaryHashers()
+	for _, h := range secondary {
+		h.Write(data)
+	}
+	s.log.Debug("stored delta", "key", key, "base", base, "delta_size", len(delta))
+	return s.finishPut(key, size, false, head, secondary)
+}
+
+// deltaPath returns the on-disk path of the delta for the given key, creating
+// the directory if necessary.
+func (s *CAStore) deltaPath(key string) (string, error) {
+	dir, err := s.metaPath(deltasDir)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key), nil
+}
+
+// deltaFor returns the delta entry for the given key.
+func (s *CAStore) deltaFor(key string) (deltaEntry, bool) {
+	s.deltaMu.RLock()
+	defer s.deltaMu.RUnlock()
+	ent, ok := s.deltas[key]
+	return ent, ok
+}
+
+// isDelta returns whether the given key is stored as a delta.
+func (s *CAStore) isDelta(key string) bool {
+	_, ok := s.deltaFor(key)
+	return ok
+}
+
+// deltaChain returns the number of deltas that must be applied to
+// reconstruct the given key.
+func (s *CAStore) deltaChain(key string) int {
+	s.deltaMu.RLock()
+	defer s.deltaMu.RUnlock()
+	n := 0
+	for ent, ok := s.deltas[key]; ok && n <= maxDeltaChain; ent, ok = s.deltas[ent.base] {
+		n++
+	}
+	return n
+}
+
+// deltaObjects returns the keys and sizes of every object stored as a delta.
+func (s *CAStore) deltaObjects() map[string]int64 {
+	s.deltaMu.RLock()
+	defer s.deltaMu.RUnlock()
+
+	objects := make(map[string]int64, len(s.deltas))
+	for key, ent := range s.deltas {
+		objects[key] = ent.size
+	}
+	return objects
+}
+
+// deltaReader reads the reconstructed data for an object stored as a delta.
+// Unlike ioutil.NopCloser, it keeps the bytes.Reader's Seek method, so that
+// the data can be served with http.ServeContent.
+type deltaReader struct {
+	*bytes.Reader
+}
+
+func (deltaReader) Close() error {
+	return nil
+}
+
+// openDelta reconstructs the data for an object stored as a delta.
+func (s *CAStore) openDelta(key string) (io.ReadSeekCloser, int64, error) {
+	data, err := s.readDelta(key)
+	if err != nil {
+		return nil, -1, err
+	}
+	return deltaReader{bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+// readDelta reconstructs the data for an object stored as a delta, and checks
+// that it matches its key.
+func (s *CAStore) readDelta(key string) ([]byte, error) {
+	if _, ok := s.deltaFor(key); !ok {
+		return nil, os.ErrNotExist
+	}
+	f, err := s.openFile(filepath.Join(s.opts.BasePath, metaDir, deltasDir, key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	ent, err := readDeltaHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	base, err := s.readObject(ent.base)
+	if os.IsNotExist(err) {
+		s.log.Error("base of delta is missing", "key", key, "base", ent.base)
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := applyDelta(base, delta, ent.size)
+	if err == nil {
+		hasher := s.opts.Hash()
+		hasher.Write(data)
+		if s.opts.KeyEncoding.Encode(hasher.Sum(nil)) != key {
+			err = ErrCorrupt
+		}
+	}
+	if err != nil {
+		s.log.Warn("delta is corrupt", "key", key, "err", err)
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, key)
+	}
+	return data, nil
+}
+
+// readObject reads the whole of the data for the given key, however it is
+// stored.  If the key does not exist, the error satisfies os.IsNotExist.
+func (s *CAStore) readObject(key string) ([]byte, error) {
+	p, _, err := s.locate(key)
+	if err == nil {
+		var f *limitedFile
+		if f, err = s.openFile(p); err == nil {
+			defer f.Close()
+			return ioutil.ReadAll(f)
+		}
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	pr, _, err := s.openPacked(key)
+	if err == nil {
+		defer pr.Close()
+		return ioutil.ReadAll(pr)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s.readDelta(key)
+}
+
+// rehydrateDependents stores every object that is stored as a delta against
+// the given key in full, so that the key can be removed.
+func (s *CAStore) rehydrateDependents(base string) error {
+	s.deltaMu.RLock()
+	var dependents []string
+	for key, ent := range s.deltas {
+		if ent.base == base {
+			dependents = append(dependents, key)
+		}
+	}
+	s.deltaMu.RUnlock()
+
+	for _, key := range dependents {
+		if err := s.rehydrate(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deltaBases returns the set of keys that objects are stored as deltas
+// against.
+func (s *CAStore) deltaBases() map[string]bool {
+	s.deltaMu.RLock()
+	defer s.deltaMu.RUnlock()
+	bases := make(map[string]bool)
+	for _, ent := range s.deltas {
+		bases[ent.base] = true
+	}
+	return bases
+}
+
+// rehydrate replaces the delta for the given key with a loose copy of its
+// data.
+func (s *CAStore) rehydrate(key string) error {
+	data, err := s.readDelta(key)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tfile, err := s.createTemp()
+	if err != nil {
+		return err
+	}
+	if tfile.anonymous {
+		err = s.setFilePerms(tfile.File, s.opts.FileMode)
+	} else {
+		err = s.setPerms(tfile.Name(), s.opts.FileMode)
+	}
+	if err == nil {
+		_, err = tfile.Write(data)
+	}
+	if err == nil && s.syncData() {
+		err = tfile.Sync()
+	}
+	if err == nil && tfile.anonymous {
+		err = s.linkInto(tfile.File, s.blobPath(key))
+	}
+	tfile.Close()
+	if err == nil && !tfile.anonymous {
+		err = s.moveInto(tfile.Name(), s.blobPath(key))
+	}
+	if err != nil {
+		if !tfile.anonymous {
+			s.removeTemp(tfile.Name())
+		}
+		return err
+	}
+	s.indexAdd(key, int64(len(data)))
+
+	s.deltaMu.Lock()
+	delete(s.deltas, key)
+	s.deltaMu.Unlock()
+	if err = os.Remove(filepath.Join(s.opts.BasePath, metaDir, deltasDir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.log.Debug("stored delta in full", "key", key)
+	return nil
+}
+
+// deleteDelta is the implementation of delete for an object stored as a
+// delta.
+func (s *CAStore) deleteDelta(key string, ent deltaEntry) (int64, error) {
+	if s.opts.WriteOnce {
+		s.log.Warn("refusing to delete object from write-once store", "key", key)
+		return -1, ErrWriteOnce
+	}
+
+	defer s.holdLedger()()
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	protected, err := s.protectedKeys()
+	if err != nil {
+		return -1, err
+	}
+	if protected[key] {
+		// Snapshotted data is kept in full, so it can be undeleted.
+		data, err := s.readDelta(key)
+		if err != nil {
+			return -1, err
+		}
+		dest, err := s.atticPath(key)
+		if err != nil {
+			return -1, err
+		}
+		if err = writeFileAtomic(dest, data); err != nil {
+			return -1, err
+		}
+	}
+
+	s.deltaMu.Lock()
+	_, removed := s.deltas[key]
+	delete(s.deltas, key)
+	s.deltaMu.Unlock()
+	if err = os.Remove(filepath.Join(s.opts.BasePath, metaDir, deltasDir, key)); err != nil && !os.IsNotExist(err) {
+		return -1, err
+	}
+	if !protected[key] {
+		if err = s.removeMetadata(key); err != nil {
+			return -1, err
+		}
+	}
+	if removed {
+		s.ledgerRemove(ent.size)
+	}
+	s.releaseQuota(ent.size)
+	s.forgetHits(key)
+	s.forgetCached(key)
+
+	s.log.Info("deleted object", "key", key, "size", ent.size, "snapshotted", protected[key], "delta", true)
+	s.stats.deletes.add(1)
+	s.opts.Hooks.onDelete(key, ent.size)
+	return ent.size, nil
+}
+
+// computeDelta returns the instructions for building target from base.
+func computeDelta(base, target []byte) []byte {
+	var out []byte
+	pending := 0
+	if len(base) >= deltaBlock && len(target) >= deltaBlock {
+		// Index the base's blocks, keeping the first of any duplicates.
+		index := make(map[uint32]int, len(base)/deltaBlock)
+		for i := 0; i+deltaBlock <= len(base); i += deltaBlock {
+			h := blockHash(base[i : i+deltaBlock])
+			if _, ok := index[h]; !ok {
+				index[h] = i
+			}
+		}
+
+		// Slide a window over the target, looking for matching blocks.
+		h := blockHash(target[:deltaBlock])
+		for i := 0; ; {
+			if off, ok := index[h]; ok && bytes.Equal(base[off:off+deltaBlock], target[i:i+deltaBlock]) {
+				// Extend the match in both directions as far as possible.
+				start, bstart := i, off
+				for start > pending && bstart > 0 && target[start-1] == base[bstart-1] {
+					start--
+					bstart--
+				}
+				end, bend := i+deltaBlock, off+deltaBlock
+				for end < len(target) && bend < len(base) && target[end] == base[bend] {
+					end++
+					bend++
+				}
+				out = appendInsert(out, target[pending:start])
+				out = append(out, deltaCopy)
+				out = binary.AppendUvarint(out, uint64(bstart))
+				out = binary.AppendUvarint(out, uint64(end-start))
+				pending, i = end, end
+				if i+deltaBlock > len(target) {
+					break
+				}
+				h = blockHash(target[i : i+deltaBlock])
+				continue
+			}
+			if i+deltaBlock >= len(target) {
+				break
+			}
+			h = rollHash(h, target[i], target[i+deltaBlock])
+			i++
+		}
+	}
+	return appendInsert(out, target[pending:])
+}
+
+// appendInsert appends an instruction to insert the given data, if there is
+// any.
+func appendInsert(out, data []byte) []byte {
+	if len(data) == 0 {
+		return out
+	}
+	out = append(out, deltaInsert)
+	out = binary.AppendUvarint(out, uint64(len(data)))
+	return append(out, data...)
+}
+
+// applyDelta follows the instructions in delta to rebuild data of the given
+// size from base.
+func applyDelta(base, delta []byte, size int64) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		a, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, ErrCorrupt
+		}
+		delta = delta[n:]
+
+		switch op {
+		case deltaInsert:
+			if a > uint64(len(delta)) {
+				return nil, ErrCorrupt
+			}
+			data = append(data, delta[:a]...)
+			delta = delta[a:]
+		case deltaCopy:
+			b, n := binary.Uvarint(delta)
+			if n <= 0 || a > uint64(len(base)) || b > uint64(len(base))-a {
+				return nil, ErrCorrupt
+			}
+			delta = delta[n:]
+			data = append(data, base[a:a+b]...)
+		default:
+			return nil, ErrCorrupt
+		}
+		if int64(len(data)) > size {
+			return nil, ErrCorrupt
+		}
+	}
+	if int64(len(data)) != size {
+		return nil, ErrCorrupt
+	}
+	return data, nil
+}
+
+// deltaHashBase is the multiplier of the rolling hash used to find matching
+// blocks, and deltaHashPow is it raised to the power of deltaBlock-1.
+const deltaHashBase = 16777619
+
+var deltaHashPow = func() uint32 {
+	p := uint32(1)
+	for i := 0; i < deltaBlock-1; i++ {
+		p *= deltaHashBase
+	}
+	return p
+}()
+
+// blockHash returns the rolling hash of a block.
+func blockHash(b []byte) uint32 {
+	var h uint32
+	for _, c := range b {
+		h = h*deltaHashBase + uint32(c)
+	}
+	return h
+}
+
+// rollHash returns the hash of the block that follows the one with hash h,
+// which starts with out, once in has been added to its end.
+func rollHash(h uint32, out, in byte) uint32 {
+	return (h-uint32(out)*deltaHashPow)*deltaHashBase + uint32(in)
+}
@@ -0,0 +1,135 @@
+package castore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// revise returns a copy of data with a few small edits.
+func revise(data []byte) []byte {
+	out := append([]byte(nil), data[:1000]...)
+	out = append(out, "inserted text"...)
+	out = append(out, data[1000:5000]...)
+	out = append(out, data[5100:]...)
+	out[20000] ^= 0xff
+	return out
+}
+
+func TestComputeDelta(t *testing.T) {
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(base)
+	target := revise(base)
+
+	delta := computeDelta(base, target)
+	assert.True(t, len(delta) < 200, "delta is %d bytes", len(delta))
+	data, err := applyDelta(base, delta, int64(len(target)))
+	assert.NoError(t, err)
+	assert.Equal(t, target, data)
+
+	// Unrelated data is inserted whole.
+	delta = computeDelta(base, []byte(TEST_VALUE))
+	data, err = applyDelta(base, delta, int64(len(TEST_VALUE)))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	_, err = applyDelta(base, delta[:3], int64(len(TEST_VALUE)))
+	assert.Equal(t, ErrCorrupt, err)
+}
+
+func TestPutDelta(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-delta"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir}
+	s, err := New(opts)
+	assert.NoError(t, err)
+
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(base)
+	target := revise(base)
+	bkey, err := s.PutBytes(base)
+	assert.NoError(t, err)
+
+	key, err := s.PutDelta(bytes.NewReader(target), bkey)
+	assert.NoError(t, err)
+	assert.True(t, s.isDelta(key))
+	assert.Equal(t, string(target), readKey(t, s, key))
+	size, err := s.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(target)), size)
+	assert.Equal(t, 2, len(keysOf(t, s)))
+
+	// Data that isn't similar enough is stored in full.
+	other, err := s.PutDelta(bytes.NewReader([]byte(TEST_VALUE)), bkey)
+	assert.NoError(t, err)
+	assert.False(t, s.isDelta(other))
+
+	// Deltas are found when the store is reopened, and verified.
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.True(t, s.isDelta(key))
+	report, err := s.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.Empty(t, report.Corrupt)
+
+	// Deleting the base stores the delta in full.
+	assert.NoError(t, s.Delete(bkey))
+	assert.False(t, s.isDelta(key))
+	assert.Equal(t, string(target), readKey(t, s, key))
+
+	key, err = s.PutDelta(bytes.NewReader(revise(target)), key)
+	assert.NoError(t, err)
+	assert.True(t, s.isDelta(key))
+	assert.NoError(t, s.Delete(key))
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPutDeltaObserved(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-delta"))
+	defer os.RemoveAll(tdir)
+
+	var ops []Operation
+	s, err := New(Options{
+		BasePath:          tdir,
+		MaxConcurrentPuts: 1,
+		Observer: func(op Operation) {
+			ops = append(ops, op)
+		},
+	})
+	assert.NoError(t, err)
+
+	base := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(base)
+	target := revise(base)
+	bkey, err := s.PutBytes(base)
+	assert.NoError(t, err)
+
+	// Deltas are reported like any other Put, including when the data was
+	// already there.
+	key, err := s.PutDelta(bytes.NewReader(target), bkey)
+	assert.NoError(t, err)
+	assert.True(t, s.isDelta(key))
+	again, err := s.PutDelta(bytes.NewReader(target), bkey)
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+
+	if assert.Len(t, ops, 3) {
+		assert.Equal(t, OpPut, ops[1].Op)
+		assert.Equal(t, key, ops[1].Key)
+		assert.Equal(t, int64(len(target)), ops[1].Size)
+		assert.False(t, ops[1].Dedup)
+		assert.True(t, ops[2].Dedup)
+	}
+	stats := s.Stats()
+	assert.Equal(t, int64(3), stats.Puts)
+	assert.Equal(t, int64(1), stats.Dedups)
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package castore
+
+// diskUsage returns the number of bytes used and in total on the filesystem
+// that the given path is on.  This is not supported on this platform.
+func diskUsage(path string) (used, total int64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package castore
+
+import (
+	"syscall"
+)
+
+// diskUsage returns the number of bytes used and in total on the filesystem
+// that the given path is on, as seen by an unprivileged user.
+func diskUsage(path string) (used, total int64, ok bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, false
+	}
+	bsize := int64(st.Bsize)
+	free := int64(st.Bavail) * bsize
+	total = int64(st.Blocks) * bsize
+	return total - free, total, true
+}
@@ -0,0 +1,33 @@
+package castore
+
+// Durability is a level of care taken by Put to ensure that data has reached
+// stable storage, as used by Options.Durability.
+type Durability int
+
+const (
+	// DurabilityNone leaves the operating system to decide when data is
+	// written to disk.  This is the fastest option, but after a crash, objects
+	// that were recently inserted may be missing or truncated.
+	DurabilityNone Durability = iota
+
+	// DurabilityData syncs each object's data to disk before it is moved into
+	// place, so that an object in the store always has its complete data,
+	// even after a crash.  Recently inserted objects may still be missing.
+	DurabilityData
+
+	// DurabilityFull additionally syncs the directory that each object is
+	// moved into (and any directories that are created), so that once Put
+	// returns, the object will still be in the store after a crash.
+	DurabilityFull
+)
+
+// syncData returns whether data must be synced before it is moved into place.
+func (s *CAStore) syncData() bool {
+	return s.opts.Durability >= DurabilityData && !s.opts.BurstMode
+}
+
+// syncDirs returns whether directories must be synced after data is moved
+// into them.
+func (s *CAStore) syncDirs() bool {
+	return s.opts.Durability >= DurabilityFull && !s.opts.BurstMode
+}
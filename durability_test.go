@@ -0,0 +1,53 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDurability(t *testing.T) {
+	for _, d := range []Durability{DurabilityNone, DurabilityData, DurabilityFull} {
+		tdir := must_s(ioutil.TempDir("", "castore-test-durability"))
+		defer os.RemoveAll(tdir)
+
+		s, err := New(Options{
+			BasePath:   tdir,
+			Transform:  DepthTransformFunc(2),
+			Durability: d,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, d >= DurabilityData, s.syncData())
+		assert.Equal(t, d >= DurabilityFull, s.syncDirs())
+
+		key, err := s.PutString(TEST_VALUE)
+		assert.NoError(t, err)
+		assert.Equal(t, TEST_KEY, key)
+
+		dir, err := s.Scratch()
+		assert.NoError(t, err)
+		p := filepath.Join(dir, "file")
+		assert.NoError(t, ioutil.WriteFile(p, []byte("other"), 0600))
+		_, err = s.PutFile(p)
+		assert.NoError(t, err)
+
+		size, err := s.Size(TEST_KEY)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(TEST_VALUE)), size)
+	}
+
+	// Burst mode defers syncing to Flush.
+	tdir := must_s(ioutil.TempDir("", "castore-test-durability"))
+	defer os.RemoveAll(tdir)
+	s, err := New(Options{
+		BasePath:   tdir,
+		BurstMode:  true,
+		Durability: DurabilityFull,
+	})
+	assert.NoError(t, err)
+	assert.False(t, s.syncData())
+	assert.False(t, s.syncDirs())
+}
@@ -0,0 +1,239 @@
+package castore
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// KeyEncoding determines how the digest of a piece of data is encoded to form
+// its key.
+type KeyEncoding interface {
+	// Encode returns the key for the given digest.
+	Encode(digest []byte) string
+
+	// Decode returns the digest encoded in the given key, or false if the key
+	// is not valid for this encoding.
+	Decode(key string) ([]byte, bool)
+}
+
+// HexEncoding encodes keys as lowercase hexadecimal strings.  This is the
+// default encoding.
+var HexEncoding KeyEncoding = hexEncoding{}
+
+type hexEncoding struct{}
+
+func (hexEncoding) Encode(digest []byte) string {
+	return hex.EncodeToString(digest)
+}
+
+func (hexEncoding) Decode(key string) ([]byte, bool) {
+	digest, err := hex.DecodeString(key)
+	return digest, err == nil
+}
+
+// Base32Encoding encodes keys as lowercase, unpadded base32 strings (RFC 4648),
+// which are 20% shorter than hex, and are safe to use on case-insensitive
+// filesystems.
+var Base32Encoding KeyEncoding = stdEncoding{cidBase32}
+
+// Base64URLEncoding encodes keys as unpadded, URL-safe base64 strings (RFC
+// 4648), which are a third shorter than hex.  Since keys differing only in
+// case would collide, this must not be used on case-insensitive filesystems.
+var Base64URLEncoding KeyEncoding = stdEncoding{base64.RawURLEncoding}
+
+// stdEncoding adapts one of the standard library's encodings.
+type stdEncoding struct {
+	enc interface {
+		EncodeToString(src []byte) string
+		DecodeString(s string) ([]byte, error)
+	}
+}
+
+func (e stdEncoding) Encode(digest []byte) string {
+	return e.enc.EncodeToString(digest)
+}
+
+func (e stdEncoding) Decode(key string) ([]byte, bool) {
+	digest, err := e.enc.DecodeString(key)
+	return digest, err == nil
+}
+
+// Multihash function codes for use with MultihashEncoding and CIDEncoding.
+// These must match the store's Hash.
+const (
+	MultihashSHA256 = 0x12
+	MultihashSHA512 = 0x13
+	MultihashBLAKE3 = 0x1e
+)
+
+// cidCodecRaw is the multicodec code for raw binary data, which is used as
+// the content type of CIDs created by CIDEncoding.
+const cidCodecRaw = 0x55
+
+// MultihashEncoding returns an encoding that represents keys as base58btc
+// multihashes, as used by IPFS, where code is the multihash code of the
+// store's Hash function.  For SHA-256, these are the same as version 0 CIDs
+// (e.g. "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG").
+func MultihashEncoding(code uint64) KeyEncoding {
+	return multihashEncoding{code}
+}
+
+type multihashEncoding struct {
+	code uint64
+}
+
+func (e multihashEncoding) Encode(digest []byte) string {
+	return base58Encode(appendMultihash(nil, e.code, digest))
+}
+
+func (e multihashEncoding) Decode(key string) ([]byte, bool) {
+	b, ok := base58Decode(key)
+	if !ok {
+		return nil, false
+	}
+	return readMultihash(b, e.code)
+}
+
+// CIDEncoding returns an encoding that represents keys as version 1 CIDs with
+// the "raw" codec, in lowercase base32 (e.g. "bafkrei..."), where code is the
+// multihash code of the store's Hash function.
+func CIDEncoding(code uint64) KeyEncoding {
+	return cidEncoding{code}
+}
+
+type cidEncoding struct {
+	code uint64
+}
+
+// cidBase32 is the base32 encoding used by CIDs and Base32Encoding: RFC 4648,
+// lowercase, without padding.
+var cidBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func (e cidEncoding) Encode(digest []byte) string {
+	b := binary.AppendUvarint(nil, 1)
+	b = binary.AppendUvarint(b, cidCodecRaw)
+	b = appendMultihash(b, e.code, digest)
+
+	// The "b" prefix is the multibase code for this version of base32.
+	return "b" + cidBase32.EncodeToString(b)
+}
+
+func (e cidEncoding) Decode(key string) ([]byte, bool) {
+	if len(key) < 2 || key[0] != 'b' {
+		return nil, false
+	}
+	b, err := cidBase32.DecodeString(key[1:])
+	if err != nil {
+		return nil, false
+	}
+
+	version, n := binary.Uvarint(b)
+	if n <= 0 || version != 1 {
+		return nil, false
+	}
+	b = b[n:]
+	codec, n := binary.Uvarint(b)
+	if n <= 0 || codec != cidCodecRaw {
+		return nil, false
+	}
+	return readMultihash(b[n:], e.code)
+}
+
+// appendMultihash appends the multihash of the given digest to b.
+func appendMultihash(b []byte, code uint64, digest []byte) []byte {
+	b = binary.AppendUvarint(b, code)
+	b = binary.AppendUvarint(b, uint64(len(digest)))
+	return append(b, digest...)
+}
+
+// readMultihash returns the digest in the given multihash, which must use the
+// given code and contain nothing else.
+func readMultihash(b []byte, code uint64) ([]byte, bool) {
+	c, n := binary.Uvarint(b)
+	if n <= 0 || c != code {
+		return nil, false
+	}
+	b = b[n:]
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l != uint64(len(b)-n) {
+		return nil, false
+	}
+	return b[n:], true
+}
+
+// base58Alphabet is the Bitcoin base58 alphabet, as used by multihashes.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Encode encodes the given bytes in base58btc.
+func base58Encode(b []byte) string {
+	// Leading zero bytes are encoded as the first character of the alphabet.
+	zeros := 0
+	for zeros < len(b) && b[zeros] == 0 {
+		zeros++
+	}
+
+	// Convert the rest from base 256 to base 58, least significant digit
+	// first.
+	var digits []byte
+	for _, c := range b[zeros:] {
+		carry := int(c)
+		for i := range digits {
+			carry += int(digits[i]) << 8
+			digits[i] = byte(carry % 58)
+			carry /= 58
+		}
+		for carry > 0 {
+			digits = append(digits, byte(carry%58))
+			carry /= 58
+		}
+	}
+
+	ret := make([]byte, zeros+len(digits))
+	for i := 0; i < zeros; i++ {
+		ret[i] = base58Alphabet[0]
+	}
+	for i, d := range digits {
+		ret[len(ret)-1-i] = base58Alphabet[d]
+	}
+	return string(ret)
+}
+
+// base58Decode decodes the given base58btc string.
+func base58Decode(s string) ([]byte, bool) {
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	// Convert from base 58 to base 256, least significant byte first.
+	var bytes []byte
+	for i := zeros; i < len(s); i++ {
+		carry := -1
+		for j := 0; j < len(base58Alphabet); j++ {
+			if base58Alphabet[j] == s[i] {
+				carry = j
+				break
+			}
+		}
+		if carry < 0 {
+			return nil, false
+		}
+		for j := range bytes {
+			carry += int(bytes[j]) * 58
+			bytes[j] = byte(carry)
+			carry >>= 8
+		}
+		for carry > 0 {
+			bytes = append(bytes, byte(carry))
+			carry >>= 8
+		}
+	}
+
+	ret := make([]byte, zeros+len(bytes))
+	for i, c := range bytes {
+		ret[len(ret)-1-i] = c
+	}
+	return ret, true
+}
@@ -0,0 +1,99 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58(t *testing.T) {
+	for _, tc := range []struct {
+		in  []byte
+		out string
+	}{
+		{nil, ""},
+		{[]byte{0}, "1"},
+		{[]byte{0, 0, 1}, "112"},
+		{[]byte("hello world"), "StV1DL6CwTryKyV"},
+	} {
+		assert.Equal(t, tc.out, base58Encode(tc.in))
+		b, ok := base58Decode(tc.out)
+		assert.True(t, ok)
+		assert.Equal(t, string(tc.in), string(b))
+	}
+
+	_, ok := base58Decode("0OIl")
+	assert.False(t, ok)
+}
+
+func TestKeyEncodings(t *testing.T) {
+	for _, tc := range []struct {
+		enc KeyEncoding
+		key string
+	}{
+		{HexEncoding, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+		{Base32Encoding, "xfgspomtju7arjjokll5u7nl7lcij37dpjjyb3uqrd32zyxpzxuq"},
+		{Base64URLEncoding, "uU0nuZNNPgilLlLX2n2r-sSE7-N6U4DukIj3rOLvzek"},
+		{MultihashEncoding(MultihashSHA256), "QmaozNR7DZHQK1ZcU9p7QdrshMvXqWK6gpu5rmrkPdT3L4"},
+		{CIDEncoding(MultihashSHA256), "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e"},
+	} {
+		tdir := must_s(ioutil.TempDir("", "castore-test-encoding"))
+		defer os.RemoveAll(tdir)
+
+		s, err := New(Options{
+			BasePath:    tdir,
+			Transform:   DepthTransformFunc(1),
+			KeyEncoding: tc.enc,
+		})
+		assert.NoError(t, err)
+
+		key, err := s.PutString("hello world")
+		assert.NoError(t, err)
+		assert.Equal(t, tc.key, key)
+
+		// The directories are based on the digest, whatever the encoding.
+		_, err = os.Stat(tdir + "/b9/" + key)
+		assert.NoError(t, err)
+
+		r, err := s.Get(key)
+		assert.NoError(t, err)
+		r.Close()
+
+		report, err := s.Verify(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Checked)
+		assert.True(t, report.OK())
+
+		var keys []string
+		assert.NoError(t, s.Walk(func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		assert.Equal(t, []string{key}, keys)
+	}
+}
+
+func TestKeyEncodingInvalid(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-encoding"))
+	defer os.RemoveAll(tdir)
+
+	// Non-canonical keys are rejected.
+	s, err := New(Options{BasePath: tdir, KeyEncoding: Base64URLEncoding})
+	assert.NoError(t, err)
+	assert.True(t, s.validKey("uU0nuZNNPgilLlLX2n2r-sSE7-N6U4DukIj3rOLvzek"))
+	assert.False(t, s.validKey("uU0nuZNNPgilLlLX2n2r-sSE7-N6U4DukIj3rOLvzel"))
+	assert.False(t, s.validKey("uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek"))
+
+	enc := CIDEncoding(MultihashSHA256)
+	_, ok := enc.Decode("QmaozNR7DZHQK1ZcU9p7QdrshMvXqWK6gpu5rmrkPdT3L4")
+	assert.False(t, ok)
+
+	// The wrong hash function.
+	_, ok = CIDEncoding(MultihashSHA512).Decode("bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e")
+	assert.False(t, ok)
+	_, ok = MultihashEncoding(MultihashSHA512).Decode("QmaozNR7DZHQK1ZcU9p7QdrshMvXqWK6gpu5rmrkPdT3L4")
+	assert.False(t, ok)
+}
@@ -0,0 +1,248 @@
+package castore
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+)
+
+// ObjectInfo describes an object in the store, as given to an EvictScoreFunc.
+type ObjectInfo struct {
+	// Key is the key of the object.
+	Key string
+
+	// Size is the size of the object, in bytes.
+	Size int64
+
+	// Age is how long ago the object was written to disk.
+	Age time.Duration
+
+	// Idle is how long ago the object was last retrieved or written (see
+	// Options.AccessTimes).  Without access times, it is the same as Age.
+	Idle time.Duration
+
+	// Hits is the number of times the object has been retrieved by Get since
+	// the store was created.
+	Hits int64
+
+	// Metadata is the metadata attached to the object (see SetMetadata).
+	Metadata map[string]string
+}
+
+// EvictScoreFunc is the type of function used to choose which objects are
+// removed by Evict.  Objects with higher scores are evicted first, and objects
+// with a negative score are never evicted.
+type EvictScoreFunc func(obj ObjectInfo) float64
+
+// OldestFirst is the default EvictScoreFunc, which evicts the least recently
+// written objects first.
+func OldestFirst(obj ObjectInfo) float64 {
+	return obj.Age.Seconds()
+}
+
+// recordHit notes that the given key has been retrieved, if an EvictScore
+// function that may need it has been set.
+func (s *CAStore) recordHit(key string) {
+	if s.opts.EvictScore == nil {
+		return
+	}
+	s.hitsMu.Lock()
+	s.hits[key]++
+	s.hitsMu.Unlock()
+}
+
+// forgetHits discards the hit count of a removed key.
+func (s *CAStore) forgetHits(key string) {
+	if s.opts.EvictScore == nil {
+		return
+	}
+	s.hitsMu.Lock()
+	delete(s.hits, key)
+	s.hitsMu.Unlock()
+}
+
+// pin prevents Evict from removing the given key until it is unpinned as many
+// times.
+func (s *CAStore) pin(key string) {
+	s.pinnedMu.Lock()
+	if s.pinned == nil {
+		s.pinned = make(map[string]int)
+	}
+	s.pinned[key]++
+	s.pinnedMu.Unlock()
+}
+
+// unpin reverses a call to pin.
+func (s *CAStore) unpin(key string) {
+	s.pinnedMu.Lock()
+	if s.pinned[key]--; s.pinned[key] <= 0 {
+		delete(s.pinned, key)
+	}
+	s.pinnedMu.Unlock()
+}
+
+// isPinned returns whether the given key has been pinned.
+func (s *CAStore) isPinned(key string) bool {
+	s.pinnedMu.Lock()
+	defer s.pinnedMu.Unlock()
+	return s.pinned[key] > 0
+}
+
+// EvictReason describes why an object was removed by the store of its own
+// accord.
+type EvictReason string
+
+// The reasons reported in an Eviction.
+const (
+	// EvictForSpace means that the object was chosen by Evict to free space.
+	EvictForSpace EvictReason = "space"
+
+	// EvictSnapshotPruned means that the object was the manifest of a
+	// snapshot that was pruned due to SnapshotHistory.
+	EvictSnapshotPruned EvictReason = "snapshot-pruned"
+)
+
+// Eviction describes an object that was removed by the store of its own
+// accord, as sent to Options.Evictions.
+type Eviction struct {
+	Key    string
+	Size   int64
+	Reason EvictReason
+}
+
+// evicted reports that an object has been evicted, to the OnEvict hook, the
+// Observer and the Evictions channel.  The start time is when removing the
+// object began.
+func (s *CAStore) evicted(key string, size int64, reason EvictReason, start time.Time) {
+	s.opts.Hooks.onEvict(key, size)
+	s.observe(Operation{Op: OpEvict, Key: key, Size: size, Duration: time.Since(start)})
+	if s.opts.Evictions == nil {
+		return
+	}
+	select {
+	case s.opts.Evictions <- Eviction{key, size, reason}:
+	default:
+		s.log.Warn("dropped eviction notification", "key", key, "reason", string(reason))
+	}
+}
+
+// evictCandidate is an object that may be evicted.
+type evictCandidate struct {
+	key   string
+	path  string
+	info  os.FileInfo
+	score float64
+}
+
+// Evict will remove objects from the store until at least the given number of
+// bytes have been freed, or no more objects can be evicted, and return the
+// number and total size of the objects removed.  The objects are chosen
+// according to the store's EvictScore function, with those scored highest
+// removed first.  Eviction ignores the WriteOnce option, and fires the
+// OnEvict hook for each object rather than OnDelete.  It will stop early if
+// the context is cancelled.  Objects that are referenced by a snapshot, or
+// are waiting to be uploaded by a write-back Tiered store, are never evicted.
+func (s *CAStore) Evict(ctx context.Context, bytes int64) (Usage, error) {
+	var evicted Usage
+	if bytes <= 0 {
+		return evicted, nil
+	}
+
+	unlock, err := s.lockExclusive()
+	if err != nil {
+		return evicted, err
+	}
+	defer unlock()
+
+	score := s.opts.EvictScore
+	if score == nil && s.opts.CacheSize > 0 {
+		score = LeastRecentlyUsed
+	} else if score == nil {
+		score = OldestFirst
+	}
+
+	// Data referenced by a snapshot would only be moved to the attic, which
+	// doesn't free any space.
+	s.snapMu.Lock()
+	protected, err := s.protectedKeys()
+	s.snapMu.Unlock()
+	if err != nil {
+		return evicted, err
+	}
+
+	// Evicting the base of a delta would mean storing the delta in full.
+	bases := s.deltaBases()
+
+	now := time.Now()
+	var candidates []evictCandidate
+	err = s.walkFiles(func(key, p string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if protected[key] || s.isPinned(key) || bases[key] {
+			return nil
+		}
+
+		md, err := s.GetMetadata(key)
+		if err != nil {
+			return err
+		}
+		s.hitsMu.Lock()
+		hits := s.hits[key]
+		s.hitsMu.Unlock()
+		accessed := info.ModTime()
+		if s.opts.Index {
+			if ent, ok := s.indexLookup(key); ok && ent.accessed().After(accessed) {
+				accessed = ent.accessed()
+			}
+		}
+
+		sc := score(ObjectInfo{
+			Key:      key,
+			Size:     info.Size(),
+			Age:      now.Sub(info.ModTime()),
+			Idle:     now.Sub(accessed),
+			Hits:     hits,
+			Metadata: md,
+		})
+		if sc >= 0 {
+			candidates = append(candidates, evictCandidate{key, p, info, sc})
+		}
+		return nil
+	})
+	if err != nil {
+		return evicted, err
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	for _, c := range candidates {
+		if evicted.Bytes >= bytes {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			return evicted, err
+		}
+
+		start := time.Now()
+		var removed bool
+		if _, removed, err = s.remove(c.key, c.path, c.info); err != nil {
+			return evicted, err
+		}
+		if !removed {
+			// It was deleted, or packed, since we found it.
+			continue
+		}
+		evicted.Objects++
+		evicted.Bytes += c.info.Size()
+
+		s.log.Info("evicted object", "key", c.key, "size", c.info.Size(), "score", c.score)
+		s.evicted(c.key, c.info.Size(), EvictForSpace, start)
+	}
+	if evicted.Objects > 0 {
+		s.checkUsage()
+	}
+	return evicted, nil
+}
@@ -0,0 +1,126 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictOldestFirst(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-evict"))
+	defer os.RemoveAll(tdir)
+
+	var evicted []string
+	s, err := New(Options{
+		BasePath:  tdir,
+		WriteOnce: true,
+		Hooks: Hooks{
+			OnEvict: func(key string, size int64) { evicted = append(evicted, key) },
+		},
+	})
+	assert.NoError(t, err)
+
+	var keys []string
+	for i, val := range []string{"aaaa", "bbbb", "cccc"} {
+		key, err := s.PutString(val)
+		assert.NoError(t, err)
+		mtime := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(s.blobPath(key), mtime, mtime))
+		keys = append(keys, key)
+	}
+
+	u, err := s.Evict(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 8}, u)
+	assert.Equal(t, keys[:2], evicted)
+
+	exists, err := s.Exists(keys[2])
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestEvictScore(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-evict"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		EvictScore: func(obj ObjectInfo) float64 {
+			// Keep manifests and popular objects; otherwise evict the largest.
+			if obj.Metadata["kind"] == "manifest" || obj.Hits > 0 {
+				return -1
+			}
+			return float64(obj.Size)
+		},
+	})
+	assert.NoError(t, err)
+
+	manifest, err := s.PutWithMetadata(strings.NewReader("a large manifest"), map[string]string{"kind": "manifest"})
+	assert.NoError(t, err)
+	popular, err := s.PutString("popular object")
+	assert.NoError(t, err)
+	r, err := s.Get(popular)
+	assert.NoError(t, err)
+	r.Close()
+	small, err := s.PutString("small")
+	assert.NoError(t, err)
+	large, err := s.PutString("large object")
+	assert.NoError(t, err)
+
+	u, err := s.Evict(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 1, Bytes: 12}, u)
+
+	// Nothing else can be evicted.
+	u, err = s.Evict(context.Background(), 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 1, Bytes: 5}, u)
+
+	for key, exists := range map[string]bool{manifest: true, popular: true, small: false, large: false} {
+		ok, err := s.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, exists, ok)
+	}
+}
+
+func TestEvictions(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-evict"))
+	defer os.RemoveAll(tdir)
+
+	ch := make(chan Eviction, 1)
+	s, err := New(Options{
+		BasePath:        tdir,
+		SnapshotHistory: 1,
+		Evictions:       ch,
+	})
+	assert.NoError(t, err)
+
+	first, err := s.Snapshot("first")
+	assert.NoError(t, err)
+	_, err = s.PutString("other")
+	assert.NoError(t, err)
+	_, err = s.Snapshot("second")
+	assert.NoError(t, err)
+	assert.Equal(t, Eviction{first.Key, 0, EvictSnapshotPruned}, <-ch)
+
+	// Everything else is protected by the second snapshot, so only this
+	// object can be evicted.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Evict(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Eviction{TEST_KEY, int64(len(TEST_VALUE)), EvictForSpace}, <-ch)
+
+	// A full channel doesn't block eviction.
+	ch <- Eviction{}
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	u, err := s.Evict(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), u.Objects)
+}
@@ -0,0 +1,119 @@
+package castore
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultExistsCacheSize is the default maximum number of entries in an
+// ExistsCache.
+const defaultExistsCacheSize = 64 * 1024
+
+// ExistsCache wraps a Store - typically a remote one - and remembers the
+// results of Exists, so that callers can choose to accept a recent answer
+// rather than waiting for the store.  Since data for a key can never change,
+// a cached positive answer only becomes wrong if the key is deleted; Puts and
+// Deletes made through the cache update it immediately.
+type ExistsCache struct {
+	s    Store
+	size int
+
+	mu      sync.Mutex
+	entries map[string]existsEntry
+}
+
+// existsEntry is a single cached result.
+type existsEntry struct {
+	exists  bool
+	checked time.Time
+}
+
+// NewExistsCache returns an ExistsCache for the given store, which will hold
+// at most size entries.  If size is not positive, a default of 65536 is used.
+// If the store is a CAStore, answers for keys added or deleted through it are
+// forgotten, as are answers for keys changed by other processes if it was
+// opened with Options.WatchExternal.
+func NewExistsCache(s Store, size int) *ExistsCache {
+	if size <= 0 {
+		size = defaultExistsCacheSize
+	}
+	c := &ExistsCache{
+		s:       s,
+		size:    size,
+		entries: make(map[string]existsEntry),
+	}
+	if cs, ok := s.(*CAStore); ok {
+		cs.forgetOnChange(c)
+	}
+	return c
+}
+
+// Exists will return whether the given key exists.  If the answer is known
+// from no more than maxStaleness ago, it is returned without consulting the
+// underlying store; a maxStaleness of zero always consults the store.
+func (c *ExistsCache) Exists(key string, maxStaleness time.Duration) (bool, error) {
+	now := time.Now()
+	if maxStaleness > 0 {
+		c.mu.Lock()
+		ent, ok := c.entries[key]
+		c.mu.Unlock()
+		if ok && now.Sub(ent.checked) <= maxStaleness {
+			return ent.exists, nil
+		}
+	}
+
+	exists, err := c.s.Exists(key)
+	if err != nil {
+		return false, err
+	}
+	c.record(key, exists, now)
+	return exists, nil
+}
+
+// Put will insert data into the underlying store, and record that its key
+// exists.
+func (c *ExistsCache) Put(r io.Reader) (string, error) {
+	key, err := c.s.Put(r)
+	if err != nil {
+		return "", err
+	}
+	c.record(key, true, time.Now())
+	return key, nil
+}
+
+// Delete will delete the given key from the underlying store, and record that
+// it no longer exists.
+func (c *ExistsCache) Delete(key string) error {
+	// Whatever happens, our cached answer may no longer be right.
+	c.Forget(key)
+	if err := c.s.Delete(key); err != nil {
+		return err
+	}
+	c.record(key, false, time.Now())
+	return nil
+}
+
+// Forget removes any cached answer for the given key.  It should be called
+// when the key is known to have been modified by something other than this
+// cache; see Invalidate.
+func (c *ExistsCache) Forget(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// record saves the answer for the given key.
+func (c *ExistsCache) record(key string, exists bool, checked time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		// Make room by dropping an arbitrary entry.
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = existsEntry{exists, checked}
+}
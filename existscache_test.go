@@ -0,0 +1,79 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts the calls to Exists on a Store.
+type countingStore struct {
+	Store
+	exists int
+}
+
+func (s *countingStore) Exists(key string) (bool, error) {
+	s.exists++
+	return s.Store.Exists(key)
+}
+
+func TestExistsCache(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-existscache"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	cs := &countingStore{Store: s}
+	c := NewExistsCache(cs, 0)
+
+	// The first lookup has to consult the store.
+	exists, err := c.Exists(TEST_KEY, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 1, cs.exists)
+
+	// Puts through the cache are seen immediately.
+	_, err = c.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	exists, err = c.Exists(TEST_KEY, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, 1, cs.exists)
+
+	// Changes made elsewhere are only seen once the answer is too old.
+	assert.NoError(t, s.Delete(TEST_KEY))
+	exists, err = c.Exists(TEST_KEY, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = c.Exists(TEST_KEY, 0)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 2, cs.exists)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Delete(TEST_KEY))
+	exists, err = c.Exists(TEST_KEY, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, 2, cs.exists)
+}
+
+func TestExistsCacheSize(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-existscache"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	c := NewExistsCache(s, 2)
+
+	for _, val := range []string{"a", "b", "c"} {
+		_, err = c.Put(strings.NewReader(val))
+		assert.NoError(t, err)
+	}
+	assert.Len(t, c.entries, 2)
+}
@@ -0,0 +1,65 @@
+package castore
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// defaultMaxOpenFiles returns the default value for Options.MaxOpenFiles,
+// based on the process's file descriptor limit.
+func defaultMaxOpenFiles() int {
+	limit := fileLimit()
+	if limit <= 0 {
+		return -1
+	}
+
+	// Leave a quarter of the descriptors for the rest of the process.
+	if n := limit - limit/4; n > 0 {
+		return int(n)
+	}
+	return 1
+}
+
+// acquireFD waits until the store may open another file, and returns a
+// function that must be called once the file has been closed.
+func (s *CAStore) acquireFD() func() {
+	if s.fds == nil {
+		return func() {}
+	}
+
+	s.fds <- struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-s.fds })
+	}
+}
+
+// limitedFile is an open file that counts against the store's open file
+// limit until it is closed.  As it embeds the *os.File, it also implements
+// io.WriterTo and syscall.Conn, which let copies to sockets and other files
+// use sendfile or splice.
+type limitedFile struct {
+	*os.File
+	release func()
+}
+
+var _ io.WriterTo = (*limitedFile)(nil)
+
+func (f *limitedFile) Close() error {
+	err := f.File.Close()
+	f.release()
+	return err
+}
+
+// openFile is a helper function that will open the file at the given path for
+// reading, respecting the store's open file limit.
+func (s *CAStore) openFile(p string) (*limitedFile, error) {
+	release := s.acquireFD()
+	f, err := os.Open(p)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &limitedFile{f, release}, nil
+}
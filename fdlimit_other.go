@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package castore
+
+// fileLimit returns the process's soft limit on open file descriptors, or zero
+// if it cannot be determined.  There is no such limit on this platform.
+func fileLimit() int64 {
+	return 0
+}
@@ -0,0 +1,51 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxOpenFiles(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-fdlimit"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:     tdir,
+		MaxOpenFiles: 1,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+
+	// With the only file descriptor in use, a Put has to wait.
+	done := make(chan error)
+	go func() {
+		_, err := s.PutString("other")
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Put should have waited for the reader to be closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	r.Close()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Put did not complete after the reader was closed")
+	}
+
+	// The default is derived from the rlimit.
+	assert.True(t, defaultMaxOpenFiles() != 0)
+}
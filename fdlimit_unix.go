@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"syscall"
+)
+
+// fileLimit returns the process's soft limit on open file descriptors, or zero
+// if it cannot be determined.
+func fileLimit() int64 {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0
+	}
+	// Treat huge limits (including RLIM_INFINITY) as unlimited.
+	if rlim.Cur <= 0 || rlim.Cur > 1<<30 {
+		return 0
+	}
+	return int64(rlim.Cur)
+}
@@ -0,0 +1,62 @@
+package castore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Fingerprint will return a short string that identifies the store's
+// configuration and contents, so that orchestration systems can cheaply check
+// that nodes which are supposed to host identical stores really do, or use it
+// to bust caches.  It never reads any objects, so it is fast regardless of the
+// size of the store.
+//
+// The configuration part covers the options that determine which data the
+// store accepts and where it puts it: the Hash, KeyEncoding and Transform
+// (identified by their behaviour, since functions cannot be compared),
+// SecondaryHashes, MaxSize, MinSize, RejectEmpty, WriteOnce and
+// SniffContentType.  Options that only affect how the store operates, such as
+// Durability or Logger, are not included.  The contents part is the name and
+// manifest key of the most recent snapshot (see Snapshot), which is the root
+// of a Merkle tree over every key in the store at that point; stores that
+// take identically named snapshots of identical contents therefore have the
+// same fingerprint, and changes between snapshots are not reflected.
+func (s *CAStore) Fingerprint() (string, error) {
+	h := sha256.New()
+
+	// The key of the empty object identifies the hash function and encoding,
+	// and its location identifies the transform.
+	probe := s.opts.KeyEncoding.Encode(s.opts.Hash().Sum(nil))
+	fmt.Fprintf(h, "key %s\n", probe)
+	fmt.Fprintf(h, "path %q\n", s.opts.Transform(probe))
+
+	names := make([]string, 0, len(s.opts.SecondaryHashes))
+	for name := range s.opts.SecondaryHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "secondary %s %x\n", name, s.opts.SecondaryHashes[name]().Sum(nil))
+	}
+
+	fmt.Fprintf(h, "size %d %d\n", s.opts.MinSize, s.opts.MaxSize)
+	fmt.Fprintf(h, "flags %t %t %t\n", s.opts.RejectEmpty, s.opts.WriteOnce, s.opts.SniffContentType)
+
+	s.snapMu.Lock()
+	history, err := s.readSnapshots()
+	s.snapMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if len(history) > 0 {
+		latest := history[len(history)-1]
+		fmt.Fprintf(h, "snapshot %s %s\n", latest.Name, latest.Key)
+	} else {
+		io.WriteString(h, "snapshot none\n")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
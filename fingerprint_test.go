@@ -0,0 +1,57 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	tdir1 := must_s(ioutil.TempDir("", "castore-test-fingerprint"))
+	defer os.RemoveAll(tdir1)
+	tdir2 := must_s(ioutil.TempDir("", "castore-test-fingerprint"))
+	defer os.RemoveAll(tdir2)
+	tdir3 := must_s(ioutil.TempDir("", "castore-test-fingerprint"))
+	defer os.RemoveAll(tdir3)
+
+	s1, err := New(Options{BasePath: tdir1})
+	assert.NoError(t, err)
+	s2, err := New(Options{BasePath: tdir2})
+	assert.NoError(t, err)
+	s3, err := New(Options{BasePath: tdir3, Transform: DepthTransformFunc(1)})
+	assert.NoError(t, err)
+
+	fp := func(s *CAStore) string {
+		f, err := s.Fingerprint()
+		assert.NoError(t, err)
+		return f
+	}
+
+	// The configuration matters, but the BasePath doesn't.
+	assert.Len(t, fp(s1), 32)
+	assert.Equal(t, fp(s1), fp(s2))
+	assert.NotEqual(t, fp(s1), fp(s3))
+
+	// Identical snapshots of identical contents give the same fingerprint.
+	for _, s := range []*CAStore{s1, s2} {
+		_, err = s.PutString(TEST_VALUE)
+		assert.NoError(t, err)
+	}
+	before := fp(s1)
+	for _, s := range []*CAStore{s1, s2} {
+		_, err = s.Snapshot("epoch-1")
+		assert.NoError(t, err)
+	}
+	assert.NotEqual(t, before, fp(s1))
+	assert.Equal(t, fp(s1), fp(s2))
+
+	_, err = s2.PutString("different")
+	assert.NoError(t, err)
+	_, err = s2.Snapshot("epoch-2")
+	assert.NoError(t, err)
+	_, err = s1.Snapshot("epoch-2")
+	assert.NoError(t, err)
+	assert.NotEqual(t, fp(s1), fp(s2))
+}
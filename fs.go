@@ -0,0 +1,316 @@
+package castore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storeFS is an fs.FS view of a CAStore.
+type storeFS struct {
+	s    *CAStore
+	root fs.FS
+}
+
+// FS returns an fs.FS view of the store.  The directory hierarchy mirrors the
+// on-disk layout given by the store's TransformFunction, so with
+// DepthTransformFunc(2) the data for "abcdef" is found at "ab/cd/abcdef".  As
+// a convenience, any key can also be opened directly by using it as the path.
+// Objects that don't have files of their own, such as those that have been
+// packed, can only be opened this way.  Objects are read with Get, so reads
+// made through the returned value are verified, rate limited and counted in
+// the same way as any other.
+//
+// The returned value also implements fs.StatFS and fs.ReadDirFS, and only
+// exposes directories and data files - anything else stored under the
+// BasePath is hidden.
+func (s *CAStore) FS() fs.FS {
+	return storeFS{s: s, root: os.DirFS(s.opts.BasePath)}
+}
+
+// resolve maps the given path onto a path relative to the root of the store,
+// returning fs.ErrNotExist if the path does not refer to something we expose.
+func (f storeFS) resolve(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == metaDir || strings.HasPrefix(name, metaDir+"/") {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	// Top-level keys are resolved through the transform.
+	if f.s.validKey(name) {
+		p, _, err := f.s.locate(name)
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		rel, err := relPath(f.s.opts.BasePath, p)
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		return rel, nil
+	}
+	return name, nil
+}
+
+// visible returns whether a file with the given info should be exposed.
+func (f storeFS) visible(info fs.FileInfo) bool {
+	return info.IsDir() || (info.Mode().IsRegular() && f.s.validKey(info.Name()))
+}
+
+func (f storeFS) Open(name string) (fs.File, error) {
+	rel, err := f.resolve("open", name)
+	if errors.Is(err, fs.ErrNotExist) && f.s.validKey(name) {
+		// It may be packed, stored as a delta, or fetched from a seed.
+		return f.s.openObject(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fs.Stat(f.root, rel)
+	if err != nil {
+		return nil, err
+	}
+	if !f.visible(info) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return f.s.openObject(info.Name())
+	}
+
+	file, err := f.root.Open(rel)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return storeDir{dir, f}, nil
+}
+
+func (f storeFS) Stat(name string) (fs.FileInfo, error) {
+	rel, err := f.resolve("stat", name)
+	if errors.Is(err, fs.ErrNotExist) && f.s.validKey(name) {
+		info, err := f.s.statRetained(name)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := fs.Stat(f.root, rel)
+	if err != nil {
+		return nil, err
+	}
+	if !f.visible(info) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (f storeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	rel, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := fs.ReadDir(f.root, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.filterEntries(entries), nil
+}
+
+// filterEntries removes any directory entries that we don't expose.
+func (f storeFS) filterEntries(entries []fs.DirEntry) []fs.DirEntry {
+	ret := entries[:0]
+	for _, ent := range entries {
+		if ent.IsDir() && ent.Name() == metaDir {
+			continue
+		}
+		if ent.IsDir() || (ent.Type().IsRegular() && f.s.validKey(ent.Name())) {
+			ret = append(ret, ent)
+		}
+	}
+	return ret
+}
+
+// storeDir wraps an open directory so that its entries are filtered in the
+// same way as storeFS.ReadDir.
+type storeDir struct {
+	fs.ReadDirFile
+	f storeFS
+}
+
+func (d storeDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries, err := d.ReadDirFile.ReadDir(n)
+		return d.f.filterEntries(entries), err
+	}
+
+	// Keep reading until we have enough visible entries, or run out.
+	var ret []fs.DirEntry
+	for len(ret) < n {
+		entries, err := d.ReadDirFile.ReadDir(n - len(ret))
+		ret = append(ret, d.f.filterEntries(entries)...)
+		if err != nil {
+			if len(ret) > 0 && err == io.EOF {
+				err = nil
+			}
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// objectFile is an fs.File, and an http.File, that reads an object with Get.
+type objectFile struct {
+	s    *CAStore
+	rc   io.ReadCloser
+	pos  int64
+	info fs.FileInfo
+}
+
+// openObject opens the object with the given key as an objectFile.  If there
+// is no such object, the returned error satisfies os.IsNotExist.
+func (s *CAStore) openObject(key string) (*objectFile, error) {
+	rc, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil {
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+	info, err := s.statObject(key)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &objectFile{s: s, rc: rc, info: info}, nil
+}
+
+// statObject returns the information for the object with the given key,
+// whether or not it has a file of its own.
+func (s *CAStore) statObject(key string) (fs.FileInfo, error) {
+	_, info, err := s.locate(key)
+	if os.IsNotExist(err) {
+		return s.statRetained(key)
+	}
+	return info, err
+}
+
+func (f *objectFile) Read(b []byte) (int, error) {
+	n, err := f.rc.Read(b)
+	f.pos += int64(n)
+	return n, err
+}
+
+// Seek seeks within the object.  Readers that verify the data as it is
+// streamed can't seek, so for them, seeking backwards reopens the object and
+// seeking forwards reads and discards the data in between.
+func (f *objectFile) Seek(offset int64, whence int) (int64, error) {
+	if rs, ok := f.rc.(io.Seeker); ok {
+		pos, err := rs.Seek(offset, whence)
+		if err == nil {
+			f.pos = pos
+		}
+		return pos, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return f.pos, &fs.PathError{Op: "seek", Path: f.info.Name(), Err: fs.ErrInvalid}
+	}
+	if offset < f.pos {
+		rc, err := f.s.Get(f.info.Name())
+		if err == nil && rc == nil {
+			err = &fs.PathError{Op: "seek", Path: f.info.Name(), Err: fs.ErrNotExist}
+		}
+		if err != nil {
+			return f.pos, err
+		}
+		f.rc.Close()
+		f.rc, f.pos = rc, 0
+	}
+	if offset > f.pos {
+		n, err := io.CopyN(ioutil.Discard, f.rc, offset-f.pos)
+		f.pos += n
+		if err != nil && err != io.EOF {
+			return f.pos, err
+		}
+	}
+	return f.pos, nil
+}
+
+func (f *objectFile) Close() error {
+	return f.rc.Close()
+}
+
+func (f *objectFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *objectFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: f.info.Name(), Err: fs.ErrInvalid}
+}
+
+// retainedFileInfo describes an object that doesn't have a file of its own,
+// because it has been packed or stored as a delta.  Its ModTime is that of
+// the file that holds it.
+type retainedFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi retainedFileInfo) Name() string       { return fi.name }
+func (fi retainedFileInfo) Size() int64        { return fi.size }
+func (fi retainedFileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi retainedFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi retainedFileInfo) IsDir() bool        { return false }
+func (fi retainedFileInfo) Sys() interface{}   { return nil }
+
+// statRetained returns the information for an object that doesn't have a file
+// of its own.  If there is no such object, the returned error satisfies
+// os.IsNotExist.
+func (s *CAStore) statRetained(key string) (retainedFileInfo, error) {
+	var size int64
+	if ent, ok := s.packedEntry(key); ok {
+		size = ent.size
+	} else if ent, ok := s.deltaFor(key); ok {
+		size = ent.size
+	} else {
+		return retainedFileInfo{}, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+	info, err := s.retainedInfo(key)
+	if err != nil {
+		return retainedFileInfo{}, err
+	}
+	return retainedFileInfo{key, size, info.ModTime()}, nil
+}
+
+// relPath returns the slash-separated path of target relative to base.
+func relPath(base, target string) (string, error) {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
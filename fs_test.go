@@ -0,0 +1,114 @@
+package castore
+
+import (
+	"context"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFS(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-fs"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(1),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Non-data files should be hidden.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tdir, "junk"), []byte("x"), 0600))
+
+	fsys := s.FS()
+	assert.NoError(t, fstest.TestFS(fsys, TEST_KEY[0:2]+"/"+TEST_KEY))
+
+	// Keys can be opened directly.
+	data, err := fs.ReadFile(fsys, TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	_, err = fs.Stat(fsys, "junk")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	var found []string
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			found = append(found, p)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{TEST_KEY[0:2] + "/" + TEST_KEY}, found)
+}
+
+func TestFSPacked(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-fs"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:    tdir,
+		Transform:   DepthTransformFunc(1),
+		PackMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+
+	// Packed objects can only be opened by key.
+	fsys := s.FS()
+	data, err := fs.ReadFile(fsys, TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	info, err := fs.Stat(fsys, TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, info.Name())
+	assert.Equal(t, int64(len(TEST_VALUE)), info.Size())
+	assert.True(t, info.Mode().IsRegular())
+	assert.False(t, info.ModTime().IsZero())
+}
+
+func TestFSGet(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-fs"))
+	defer os.RemoveAll(tdir)
+
+	var gets int
+	s, err := New(Options{
+		BasePath:     tdir,
+		Transform:    DepthTransformFunc(1),
+		VerifyOnRead: true,
+		CorruptReads: CorruptReadPartial,
+		Observer: func(op Operation) {
+			if op.Op == OpGet {
+				gets++
+			}
+		},
+	})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Files are read with Get, whichever path they are opened by, and the
+	// readers that verify data as it is read still support the fs.FS rules.
+	fsys := s.FS()
+	assert.NoError(t, fstest.TestFS(fsys, TEST_KEY[0:2]+"/"+TEST_KEY))
+	assert.NotZero(t, gets)
+
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("fooBAR"), 0600))
+	for _, name := range []string{TEST_KEY, TEST_KEY[0:2] + "/" + TEST_KEY} {
+		_, err = fs.ReadFile(fsys, name)
+		assert.ErrorIs(t, err, ErrCorrupt, name)
+	}
+}
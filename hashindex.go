@@ -0,0 +1,91 @@
+package castore
+
+import (
+	"encoding/hex"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hashesDir is the name of the directory in the store's internal state
+// directory in which the secondary hash indexes are persisted.  Each index is
+// a directory named after its hash, containing one file per digest that holds
+// the corresponding key.
+const hashesDir = "hashes"
+
+// validHashName returns whether the given string can be used as the name of a
+// secondary hash.
+func validHashName(name string) bool {
+	return name != "" && name[0] != '.' && !strings.ContainsAny(name, `/\`+"\x00")
+}
+
+// newSecondaryHashers returns a new instance of each of the store's secondary
+// hashes, or nil if there are none.
+func (s *CAStore) newSecondaryHashers() map[string]hash.Hash {
+	if len(s.opts.SecondaryHashes) == 0 {
+		return nil
+	}
+	hashers := make(map[string]hash.Hash, len(s.opts.SecondaryHashes))
+	for name, fn := range s.opts.SecondaryHashes {
+		hashers[name] = fn()
+	}
+	return hashers
+}
+
+// hashIndexPath returns the on-disk path of the index entry for the given
+// digest of the named secondary hash.
+func (s *CAStore) hashIndexPath(name string, digest []byte) (string, error) {
+	dir, err := s.metaPath(hashesDir)
+	if err != nil {
+		return "", err
+	}
+	h := hex.EncodeToString(digest)
+	return filepath.Join(dir, name, h[:2], h), nil
+}
+
+// indexHashes records the secondary digests of a newly-stored object.
+func (s *CAStore) indexHashes(key string, hashers map[string]hash.Hash) error {
+	for name, h := range hashers {
+		p, err := s.hashIndexPath(name, h.Sum(nil))
+		if err != nil {
+			return err
+		}
+		if current, err := readRef(p); err == nil && current == key {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return err
+		}
+		if err = writeFileAtomic(p, []byte(key+"\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LookupHash will return the key of the object whose digest under the named
+// secondary hash (see Options.SecondaryHashes) is the given one, or an empty
+// string if there is no such object.  Only objects inserted while the
+// secondary hash was configured can be found.
+func (s *CAStore) LookupHash(name string, digest []byte) (string, error) {
+	if _, ok := s.opts.SecondaryHashes[name]; !ok || len(digest) == 0 {
+		return "", nil
+	}
+	p, err := s.hashIndexPath(name, digest)
+	if err != nil {
+		return "", err
+	}
+	key, err := readRef(p)
+	if err != nil || key == "" {
+		return "", err
+	}
+
+	// Index entries are not removed when objects are deleted, so check that
+	// the object is still there.
+	exists, err := s.Exists(key)
+	if err != nil || !exists {
+		return "", err
+	}
+	return key, nil
+}
@@ -0,0 +1,67 @@
+package castore
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"hash"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondaryHashes(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-hashindex"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		SecondaryHashes: map[string]func() hash.Hash{
+			"md5":  md5.New,
+			"sha1": sha1.New,
+		},
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	md5sum := md5.Sum([]byte(TEST_VALUE))
+	sha1sum := sha1.Sum([]byte(TEST_VALUE))
+
+	found, err := s.LookupHash("md5", md5sum[:])
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, found)
+
+	found, err = s.LookupHash("sha1", sha1sum[:])
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, found)
+
+	// Unknown hashes and digests aren't found.
+	found, err = s.LookupHash("sha1", md5sum[:])
+	assert.NoError(t, err)
+	assert.Equal(t, "", found)
+	found, err = s.LookupHash("crc32", md5sum[:])
+	assert.NoError(t, err)
+	assert.Equal(t, "", found)
+
+	// Nor are deleted objects.
+	assert.NoError(t, s.Delete(TEST_KEY))
+	found, err = s.LookupHash("md5", md5sum[:])
+	assert.NoError(t, err)
+	assert.Equal(t, "", found)
+
+	// The index is not visible as objects.
+	assert.NoError(t, s.Walk(func(key string, size int64) error {
+		t.Errorf("unexpected key %s", key)
+		return nil
+	}))
+
+	_, err = New(Options{
+		BasePath:        tdir,
+		SecondaryHashes: map[string]func() hash.Hash{"a/b": md5.New},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package castore
+
+// Hooks contains callbacks that are fired after content in a store changes.
+// They are called synchronously, after the change has been made, and any of
+// them may be nil.
+type Hooks struct {
+	// OnPut is called after each successful Put, including those whose data
+	// was already present in the store.
+	OnPut func(key string, size int64)
+
+	// OnDelete is called after an object is removed by Delete.
+	OnDelete func(key string, size int64)
+
+	// OnEvict is called after the store removes an object of its own accord:
+	// by Evict, or the manifest of a snapshot pruned due to SnapshotHistory.
+	OnEvict func(key string, size int64)
+
+	// OnThreshold is called when usage of the store's quota or filesystem
+	// crosses one of Options.UsageThresholds, in either direction.
+	OnThreshold func(alert UsageAlert)
+}
+
+func (h *Hooks) onPut(key string, size int64) {
+	if h.OnPut != nil {
+		h.OnPut(key, size)
+	}
+}
+
+func (h *Hooks) onDelete(key string, size int64) {
+	if h.OnDelete != nil {
+		h.OnDelete(key, size)
+	}
+}
+
+func (h *Hooks) onEvict(key string, size int64) {
+	if h.OnEvict != nil {
+		h.OnEvict(key, size)
+	}
+}
+
+func (h *Hooks) onThreshold(alert UsageAlert) {
+	if h.OnThreshold != nil {
+		h.OnThreshold(alert)
+	}
+}
@@ -0,0 +1,61 @@
+package castore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHooks(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-hooks"))
+	defer os.RemoveAll(tdir)
+
+	var events []string
+	record := func(event string) func(string, int64) {
+		return func(key string, size int64) {
+			events = append(events, fmt.Sprintf("%s %s %d", event, key, size))
+		}
+	}
+
+	s, err := New(Options{
+		BasePath:        tdir,
+		SnapshotHistory: 1,
+		Hooks: Hooks{
+			OnPut:    record("put"),
+			OnDelete: record("delete"),
+			OnEvict:  record("evict"),
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(TEST_KEY))
+
+	// Nonexistent keys don't fire the hook.
+	assert.NoError(t, s.Delete(TEST_KEY))
+
+	assert.Equal(t, []string{
+		"put " + TEST_KEY + " 6",
+		"delete " + TEST_KEY + " 6",
+	}, events)
+
+	// Pruning a snapshot evicts its manifest.
+	first, err := s.Snapshot("first")
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	events = nil
+	_, err = s.Snapshot("second")
+	assert.NoError(t, err)
+	assert.Contains(t, events, fmt.Sprintf("evict %s 0", first.Key))
+
+	// Hooks are optional.
+	s, err = New(Options{BasePath: tdir, Hooks: Hooks{OnDelete: record("delete")}})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+}
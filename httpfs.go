@@ -0,0 +1,41 @@
+package castore
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// httpFileSystem is an http.FileSystem view of a CAStore.
+type httpFileSystem struct {
+	s *CAStore
+}
+
+// HTTPFileSystem returns an http.FileSystem that serves the data in the store,
+// such that the path "/<key>" refers to the data stored with that key.  The
+// returned files report the Size and ModTime of the underlying on-disk files,
+// so they can be used by http.FileServer for caching headers.  Objects that
+// don't have files of their own, such as those that have been packed, take
+// the ModTime of the file that holds them.  Objects are read with Get, so
+// reads made through the returned value are verified, rate limited and
+// counted in the same way as any other.
+//
+// Any path that is not a well-formed key - including the root directory - will
+// return an error that satisfies os.IsNotExist.
+func (s *CAStore) HTTPFileSystem() http.FileSystem {
+	return httpFileSystem{s}
+}
+
+func (fs httpFileSystem) Open(name string) (http.File, error) {
+	key := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !fs.s.validKey(key) {
+		return nil, os.ErrNotExist
+	}
+
+	f, err := fs.s.openObject(key)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,125 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPFileSystem(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-httpfs"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:    tdir,
+		Transform:   DepthTransformFunc(2),
+		PackMaxSize: 8,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	fs := s.HTTPFileSystem()
+
+	// Existing key
+	f, err := fs.Open("/" + TEST_KEY)
+	assert.NoError(t, err)
+	inf, err := f.Stat()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), inf.Size())
+	f.Close()
+
+	// Bad keys shouldn't panic the transform function
+	for _, name := range []string{"/", "/a", "/../" + TEST_KEY[1:], "/ab/cd"} {
+		_, err = fs.Open(name)
+		assert.True(t, os.IsNotExist(err), name)
+	}
+
+	// Serving through http.FileServer
+	srv := httptest.NewServer(http.FileServer(fs))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+	assert.NotEqual(t, "", resp.Header.Get("Last-Modified"))
+
+	// Packed objects are served from their pack.
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	_, err = os.Stat(s.blobPath(TEST_KEY))
+	assert.True(t, os.IsNotExist(err))
+	f, err = fs.Open("/" + TEST_KEY)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(f)
+	f.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	resp, err = http.Get(srv.URL + "/bad-key")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHTTPFileSystemGet(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-httpfs"))
+	defer os.RemoveAll(tdir)
+
+	// Files are read with Get, even when its reader can't seek.
+	var gets int
+	s, err := New(Options{
+		BasePath:     tdir,
+		VerifyOnRead: true,
+		CorruptReads: CorruptReadPartial,
+		Observer: func(op Operation) {
+			if op.Op == OpGet {
+				gets++
+			}
+		},
+	})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(http.FileServer(s.HTTPFileSystem()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, TEST_VALUE, string(data))
+	assert.NotZero(t, gets)
+
+	req, err := http.NewRequest("GET", srv.URL+"/"+TEST_KEY, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Range", "bytes=2-3")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "ob", string(data))
+
+	// Corruption is caught.
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("fooBAR"), 0600))
+	f, err := s.HTTPFileSystem().Open("/" + TEST_KEY)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(f)
+	f.Close()
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
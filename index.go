@@ -0,0 +1,401 @@
+package castore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// indexFile is the name of the file in the store's internal state
+	// directory that holds the index of loose objects (see Options.Index).  It
+	// is a log of "+ <key> <size> <mtime> [<atime>]", "a <key> <atime>" and
+	// "- <key>" lines, which is rewritten as a list of additions when it grows
+	// too long.
+	indexFile = "index"
+
+	// indexDirtyFile is the name of the file in the store's internal state
+	// directory that exists while a store is using the index, so that if the
+	// store is not closed cleanly, the index is rebuilt when it is next
+	// opened.
+	indexDirtyFile = "index.dirty"
+
+	// accessTimeResolution is how much an object's access time must have
+	// changed by before it is recorded again, so that an object that is
+	// retrieved repeatedly doesn't add a record to the index each time.
+	accessTimeResolution = time.Minute
+)
+
+// indexEntry is the information kept about a loose object in the index.
+type indexEntry struct {
+	size  int64
+	mtime int64
+	atime int64
+}
+
+// accessed returns the time at which the object was last retrieved, or else
+// written.
+func (ent indexEntry) accessed() time.Time {
+	if ent.atime > ent.mtime {
+		return time.Unix(0, ent.atime)
+	}
+	return time.Unix(0, ent.mtime)
+}
+
+// openIndex loads the index, or rebuilds it if it may be out of date.
+func (s *CAStore) openIndex() error {
+	dirty, err := s.metaPath(indexDirtyFile)
+	if err != nil {
+		return err
+	}
+	p, err := s.metaPath(indexFile)
+	if err != nil {
+		return err
+	}
+
+	_, derr := os.Stat(dirty)
+	index, records, err := readIndex(p)
+	if err == nil && derr == nil {
+		s.log.Warn("store was not closed cleanly; rebuilding index")
+		// The rebuilt index keeps whatever access times were recorded.
+		s.indexMu.Lock()
+		s.index = index
+		s.indexMu.Unlock()
+		err = s.RebuildIndex()
+	} else if os.IsNotExist(err) {
+		err = s.RebuildIndex()
+	} else if err == nil {
+		s.indexMu.Lock()
+		s.index = index
+		s.indexRecords = records
+		err = s.openIndexLog(p)
+		s.indexMu.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Nothing is synced until the store is closed; until then, a crash means
+	// the index has to be rebuilt.
+	return writeFileAtomic(dirty, nil)
+}
+
+// readIndex reads the index log, and returns the index and the number of
+// records in the log.
+func readIndex(p string) (map[string]indexEntry, int, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	index := make(map[string]indexEntry)
+	records := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case (len(fields) == 4 || len(fields) == 5) && fields[0] == "+":
+			size, serr := strconv.ParseInt(fields[2], 10, 64)
+			mtime, merr := strconv.ParseInt(fields[3], 10, 64)
+			if serr != nil || merr != nil {
+				continue
+			}
+			ent := indexEntry{size, mtime, mtime}
+			if len(fields) == 5 {
+				atime, aerr := strconv.ParseInt(fields[4], 10, 64)
+				if aerr != nil {
+					continue
+				}
+				ent.atime = atime
+			}
+			index[fields[1]] = ent
+		case len(fields) == 3 && fields[0] == "a":
+			atime, aerr := strconv.ParseInt(fields[2], 10, 64)
+			ent, ok := index[fields[1]]
+			if aerr != nil || !ok {
+				continue
+			}
+			ent.atime = atime
+			index[fields[1]] = ent
+		case len(fields) == 2 && fields[0] == "-":
+			delete(index, fields[1])
+		default:
+			continue
+		}
+		records++
+	}
+	return index, records, scanner.Err()
+}
+
+// openIndexLog opens the index log for appending.  It must be called with
+// indexMu held.
+func (s *CAStore) openIndexLog(p string) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if s.indexFile != nil {
+		// Anything still buffered has been superseded.
+		s.indexFile.Close()
+	}
+	s.indexLog = bufio.NewWriter(f)
+	s.indexFile = f
+	return nil
+}
+
+// RebuildIndex will rebuild the index of the store (see Options.Index) from
+// the objects on disk.  It only needs to be called if the BasePath has been
+// modified by something other than this CAStore; an index that may be out of
+// date because the store was not closed is rebuilt automatically.  Puts and
+// Deletes wait until it has finished.
+func (s *CAStore) RebuildIndex() error {
+	if !s.opts.Index {
+		return nil
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	index := make(map[string]indexEntry)
+	err := s.walkFiles(func(key, p string, info os.FileInfo) error {
+		mtime := info.ModTime().UnixNano()
+		ent := indexEntry{info.Size(), mtime, mtime}
+		if old, ok := s.index[key]; ok && old.atime > ent.atime {
+			ent.atime = old.atime
+		}
+		index[key] = ent
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.writeIndex(index); err != nil {
+		return err
+	}
+	s.log.Info("rebuilt index", "objects", len(index))
+	return nil
+}
+
+// writeIndex replaces the index log with a list of the given index's entries,
+// and starts using it.  It must be called with indexMu held.
+func (s *CAStore) writeIndex(index map[string]indexEntry) error {
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var data []byte
+	for _, key := range keys {
+		ent := index[key]
+		if ent.atime > ent.mtime {
+			data = append(data, fmt.Sprintf("+ %s %d %d %d\n", key, ent.size, ent.mtime, ent.atime)...)
+		} else {
+			data = append(data, fmt.Sprintf("+ %s %d %d\n", key, ent.size, ent.mtime)...)
+		}
+	}
+	p, err := s.metaPath(indexFile)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(p, data); err != nil {
+		return err
+	}
+
+	s.index = index
+	s.indexRecords = len(index)
+	return s.openIndexLog(p)
+}
+
+// indexAdd records a loose object in the index, if there is one.
+func (s *CAStore) indexAdd(key string, size int64) {
+	if !s.opts.Index {
+		return
+	}
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	mtime := time.Now().UnixNano()
+	s.index[key] = indexEntry{size, mtime, mtime}
+	s.appendIndex(fmt.Sprintf("+ %s %d %d\n", key, size, mtime))
+}
+
+// indexAccess records in the index that the given key has been retrieved, if
+// Options.AccessTimes is set.
+func (s *CAStore) indexAccess(key string) {
+	if !s.opts.Index || !s.opts.AccessTimes {
+		return
+	}
+	now := time.Now().UnixNano()
+	s.indexMu.RLock()
+	ent, ok := s.index[key]
+	s.indexMu.RUnlock()
+	if !ok || now-ent.atime < int64(accessTimeResolution) {
+		return
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if ent, ok = s.index[key]; !ok {
+		return
+	}
+	ent.atime = now
+	s.index[key] = ent
+	s.appendIndex(fmt.Sprintf("a %s %d\n", key, now))
+}
+
+// indexRemove removes a loose object from the index, if there is one.
+func (s *CAStore) indexRemove(key string) {
+	if !s.opts.Index {
+		return
+	}
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return
+	}
+	delete(s.index, key)
+	s.appendIndex("- " + key + "\n")
+}
+
+// appendIndex adds a record to the index log.  It must be called with indexMu
+// held.  If it fails, the in-memory index stays correct, and the index is
+// rebuilt when the store is next opened.
+func (s *CAStore) appendIndex(record string) {
+	if s.indexBroken {
+		return
+	}
+	if s.indexLog == nil {
+		// The store is being used after Close.
+		if err := s.reopenIndexLog(); err != nil {
+			s.log.Warn("could not reopen index", "err", err)
+			s.indexBroken = true
+			return
+		}
+	}
+	if _, err := s.indexLog.WriteString(record); err != nil {
+		s.log.Warn("could not update index; it will be rebuilt", "err", err)
+		s.indexBroken = true
+		return
+	}
+	s.indexRecords++
+}
+
+// reopenIndexLog opens the index log again after closeIndex, marking the
+// index as in use.  It must be called with indexMu held.
+func (s *CAStore) reopenIndexLog() error {
+	dirty, err := s.metaPath(indexDirtyFile)
+	if err != nil {
+		return err
+	}
+	if err = writeFileAtomic(dirty, nil); err != nil {
+		return err
+	}
+	p, err := s.metaPath(indexFile)
+	if err != nil {
+		return err
+	}
+	return s.openIndexLog(p)
+}
+
+// indexLookup returns the index's entry for the given key.  It must only be
+// called if the index is enabled.
+func (s *CAStore) indexLookup(key string) (indexEntry, bool) {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+	ent, ok := s.index[key]
+	return ent, ok
+}
+
+// LastAccess returns the time at which the object with the given key was last
+// retrieved by Get, or else written, as recorded in the index if
+// Options.AccessTimes is set.  Otherwise, or if the object isn't in the index,
+// this is the time at which it was written.  If the key does not exist, the
+// zero time is returned.
+func (s *CAStore) LastAccess(key string) (time.Time, error) {
+	if !s.validKey(key) {
+		return time.Time{}, nil
+	}
+	if s.opts.Index {
+		if ent, ok := s.indexLookup(key); ok {
+			return ent.accessed(), nil
+		}
+	}
+	_, info, err := s.locate(key)
+	if os.IsNotExist(err) {
+		info, err = s.retainedInfo(key)
+	}
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// indexedMatching returns the size of every object in the index that matches
+// opts.  Any indexed object is removed from packed, whether or not it matches,
+// so that a packed copy of it isn't visited instead.
+func (s *CAStore) indexedMatching(opts *WalkOptions, packed map[string]int64) map[string]int64 {
+	s.indexMu.RLock()
+	defer s.indexMu.RUnlock()
+
+	timed := opts.timed()
+	objects := make(map[string]int64)
+	for key, ent := range s.index {
+		delete(packed, key)
+		if !opts.matchObject(key, ent.size) {
+			continue
+		}
+		if timed && !opts.matchTime(time.Unix(0, ent.mtime), ent.accessed()) {
+			continue
+		}
+		objects[key] = ent.size
+	}
+	return objects
+}
+
+// closeIndex makes the index durable, compacting it if it has grown too long,
+// and marks it as up to date.
+func (s *CAStore) closeIndex() error {
+	if !s.opts.Index {
+		return nil
+	}
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if s.indexLog == nil || s.indexBroken {
+		return nil
+	}
+	var err error
+	if s.indexRecords > 2*len(s.index)+1000 {
+		err = s.writeIndex(s.index)
+	}
+	if err == nil {
+		err = s.indexLog.Flush()
+	}
+	if err == nil {
+		err = s.indexFile.Sync()
+	}
+	if cerr := s.indexFile.Close(); err == nil {
+		err = cerr
+	}
+	s.indexLog = nil
+	s.indexFile = nil
+	if err != nil {
+		return err
+	}
+
+	dirty, err := s.metaPath(indexDirtyFile)
+	if err != nil {
+		return err
+	}
+	return os.Remove(dirty)
+}
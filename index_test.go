@@ -0,0 +1,146 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-index"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Index: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(other))
+	assert.NoError(t, s.Close())
+
+	// The index is loaded when the store is reopened.
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key}, keysOf(t, s))
+
+	// Changes made behind the store's back aren't seen until the index is
+	// rebuilt.
+	assert.NoError(t, os.Remove(s.blobPath(key)))
+	size, err := s.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+	assert.NoError(t, s.RebuildIndex())
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	u, err := s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{}, u)
+}
+
+func TestIndexNotClosed(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-index"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Index: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Without Close, nothing has been written to the log, but the index is
+	// rebuilt.
+	data, err := ioutil.ReadFile(filepath.Join(tdir, metaDir, indexFile))
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestIndexAfterClose(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-index"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Index: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key}, keysOf(t, s))
+}
+
+func TestAccessTimes(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-index"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Index: true, AccessTimes: true}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	// Pretend that both were written a while ago.
+	old := time.Now().Add(-2 * time.Hour)
+	s.indexMu.Lock()
+	for _, k := range []string{key, other} {
+		assert.NoError(t, os.Chtimes(s.blobPath(k), old, old))
+		ent := s.index[k]
+		ent.mtime = old.UnixNano()
+		ent.atime = ent.mtime
+		s.index[k] = ent
+	}
+	assert.NoError(t, s.writeIndex(s.index))
+	s.indexMu.Unlock()
+
+	atime, err := s.LastAccess(key)
+	assert.NoError(t, err)
+	assert.Equal(t, old.UnixNano(), atime.UnixNano())
+
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+	atime, err = s.LastAccess(key)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), atime, time.Minute)
+
+	cold := func() []string {
+		var keys []string
+		err := s.WalkWithOptions(WalkOptions{NotAccessedSince: time.Now().Add(-time.Hour)}, func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	assert.Equal(t, []string{other}, cold())
+
+	// Access times survive reopening the store, and rebuilding the index
+	// when it wasn't closed.
+	assert.NoError(t, s.Close())
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{other}, cold())
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{other}, cold())
+
+	atime, err = s.LastAccess("0000000000000000000000000000000000000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.True(t, atime.IsZero())
+}
@@ -0,0 +1,30 @@
+package castore
+
+// claimKey is used by ingest to make sure that only one Put at a time moves
+// data into place for a given key, so that concurrent Puts of the same data
+// don't each replace the others' copies.  It returns nil if the caller may go
+// ahead, in which case it must call releaseKey once it is done.  Otherwise, it
+// returns a channel that is closed once the Put that holds the key finishes.
+func (s *CAStore) claimKey(key string) <-chan struct{} {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+
+	if ch, ok := s.inflight[key]; ok {
+		return ch
+	}
+	if s.inflight == nil {
+		s.inflight = make(map[string]chan struct{})
+	}
+	s.inflight[key] = make(chan struct{})
+	return nil
+}
+
+// releaseKey releases a key claimed by claimKey, waking any Puts waiting for
+// it.
+func (s *CAStore) releaseKey(key string) {
+	s.inflightMu.Lock()
+	defer s.inflightMu.Unlock()
+
+	close(s.inflight[key])
+	delete(s.inflight, key)
+}
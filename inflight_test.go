@@ -0,0 +1,68 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentPutsCoalesce(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-inflight"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// While another Put holds the key, a Put of the same data waits for it,
+	// and doesn't replace what it stored.
+	assert.Nil(t, s.claimKey(TEST_KEY))
+	done := make(chan error)
+	go func() {
+		_, err := s.PutString(TEST_VALUE)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("Put did not wait for the concurrent Put")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("marker"), 0600))
+	s.releaseKey(TEST_KEY)
+	assert.NoError(t, <-done)
+
+	data, err := ioutil.ReadFile(s.blobPath(TEST_KEY))
+	assert.NoError(t, err)
+	assert.Equal(t, "marker", string(data))
+
+	// If the other Put fails, the waiting one stores the data itself.
+	assert.NoError(t, os.Remove(s.blobPath(TEST_KEY)))
+	assert.Nil(t, s.claimKey(TEST_KEY))
+	go func() {
+		_, err := s.PutString(TEST_VALUE)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.releaseKey(TEST_KEY)
+	assert.NoError(t, <-done)
+	data, err = ioutil.ReadFile(s.blobPath(TEST_KEY))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	// Lots of concurrent Puts all succeed.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := s.PutString(TEST_VALUE)
+			assert.NoError(t, err)
+			assert.Equal(t, TEST_KEY, key)
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, s.inflight)
+}
@@ -0,0 +1,151 @@
+package castore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inlinePrefix is the prefix of the names of inline packs, to which objects
+// no larger than Options.InlineMaxSize are appended as they are stored.  Unlike
+// other packs, an inline pack's index is appended to along with its data, so
+// the last line may be incomplete after a crash.  Inline packs are always
+// rewritten by Repack, and a new one is started afterwards.
+const inlinePrefix = "inline-"
+
+// isInlinePack returns whether the named pack is an inline pack.
+func isInlinePack(pack string) bool {
+	return strings.HasPrefix(pack, inlinePrefix)
+}
+
+// appendInline adds an object to the inline pack.
+func (s *CAStore) appendInline(key string, data []byte) error {
+	s.repackMu.RLock()
+	defer s.repackMu.RUnlock()
+	s.inlineMu.Lock()
+	defer s.inlineMu.Unlock()
+
+	if s.inlineData == nil {
+		if err := s.openInline(); err != nil {
+			return err
+		}
+	}
+
+	// The data is written first, so that the index never refers to data that
+	// isn't there.  Any data left without an index entry by a crash is
+	// discarded by the next Repack.
+	offset := s.inlineSize
+	_, err := s.inlineData.WriteAt(data, offset)
+	if err == nil && s.syncData() {
+		err = s.inlineData.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	s.inlineSize += int64(len(data))
+
+	_, err = fmt.Fprintf(s.inlineIndex, "%s %d %d\n", key, offset, len(data))
+	if err == nil && s.syncData() {
+		err = s.inlineIndex.Sync()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.packMu.Lock()
+	if s.packed == nil {
+		s.packed = make(map[string]packEntry)
+	}
+	s.packed[key] = packEntry{s.inlineName, offset, int64(len(data))}
+	s.packMu.Unlock()
+	return nil
+}
+
+// openInline opens the current inline pack, starting a new one if there is
+// none.  It must be called with inlineMu held.
+func (s *CAStore) openInline() error {
+	dir, err := s.packDir()
+	if err != nil {
+		return err
+	}
+	if s.inlineName == "" {
+		var id [8]byte
+		if _, err = rand.Read(id[:]); err != nil {
+			return err
+		}
+		s.inlineName = inlinePrefix + hex.EncodeToString(id[:])
+	}
+
+	data, err := os.OpenFile(filepath.Join(dir, s.inlineName+packExt), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, s.inlineName+indexExt), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		err = truncatePartialLine(index)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = data.Stat()
+	}
+	if err != nil {
+		data.Close()
+		if index != nil {
+			index.Close()
+		}
+		return err
+	}
+
+	s.inlineData = data
+	s.inlineIndex = index
+	s.inlineSize = info.Size()
+	return nil
+}
+
+// truncatePartialLine removes an incomplete last line from the given file, as
+// may be left behind by a crash while it was being appended to.
+func truncatePartialLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	// Index lines are much shorter than this.
+	tail := int64(4096)
+	if tail > info.Size() {
+		tail = info.Size()
+	}
+	buf := make([]byte, tail)
+	if _, err = f.ReadAt(buf, info.Size()-tail); err != nil && err != io.EOF {
+		return err
+	}
+	if buf[len(buf)-1] == '\n' {
+		return nil
+	}
+	keep := info.Size() - tail + int64(bytes.LastIndexByte(buf, '\n')+1)
+	return f.Truncate(keep)
+}
+
+// closeInline closes the current inline pack, so that the next object that is
+// inlined starts a new one.
+func (s *CAStore) closeInline() error {
+	s.inlineMu.Lock()
+	defer s.inlineMu.Unlock()
+
+	s.inlineName = ""
+	if s.inlineData == nil {
+		return nil
+	}
+	err := s.inlineData.Close()
+	if cerr := s.inlineIndex.Close(); err == nil {
+		err = cerr
+	}
+	s.inlineData = nil
+	s.inlineIndex = nil
+	return err
+}
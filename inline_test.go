@@ -0,0 +1,69 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInline(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-inline"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, InlineMaxSize: 8}
+	s, err := New(opts)
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	large, err := s.PutString("too large to inline")
+	assert.NoError(t, err)
+
+	// Only the large object gets a file of its own.
+	_, err = os.Stat(s.blobPath(key))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(s.blobPath(large))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+
+	// An interrupted append is ignored when the store is reopened.
+	assert.NoError(t, s.Close())
+	idx, err := filepath.Glob(filepath.Join(tdir, metaDir, packsDir, inlinePrefix+"*"+indexExt))
+	assert.NoError(t, err)
+	assert.Len(t, idx, 1)
+	f, err := os.OpenFile(idx[0], os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteString("0123 4")
+	assert.NoError(t, err)
+	f.Close()
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	s, err = New(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+	assert.Equal(t, "other", readKey(t, s, other))
+
+	// Repack folds the inline pack into an ordinary one.
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	idx, err = filepath.Glob(filepath.Join(tdir, metaDir, packsDir, inlinePrefix+"*"))
+	assert.NoError(t, err)
+	assert.Empty(t, idx)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+	assert.Equal(t, "other", readKey(t, s, other))
+
+	// After which inlining starts afresh.
+	third, err := s.PutString("third")
+	assert.NoError(t, err)
+	assert.Equal(t, "third", readKey(t, s, third))
+	idx, err = filepath.Glob(filepath.Join(tdir, metaDir, packsDir, inlinePrefix+"*"+indexExt))
+	assert.NoError(t, err)
+	assert.Len(t, idx, 1)
+}
@@ -0,0 +1,81 @@
+package castore
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// InventoryRecord is a single line of the output of Inventory.
+type InventoryRecord struct {
+	// Key is the key of the object.
+	Key string `json:"key"`
+
+	// Size is the size of the object, in bytes.
+	Size int64 `json:"size"`
+
+	// Stored is the time at which the object was last written to disk.
+	Stored time.Time `json:"stored"`
+
+	// Labels and Refs are the labels and refs that are attached to the
+	// object, in sorted order.
+	Labels []string `json:"labels,omitempty"`
+	Refs   []string `json:"refs,omitempty"`
+
+	// Metadata is the metadata attached to the object (see SetMetadata).
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Inventory will write an InventoryRecord for every object in the store to w,
+// as newline-delimited JSON, in no particular order.  Objects that are
+// inserted or deleted while the inventory is being written may or may not be
+// included.
+func (s *CAStore) Inventory(w io.Writer) error {
+	labels, err := s.Labels()
+	if err != nil {
+		return err
+	}
+	refs, err := s.ListRefs()
+	if err != nil {
+		return err
+	}
+	labelsFor := invert(labels)
+	refsFor := invert(refs)
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err = s.walkFiles(func(key, p string, info os.FileInfo) error {
+		metadata, err := s.GetMetadata(key)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(InventoryRecord{
+			Key:      key,
+			Size:     info.Size(),
+			Stored:   info.ModTime().UTC(),
+			Labels:   labelsFor[key],
+			Refs:     refsFor[key],
+			Metadata: metadata,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// invert is a helper function that converts a map from names to keys into a
+// map from keys to their sorted names.
+func invert(m map[string]string) map[string][]string {
+	ret := make(map[string][]string)
+	for name, key := range m {
+		ret[key] = append(ret[key], name)
+	}
+	for _, names := range ret {
+		sort.Strings(names)
+	}
+	return ret
+}
@@ -0,0 +1,51 @@
+package castore
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInventory(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-inventory"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutWithMetadata(strings.NewReader("other"), map[string]string{"name": "other.txt"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetLabel("b", key))
+	assert.NoError(t, s.SetLabel("a", key))
+	assert.NoError(t, s.SetRef("latest", other))
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.Inventory(&buf))
+
+	var records []InventoryRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec InventoryRecord
+		assert.NoError(t, dec.Decode(&rec))
+		assert.WithinDuration(t, time.Now(), rec.Stored, time.Minute)
+		rec.Stored = time.Time{}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+
+	expected := []InventoryRecord{
+		{Key: key, Size: int64(len(TEST_VALUE)), Labels: []string{"a", "b"}},
+		{Key: other, Size: 5, Refs: []string{"latest"}, Metadata: map[string]string{"name": "other.txt"}},
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i].Key < expected[j].Key })
+	assert.Equal(t, expected, records)
+}
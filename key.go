@@ -0,0 +1,174 @@
+package castore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"io"
+)
+
+// ErrInvalidKey is the error returned when parsing a string that is not a
+// valid key, or when using a Key produced by a different hash function.
+var ErrInvalidKey = errors.New("castore: invalid key")
+
+// Key identifies a piece of data: its digest, along with the hash function
+// that produced it and the encoding used to represent it as a string.  Unlike
+// a plain string, a Key can only be created by a store or by parsing, so it is
+// always well-formed.  Keys may be compared with ==.
+//
+// Every method of CAStore that takes or returns a string key has a
+// counterpart that uses a Key instead; the two are interchangeable.
+type Key struct {
+	code   uint64
+	digest string
+	enc    KeyEncoding
+}
+
+// NewKey returns the key for the given digest, where code is the multihash
+// code of the hash function that produced it (e.g. MultihashSHA256), or zero
+// if it is unknown.
+func NewKey(code uint64, digest []byte, enc KeyEncoding) Key {
+	return Key{code, string(digest), enc}
+}
+
+// ParseKey parses a key that was encoded with the given encoding.
+func ParseKey(key string, code uint64, enc KeyEncoding) (Key, error) {
+	digest, ok := enc.Decode(key)
+	if !ok || len(digest) == 0 || enc.Encode(digest) != key {
+		return Key{}, ErrInvalidKey
+	}
+	return NewKey(code, digest, enc), nil
+}
+
+// String returns the encoded form of the key, as used by the string-based
+// methods of CAStore.  The zero Key is encoded as an empty string.
+func (k Key) String() string {
+	if k.enc == nil {
+		return ""
+	}
+	return k.enc.Encode([]byte(k.digest))
+}
+
+// MarshalText implements encoding.TextMarshaler, using String.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Digest returns the raw digest of the key.
+func (k Key) Digest() []byte {
+	return []byte(k.digest)
+}
+
+// Algorithm returns the multihash code of the hash function that produced the
+// key, or zero if it is unknown.
+func (k Key) Algorithm() uint64 {
+	return k.code
+}
+
+// Encoding returns the encoding used to represent the key as a string.
+func (k Key) Encoding() KeyEncoding {
+	return k.enc
+}
+
+// IsZero returns whether this is the zero Key, which does not identify any
+// data.
+func (k Key) IsZero() bool {
+	return k.digest == ""
+}
+
+// hashCodes maps the digest of no data to the multihash code of each hash
+// function that we know of, so that a store's Hash can be identified.
+var hashCodes = map[string]uint64{
+	string(sha256.New().Sum(nil)): MultihashSHA256,
+	string(sha512.New().Sum(nil)): MultihashSHA512,
+	string(NewBLAKE3().Sum(nil)):  MultihashBLAKE3,
+}
+
+// detectHashCode returns the multihash code of the given hash function, or
+// zero if it is not one that we know of.  Since hash functions are given as
+// constructors, which cannot be compared, this is done by hashing no data and
+// comparing the result with that of the known functions.
+func detectHashCode(fn func() hash.Hash, enc KeyEncoding) uint64 {
+	switch e := enc.(type) {
+	case multihashEncoding:
+		return e.code
+	case cidEncoding:
+		return e.code
+	}
+	return hashCodes[string(fn().Sum(nil))]
+}
+
+// ParseKey parses a key in the store's KeyEncoding, returning ErrInvalidKey if
+// it could not have been produced by the store.
+func (s *CAStore) ParseKey(key string) (Key, error) {
+	if !s.validKey(key) {
+		return Key{}, ErrInvalidKey
+	}
+	digest, _ := s.opts.KeyEncoding.Decode(key)
+	return NewKey(s.hashCode, digest, s.opts.KeyEncoding), nil
+}
+
+// keyString returns the given key in the store's KeyEncoding, or
+// ErrInvalidKey if it was produced by a different hash function.
+func (s *CAStore) keyString(k Key) (string, error) {
+	if k.IsZero() || len(k.digest) != s.opts.Hash().Size() {
+		return "", ErrInvalidKey
+	}
+	if k.code != 0 && s.hashCode != 0 && k.code != s.hashCode {
+		return "", ErrInvalidKey
+	}
+	return s.opts.KeyEncoding.Encode([]byte(k.digest)), nil
+}
+
+// PutKey is like Put, but returns a Key.
+func (s *CAStore) PutKey(r io.Reader) (Key, error) {
+	key, err := s.Put(r)
+	if err != nil {
+		return Key{}, err
+	}
+	return s.ParseKey(key)
+}
+
+// PutBytesKey is like PutBytes, but returns a Key.
+func (s *CAStore) PutBytesKey(b []byte) (Key, error) {
+	return s.PutKey(bytes.NewReader(b))
+}
+
+// GetKey is like Get, but takes a Key.  A key in a different encoding from the
+// store's is converted, so long as it was produced by the same hash function.
+func (s *CAStore) GetKey(k Key) (io.ReadCloser, error) {
+	key, err := s.keyString(k)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(key)
+}
+
+// SizeKey is like Size, but takes a Key.
+func (s *CAStore) SizeKey(k Key) (int64, error) {
+	key, err := s.keyString(k)
+	if err != nil {
+		return 0, err
+	}
+	return s.Size(key)
+}
+
+// ExistsKey is like Exists, but takes a Key.
+func (s *CAStore) ExistsKey(k Key) (bool, error) {
+	key, err := s.keyString(k)
+	if err != nil {
+		return false, err
+	}
+	return s.Exists(key)
+}
+
+// DeleteKey is like Delete, but takes a Key.
+func (s *CAStore) DeleteKey(k Key) error {
+	key, err := s.keyString(k)
+	if err != nil {
+		return err
+	}
+	return s.Delete(key)
+}
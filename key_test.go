@@ -0,0 +1,90 @@
+package castore
+
+import (
+	"crypto/sha512"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKey(t *testing.T) {
+	k, err := ParseKey(TEST_KEY, MultihashSHA256, HexEncoding)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, k.String())
+	assert.Equal(t, uint64(MultihashSHA256), k.Algorithm())
+	assert.Equal(t, HexEncoding, k.Encoding())
+	assert.Len(t, k.Digest(), 32)
+	assert.False(t, k.IsZero())
+
+	text, err := k.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, string(text))
+
+	// Keys are comparable.
+	k2, err := ParseKey(TEST_KEY, MultihashSHA256, HexEncoding)
+	assert.NoError(t, err)
+	assert.True(t, k == k2)
+
+	for _, bad := range []string{"", "xyz", "C3AB8FF13720E8AD9047DD39466B3C8974E592C2FA383D4A3960714CAEF0C4F2"} {
+		_, err = ParseKey(bad, MultihashSHA256, HexEncoding)
+		assert.Equal(t, ErrInvalidKey, err, bad)
+	}
+
+	var zero Key
+	assert.True(t, zero.IsZero())
+	assert.Equal(t, "", zero.String())
+}
+
+func TestTypedKeys(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-key"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	k, err := s.PutBytesKey([]byte(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, k.String())
+	assert.Equal(t, uint64(MultihashSHA256), k.Algorithm())
+
+	exists, err := s.ExistsKey(k)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	size, err := s.SizeKey(k)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	// Keys in other encodings are converted.
+	cid := NewKey(MultihashSHA256, k.Digest(), CIDEncoding(MultihashSHA256))
+	r, err := s.GetKey(cid)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	// But keys from other hash functions are rejected.
+	other := NewKey(MultihashSHA512, make([]byte, 32), HexEncoding)
+	_, err = s.ExistsKey(other)
+	assert.Equal(t, ErrInvalidKey, err)
+	_, err = s.ExistsKey(Key{})
+	assert.Equal(t, ErrInvalidKey, err)
+
+	_, err = s.ParseKey("bad-key")
+	assert.Equal(t, ErrInvalidKey, err)
+
+	assert.NoError(t, s.DeleteKey(k))
+	exists, err = s.ExistsKey(k)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDetectHashCode(t *testing.T) {
+	assert.Equal(t, uint64(MultihashSHA512), detectHashCode(sha512.New, HexEncoding))
+	assert.Equal(t, uint64(MultihashBLAKE3), detectHashCode(NewBLAKE3, Base32Encoding))
+	assert.Equal(t, uint64(MultihashSHA512), detectHashCode(NewBLAKE3, MultihashEncoding(MultihashSHA512)))
+	assert.Equal(t, uint64(0), detectHashCode(sha512.New512_224, HexEncoding))
+}
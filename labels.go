@@ -0,0 +1,171 @@
+package castore
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// labelsFile is the name of the file in the store's internal state directory
+// in which labels are persisted.
+const labelsFile = "labels.json"
+
+// ErrEmptyLabel is the error returned when attempting to use an empty label.
+var ErrEmptyLabel = errors.New("castore: label cannot be empty")
+
+// UpdateLabels will call fn with the current set of labels, as a map from
+// label to key, and then persist any changes fn makes to the map.  The update
+// is atomic - either all of the changes are saved, or none are - and if fn
+// returns an error, nothing is saved and the error is returned.  Every key
+// that a label refers to must exist in the store, or ErrNotFound is returned.
+func (s *CAStore) UpdateLabels(fn func(labels map[string]string) error) error {
+	s.labelsMu.Lock()
+	defer s.labelsMu.Unlock()
+
+	labels, err := s.readLabels()
+	if err != nil {
+		return err
+	}
+	old := make(map[string]string, len(labels))
+	for l, k := range labels {
+		old[l] = k
+	}
+
+	if err = fn(labels); err != nil {
+		return err
+	}
+
+	for l, k := range labels {
+		if l == "" {
+			return ErrEmptyLabel
+		}
+		if old[l] == k {
+			continue
+		}
+		exists, err := s.Exists(k)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+	}
+	return s.writeLabels(labels)
+}
+
+// SetLabel will attach the given label to the given key, replacing any key it
+// was previously attached to.  A key can have any number of labels.
+func (s *CAStore) SetLabel(label, key string) error {
+	return s.UpdateLabels(func(labels map[string]string) error {
+		labels[label] = key
+		return nil
+	})
+}
+
+// DeleteLabel will remove the given label.  No error is returned if the label
+// does not exist.
+func (s *CAStore) DeleteLabel(label string) error {
+	return s.UpdateLabels(func(labels map[string]string) error {
+		delete(labels, label)
+		return nil
+	})
+}
+
+// Label will return the key that the given label is attached to, or an empty
+// string if the label does not exist.
+func (s *CAStore) Label(label string) (string, error) {
+	labels, err := s.Labels()
+	if err != nil {
+		return "", err
+	}
+	return labels[label], nil
+}
+
+// Labels will return all labels in the store, as a map from label to key.
+func (s *CAStore) Labels() (map[string]string, error) {
+	s.labelsMu.Lock()
+	defer s.labelsMu.Unlock()
+	return s.readLabels()
+}
+
+// LabelsFor will return the labels attached to the given key, in sorted order.
+func (s *CAStore) LabelsFor(key string) ([]string, error) {
+	labels, err := s.Labels()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for l, k := range labels {
+		if k == key {
+			ret = append(ret, l)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// readLabels loads the labels file.  It must be called with labelsMu held.
+func (s *CAStore) readLabels() (map[string]string, error) {
+	labels := make(map[string]string)
+
+	p, err := s.metaPath(labelsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return labels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// writeLabels atomically replaces the labels file.  It must be called with
+// labelsMu held.
+func (s *CAStore) writeLabels(labels map[string]string) error {
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	p, err := s.metaPath(labelsFile)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data)
+}
+
+// writeFileAtomic is a helper function that will write the given data to a
+// temporary file in the same directory as the destination, sync it, and then
+// rename it over the destination.
+func writeFileAtomic(dest string, data []byte) error {
+	tfile, err := ioutil.TempFile(filepath.Dir(dest), ".tmp-"+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+
+	_, err = tfile.Write(data)
+	if err == nil {
+		err = tfile.Sync()
+	}
+	if cerr := tfile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tfile.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(tfile.Name())
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package castore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabels(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-labels"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SetLabel("build:1234", key))
+	assert.NoError(t, s.SetLabel("branch:main", key))
+	assert.Equal(t, ErrNotFound, s.SetLabel("bad", "nonexistent"))
+	assert.Equal(t, ErrEmptyLabel, s.SetLabel("", key))
+
+	l, err := s.Label("build:1234")
+	assert.NoError(t, err)
+	assert.Equal(t, key, l)
+
+	l, err = s.Label("nonexistent")
+	assert.NoError(t, err)
+	assert.Equal(t, "", l)
+
+	labels, err := s.LabelsFor(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"branch:main", "build:1234"}, labels)
+
+	// Failed updates save nothing.
+	fail := errors.New("fail")
+	err = s.UpdateLabels(func(labels map[string]string) error {
+		delete(labels, "build:1234")
+		return fail
+	})
+	assert.Equal(t, fail, err)
+
+	// Labels persist across instances.
+	s2, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+	all, err := s2.Labels()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"build:1234": key, "branch:main": key}, all)
+
+	assert.NoError(t, s2.DeleteLabel("build:1234"))
+	labels, err = s.LabelsFor(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"branch:main"}, labels)
+}
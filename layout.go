@@ -0,0 +1,161 @@
+package castore
+
+import (
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// LayoutProblem describes what is wrong with a file found by VerifyLayout.
+type LayoutProblem int
+
+const (
+	// LayoutInvalidName means that the file's name is not a valid key.
+	LayoutInvalidName LayoutProblem = iota
+
+	// LayoutMisplaced means that the file is not in the directory given by
+	// the Transform (or any of the LegacyTransforms) for its key.
+	LayoutMisplaced
+
+	// LayoutCorrupt means that the file's data does not match its key.
+	LayoutCorrupt
+
+	// LayoutNotRegular means that the entry is not a regular file or
+	// directory - for example, a symlink or a device.
+	LayoutNotRegular
+)
+
+func (p LayoutProblem) String() string {
+	switch p {
+	case LayoutInvalidName:
+		return "invalid name"
+	case LayoutMisplaced:
+		return "misplaced"
+	case LayoutCorrupt:
+		return "corrupt"
+	case LayoutNotRegular:
+		return "not a regular file"
+	}
+	return "unknown"
+}
+
+// LayoutViolation is a single problem found by VerifyLayout.
+type LayoutViolation struct {
+	// Path is the path of the offending file, relative to the base path.
+	Path string
+
+	// Problem describes what is wrong with the file.
+	Problem LayoutProblem
+}
+
+// LayoutReport contains the results of a call to VerifyLayout.
+type LayoutReport struct {
+	// Checked is the number of files that were examined.
+	Checked int
+
+	// Violations lists every problem that was found, in the order that the
+	// files were visited.
+	Violations []LayoutViolation
+}
+
+// OK returns whether the report contains no problems.
+func (r *LayoutReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// VerifyLayout will check that the directory at basePath, which is claimed to
+// be a store created with the given options, really is one: every file must
+// be named with a valid key, be in the right directory for that key, and
+// contain data that hashes to it.  Unlike New, it never modifies the
+// directory, so it is safe to use on directories from untrusted sources
+// before opening them as a store.  The BasePath in opts is ignored, and the
+// store's internal state directory is not checked.
+//
+// An error is only returned if the directory cannot be read; problems with its
+// contents are reported as violations.
+func VerifyLayout(basePath string, opts Options) (*LayoutReport, error) {
+	if basePath == "" {
+		return nil, ErrNoBasePath
+	}
+	opts.BasePath = basePath
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.KeyEncoding == nil {
+		opts.KeyEncoding = HexEncoding
+	}
+	if opts.Transform == nil {
+		opts.Transform = FlatTransformFunc
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(discardHandler{})
+	}
+	if opts.CopyBufferSize <= 0 {
+		opts.CopyBufferSize = defaultCopyBufferSize
+	}
+	s := &CAStore{opts: opts, log: opts.Logger}
+
+	report := &LayoutReport{}
+	err := filepath.Walk(basePath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == filepath.Join(basePath, metaDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(basePath, p)
+		if err != nil {
+			return err
+		}
+		if rel == manifestFile {
+			return nil
+		}
+		report.Checked++
+		violation := func(problem LayoutProblem) {
+			s.log.Warn("layout violation", "path", rel, "problem", problem.String())
+			report.Violations = append(report.Violations, LayoutViolation{rel, problem})
+		}
+
+		key := info.Name()
+		switch {
+		case !info.Mode().IsRegular():
+			violation(LayoutNotRegular)
+		case !s.validKey(key):
+			violation(LayoutInvalidName)
+		case !s.placedCorrectly(key, filepath.Dir(p)):
+			violation(LayoutMisplaced)
+		default:
+			ok, err := s.verifyFile(key, p)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				violation(LayoutCorrupt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// placedCorrectly returns whether dir is a directory in which the data for the
+// given key may be stored.
+func (s *CAStore) placedCorrectly(key, dir string) bool {
+	if dir == s.transform(key) {
+		return true
+	}
+	for _, t := range s.legacyTransforms() {
+		if dir == filepath.Join(s.opts.BasePath, filepath.Join(t(s.shardKey(key))...)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyLayout(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-layout"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(1),
+	}
+	s, err := New(opts)
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetRef("latest", other))
+
+	report, err := VerifyLayout(tdir, opts)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2, report.Checked)
+
+	// Break things in every way we can think of.
+	assert.NoError(t, os.Rename(s.blobPath(other), filepath.Join(tdir, other)))
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("tampered"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tdir, "README"), []byte("hi"), 0600))
+	assert.NoError(t, os.Symlink("/etc/passwd", filepath.Join(tdir, "link")))
+
+	report, err = VerifyLayout(tdir, opts)
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 4, report.Checked)
+	assert.ElementsMatch(t, []LayoutViolation{
+		{other, LayoutMisplaced},
+		{filepath.Join(TEST_KEY[:2], TEST_KEY), LayoutCorrupt},
+		{"README", LayoutInvalidName},
+		{"link", LayoutNotRegular},
+	}, report.Violations)
+
+	// The old location is fine if it's a legacy transform.
+	opts.LegacyTransforms = []TransformFunction{FlatTransformFunc}
+	report, err = VerifyLayout(tdir, opts)
+	assert.NoError(t, err)
+	assert.Len(t, report.Violations, 3)
+
+	_, err = VerifyLayout(filepath.Join(tdir, "missing"), opts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+package castore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// ledgerFile is the name of the file in the store's internal state
+	// directory in which the number and total size of the objects in the store
+	// (see Options.Ledger) are saved when the store is closed.
+	ledgerFile = "ledger"
+
+	// ledgerDirtyFile is the name of the file in the store's internal state
+	// directory that exists while the store is using the ledger, so that if it
+	// is not closed cleanly, the ledger is reconciled when it is next opened.
+	ledgerDirtyFile = "ledger.dirty"
+)
+
+// ledgerLock is held in shared mode by each Put and Delete from just before it
+// modifies the store until it has updated the ledger, and in exclusive mode by
+// Reconcile.  Unlike a sync.RWMutex, a waiting Reconcile doesn't stop more Puts
+// and Deletes from starting, since they may be holding other locks that a Put
+// or Delete that is already in progress is waiting for.
+type ledgerLock struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	shared    int
+	exclusive bool
+}
+
+func (l *ledgerLock) lockShared() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.exclusive {
+		l.cond.Wait()
+	}
+	l.shared++
+}
+
+func (l *ledgerLock) unlockShared() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.shared--; l.shared == 0 {
+		l.cond.Broadcast()
+	}
+}
+
+func (l *ledgerLock) lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.exclusive || l.shared > 0 {
+		l.cond.Wait()
+	}
+	l.exclusive = true
+}
+
+func (l *ledgerLock) unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.exclusive = false
+	l.cond.Broadcast()
+}
+
+// openLedger loads the saved ledger, or reconciles it if it may be out of
+// date.
+func (s *CAStore) openLedger() error {
+	s.ledgerMu.cond = sync.NewCond(&s.ledgerMu.mu)
+
+	dirty, err := s.metaPath(ledgerDirtyFile)
+	if err != nil {
+		return err
+	}
+	p, err := s.metaPath(ledgerFile)
+	if err != nil {
+		return err
+	}
+
+	var u Usage
+	data, err := ioutil.ReadFile(p)
+	if err == nil {
+		_, err = fmt.Sscanf(string(data), "%d %d\n", &u.Objects, &u.Bytes)
+	}
+	if _, derr := os.Stat(dirty); err == nil && derr == nil {
+		s.log.Warn("store was not closed cleanly; reconciling ledger")
+		_, err = s.Reconcile()
+	} else if err != nil {
+		_, err = s.Reconcile()
+	} else {
+		atomic.StoreInt64(&s.ledgerObjects, u.Objects)
+		atomic.StoreInt64(&s.ledgerBytes, u.Bytes)
+	}
+	if err != nil {
+		return err
+	}
+	return s.markLedgerDirty()
+}
+
+// markLedgerDirty records that the saved ledger is out of date.
+func (s *CAStore) markLedgerDirty() error {
+	dirty, err := s.metaPath(ledgerDirtyFile)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dirty, nil)
+}
+
+// Reconcile will recount the objects in the store and their total size, and
+// return the result.  If Options.Ledger is set, the ledger is corrected to
+// match, as is the space used against the Quota; this only needs to be done
+// if the BasePath has been modified by something other than this CAStore.
+// Puts, Deletes and transaction commits wait until it has finished, and it
+// waits for any that are in progress.
+func (s *CAStore) Reconcile() (Usage, error) {
+	if !s.opts.Ledger {
+		return s.walkUsage()
+	}
+
+	s.ledgerMu.lock()
+	defer s.ledgerMu.unlock()
+
+	u, err := s.walkUsage()
+	if err != nil {
+		return u, err
+	}
+	objects := atomic.SwapInt64(&s.ledgerObjects, u.Objects)
+	bytes := atomic.SwapInt64(&s.ledgerBytes, u.Bytes)
+	if objects != u.Objects || bytes != u.Bytes {
+		s.log.Info("reconciled ledger", "objects", u.Objects, "bytes", u.Bytes,
+			"was_objects", objects, "was_bytes", bytes)
+	}
+
+	// No Put has reserved space that it hasn't yet used.
+	if s.trackingUsage() {
+		atomic.StoreInt64(&s.used, u.Bytes)
+	}
+	return u, nil
+}
+
+// holdLedger must be called before an object is added to or removed from the
+// store, and the returned function called once the ledger has been updated, so
+// that Reconcile doesn't see the change without it being counted or vice
+// versa.
+func (s *CAStore) holdLedger() func() {
+	if !s.opts.Ledger {
+		return func() {}
+	}
+	s.ledgerMu.lockShared()
+	return s.ledgerMu.unlockShared
+}
+
+// ledgerAdd records that an object of the given size has been added to the
+// store.
+func (s *CAStore) ledgerAdd(size int64) {
+	s.ledgerUpdate(1, size)
+}
+
+// ledgerRemove records that an object of the given size has been removed from
+// the store.
+func (s *CAStore) ledgerRemove(size int64) {
+	s.ledgerUpdate(-1, -size)
+}
+
+func (s *CAStore) ledgerUpdate(objects, bytes int64) {
+	if !s.opts.Ledger {
+		return
+	}
+	atomic.AddInt64(&s.ledgerObjects, objects)
+	atomic.AddInt64(&s.ledgerBytes, bytes)
+
+	if atomic.CompareAndSwapInt32(&s.ledgerSaved, 1, 0) {
+		// The store is being used after Close, so the saved ledger is now out
+		// of date.
+		if err := s.markLedgerDirty(); err != nil {
+			s.log.Warn("could not mark ledger as in use", "err", err)
+		}
+	}
+}
+
+// ledgerUsage returns the usage recorded by the ledger.
+func (s *CAStore) ledgerUsage() Usage {
+	return Usage{
+		Objects: atomic.LoadInt64(&s.ledgerObjects),
+		Bytes:   atomic.LoadInt64(&s.ledgerBytes),
+	}
+}
+
+// closeLedger saves the ledger, and marks it as up to date.
+func (s *CAStore) closeLedger() error {
+	if !s.opts.Ledger {
+		return nil
+	}
+	s.ledgerMu.lock()
+	defer s.ledgerMu.unlock()
+
+	p, err := s.metaPath(ledgerFile)
+	if err != nil {
+		return err
+	}
+	u := s.ledgerUsage()
+	if err = writeFileAtomic(p, []byte(fmt.Sprintf("%d %d\n", u.Objects, u.Bytes))); err != nil {
+		return err
+	}
+	dirty, err := s.metaPath(ledgerDirtyFile)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(dirty); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.ledgerSaved, 1)
+	return nil
+}
@@ -0,0 +1,73 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedger(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-ledger"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Ledger: true, InlineMaxSize: 2}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString("ab")
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(other))
+	assert.NoError(t, s.Delete(other))
+
+	u, err := s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 8}, u)
+	assert.NoError(t, s.Close())
+
+	// The ledger is loaded when the store is reopened, so an object added
+	// behind the store's back isn't counted until it is reconciled.
+	plain, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	_, err = plain.PutString("other")
+	assert.NoError(t, err)
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	u, err = s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 8}, u)
+	u, err = s.Reconcile()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 3, Bytes: 13}, u)
+	u, err = s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 3, Bytes: 13}, u)
+}
+
+func TestLedgerNotClosed(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-ledger"))
+	defer os.RemoveAll(tdir)
+
+	opts := Options{BasePath: tdir, Ledger: true, Quota: 100}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	// Puts made after Close mean the saved ledger must be reconciled.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	s, err = New(opts)
+	assert.NoError(t, err)
+	u, err := s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 1, Bytes: 6}, u)
+	assert.Equal(t, int64(6), s.used)
+}
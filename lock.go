@@ -0,0 +1,50 @@
+package castore
+
+import (
+	"os"
+)
+
+// lockFile is the name of the file in the store's internal state directory
+// that is locked when Options.ProcessLocking is set.
+const lockFile = "lock"
+
+// lockShared takes a shared lock on the store, which is held by operations
+// that add, read or remove individual objects, and returns a function that
+// releases it.  It does nothing unless Options.ProcessLocking is set.
+func (s *CAStore) lockShared() (func(), error) {
+	return s.lock(false)
+}
+
+// lockExclusive is like lockShared, but takes an exclusive lock, which is held
+// by maintenance operations that sweep the whole store, such as Compact and
+// Evict.
+func (s *CAStore) lockExclusive() (func(), error) {
+	return s.lock(true)
+}
+
+func (s *CAStore) lock(exclusive bool) (func(), error) {
+	if !s.opts.ProcessLocking {
+		return func() {}, nil
+	}
+
+	p, err := s.metaPath(lockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each lock uses its own open file, so that locks taken by different
+	// goroutines in this process conflict in the same way as those taken by
+	// other processes.
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		// Closing the file releases the lock.
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package castore
+
+import (
+	"os"
+)
+
+// flock takes an advisory lock on the given file, blocking until it is
+// available.  Advisory locks are not supported on this platform, so it does
+// nothing.
+func flock(f *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessLocking(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-lock"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, ProcessLocking: true})
+	assert.NoError(t, err)
+
+	// Shared locks don't conflict with each other.
+	unlock, err := s.lockShared()
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	unlock()
+
+	// An exclusive lock, as would be held by another process, blocks Puts
+	// until it is released.
+	unlock, err = s.lockExclusive()
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		_, err := s.PutString("other")
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("Put did not wait for the exclusive lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	assert.NoError(t, <-done)
+
+	// Maintenance operations still work.
+	_, err = s.Compact()
+	assert.NoError(t, err)
+	u, err := s.Evict(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), u.Objects)
+}
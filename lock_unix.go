@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock takes an advisory lock on the given file, blocking until it is
+// available.
+func flock(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package castore
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// discardHandler is a slog.Handler that drops all records.  It is used when
+// no Logger is specified, so that the rest of the store can log
+// unconditionally.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// removeTemp is a helper function that will remove a temporary file that is
+// no longer needed, logging the result.
+func (s *CAStore) removeTemp(name string) {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		s.log.Warn("failed to remove temporary file", "path", name, "err", err)
+		return
+	}
+	s.log.Debug("removed temporary file", "path", name)
+}
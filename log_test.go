@@ -0,0 +1,52 @@
+package castore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-log"))
+	defer os.RemoveAll(tdir)
+
+	var buf bytes.Buffer
+	s, err := New(Options{
+		BasePath: tdir,
+		MaxSize:  16,
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "msg=put key="+TEST_KEY+" size=6 dedup=false")
+
+	// A failed Put cleans up its temporary file.
+	buf.Reset()
+	_, err = s.PutString("this is too large for the store")
+	assert.Equal(t, ErrSizeExceeded, err)
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, metaDir, tempDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+	assert.Contains(t, buf.String(), `msg="put failed" err="castore: size exceeded"`)
+
+	// Corruption is reported as a warning.
+	buf.Reset()
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("bad"), 0600))
+	_, err = s.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `level=WARN msg="object is corrupt" key=`+TEST_KEY)
+
+	buf.Reset()
+	assert.NoError(t, s.Delete(TEST_KEY))
+	assert.Contains(t, buf.String(), `level=INFO msg="deleted object" key=`+TEST_KEY)
+}
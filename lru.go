@@ -0,0 +1,76 @@
+package castore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// LeastRecentlyUsed is an EvictScoreFunc that evicts the objects that have
+// gone longest without being retrieved first.  It is the default when
+// Options.CacheSize is set.  Retrievals are only known about if Options.Index
+// and Options.AccessTimes are set; otherwise, this is the same as OldestFirst.
+func LeastRecentlyUsed(obj ObjectInfo) float64 {
+	return obj.Idle.Seconds()
+}
+
+// trackingUsage returns whether the store keeps a running total of its size,
+// for the Quota or CacheSize.
+func (s *CAStore) trackingUsage() bool {
+	return s.opts.Quota > 0 || s.opts.CacheSize > 0
+}
+
+// enforceCacheSize evicts objects if the store is larger than its CacheSize,
+// until it is down to the low watermark.  If another call is already
+// evicting, it returns immediately.
+func (s *CAStore) enforceCacheSize() {
+	if s.opts.CacheSize <= 0 {
+		return
+	}
+	used := atomic.LoadInt64(&s.used)
+	if used <= s.opts.CacheSize || !atomic.CompareAndSwapInt32(&s.cacheEvicting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.cacheEvicting, 0)
+
+	target := s.opts.CacheSize
+	if low := s.opts.CacheLowWater; low > 0 && low < 1 {
+		target = int64(float64(target) * low)
+	}
+	s.log.Debug("store is over its cache size", "used", used, "cache_size", s.opts.CacheSize, "target", target)
+	if _, err := s.Evict(context.Background(), used-target); err != nil {
+		s.log.Warn("could not evict objects to fit the cache size", "err", err)
+	}
+}
+
+// startCacheLoop starts the goroutine that enforces the CacheSize every
+// CacheEvictInterval.
+func (s *CAStore) startCacheLoop() {
+	s.cacheStop = make(chan struct{})
+	s.cacheDone = make(chan struct{})
+	go s.cacheLoop()
+}
+
+// cacheLoop enforces the CacheSize periodically until the store is closed.
+func (s *CAStore) cacheLoop() {
+	defer close(s.cacheDone)
+
+	ticker := time.NewTicker(s.opts.CacheEvictInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.enforceCacheSize()
+		case <-s.cacheStop:
+			return
+		}
+	}
+}
+
+// afterPut enforces the CacheSize after a Put, unless that is done in the
+// background.
+func (s *CAStore) afterPut() {
+	if s.opts.CacheEvictInterval <= 0 {
+		s.enforceCacheSize()
+	}
+}
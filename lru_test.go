@@ -0,0 +1,154 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSize(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-lru"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:    tdir,
+		Index:       true,
+		AccessTimes: true,
+		CacheSize:   12,
+	})
+	assert.NoError(t, err)
+
+	var keys []string
+	for i, val := range []string{"aaaa", "bbbb", "cccc"} {
+		key, err := s.PutString(val)
+		assert.NoError(t, err)
+		old := time.Now().Add(-time.Duration(3-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(s.blobPath(key), old, old))
+		s.indexMu.Lock()
+		s.index[key] = indexEntry{4, old.UnixNano(), old.UnixNano()}
+		s.indexMu.Unlock()
+		keys = append(keys, key)
+	}
+
+	// The oldest object was retrieved recently, so the next is evicted.
+	assert.Equal(t, "aaaa", readKey(t, s, keys[0]))
+	d, err := s.PutString("dddd")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{keys[0], keys[2], d}, filterKeys(t, s, keys[0], keys[1], keys[2], d))
+
+	// Pinned objects are kept.
+	s.pin(keys[2])
+	e, err := s.PutString("eeee")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{keys[2], d, e}, filterKeys(t, s, keys[0], keys[2], d, e))
+}
+
+func TestCacheLowWater(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-lru"))
+	defer os.RemoveAll(tdir)
+
+	var evictions int
+	s, err := New(Options{
+		BasePath:      tdir,
+		CacheSize:     16,
+		CacheLowWater: 0.5,
+		Hooks: Hooks{
+			OnEvict: func(key string, size int64) { evictions++ },
+		},
+	})
+	assert.NoError(t, err)
+
+	// Nothing is evicted until the high watermark is passed, and then enough
+	// is evicted to reach the low one.
+	for _, val := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		_, err = s.PutString(val)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 0, evictions)
+	_, err = s.PutString("eeee")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, evictions)
+	u, err := s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 8}, u)
+
+	_, err = s.PutString("ffff")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, evictions)
+}
+
+func TestCacheEvictInterval(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-lru"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:           tdir,
+		CacheSize:          8,
+		CacheEvictInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	for _, val := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		_, err = s.PutString(val)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		u, err := s.Usage()
+		return err == nil && u.Bytes <= 8
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// filterKeys returns those of the given keys that exist in the store, in the
+// same order.
+func filterKeys(t *testing.T, s *CAStore, keys ...string) []string {
+	var found []string
+	for _, key := range keys {
+		exists, err := s.Exists(key)
+		assert.NoError(t, err)
+		if exists {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+func TestCacheSizeSnapshot(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-lru"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		CacheSize: 100,
+	})
+	assert.NoError(t, err)
+
+	a, err := s.PutString(strings.Repeat("a", 40))
+	assert.NoError(t, err)
+	b, err := s.PutString(strings.Repeat("b", 40))
+	assert.NoError(t, err)
+
+	// Storing the manifest evicts objects, which must not wait for the
+	// snapshot being taken.
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Snapshot("x")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("Snapshot did not return")
+	}
+
+	// The snapshotted objects are protected from eviction.
+	keys, err := s.ListAt("x")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Equal(t, []string{a, b}, filterKeys(t, s, a, b))
+}
@@ -0,0 +1,176 @@
+package castore
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+const (
+	// manifestFile is the name of the file in the BasePath that records the
+	// options a store was created with (see Manifest).  As it isn't a valid
+	// key, it is never mistaken for an object.
+	manifestFile = "castore.json"
+
+	// legacyFormatVersion is the format version of stores that were written
+	// before manifests were introduced.
+	legacyFormatVersion = 1
+)
+
+// formatVersion is the version of the on-disk format written by this package.
+// Stores with an older format must be upgraded with Upgrade before they can
+// be opened.  It is a variable so that tests can add migrations.
+var formatVersion = 1
+
+// ErrIncompatibleOptions is returned by New if the options given are not
+// compatible with those that the store was created with, as recorded in its
+// manifest.  Opening the store anyway would make existing objects
+// unreachable, and the returned error says which option is at fault.
+var ErrIncompatibleOptions = errors.New("castore: options are incompatible with the store")
+
+// Manifest records the options that determine where a store keeps each
+// object, so that New can refuse to open it with different ones.  Since
+// functions can't be compared, the Hash, KeyEncoding and Transform are
+// identified by their behaviour on a probe.  It is kept as castore.json in the
+// BasePath, and written when the store is first opened.
+type Manifest struct {
+	// FormatVersion is the version of the store's on-disk format.
+	FormatVersion int `json:"format_version"`
+
+	// Hash is the hex-encoded digest of the empty object, which identifies
+	// the hash function.
+	Hash string `json:"hash"`
+
+	// Key is the key of the empty object, which identifies the KeyEncoding.
+	Key string `json:"key"`
+
+	// Transform is the directories in which the empty object is stored,
+	// which identifies the Transform.
+	Transform []string `json:"transform"`
+
+	// Created is when the manifest was first written.
+	Created time.Time `json:"created"`
+}
+
+// Manifest returns the store's manifest.
+func (s *CAStore) Manifest() (*Manifest, error) {
+	return readManifestFile(filepath.Join(s.opts.BasePath, manifestFile))
+}
+
+// probeManifest returns the manifest that describes the store's options, using
+// the given transform.
+func (s *CAStore) probeManifest(t TransformFunction) *Manifest {
+	sum := s.opts.Hash().Sum(nil)
+	key := s.opts.KeyEncoding.Encode(sum)
+	dirs := t(s.shardKey(key))
+	if dirs == nil {
+		dirs = []string{}
+	}
+	return &Manifest{
+		FormatVersion: formatVersion,
+		Hash:          hex.EncodeToString(sum),
+		Key:           key,
+		Transform:     dirs,
+	}
+}
+
+// readManifestFile reads the manifest at the given path.
+func readManifestFile(p string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("castore: reading %s: %w", manifestFile, err)
+	}
+	return m, nil
+}
+
+// writeManifest writes the given manifest into the BasePath.
+func (s *CAStore) writeManifest(m *Manifest) error {
+	return writeManifestFile(filepath.Join(s.opts.BasePath, manifestFile), m)
+}
+
+// writeManifestFile writes the given manifest to the given path.
+func writeManifestFile(p string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, append(data, '\n'))
+}
+
+// checkManifest makes sure that the store's options are compatible with its
+// manifest, writing one if it doesn't have one yet.
+func (s *CAStore) checkManifest() error {
+	m, err := s.Manifest()
+	if os.IsNotExist(err) {
+		m = s.probeManifest(s.opts.Transform)
+		m.Created = time.Now().UTC()
+		if existing, err := s.hasContents(); err != nil {
+			return err
+		} else if existing {
+			m.FormatVersion = legacyFormatVersion
+		}
+		if err = s.writeManifest(m); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	want := s.probeManifest(s.opts.Transform)
+	switch {
+	case m.FormatVersion > formatVersion:
+		return fmt.Errorf("castore: store has format version %d, but only version %d is supported", m.FormatVersion, formatVersion)
+	case m.FormatVersion < formatVersion:
+		return fmt.Errorf("%w: store has format version %d, and the current version is %d", ErrUpgradeRequired, m.FormatVersion, formatVersion)
+	case m.Hash != want.Hash:
+		return fmt.Errorf("%w: the Hash differs", ErrIncompatibleOptions)
+	case m.Key != want.Key:
+		return fmt.Errorf("%w: the KeyEncoding differs", ErrIncompatibleOptions)
+	}
+
+	// The store may be in the middle of moving to a new Transform, in which
+	// case its old one must be given as a legacy transform.
+	for _, t := range append([]TransformFunction{s.opts.Transform}, s.opts.LegacyTransforms...) {
+		if reflect.DeepEqual(m.Transform, s.probeManifest(t).Transform) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the Transform differs", ErrIncompatibleOptions)
+}
+
+// hasContents returns whether anything other than the internal state
+// directory is in the BasePath, which means that a store without a manifest
+// was written before manifests were introduced.
+func (s *CAStore) hasContents() (bool, error) {
+	entries, err := ioutil.ReadDir(s.opts.BasePath)
+	if err != nil {
+		return false, err
+	}
+	for _, ent := range entries {
+		if ent.Name() != metaDir {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateManifestTransform records in the manifest that every object is now
+// stored using the current transform.
+func (s *CAStore) updateManifestTransform() error {
+	m, err := s.Manifest()
+	if err != nil {
+		return err
+	}
+	m.Transform = s.probeManifest(s.currentTransform()).Transform
+	return s.writeManifest(m)
+}
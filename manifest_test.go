@@ -0,0 +1,63 @@
+package castore
+
+import (
+	"context"
+	"crypto/sha512"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifest(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-manifest"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	m, err := s.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, m.FormatVersion)
+	assert.Equal(t, []string{}, m.Transform)
+	assert.False(t, m.Created.IsZero())
+
+	incompatible := func(opts Options) {
+		opts.BasePath = tdir
+		_, err := New(opts)
+		assert.True(t, errors.Is(err, ErrIncompatibleOptions), "got %v", err)
+	}
+	incompatible(Options{Hash: sha512.New})
+	incompatible(Options{KeyEncoding: Base32Encoding})
+	incompatible(Options{Transform: DepthTransformFunc(1)})
+
+	// A new Transform can be used alongside the old one, and on its own once
+	// the store has been moved to it.
+	s, err = New(Options{BasePath: tdir, Transform: DepthTransformFunc(1), LegacyTransforms: []TransformFunction{FlatTransformFunc}})
+	assert.NoError(t, err)
+	_, err = s.RewriteLegacy(context.Background(), 0)
+	assert.NoError(t, err)
+	s, err = New(Options{BasePath: tdir, Transform: DepthTransformFunc(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+	incompatible(Options{})
+
+	_, err = s.Relayout(context.Background(), FlatTransformFunc)
+	assert.NoError(t, err)
+	_, err = New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// A store written by a newer version isn't opened.
+	m.FormatVersion = formatVersion + 1
+	assert.NoError(t, s.writeManifest(m))
+	_, err = New(Options{BasePath: tdir})
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tdir, manifestFile), []byte("junk"), 0600))
+	_, err = New(Options{BasePath: tdir})
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package castore
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// metadataDir is the name of the directory in the store's internal state
+// directory in which per-object metadata is persisted.
+const metadataDir = "metadata"
+
+// PutWithMetadata will insert the data from the given io.Reader into the store,
+// as with Put, and then attach the given metadata to it.  If the object
+// already has metadata, the new values are merged into it, replacing any
+// existing values with the same names.  Metadata is intended for small
+// attributes, such as an original filename or upload time.
+func (s *CAStore) PutWithMetadata(r io.Reader, metadata map[string]string) (string, error) {
+	key, err := s.Put(r)
+	if err != nil {
+		return "", err
+	}
+	if len(metadata) == 0 {
+		return key, nil
+	}
+	if err = s.SetMetadata(key, metadata); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// SetMetadata will merge the given metadata into that of the given key, which
+// must exist in the store.  A value of "" removes the corresponding name.
+func (s *CAStore) SetMetadata(key string, metadata map[string]string) error {
+	exists, err := s.Exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+
+	current, err := s.readMetadata(key)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = make(map[string]string, len(metadata))
+	}
+	for name, val := range metadata {
+		if val == "" {
+			delete(current, name)
+		} else {
+			current[name] = val
+		}
+	}
+
+	p, err := s.metadataPath(key)
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		if err = os.Remove(p); os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data)
+}
+
+// GetMetadata will return the metadata attached to the given key, or nil if
+// it has none or the key does not exist.
+func (s *CAStore) GetMetadata(key string) (map[string]string, error) {
+	if !s.validKey(key) {
+		return nil, nil
+	}
+
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+	return s.readMetadata(key)
+}
+
+// metadataPath returns the on-disk path of the metadata for the given key.
+func (s *CAStore) metadataPath(key string) (string, error) {
+	dir, err := s.metaPath(metadataDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, s.shardKey(key)[:2], key+".json"), nil
+}
+
+// readMetadata loads the metadata for the given key.  It must be called with
+// metadataMu held.
+func (s *CAStore) readMetadata(key string) (map[string]string, error) {
+	p, err := s.metadataPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ret map[string]string
+	if err = json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// removeMetadata removes the metadata for the given key, if any.
+func (s *CAStore) removeMetadata(key string) error {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+
+	p, err := s.metadataPath(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(p))
+	return nil
+}
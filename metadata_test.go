@@ -0,0 +1,53 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadata(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-metadata"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	key, err := s.PutWithMetadata(strings.NewReader(TEST_VALUE), map[string]string{
+		"filename": "foo.txt",
+		"uploaded": "2020-01-01T00:00:00Z",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	// Metadata is merged.
+	_, err = s.PutWithMetadata(strings.NewReader(TEST_VALUE), map[string]string{
+		"filename": "bar.txt",
+		"owner":    "alice",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetMetadata(key, map[string]string{"uploaded": ""}))
+
+	md, err := s.GetMetadata(key)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"filename": "bar.txt", "owner": "alice"}, md)
+
+	// Metadata isn't visible as data.
+	n := 0
+	assert.NoError(t, s.Walk(func(string, int64) error { n++; return nil }))
+	assert.Equal(t, 1, n)
+
+	// ... and is removed along with it.
+	assert.NoError(t, s.Delete(key))
+	md, err = s.GetMetadata(key)
+	assert.NoError(t, err)
+	assert.Nil(t, md)
+
+	assert.Equal(t, ErrNotFound, s.SetMetadata(key, map[string]string{"a": "b"}))
+	md, err = s.GetMetadata("invalid")
+	assert.NoError(t, err)
+	assert.Nil(t, md)
+}
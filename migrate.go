@@ -0,0 +1,141 @@
+package castore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// relayoutFile is the name of the file in the store's internal state directory
+// that exists while a Relayout is in progress.
+const relayoutFile = "relayout"
+
+// RewriteLegacy will walk the store and move every piece of data that is not
+// stored at the location given by the current Transform into that location.
+// It is intended to be run in the background after changing the Transform of
+// an existing store (with the old one given in Options.LegacyTransforms) -
+// reads continue to work throughout, and once this function returns without
+// error, LegacyTransforms is no longer necessary.
+//
+// To avoid competing with other I/O, RewriteLegacy will sleep for the given
+// delay between each moved object.  It returns the number of objects moved,
+// and will stop early if the context is cancelled.
+func (s *CAStore) RewriteLegacy(ctx context.Context, delay time.Duration) (int, error) {
+	unlock, err := s.lockExclusive()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	moved := 0
+	err = s.walkFiles(func(key, p string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dest := s.blobPath(key)
+		if p == dest {
+			return nil
+		}
+
+		// If the data already exists in the new location (e.g. because it was
+		// re-inserted after the Transform changed), we can just remove the old
+		// copy.
+		if _, err := os.Stat(dest); err == nil {
+			if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			s.removeEmptyDirs(filepath.Dir(p))
+			return nil
+		}
+
+		if err := s.moveInto(p, dest); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		s.removeEmptyDirs(filepath.Dir(p))
+		moved++
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return moved, err
+	}
+	return moved, s.updateManifestTransform()
+}
+
+// Relayout will move every piece of data in the store into the location given
+// by newTransform, and switch the store to using it.  Reads and writes
+// continue to work throughout, and each object is moved atomically.  Once
+// Relayout returns without error, the store must be opened with its
+// Transform set to newTransform in future, as recorded in its Manifest.  It
+// returns the number of objects moved, and will stop early if the context is
+// cancelled.
+//
+// If Relayout is interrupted, objects that were already moved cannot be found
+// by a store opened with the old Transform (and New will log a warning).  To
+// resume, call Relayout again with the same newTransform; it is safe to do so
+// any number of times.  Alternatively, open the store with Transform set to
+// newTransform and the old Transform in LegacyTransforms, and call
+// RewriteLegacy.
+func (s *CAStore) Relayout(ctx context.Context, newTransform TransformFunction) (int, error) {
+	s.relayoutMu.Lock()
+	defer s.relayoutMu.Unlock()
+
+	marker, err := s.metaPath(relayoutFile)
+	if err != nil {
+		return 0, err
+	}
+	if err = writeFileAtomic(marker, []byte(time.Now().UTC().Format(time.RFC3339)+"\n")); err != nil {
+		return 0, err
+	}
+
+	// From now on, new data is written using the new transform, and the old one
+	// is treated as legacy.
+	s.relayout.Store(newTransform)
+
+	moved, err := s.RewriteLegacy(ctx, 0)
+	if err != nil {
+		return moved, err
+	}
+	if err = os.Remove(marker); err != nil {
+		return moved, err
+	}
+	s.log.Info("relayout complete", "moved", moved)
+	return moved, nil
+}
+
+// relayoutInterrupted returns whether a Relayout was started but did not
+// complete.
+func (s *CAStore) relayoutInterrupted() bool {
+	_, err := os.Stat(filepath.Join(s.opts.BasePath, metaDir, relayoutFile))
+	return err == nil
+}
+
+// currentTransform returns the TransformFunction that determines where new
+// data is written.
+func (s *CAStore) currentTransform() TransformFunction {
+	if t, ok := s.relayout.Load().(TransformFunction); ok {
+		return t
+	}
+	return s.opts.Transform
+}
+
+// legacyTransforms returns the TransformFunctions that should be tried when
+// data cannot be found at the location given by currentTransform.
+func (s *CAStore) legacyTransforms() []TransformFunction {
+	if _, ok := s.relayout.Load().(TransformFunction); ok {
+		return append([]TransformFunction{s.opts.Transform}, s.opts.LegacyTransforms...)
+	}
+	return s.opts.LegacyTransforms
+}
@@ -0,0 +1,107 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyTransforms(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-migrate"))
+	defer os.RemoveAll(tdir)
+
+	// Write some data with the old layout.
+	old, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+	_, err = old.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Open with a new layout, and ensure that reads still work.
+	s, err := New(Options{
+		BasePath:         tdir,
+		Transform:        DepthTransformFunc(2),
+		LegacyTransforms: []TransformFunction{FlatTransformFunc},
+	})
+	assert.NoError(t, err)
+
+	size, err := s.Size(TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	// Rewrite the data into the new layout.
+	moved, err := s.RewriteLegacy(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	_, err = os.Stat(filepath.Join(tdir, TEST_KEY))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tdir, TEST_KEY[0:2], TEST_KEY[2:4], TEST_KEY))
+	assert.NoError(t, err)
+
+	// Nothing left to do on a second run.
+	moved, err = s.RewriteLegacy(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
+
+func TestRelayout(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-migrate"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+	})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	// An interrupted relayout is noticed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Relayout(ctx, DepthTransformFunc(2))
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, s.relayoutInterrupted())
+
+	// Running it again finishes the job.
+	moved, err := s.Relayout(context.Background(), DepthTransformFunc(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.False(t, s.relayoutInterrupted())
+
+	_, err = os.Stat(filepath.Join(tdir, TEST_KEY[0:2], TEST_KEY[2:4], TEST_KEY))
+	assert.NoError(t, err)
+	exists, err := s.Exists(other)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// New data uses the new layout.
+	key, err := s.PutString("new")
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(tdir, key[0:2], key[2:4], key))
+	assert.NoError(t, err)
+
+	// As does a store opened with it.
+	s2, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+	size, err := s2.Size(other)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("other")), size)
+}
@@ -0,0 +1,99 @@
+package castore
+
+import (
+	"io/ioutil"
+	"time"
+)
+
+// Mapped is the data of an object returned by GetMapped.  It must be closed
+// once the data is no longer needed, after which the slice returned by Bytes
+// must not be used.
+type Mapped struct {
+	data  []byte
+	unmap func() error
+}
+
+// Bytes returns the object's data, which must not be modified.
+func (m *Mapped) Bytes() []byte {
+	return m.data
+}
+
+// Close releases the object's data.
+func (m *Mapped) Close() error {
+	var err error
+	if m.unmap != nil {
+		err = m.unmap()
+		m.unmap = nil
+	}
+	m.data = nil
+	return err
+}
+
+// GetMapped will return the data stored with the given key as a byte slice,
+// or nil if the key does not exist in the store.  Where the platform allows,
+// an object stored in a file of its own is memory-mapped rather than read,
+// which avoids copying it for applications that work with byte slices; other
+// objects, and stores with a read rate limit, are read into memory instead.
+// A mapped object that is verified on read and turns out to be corrupt is
+// read again with Get, so that the CorruptReads policy applies.
+func (s *CAStore) GetMapped(key string) (*Mapped, error) {
+	if m, err := s.mapObject(key); m != nil || err != nil {
+		return m, err
+	}
+
+	r, err := s.Get(key)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Mapped{data: data}, nil
+}
+
+// mapObject memory-maps the object with the given key, or returns nil if it
+// has to be read with Get instead.
+func (s *CAStore) mapObject(key string) (*Mapped, error) {
+	if len(limiters(s.readLimiter, s.opts.OpReadRate)) != 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	unlock, err := s.lockShared()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	p, info, err := s.locate(key)
+	if err != nil || info.Size() == 0 {
+		return nil, nil
+	}
+	f, err := s.openFile(p)
+	if err != nil {
+		return nil, nil
+	}
+
+	// The mapping stays valid once the file is closed.
+	data, unmap, err := mapFile(f.File, info.Size())
+	f.Close()
+	if err != nil {
+		return nil, nil
+	}
+	if s.opts.VerifyOnRead {
+		hasher := s.opts.Hash()
+		hasher.Write(data)
+		if s.opts.KeyEncoding.Encode(hasher.Sum(nil)) != key {
+			unmap()
+			return nil, nil
+		}
+	}
+
+	s.recordHit(key)
+	s.indexAccess(key)
+	s.stats.gets.add(1)
+	s.observe(Operation{Op: OpGet, Key: key, Size: info.Size(), Duration: time.Since(start)})
+	return &Mapped{data: data, unmap: unmap}, nil
+}
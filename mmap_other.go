@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package castore
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile always fails, as memory-mapping files isn't supported on this
+// platform, so GetMapped reads objects into memory instead.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return nil, nil, errors.New("castore: memory-mapping is not supported")
+}
@@ -0,0 +1,40 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMapped(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-mmap"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, VerifyOnRead: true})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	m, err := s.GetMapped(key)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(m.Bytes()))
+	if runtime.GOOS != "windows" && runtime.GOOS != "plan9" {
+		assert.NotNil(t, m.unmap)
+	}
+	assert.NoError(t, m.Close())
+	assert.Nil(t, m.Bytes())
+
+	m, err = s.GetMapped(strings.Repeat("0", 64))
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	// Corrupt objects are read with Get, which fails.
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(key), []byte("corrupted data"), 0644))
+	m, err = s.GetMapped(key)
+	assert.Equal(t, ErrCorrupt, err)
+	assert.Nil(t, m)
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile memory-maps the first size bytes of the given file for reading, and
+// returns the mapping along with a function that removes it.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	if int64(int(size)) != size {
+		return nil, nil, errors.New("castore: file is too large to map")
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,66 @@
+package castore
+
+import (
+	"time"
+)
+
+// Op identifies a type of operation performed on a CAStore.
+type Op string
+
+// The operations reported to Options.Observer.
+const (
+	OpPut    Op = "put"
+	OpGet    Op = "get"
+	OpDelete Op = "delete"
+
+	// OpEvict is reported for each object removed by the store of its own
+	// accord, such as by Evict or when a snapshot is pruned.
+	OpEvict Op = "evict"
+
+	// OpUndelete is reported when Undelete restores the data for a key.
+	OpUndelete Op = "undelete"
+)
+
+// Operation describes a single operation performed on a CAStore, as reported
+// to Options.Observer.
+type Operation struct {
+	// Op is the type of operation.
+	Op Op
+
+	// Key is the key that was operated on.  It is empty for a failed Put.
+	Key string
+
+	// Size is the size of the data that was stored, retrieved, deleted or
+	// restored, or a negative value if the key did not exist or the operation
+	// failed.
+	Size int64
+
+	// Dedup is set for a Put whose data was already present in the store.
+	Dedup bool
+
+	// Duration is how long the operation took.  For a Get, this does not
+	// include the time taken to read the data.
+	Duration time.Duration
+
+	// Err is the error returned by the operation, if any.
+	Err error
+}
+
+// observe is a helper function that reports an operation to the Observer, if
+// one is set.
+func (s *CAStore) observe(op Operation) {
+	if s.opts.Observer != nil {
+		s.opts.Observer(op)
+	}
+}
+
+// Observers returns an observer that reports each operation to all of the
+// given observers, in order.  This allows multiple observers to be set as a
+// store's Options.Observer.
+func Observers(fns ...func(Operation)) func(Operation) {
+	return func(op Operation) {
+		for _, fn := range fns {
+			fn(op)
+		}
+	}
+}
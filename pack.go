@@ -0,0 +1,613 @@
+package castore
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// packsDir is the name of the directory in the store's internal state
+	// directory that holds pack files.  Each pack has a data file, named with
+	// packExt, and an index listing the key, offset and size of each object
+	// in it, named with indexExt.  A pack without an index is incomplete.
+	packsDir = "packs"
+	packExt  = ".pack"
+	indexExt = ".idx"
+
+	// packTombstonesFile is the name of the file in packsDir that lists the
+	// objects that have been deleted from packs, one "<pack> <key>" per line.
+	packTombstonesFile = "deleted"
+
+	// defaultPackMaxSize is the default Options.PackMaxSize.
+	defaultPackMaxSize = 4 * 1024
+)
+
+// packEntry is the location of an object within a pack.
+type packEntry struct {
+	pack   string
+	offset int64
+	size   int64
+}
+
+// packTombstone records that an object has been deleted from a pack.
+type packTombstone struct {
+	pack string
+	key  string
+}
+
+// packDir returns the path of packsDir, creating it if necessary.
+func (s *CAStore) packDir() (string, error) {
+	dir, err := s.metaPath(packsDir)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// loadPacks reads the indexes of all complete packs, and the list of objects
+// deleted from them.
+func (s *CAStore) loadPacks() error {
+	dir := filepath.Join(s.opts.BasePath, metaDir, packsDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	packed := make(map[string]packEntry)
+	for _, ent := range entries {
+		name := ent.Name()
+		if !strings.HasSuffix(name, indexExt) {
+			continue
+		}
+		pack := strings.TrimSuffix(name, indexExt)
+		if err = readPackIndex(filepath.Join(dir, name), pack, packed); err != nil {
+			return err
+		}
+		if isInlinePack(pack) {
+			s.inlineName = pack
+		}
+	}
+
+	dead, err := readTombstones(filepath.Join(dir, packTombstonesFile))
+	if err != nil {
+		return err
+	}
+	var live []packTombstone
+	for _, t := range dead {
+		if ent, ok := packed[t.key]; ok && ent.pack == t.pack {
+			delete(packed, t.key)
+			live = append(live, t)
+		}
+	}
+
+	s.packMu.Lock()
+	s.packed = packed
+	s.packDead = live
+	s.packMu.Unlock()
+	return nil
+}
+
+// readPackIndex adds the entries of the given pack's index to packed.
+func readPackIndex(p, pack string, packed map[string]packEntry) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		offset, oerr := strconv.ParseInt(fields[1], 10, 64)
+		size, serr := strconv.ParseInt(fields[2], 10, 64)
+		if oerr != nil || serr != nil {
+			// Probably the end of an append to the inline pack that was
+			// interrupted.
+			continue
+		}
+		packed[fields[0]] = packEntry{pack, offset, size}
+	}
+	return scanner.Err()
+}
+
+// readTombstones reads the list of objects deleted from packs.
+func readTombstones(p string) ([]packTombstone, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dead []packTombstone
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 {
+			dead = append(dead, packTombstone{fields[0], fields[1]})
+		}
+	}
+	return dead, scanner.Err()
+}
+
+// packedEntry returns the location of the given key within a pack, if it has
+// been packed.
+func (s *CAStore) packedEntry(key string) (packEntry, bool) {
+	s.packMu.RLock()
+	defer s.packMu.RUnlock()
+	ent, ok := s.packed[key]
+	return ent, ok
+}
+
+// isPacked returns whether the given key is stored in a pack.
+func (s *CAStore) isPacked(key string) bool {
+	_, ok := s.packedEntry(key)
+	return ok
+}
+
+// packReader reads a single object from a pack.
+type packReader struct {
+	*io.SectionReader
+	f *limitedFile
+}
+
+func (r *packReader) Close() error {
+	return r.f.Close()
+}
+
+// WriteTo copies the rest of the object to w.  The pack file is read directly
+// rather than through the section reader, so that copying to a socket or to
+// another file can use sendfile, splice or copy_file_range where available.
+func (r *packReader) WriteTo(w io.Writer) (int64, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	_, off, size := r.Outer()
+	if pos >= size {
+		return 0, nil
+	}
+	if _, err = r.f.Seek(off+pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, io.LimitReader(r.f.File, size-pos))
+	if _, serr := r.Seek(n, io.SeekCurrent); err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// openPacked opens the given key's data within its pack.  If the key has not
+// been packed, the returned error satisfies os.IsNotExist.
+func (s *CAStore) openPacked(key string) (*packReader, int64, error) {
+	// The pack may be replaced by Repack between looking the key up and
+	// opening it, in which case the key will be in the new pack.
+	for attempt := 0; ; attempt++ {
+		ent, ok := s.packedEntry(key)
+		if !ok {
+			return nil, -1, os.ErrNotExist
+		}
+		f, err := s.openFile(filepath.Join(s.opts.BasePath, metaDir, packsDir, ent.pack+packExt))
+		if os.IsNotExist(err) && attempt < 1 {
+			continue
+		}
+		if err != nil {
+			return nil, -1, err
+		}
+		return &packReader{io.NewSectionReader(f, ent.offset, ent.size), f}, ent.size, nil
+	}
+}
+
+// packedObjects returns the keys and sizes of every packed object.
+func (s *CAStore) packedObjects() map[string]int64 {
+	s.packMu.RLock()
+	defer s.packMu.RUnlock()
+
+	objects := make(map[string]int64, len(s.packed))
+	for key, ent := range s.packed {
+		objects[key] = ent.size
+	}
+	return objects
+}
+
+// deletePacked is the implementation of delete for a packed object.
+func (s *CAStore) deletePacked(key string, ent packEntry) (int64, error) {
+	if s.opts.WriteOnce {
+		s.log.Warn("refusing to delete object from write-once store", "key", key)
+		return -1, ErrWriteOnce
+	}
+
+	defer s.holdLedger()()
+	s.repackMu.RLock()
+	defer s.repackMu.RUnlock()
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	protected, err := s.protectedKeys()
+	if err != nil {
+		return -1, err
+	}
+	if protected[key] {
+		if err = s.unpackToAttic(key); err != nil {
+			return -1, err
+		}
+	}
+
+	dir, err := s.packDir()
+	if err != nil {
+		return -1, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, packTombstonesFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return -1, err
+	}
+	_, err = fmt.Fprintf(f, "%s %s\n", ent.pack, key)
+	if err == nil && s.syncData() {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	s.packMu.Lock()
+	removed := s.packed[key] == ent
+	if removed {
+		delete(s.packed, key)
+		s.packDead = append(s.packDead, packTombstone{ent.pack, key})
+	}
+	s.packMu.Unlock()
+	if removed {
+		s.ledgerRemove(ent.size)
+	}
+
+	if !protected[key] {
+		if err = s.removeMetadata(key); err != nil {
+			return -1, err
+		}
+	}
+	s.releaseQuota(ent.size)
+	s.forgetHits(key)
+	s.forgetCached(key)
+
+	s.log.Info("deleted object", "key", key, "size", ent.size, "snapshotted", protected[key], "packed", true)
+	s.stats.deletes.add(1)
+	s.opts.Hooks.onDelete(key, ent.size)
+	return ent.size, nil
+}
+
+// unpackToAttic copies a packed object's data into the attic, so that it can
+// be undeleted.
+func (s *CAStore) unpackToAttic(key string) error {
+	r, _, err := s.openPacked(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	dest, err := s.atticPath(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dest, data)
+}
+
+// Repack will move every object no larger than Options.PackMaxSize into a
+// single new pack file, along with the contents of the inline pack (see
+// Options.InlineMaxSize) and of any existing packs that objects have been
+// deleted from, so that large numbers of small objects don't each use up an
+// inode and the slack at the end of a filesystem block.  It returns the
+// number and total size of the objects that were newly packed.  If an
+// object's data does not match its key, Repack fails with ErrCorrupt and
+// leaves the store unchanged.  It is intended to be run periodically for
+// maintenance, and will stop early if the context is cancelled.
+//
+// Packed objects are handled transparently by Get, Size, Exists, Walk, Delete,
+// Verify, FS and HTTPFileSystem, but are never evicted.
+func (s *CAStore) Repack(ctx context.Context) (Usage, error) {
+	var packed Usage
+
+	unlock, err := s.lockExclusive()
+	if err != nil {
+		return packed, err
+	}
+	defer unlock()
+	s.repackMu.Lock()
+	defer s.repackMu.Unlock()
+
+	dir, err := s.packDir()
+	if err != nil {
+		return packed, err
+	}
+	if err = s.removeIncompletePacks(dir); err != nil {
+		return packed, err
+	}
+
+	// Packs with deleted entries are rewritten with just their live ones.
+	s.packMu.RLock()
+	rewrite := make(map[string]bool)
+	for _, t := range s.packDead {
+		rewrite[t.pack] = true
+	}
+	for _, ent := range s.packed {
+		if isInlinePack(ent.pack) {
+			rewrite[ent.pack] = true
+		}
+	}
+	s.inlineMu.Lock()
+	if s.inlineName != "" {
+		rewrite[s.inlineName] = true
+	}
+	s.inlineMu.Unlock()
+	var carried []string
+	for key, ent := range s.packed {
+		if rewrite[ent.pack] {
+			carried = append(carried, key)
+		}
+	}
+	s.packMu.RUnlock()
+
+	type looseObject struct {
+		key  string
+		path string
+		size int64
+	}
+	var loose, redundant []looseObject
+	err = s.walkFiles(func(key, p string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		obj := looseObject{key, p, info.Size()}
+		if s.isPacked(key) {
+			redundant = append(redundant, obj)
+		} else if info.Size() <= s.opts.PackMaxSize {
+			loose = append(loose, obj)
+		}
+		return nil
+	})
+	if err != nil {
+		return packed, err
+	}
+
+	pack := ""
+	var index []packIndexEntry
+	if len(loose) > 0 || len(carried) > 0 {
+		w, err := newPackWriter(s, dir)
+		if err != nil {
+			return packed, err
+		}
+		for _, obj := range loose {
+			err = w.addFile(obj.key, obj.path)
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				w.abort()
+				return packed, err
+			}
+		}
+		for _, key := range carried {
+			r, _, err := s.openPacked(key)
+			if err == nil {
+				err = w.add(key, r)
+				r.Close()
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				w.abort()
+				return packed, err
+			}
+		}
+		if pack, err = w.finish(); err != nil {
+			return packed, err
+		}
+		index = w.index
+	}
+
+	// The new pack is complete, so swap it in.
+	s.packMu.Lock()
+	if s.packed == nil {
+		s.packed = make(map[string]packEntry)
+	}
+	for _, e := range index {
+		s.packed[e.key] = packEntry{pack, e.offset, e.size}
+	}
+	s.packMu.Unlock()
+
+	// Remove the indexes of the old packs before forgetting which of their
+	// entries were deleted, so that the deleted entries can't reappear.
+	if err = s.closeInline(); err != nil {
+		return packed, err
+	}
+	for old := range rewrite {
+		if err = os.Remove(filepath.Join(dir, old+indexExt)); err != nil && !os.IsNotExist(err) {
+			return packed, err
+		}
+		if err = os.Remove(filepath.Join(dir, old+packExt)); err != nil && !os.IsNotExist(err) {
+			return packed, err
+		}
+		s.log.Debug("removed rewritten pack", "pack", old)
+	}
+	if len(rewrite) > 0 {
+		s.packMu.Lock()
+		var dead []packTombstone
+		var data []byte
+		for _, t := range s.packDead {
+			if !rewrite[t.pack] {
+				dead = append(dead, t)
+				data = append(data, t.pack+" "+t.key+"\n"...)
+			}
+		}
+		s.packDead = dead
+		err = writeFileAtomic(filepath.Join(dir, packTombstonesFile), data)
+		s.packMu.Unlock()
+		if err != nil {
+			return packed, err
+		}
+	}
+
+	// Finally, remove the loose copies of everything that is now packed.
+	for _, obj := range append(loose, redundant...) {
+		if err = os.Remove(obj.path); err != nil && !os.IsNotExist(err) {
+			return packed, err
+		}
+		s.removeEmptyDirs(filepath.Dir(obj.path))
+		s.indexRemove(obj.key)
+	}
+	packed.Objects = int64(len(loose))
+	for _, obj := range loose {
+		packed.Bytes += obj.size
+	}
+	if pack != "" {
+		s.log.Info("repacked objects", "pack", pack, "packed", packed.Objects, "rewritten", len(rewrite))
+	}
+	return packed, nil
+}
+
+// removeIncompletePacks removes pack files without an index, and temporary
+// files, left behind by an interrupted Repack.  It must be called with
+// repackMu held.
+func (s *CAStore) removeIncompletePacks(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	indexed := make(map[string]bool)
+	for _, ent := range entries {
+		if name := ent.Name(); strings.HasSuffix(name, indexExt) {
+			indexed[strings.TrimSuffix(name, indexExt)] = true
+		}
+	}
+	for _, ent := range entries {
+		name := ent.Name()
+		incomplete := strings.HasSuffix(name, packExt) && !indexed[strings.TrimSuffix(name, packExt)]
+		if !incomplete && !strings.HasPrefix(name, ".tmp-") {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.log.Debug("removed incomplete pack file", "name", name)
+	}
+	return nil
+}
+
+// packWriter writes a new pack.
+type packWriter struct {
+	s      *CAStore
+	dir    string
+	f      *os.File
+	offset int64
+	index  []packIndexEntry
+}
+
+// packIndexEntry is an object that has been written to a new pack.
+type packIndexEntry struct {
+	key    string
+	offset int64
+	size   int64
+}
+
+func newPackWriter(s *CAStore, dir string) (*packWriter, error) {
+	f, err := ioutil.TempFile(dir, ".tmp-pack-")
+	if err != nil {
+		return nil, err
+	}
+	return &packWriter{s: s, dir: dir, f: f}, nil
+}
+
+// addFile adds the object at the given path to the pack.
+func (w *packWriter) addFile(key, p string) error {
+	f, err := w.s.openFile(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return w.add(key, f)
+}
+
+// add adds an object to the pack, checking that its data matches its key.
+func (w *packWriter) add(key string, r io.Reader) error {
+	hasher := w.s.opts.Hash()
+	n, err := w.s.copyBuffer(io.MultiWriter(w.f, hasher), r)
+	if err != nil {
+		return err
+	}
+	if w.s.opts.KeyEncoding.Encode(hasher.Sum(nil)) != key {
+		return fmt.Errorf("%s: %s", ErrCorrupt, key)
+	}
+	w.index = append(w.index, packIndexEntry{key, w.offset, n})
+	w.offset += n
+	return nil
+}
+
+// finish makes the pack durable and gives it its final name, which it
+// returns.
+func (w *packWriter) finish() (string, error) {
+	var id [8]byte
+	_, err := rand.Read(id[:])
+	if err == nil {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	pack := "pack-" + hex.EncodeToString(id[:])
+	if err == nil {
+		err = os.Rename(w.f.Name(), filepath.Join(w.dir, pack+packExt))
+	}
+	if err != nil {
+		os.Remove(w.f.Name())
+		return "", err
+	}
+
+	// The index is written last, since it marks the pack as complete.
+	var index []byte
+	for _, e := range w.index {
+		index = append(index, fmt.Sprintf("%s %d %d\n", e.key, e.offset, e.size)...)
+	}
+	if err = writeFileAtomic(filepath.Join(w.dir, pack+indexExt), index); err != nil {
+		os.Remove(filepath.Join(w.dir, pack+packExt))
+		return "", err
+	}
+	if err = syncPath(w.dir); err != nil {
+		return "", err
+	}
+	return pack, nil
+}
+
+// abort discards the pack.
+func (w *packWriter) abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
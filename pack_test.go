@@ -0,0 +1,168 @@
+package castore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepack(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-pack"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, PackMaxSize: 8})
+	assert.NoError(t, err)
+
+	small, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	large, err := s.PutString("too large to pack")
+	assert.NoError(t, err)
+
+	u, err := s.Repack(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 2, Bytes: 11}, u)
+	_, err = os.Stat(s.blobPath(small))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(s.blobPath(large))
+	assert.NoError(t, err)
+
+	// Packed objects are still readable, even after reopening the store.
+	s, err = New(Options{BasePath: tdir, PackMaxSize: 8})
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, small))
+	size, err := s.Size(other)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	keys := keysOf(t, s)
+	expected := []string{small, other, large}
+	sort.Strings(expected)
+	assert.Equal(t, expected, keys)
+	report, err := s.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.True(t, report.OK())
+
+	// Putting packed data again doesn't create a loose copy.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = os.Stat(s.blobPath(small))
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleted objects stay deleted, and their space is reclaimed.
+	assert.NoError(t, s.Delete(small))
+	exists, err := s.Exists(small)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	s, err = New(Options{BasePath: tdir, PackMaxSize: 8})
+	assert.NoError(t, err)
+	exists, err = s.Exists(small)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	u, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{}, u)
+	packs, err := filepath.Glob(filepath.Join(tdir, metaDir, packsDir, "*"+packExt))
+	assert.NoError(t, err)
+	assert.Len(t, packs, 1)
+	data, err := ioutil.ReadFile(packs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(data))
+	assert.Equal(t, "other", readKey(t, s, other))
+}
+
+func TestDeleteRepacked(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-pack"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, Quota: 1024})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// The object is packed between Delete finding it and removing it.
+	p, info, err := s.locate(key)
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	used := atomic.LoadInt64(&s.used)
+	_, removed, err := s.remove(key, p, info)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	assert.Equal(t, used, atomic.LoadInt64(&s.used))
+
+	assert.NoError(t, s.Delete(key))
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestRepackCorrupt(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-pack"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(key), []byte("corrupt"), 0600))
+
+	_, err = s.Repack(context.Background())
+	assert.True(t, strings.HasPrefix(err.Error(), ErrCorrupt.Error()))
+	_, err = os.Stat(s.blobPath(key))
+	assert.NoError(t, err)
+	packs, err := filepath.Glob(filepath.Join(tdir, metaDir, packsDir, "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, packs)
+}
+
+func TestGetWriteTo(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-writeto"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, PackMaxSize: 16})
+	assert.NoError(t, err)
+	packed, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString("other")
+	assert.NoError(t, err)
+	loose, err := s.PutString("too large to be packed")
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, s.isPacked(packed))
+
+	for _, key := range []string{packed, loose} {
+		r, err := s.Get(key)
+		assert.NoError(t, err)
+		wt, ok := r.(io.WriterTo)
+		assert.True(t, ok)
+
+		// The rest of the object is copied after a partial read.
+		head := make([]byte, 2)
+		_, err = io.ReadFull(r, head)
+		assert.NoError(t, err)
+		f, err := ioutil.TempFile("", "castore-test-writeto")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+		n, err := wt.WriteTo(f)
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+		assert.NoError(t, r.Close())
+
+		data, err := ioutil.ReadFile(f.Name())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+		assert.Equal(t, readKey(t, s, key), string(head)+string(data))
+	}
+}
@@ -0,0 +1,74 @@
+package castore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Owner identifies a user and group by their numeric IDs, as used by
+// Options.Owner.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// mkdirData is a helper function that will create the given directory, and
+// any missing parents beneath the BasePath, with the store's DirMode and
+// Owner.
+func (s *CAStore) mkdirData(dir string) error {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+
+	// Note which directories are about to be created, so that their
+	// permissions can be set exactly rather than being subject to the umask.
+	var created []string
+	base := filepath.Clean(s.opts.BasePath)
+	for d := filepath.Clean(dir); strings.HasPrefix(d, base+string(filepath.Separator)); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+	}
+
+	if err := os.MkdirAll(dir, s.opts.DirMode); err != nil {
+		return err
+	}
+	for _, d := range created {
+		// The directory may already have been removed by a concurrent
+		// Compact; moveInto will try again.
+		if err := s.setPerms(d, s.opts.DirMode); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if s.syncDirs() {
+			if err := syncPath(filepath.Dir(d)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setPerms is a helper function that will set the given mode, and the store's
+// Owner if any, on the file or directory at p.
+func (s *CAStore) setPerms(p string, mode os.FileMode) error {
+	if err := os.Chmod(p, mode); err != nil {
+		return err
+	}
+	if s.opts.Owner != nil {
+		return os.Chown(p, s.opts.Owner.UID, s.opts.Owner.GID)
+	}
+	return nil
+}
+
+// setFilePerms is like setPerms, but for an open file.
+func (s *CAStore) setFilePerms(f *os.File, mode os.FileMode) error {
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	if s.opts.Owner != nil {
+		return f.Chown(s.opts.Owner.UID, s.opts.Owner.GID)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+//go:build !windows && !plan9
+
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermissions(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-perms"))
+	defer os.RemoveAll(tdir)
+
+	// The modes should be applied exactly, regardless of the umask.
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(2),
+		DirMode:   0755,
+		FileMode:  0644,
+		Owner:     &Owner{os.Getuid(), os.Getgid()},
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(tdir, TEST_KEY[0:2]))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(tdir, TEST_KEY[0:2], TEST_KEY[2:4]))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	info, err = os.Stat(s.blobPath(TEST_KEY))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
+
+func TestDefaultPermissions(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-perms"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(1),
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(tdir, TEST_KEY[0:2]))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	info, err = os.Stat(s.blobPath(TEST_KEY))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
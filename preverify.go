@@ -0,0 +1,92 @@
+package castore
+
+import (
+	"os"
+	"time"
+)
+
+// preverifyQueueSize is the maximum number of objects that can be waiting for
+// background re-verification.
+const preverifyQueueSize = 1024
+
+// preverifyItem is a single entry in the re-verification queue.
+type preverifyItem struct {
+	key     string
+	written time.Time
+}
+
+// startPreverify starts the background re-verification goroutine.
+func (s *CAStore) startPreverify() {
+	s.preverifyCh = make(chan preverifyItem, preverifyQueueSize)
+	s.preverifyStop = make(chan struct{})
+	s.preverifyDone = make(chan struct{})
+	go s.preverifyLoop()
+}
+
+// queuePreverify adds the given key to the re-verification queue, or drops it
+// if the queue is full.
+func (s *CAStore) queuePreverify(key string) {
+	select {
+	case s.preverifyCh <- preverifyItem{key, time.Now()}:
+	default:
+	}
+}
+
+// preverifyLoop processes the re-verification queue until the store is closed.
+func (s *CAStore) preverifyLoop() {
+	defer close(s.preverifyDone)
+
+	limiter := time.NewTicker(time.Second / time.Duration(s.opts.PreverifyRate))
+	defer limiter.Stop()
+
+	for {
+		var item preverifyItem
+		select {
+		case item = <-s.preverifyCh:
+		case <-s.preverifyStop:
+			return
+		}
+
+		// Wait until the item is old enough, and for our rate limit.
+		if wait := time.Until(item.written.Add(s.opts.PreverifyDelay)); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-s.preverifyStop:
+				return
+			}
+		}
+		select {
+		case <-limiter.C:
+		case <-s.preverifyStop:
+			return
+		}
+
+		s.preverifyOne(item.key)
+	}
+}
+
+// preverifyOne re-verifies a single key, reporting any failure.
+func (s *CAStore) preverifyOne(key string) {
+	p, _, err := s.locate(key)
+	if os.IsNotExist(err) {
+		// Deleted in the meantime.
+		return
+	}
+
+	var ok bool
+	if err == nil {
+		ok, err = s.verifyFile(key, p)
+		if os.IsNotExist(err) {
+			return
+		}
+	}
+	if err == nil && !ok {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		s.log.Warn("background verification failed", "key", key, "err", err)
+	}
+	if err != nil && s.opts.PreverifyFailed != nil {
+		s.opts.PreverifyFailed(key, err)
+	}
+}
@@ -0,0 +1,47 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreverify(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-preverify"))
+	defer os.RemoveAll(tdir)
+
+	failed := make(chan string, 10)
+	s, err := New(Options{
+		BasePath:       tdir,
+		Preverify:      true,
+		PreverifyDelay: 50 * time.Millisecond,
+		PreverifyFailed: func(key string, err error) {
+			assert.Equal(t, ErrCorrupt, err)
+			failed <- key
+		},
+	})
+	assert.NoError(t, err)
+	defer s.Close()
+
+	// Good data shouldn't be reported.
+	_, err = s.PutString("good")
+	assert.NoError(t, err)
+
+	// Corrupt the data before it's re-verified.
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(key), []byte("bad"), 0600))
+
+	select {
+	case k := <-failed:
+		assert.Equal(t, key, k)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for verification failure")
+	}
+
+	assert.NoError(t, s.Close())
+	assert.Len(t, failed, 0)
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processStart returns the start time of the process with the given ID, in
+// clock ticks since boot, or "0" if it cannot be determined.
+func processStart(pid int) string {
+	start, _ := readProcessStart(pid)
+	return start
+}
+
+// processRunning returns whether the process with the given ID and start time
+// (as returned by processStart) is still running.
+func processRunning(pid int, start string) bool {
+	current, exists := readProcessStart(pid)
+	if !exists {
+		return false
+	}
+	return start == "0" || current == "0" || start == current
+}
+
+// readProcessStart reads the start time of the given process from /proc, and
+// also returns whether the process exists.
+func readProcessStart(pid int) (string, bool) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if os.IsNotExist(err) {
+		return "0", false
+	}
+	if err != nil {
+		return "0", true
+	}
+
+	// The command name is in parentheses and may contain spaces, so fields
+	// are counted from the last closing parenthesis, which ends the second
+	// field; the start time is the 22nd.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return "0", true
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return "0", true
+	}
+	return fields[19], true
+}
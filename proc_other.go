@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package castore
+
+// processStart returns the start time of the process with the given ID, or "0"
+// if it cannot be determined, which is always the case on this platform.
+func processStart(pid int) string {
+	return "0"
+}
+
+// processRunning returns whether the process with the given ID and start time
+// (as returned by processStart) is still running.  This cannot be determined
+// on this platform, so every process is assumed to be running.
+func processRunning(pid int, start string) bool {
+	return true
+}
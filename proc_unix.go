@@ -0,0 +1,21 @@
+//go:build !linux && !windows && !plan9
+
+package castore
+
+import (
+	"syscall"
+)
+
+// processStart returns the start time of the process with the given ID, or "0"
+// if it cannot be determined, which is always the case on this platform.
+func processStart(pid int) string {
+	return "0"
+}
+
+// processRunning returns whether the process with the given ID and start time
+// (as returned by processStart) is still running.  Since start times are not
+// available, a reused process ID will be reported as running.
+func processRunning(pid int, start string) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,49 @@
+package castore
+
+import "io"
+
+// ProgressFunc is the type of function called by PutWithProgress and
+// GetWithProgress as data is transferred, with the total number of bytes
+// transferred so far.  It is called from the goroutine doing the transfer, so
+// it should return quickly.
+type ProgressFunc func(bytesTransferred int64)
+
+// PutWithProgress is like Put, but calls fn as the data is read from r.
+func (s *CAStore) PutWithProgress(r io.Reader, fn ProgressFunc) (string, error) {
+	return s.Put(NewProgressReader(r, fn))
+}
+
+// GetWithProgress is like Get, but calls fn as the data is read from the
+// returned reader.
+func (s *CAStore) GetWithProgress(key string, fn ProgressFunc) (io.ReadCloser, error) {
+	r, err := s.Get(key)
+	if err != nil || r == nil {
+		return r, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{NewProgressReader(r, fn), r}, nil
+}
+
+// NewProgressReader returns an io.Reader that reads from r, calling fn after
+// each read with the total number of bytes read so far.  It can be used to
+// report progress for any Store.
+func NewProgressReader(r io.Reader, fn ProgressFunc) io.Reader {
+	return &progressReader{r: r, fn: fn}
+}
+
+type progressReader struct {
+	r     io.Reader
+	fn    ProgressFunc
+	total int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.fn(p.total)
+	}
+	return n, err
+}
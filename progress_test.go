@@ -0,0 +1,38 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-progress"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	var put int64
+	key, err := s.PutWithProgress(strings.NewReader(TEST_VALUE), func(n int64) { put = n })
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), put)
+
+	var got []int64
+	r, err := s.GetWithProgress(key, func(n int64) { got = append(got, n) })
+	assert.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = r.Read(buf)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, []int64{4, 6}, got)
+
+	r, err = s.GetWithProgress(TEST_KEY[:60]+"0000", func(n int64) {})
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+}
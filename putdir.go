@@ -0,0 +1,124 @@
+package castore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// PutDirOptions are the options for PutDir.
+type PutDirOptions struct {
+	// Concurrency is the number of files that are stored at once.  If not
+	// positive, one per CPU is used.  The number of Puts in progress at once
+	// is still subject to MaxConcurrentPuts.
+	Concurrency int
+}
+
+// DirEntry describes a file stored by PutDir.
+type DirEntry struct {
+	// Path is the path of the file relative to the directory given to
+	// PutDir, using forward slashes.
+	Path string `json:"path"`
+
+	// Key is the key under which the file's contents were stored.
+	Key string `json:"key"`
+
+	// Size is the size of the file, in bytes.
+	Size int64 `json:"size"`
+
+	// Mode is the file's mode.
+	Mode os.FileMode `json:"mode"`
+}
+
+// PutDir will walk the directory at the given path and store every regular
+// file in it, several at a time, returning a manifest with an entry for each
+// file, sorted by path.  Symbolic links, and anything else that isn't a
+// regular file or directory, are skipped.  Unlike PutFile, the files are left
+// where they are, and they must not be modified while PutDir is running.
+// PutDir stops at the first error, which names the file concerned.
+func (s *CAStore) PutDir(path string, opts PutDirOptions) ([]DirEntry, error) {
+	var entries []DirEntry
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, DirEntry{
+			Path: filepath.ToSlash(rel),
+			Size: info.Size(),
+			Mode: info.Mode(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(entries) {
+		workers = len(entries)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		stop     = make(chan struct{})
+		next     = make(chan int)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				ent := &entries[i]
+				key, err := s.putDirFile(filepath.Join(path, filepath.FromSlash(ent.Path)))
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("castore: storing %s: %w", ent.Path, err)
+						close(stop)
+					})
+					continue
+				}
+				ent.Key = key
+			}
+		}()
+	}
+feed:
+	for i := range entries {
+		select {
+		case next <- i:
+		case <-stop:
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// putDirFile stores a single file for PutDir.
+func (s *CAStore) putDirFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return s.Put(f)
+}
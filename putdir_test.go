@@ -0,0 +1,40 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutDir(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-putdir"))
+	defer os.RemoveAll(tdir)
+
+	src := filepath.Join(tdir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "a", "b"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "top"), []byte(TEST_VALUE), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a", "b", "exec"), []byte("other"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "a", "copy"), []byte(TEST_VALUE), 0600))
+	assert.NoError(t, os.Symlink("top", filepath.Join(src, "link")))
+
+	s, err := New(Options{BasePath: filepath.Join(tdir, "store")})
+	assert.NoError(t, err)
+	entries, err := s.PutDir(src, PutDirOptions{Concurrency: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, DirEntry{"a/b/exec", entries[0].Key, 5, 0755}, entries[0])
+		assert.Equal(t, DirEntry{"a/copy", TEST_KEY, 6, 0600}, entries[1])
+		assert.Equal(t, DirEntry{"top", TEST_KEY, 6, 0644}, entries[2])
+		assert.Equal(t, "other", readKey(t, s, entries[0].Key))
+	}
+
+	// The files are left in place.
+	_, err = os.Stat(filepath.Join(src, "top"))
+	assert.NoError(t, err)
+
+	_, err = s.PutDir(filepath.Join(tdir, "missing"), PutDirOptions{})
+	assert.True(t, os.IsNotExist(err))
+}
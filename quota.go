@@ -0,0 +1,45 @@
+package castore
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is the error returned when storing data would take the
+// total size of the store above the Quota specified when creating the
+// CAStore.
+var ErrQuotaExceeded = errors.New("castore: quota exceeded")
+
+// initQuota calculates the current size of the store, if a quota or cache
+// size is set.
+func (s *CAStore) initQuota() error {
+	if !s.trackingUsage() {
+		return nil
+	}
+	u, err := s.Usage()
+	if err != nil {
+		return err
+	}
+	s.used = u.Bytes
+	return nil
+}
+
+// reserveQuota attempts to reserve the given number of bytes against the
+// store's quota, returning ErrQuotaExceeded if there is not enough space.
+func (s *CAStore) reserveQuota(n int64) error {
+	if !s.trackingUsage() {
+		return nil
+	}
+	if used := atomic.AddInt64(&s.used, n); s.opts.Quota > 0 && used > s.opts.Quota {
+		atomic.AddInt64(&s.used, -n)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// releaseQuota returns the given number of bytes to the store's quota.
+func (s *CAStore) releaseQuota(n int64) {
+	if s.trackingUsage() {
+		atomic.AddInt64(&s.used, -n)
+	}
+}
@@ -0,0 +1,65 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuota(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-quota"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Existing data counts against the quota.
+	s, err = New(Options{BasePath: tdir, Quota: 10})
+	assert.NoError(t, err)
+
+	_, err = s.PutString("12345")
+	assert.Equal(t, ErrQuotaExceeded, err)
+	_, err = s.PutString("1234")
+	assert.NoError(t, err)
+
+	// Data that is already present doesn't use any more space.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Deleting frees up space.
+	assert.NoError(t, s.Delete(TEST_KEY))
+	_, err = s.PutString("123456")
+	assert.NoError(t, err)
+}
+
+func TestQuotaDeleteRace(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-quota"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, Quota: 12})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString("12345")
+	assert.NoError(t, err)
+
+	// Two deletes of the same object that both found it only free its space
+	// once.
+	p, info, err := s.locate(TEST_KEY)
+	assert.NoError(t, err)
+	_, removed, err := s.remove(TEST_KEY, p, info)
+	assert.NoError(t, err)
+	assert.True(t, removed)
+	_, removed, err = s.remove(TEST_KEY, p, info)
+	assert.NoError(t, err)
+	assert.False(t, removed)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&s.used))
+
+	_, err = s.PutString("12345678")
+	assert.Equal(t, ErrQuotaExceeded, err)
+}
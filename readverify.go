@@ -0,0 +1,161 @@
+package castore
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// CorruptReadPolicy determines what happens when Options.VerifyOnRead is set
+// and data read by Get turns out to be corrupt or unreadable.
+type CorruptReadPolicy int
+
+const (
+	// CorruptReadFail verifies each object in full before Get returns it,
+	// and makes Get fail with ErrCorrupt (or the error encountered reading
+	// it) instead, so that callers never see bad data.  This is the default,
+	// and suits consumers such as package installers, but each object is
+	// read twice.
+	CorruptReadFail CorruptReadPolicy = iota
+
+	// CorruptReadPartial streams data as it is read, and ends the stream with
+	// a *PartialReadError, in place of io.EOF, if a problem is found.  This
+	// suits consumers such as media players, which would rather have some of
+	// the data than none.
+	CorruptReadPartial
+
+	// CorruptReadRetry is like CorruptReadPartial, but if reading the local
+	// copy fails part way through, the rest of the data is transparently
+	// read from Options.Replica instead.  A *PartialReadError is only
+	// returned if the replica cannot supply it either.
+	CorruptReadRetry
+)
+
+// PartialReadError is returned by readers from Get, in place of io.EOF, when
+// the CorruptReadPartial or CorruptReadRetry policies are in use and the data
+// could not be read in full.
+//
+// Corruption that doesn't cause an error while reading - for example,
+// flipped bits - can only be detected once the whole object has been read and
+// hashed, so is reported with Offset equal to the size of the object.
+type PartialReadError struct {
+	// Key is the key of the object being read.
+	Key string
+
+	// Offset is the number of bytes that had been returned when the problem
+	// was found.
+	Offset int64
+
+	// Err is ErrCorrupt if the data did not match its key, or the error
+	// encountered while reading it.
+	Err error
+}
+
+func (e *PartialReadError) Error() string {
+	return fmt.Sprintf("castore: reading %s failed after %d bytes: %s", e.Key, e.Offset, e.Err)
+}
+
+// Unwrap returns Err, so that errors.Is(err, ErrCorrupt) works as expected.
+func (e *PartialReadError) Unwrap() error {
+	return e.Err
+}
+
+// verifyOnRead is a helper function that applies the store's
+// CorruptReadPolicy to the file f, which holds the data for the given key.
+func (s *CAStore) verifyOnRead(key string, f *limitedFile) (io.ReadCloser, error) {
+	if s.opts.CorruptReads == CorruptReadFail {
+		hasher := s.opts.Hash()
+		_, err := s.copyBuffer(hasher, f)
+		if err == nil && s.opts.KeyEncoding.Encode(hasher.Sum(nil)) != key {
+			err = ErrCorrupt
+		}
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			s.log.Warn("object is corrupt", "key", key, "err", err)
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	return &verifyingReader{
+		s:      s,
+		key:    key,
+		rc:     f,
+		hasher: s.opts.Hash(),
+	}, nil
+}
+
+// verifyingReader hashes data as it is read, and recovers from problems
+// according to the store's CorruptReadPolicy.
+type verifyingReader struct {
+	s       *CAStore
+	key     string
+	rc      io.ReadCloser
+	hasher  hash.Hash
+	n       int64
+	retried bool
+	err     error
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.rc.Read(p)
+	r.hasher.Write(p[:n])
+	r.n += int64(n)
+
+	if err == io.EOF {
+		if r.s.opts.KeyEncoding.Encode(r.hasher.Sum(nil)) == r.key {
+			r.err = io.EOF
+			return n, io.EOF
+		}
+		err = ErrCorrupt
+	}
+	if err != nil {
+		r.s.log.Warn("object is corrupt", "key", r.key, "offset", r.n, "err", err)
+
+		// Corruption found at the end can't be retried, since the bad data
+		// has already been returned.
+		if err != ErrCorrupt && r.retry() {
+			return n, nil
+		}
+		r.err = &PartialReadError{r.key, r.n, err}
+		if n > 0 {
+			return n, nil
+		}
+		return 0, r.err
+	}
+	return n, nil
+}
+
+// retry switches to reading the rest of the data from the replica, if the
+// policy allows it, and returns whether it succeeded.
+func (r *verifyingReader) retry() bool {
+	if r.retried || r.s.opts.CorruptReads != CorruptReadRetry || r.s.opts.Replica == nil {
+		return false
+	}
+	r.retried = true
+
+	rc, err := r.s.opts.Replica.Get(r.key)
+	if err != nil || rc == nil {
+		return false
+	}
+	if _, err = io.CopyN(ioutil.Discard, rc, r.n); err != nil {
+		rc.Close()
+		return false
+	}
+	r.rc.Close()
+	r.rc = rc
+	r.s.log.Info("continuing read from replica", "key", r.key, "offset", r.n)
+	return true
+}
+
+func (r *verifyingReader) Close() error {
+	return r.rc.Close()
+}
@@ -0,0 +1,124 @@
+package castore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyOnReadFail(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-readverify"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, VerifyOnRead: true})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	// Corrupt data is never returned.
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("foobaz"), 0600))
+	r, err = s.Get(TEST_KEY)
+	assert.Equal(t, ErrCorrupt, err)
+	assert.Nil(t, r)
+}
+
+func TestVerifyOnReadPartial(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-readverify"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:     tdir,
+		VerifyOnRead: true,
+		CorruptReads: CorruptReadPartial,
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("foobaz"), 0600))
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "foobaz", string(data))
+
+	var perr *PartialReadError
+	if assert.True(t, errors.As(err, &perr)) {
+		assert.Equal(t, TEST_KEY, perr.Key)
+		assert.Equal(t, int64(6), perr.Offset)
+	}
+	assert.True(t, errors.Is(err, ErrCorrupt))
+}
+
+// failingReader returns the data from r, and then err in place of io.EOF.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		err = r.err
+	}
+	return n, err
+}
+
+func (r *failingReader) Close() error { return nil }
+
+func TestVerifyOnReadRetry(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-readverify"))
+	defer os.RemoveAll(tdir)
+	rdir := must_s(ioutil.TempDir("", "castore-test-readverify"))
+	defer os.RemoveAll(rdir)
+
+	replica, err := New(Options{BasePath: rdir})
+	assert.NoError(t, err)
+	_, err = replica.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	s, err := New(Options{
+		BasePath:     tdir,
+		VerifyOnRead: true,
+		CorruptReads: CorruptReadRetry,
+		Replica:      replica,
+	})
+	assert.NoError(t, err)
+
+	// The local copy fails with an I/O error half way through.
+	eio := errors.New("input/output error")
+	r := &verifyingReader{
+		s:      s,
+		key:    TEST_KEY,
+		rc:     &failingReader{strings.NewReader(TEST_VALUE[:3]), eio},
+		hasher: s.opts.Hash(),
+	}
+	data, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	// If the replica doesn't have it either, the read fails part way.
+	assert.NoError(t, replica.Delete(TEST_KEY))
+	r = &verifyingReader{
+		s:      s,
+		key:    TEST_KEY,
+		rc:     &failingReader{strings.NewReader(TEST_VALUE[:3]), eio},
+		hasher: s.opts.Hash(),
+	}
+	data, err = ioutil.ReadAll(r)
+	assert.Equal(t, TEST_VALUE[:3], string(data))
+	assert.Equal(t, &PartialReadError{TEST_KEY, 3, eio}, err)
+}
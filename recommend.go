@@ -0,0 +1,188 @@
+package castore
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultMaxEntries is the number of entries per directory that RecommendLayout
+// aims for when the filesystem is not recognised.
+const defaultMaxEntries = 4096
+
+// fsLimits describes how well a filesystem copes with large directories: the
+// number of entries per directory beyond which lookups and listings get
+// noticeably slower, and the hard limit, if any, beyond which no more entries
+// can be created.
+type fsLimits struct {
+	target int
+	hard   int
+}
+
+// filesystemLimits contains the limits of the filesystems that
+// RecommendLayout knows about, by the names returned by filesystemType.
+var filesystemLimits = map[string]fsLimits{
+	"ext4":  {10000, 0},
+	"xfs":   {50000, 0},
+	"btrfs": {50000, 0},
+	"zfs":   {50000, 0},
+	"tmpfs": {50000, 0},
+	"nfs":   {1000, 0},
+	"smb":   {1000, 0},
+	"fuse":  {1000, 0},
+	"vfat":  {1000, 65534},
+	"exfat": {1000, 2796202},
+}
+
+// LayoutRecommendation is the result of RecommendLayout.
+type LayoutRecommendation struct {
+	// Objects is the number of objects in the store.
+	Objects int64
+
+	// Filesystem is the type of filesystem that the store is on (e.g.
+	// "ext4"), or empty if it could not be determined.
+	Filesystem string
+
+	// MaxEntries is the number of entries per directory that the
+	// recommendation aims to stay below, and HardLimit is the most that the
+	// filesystem allows, or zero if it has no practical limit.
+	MaxEntries int
+	HardLimit  int
+
+	// CurrentMaxEntries is the largest number of objects currently stored in
+	// a single directory.
+	CurrentMaxEntries int
+
+	// Depth and Width describe the recommended layout, as passed to
+	// ShardTransformFunc, and Transform is the resulting TransformFunction.
+	Depth     int
+	Width     int
+	Transform TransformFunction
+
+	// ProjectedMaxEntries is the largest number of entries that a single
+	// directory would contain with the recommended layout, once the store has
+	// grown by the factor given to RecommendLayout.
+	ProjectedMaxEntries int
+}
+
+// Fits returns whether the recommended layout keeps every directory below
+// MaxEntries.  If not, the store is too large for any of the layouts that
+// were considered, and the recommendation is simply the best of them.
+func (r *LayoutRecommendation) Fits() bool {
+	return r.ProjectedMaxEntries <= r.MaxEntries
+}
+
+// RecommendLayout will examine the store and recommend the Transform that
+// keeps its directories small enough for the filesystem it is on, using as
+// few levels of directories as possible.  Rather than assuming anything about
+// the distribution of keys, it tries every candidate layout against the keys
+// actually in the store, which is expected to grow by the given factor (e.g.
+// 2 to allow for twice as many objects; values below 1 are treated as 1).
+//
+// The recommendation can be applied with Relayout.  RecommendLayout holds
+// every key in memory while it runs.
+func (s *CAStore) RecommendLayout(growth float64) (*LayoutRecommendation, error) {
+	if growth < 1 {
+		growth = 1
+	}
+
+	rec := &LayoutRecommendation{
+		Filesystem: filesystemType(s.opts.BasePath),
+		MaxEntries: defaultMaxEntries,
+	}
+	if limits, ok := filesystemLimits[rec.Filesystem]; ok {
+		rec.MaxEntries = limits.target
+		rec.HardLimit = limits.hard
+	}
+
+	var keys []string
+	current := make(map[string]int)
+	err := s.walkFiles(func(key, p string, info os.FileInfo) error {
+		keys = append(keys, key)
+		dir := filepath.Dir(p)
+		current[dir]++
+		if current[dir] > rec.CurrentMaxEntries {
+			rec.CurrentMaxEntries = current[dir]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rec.Objects = int64(len(keys))
+
+	// Try shallower layouts first, then narrower ones, since both mean fewer
+	// directories.  The flat layout is always a candidate.
+	rec.ProjectedMaxEntries = projectedEntries(keys, 0, 0, growth)
+	if !rec.Fits() {
+		best := rec.ProjectedMaxEntries
+	search:
+		for depth := 1; depth <= 4; depth++ {
+			for width := 1; width <= 4; width++ {
+				n := projectedEntries(keys, depth, width, growth)
+				if n < 0 {
+					continue
+				}
+				if n < best {
+					best = n
+					rec.Depth, rec.Width, rec.ProjectedMaxEntries = depth, width, n
+				}
+				if rec.Fits() {
+					break search
+				}
+			}
+		}
+	}
+
+	rec.Transform = FlatTransformFunc
+	if rec.Depth > 0 {
+		rec.Transform = ShardTransformFunc(rec.Depth, rec.Width)
+	}
+	return rec, nil
+}
+
+// projectedEntries returns the largest number of entries in any directory
+// when the given keys are laid out using ShardTransformFunc(depth, width),
+// with the number of objects in each of the deepest directories scaled up by
+// growth.  Intermediate directories are not scaled, since they can contain no
+// more than one entry per possible prefix.  It returns -1 if some key is too
+// short for the layout.
+func projectedEntries(keys []string, depth, width int, growth float64) int {
+	// children[i] counts the distinct prefixes of length (i+1)*width under
+	// each prefix of length i*width; the last level counts the keys
+	// themselves.
+	children := make([]map[string]int, depth+1)
+	seen := make([]map[string]bool, depth)
+	for i := range children {
+		children[i] = make(map[string]int)
+	}
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+
+	for _, key := range keys {
+		if len(key) < depth*width {
+			return -1
+		}
+		for i := 0; i < depth; i++ {
+			prefix := key[:(i+1)*width]
+			if !seen[i][prefix] {
+				seen[i][prefix] = true
+				children[i][key[:i*width]]++
+			}
+		}
+		children[depth][key[:depth*width]]++
+	}
+
+	largest := 0
+	for i, level := range children {
+		for _, n := range level {
+			if i == depth {
+				n = int(float64(n) * growth)
+			}
+			if n > largest {
+				largest = n
+			}
+		}
+	}
+	return largest
+}
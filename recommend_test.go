@@ -0,0 +1,71 @@
+package castore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecommendLayout(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-recommend"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// A small store is fine as it is.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	rec, err := s.RecommendLayout(2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rec.Objects)
+	assert.Equal(t, 1, rec.CurrentMaxEntries)
+	assert.Equal(t, 0, rec.Depth)
+	assert.Equal(t, 2, rec.ProjectedMaxEntries)
+	assert.True(t, rec.Fits())
+
+	// A larger one needs sharding.
+	for i := 0; i < 3000; i++ {
+		_, err = s.PutString(fmt.Sprintf("value %d", i))
+		assert.NoError(t, err)
+	}
+	rec, err = s.RecommendLayout(2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3001), rec.Objects)
+	assert.Equal(t, 3001, rec.CurrentMaxEntries)
+	if rec.MaxEntries < 6002 {
+		assert.Equal(t, 1, rec.Depth)
+		assert.True(t, rec.Fits())
+		assert.True(t, rec.ProjectedMaxEntries < 6002)
+	}
+
+	// The recommendation can be applied.
+	moved, err := s.Relayout(context.Background(), rec.Transform)
+	assert.NoError(t, err)
+	if rec.Depth > 0 {
+		assert.Equal(t, 3001, moved)
+	}
+	after, err := s.RecommendLayout(2)
+	assert.NoError(t, err)
+	assert.Equal(t, rec.ProjectedMaxEntries, after.ProjectedMaxEntries)
+	assert.True(t, after.CurrentMaxEntries <= rec.ProjectedMaxEntries)
+}
+
+func TestProjectedEntries(t *testing.T) {
+	keys := []string{"aaaa", "aabb", "abcc", "bbdd"}
+	assert.Equal(t, 4, projectedEntries(keys, 0, 0, 1))
+	assert.Equal(t, 8, projectedEntries(keys, 0, 0, 2))
+
+	// Two top-level directories, "a" holding three keys.
+	assert.Equal(t, 3, projectedEntries(keys, 1, 1, 1))
+
+	// "a" holds two directories, "aa" holds two keys.
+	assert.Equal(t, 2, projectedEntries(keys, 2, 1, 1))
+	assert.Equal(t, 4, projectedEntries(keys, 2, 1, 2))
+
+	assert.Equal(t, -1, projectedEntries(keys, 3, 2, 1))
+}
@@ -0,0 +1,151 @@
+package castore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// refsDir is the name of the directory in the store's internal state directory
+// in which refs are persisted, one file per ref.
+const refsDir = "refs"
+
+// ErrInvalidRef is the error returned when attempting to use a ref name that
+// is not valid.
+var ErrInvalidRef = errors.New("castore: invalid ref name")
+
+// validRef returns whether the given string is a valid ref name: one or more
+// non-empty components separated by slashes, none of which start with a dot.
+func validRef(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part[0] == '.' || strings.ContainsAny(part, `\`+"\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+// refPath returns the on-disk path of the file for the given ref.
+func (s *CAStore) refPath(name string) (string, error) {
+	if !validRef(name) {
+		return "", ErrInvalidRef
+	}
+	dir, err := s.metaPath(refsDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// SetRef will point the named ref at the given key, which must exist in the
+// store, replacing whatever it previously pointed to.  Ref names are
+// slash-separated paths, such as "releases/latest", in the same way as git
+// refs.  The update is atomic: concurrent readers see either the old or new
+// key, even across processes.
+func (s *CAStore) SetRef(name, key string) error {
+	p, err := s.refPath(name)
+	if err != nil {
+		return err
+	}
+
+	exists, err := s.Exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, []byte(key+"\n"))
+}
+
+// GetRef will return the key that the named ref points to, or an empty string
+// if the ref does not exist.
+func (s *CAStore) GetRef(name string) (string, error) {
+	p, err := s.refPath(name)
+	if err != nil {
+		return "", err
+	}
+	return readRef(p)
+}
+
+// DeleteRef will remove the named ref.  No error is returned if the ref does
+// not exist.
+func (s *CAStore) DeleteRef(name string) error {
+	p, err := s.refPath(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Clean up the directories of hierarchical refs.
+	dir, err := s.metaPath(refsDir)
+	if err != nil {
+		return err
+	}
+	for p = filepath.Dir(p); p != dir; p = filepath.Dir(p) {
+		if os.Remove(p) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ListRefs will return all refs in the store, as a map from ref name to key.
+func (s *CAStore) ListRefs() (map[string]string, error) {
+	dir, err := s.metaPath(refsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]string)
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			// Skip the temporary files from writeFileAtomic.
+			return nil
+		}
+
+		key, err := readRef(p)
+		if err != nil || key == "" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(rel)] = key
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// readRef reads the key from the ref file at the given path.
+func readRef(p string) (string, error) {
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
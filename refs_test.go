@@ -0,0 +1,57 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefs(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-refs"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SetRef("latest", key))
+	assert.NoError(t, s.SetRef("releases/v1", key))
+	assert.NoError(t, s.SetRef("releases/v2", other))
+	assert.NoError(t, s.SetRef("latest", other))
+
+	ref, err := s.GetRef("latest")
+	assert.NoError(t, err)
+	assert.Equal(t, other, ref)
+
+	ref, err = s.GetRef("nonexistent")
+	assert.NoError(t, err)
+	assert.Equal(t, "", ref)
+
+	refs, err := s.ListRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"latest":      other,
+		"releases/v1": key,
+		"releases/v2": other,
+	}, refs)
+
+	// Refs can only point at data in the store.
+	assert.Equal(t, ErrNotFound, s.SetRef("missing", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+
+	for _, name := range []string{"", "/abs", "a//b", "../escape", "a/.hidden", "trailing/"} {
+		assert.Equal(t, ErrInvalidRef, s.SetRef(name, key), name)
+	}
+
+	assert.NoError(t, s.DeleteRef("releases/v1"))
+	assert.NoError(t, s.DeleteRef("releases/v2"))
+	assert.NoError(t, s.DeleteRef("releases/v2"))
+	refs, err = s.ListRefs()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"latest": other}, refs)
+}
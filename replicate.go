@@ -0,0 +1,172 @@
+package castore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// replicationDir is the name of the directory in the store's internal state
+// directory in which Replicate records, for each named replica, the keys that
+// are known to be there.  Each file lists one key per line.
+const replicationDir = "replication"
+
+// ReplicateSummary contains the results of a call to Replicate.
+type ReplicateSummary struct {
+	// Transferred contains the keys copied to the replica, in sorted order.
+	Transferred []string
+
+	// Bytes is the total size of the objects that were copied.
+	Bytes int64
+
+	// Checked is the number of keys that were not already known to be in the
+	// replica, and so had to be looked up there.
+	Checked int
+}
+
+// Replicate will copy every object in this store that is missing from the
+// given replica, and return what was copied.  Unlike Sync, the replica is
+// never walked: this store remembers which keys it has seen in the replica
+// under the given name, and only asks it about keys that have been added
+// since the last call, so that regular pushes to a large offsite copy cost in
+// proportion to what has changed.  If the replica is a CAStore, the lookups
+// are cheapest with its BloomFilter enabled, and listing this store is
+// cheapest with the Index enabled.
+//
+// Progress is recorded as each object is copied, so if Replicate is
+// interrupted or fails, calling it again continues where it left off.  The
+// name follows the same rules as ref names, and ErrInvalidRef is returned if
+// it isn't valid.  Objects removed from the replica by anything else aren't
+// noticed; ResetReplication makes the next call check every key again.
+// Replicate must not be called concurrently with the same name.
+func (s *CAStore) Replicate(ctx context.Context, replica Store, name string) (*ReplicateSummary, error) {
+	p, err := s.replicationPath(name)
+	if err != nil {
+		return nil, err
+	}
+	known, err := readKeyList(p)
+	if err != nil {
+		return nil, err
+	}
+
+	local := make(map[string]bool)
+	sizes := make(map[string]int64)
+	var pending []string
+	err = s.Walk(func(key string, size int64) error {
+		local[key] = true
+		if !known[key] {
+			pending = append(pending, key)
+			sizes[key] = size
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pending)
+
+	if err = os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	summary := &ReplicateSummary{}
+	for _, key := range pending {
+		if err = ctx.Err(); err != nil {
+			return summary, err
+		}
+		copied, err := copyOne(replica, s, key)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since the walk.
+			delete(local, key)
+			continue
+		}
+		if err != nil {
+			return summary, fmt.Errorf("castore: copying %s: %w", key, err)
+		}
+		if _, err = f.WriteString(key + "\n"); err != nil {
+			return summary, err
+		}
+		known[key] = true
+		summary.Checked++
+		if copied {
+			summary.Transferred = append(summary.Transferred, key)
+			summary.Bytes += sizes[key]
+		}
+	}
+	if err = f.Close(); err != nil {
+		return summary, err
+	}
+
+	// Forget keys that have since been deleted from this store, so that the
+	// list doesn't grow forever.
+	var keys []string
+	for key := range known {
+		if local[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var data []byte
+	for _, key := range keys {
+		data = append(data, key+"\n"...)
+	}
+	return summary, writeFileAtomic(p, data)
+}
+
+// ResetReplication will forget which keys Replicate has seen in the named
+// replica, so that the next call checks every key.
+func (s *CAStore) ResetReplication(name string) error {
+	p, err := s.replicationPath(name)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// replicationPath returns the on-disk path of the list of keys for the named
+// replica.
+func (s *CAStore) replicationPath(name string) (string, error) {
+	if !validRef(name) {
+		return "", ErrInvalidRef
+	}
+	dir, err := s.metaPath(replicationDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+// readKeyList reads a file containing one key per line.  A file that doesn't
+// exist contains no keys.
+func readKeyList(p string) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			keys[line] = true
+		}
+	}
+	return keys, scanner.Err()
+}
@@ -0,0 +1,86 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// limitedPutStore is a Store that counts the calls to Exists, and whose Puts
+// fail once a limit is reached.
+type limitedPutStore struct {
+	Store
+	exists int
+	puts   int
+}
+
+func (s *limitedPutStore) Exists(key string) (bool, error) {
+	s.exists++
+	return s.Store.Exists(key)
+}
+
+func (s *limitedPutStore) Put(r io.Reader) (string, error) {
+	if s.puts == 0 {
+		return "", errors.New("disk on fire")
+	}
+	s.puts--
+	return s.Store.Put(r)
+}
+
+func TestReplicate(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-replicate"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir + "/src"})
+	assert.NoError(t, err)
+	for _, val := range []string{"one", "two", "three"} {
+		_, err = s.PutString(val)
+		assert.NoError(t, err)
+	}
+	dst, err := New(Options{BasePath: tdir + "/dst"})
+	assert.NoError(t, err)
+	_, err = dst.PutString("two")
+	assert.NoError(t, err)
+
+	// A failure part way through leaves a record of what was done.
+	replica := &limitedPutStore{Store: dst, puts: 1}
+	summary, err := s.Replicate(context.Background(), replica, "offsite")
+	assert.Error(t, err)
+	if assert.NotNil(t, summary) {
+		assert.Len(t, summary.Transferred, 1)
+	}
+	assert.Len(t, keysOf(t, dst), 2)
+
+	replica.puts = 1
+	replica.exists = 0
+	summary, err = s.Replicate(context.Background(), replica, "offsite")
+	assert.NoError(t, err)
+	assert.Len(t, summary.Transferred, 1)
+	assert.Equal(t, keysOf(t, s), keysOf(t, dst))
+
+	// Only new keys are checked.
+	key, err := s.PutString("four")
+	assert.NoError(t, err)
+	replica.puts = 1
+	replica.exists = 0
+	summary, err = s.Replicate(context.Background(), replica, "offsite")
+	assert.NoError(t, err)
+	assert.Equal(t, &ReplicateSummary{Transferred: []string{key}, Bytes: 4, Checked: 1}, summary)
+	assert.Equal(t, 1, replica.exists)
+
+	// Resetting checks everything again.
+	assert.NoError(t, s.ResetReplication("offsite"))
+	replica.exists = 0
+	summary, err = s.Replicate(context.Background(), replica, "offsite")
+	assert.NoError(t, err)
+	assert.Empty(t, summary.Transferred)
+	assert.Equal(t, 4, replica.exists)
+
+	_, err = s.Replicate(context.Background(), replica, "../escape")
+	assert.Equal(t, ErrInvalidRef, err)
+}
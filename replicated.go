@@ -0,0 +1,214 @@
+package castore
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ReplicatedOptions controls the behaviour of a Replicated store.
+type ReplicatedOptions struct {
+	// BestEffort makes Puts and Deletes succeed as long as at least one of
+	// the underlying stores succeeds, rather than requiring all of them to.
+	BestEffort bool
+}
+
+// Replicated is a Store that writes every object to several underlying
+// stores, and reads from the first one that can supply it, providing simple
+// redundancy - for example, across two disks - without RAID.
+type Replicated struct {
+	stores []Store
+	opts   ReplicatedOptions
+}
+
+var _ Store = (*Replicated)(nil)
+
+// NewReplicated returns a Replicated store over the given stores, which
+// should all use the same Hash and KeyEncoding.  Reads try the stores in the
+// order given.
+func NewReplicated(stores []Store, opts ReplicatedOptions) *Replicated {
+	return &Replicated{stores: stores, opts: opts}
+}
+
+// Put will insert the data from the given io.Reader into every underlying
+// store at once, reading it only once, and return its key.  Unless
+// BestEffort is set, it fails if any of the stores fails, though the data may
+// have been stored in the others.  If the stores disagree about the key,
+// ErrKeyMismatch is returned.
+func (rs *Replicated) Put(r io.Reader) (string, error) {
+	n := len(rs.stores)
+	keys := make([]string, n)
+	errs := make([]error, n)
+	pws := make([]*io.PipeWriter, n)
+
+	var wg sync.WaitGroup
+	for i, st := range rs.stores {
+		pr, pw := io.Pipe()
+		pws[i] = pw
+		wg.Add(1)
+		go func(i int, st Store) {
+			defer wg.Done()
+			keys[i], errs[i] = st.Put(pr)
+
+			// Make sure that writes to a store that stopped reading early
+			// fail rather than blocking.
+			pr.CloseWithError(io.ErrClosedPipe)
+		}(i, st)
+	}
+
+	_, err := io.Copy(&fanWriter{pws: pws}, r)
+	for _, pw := range pws {
+		pw.CloseWithError(err)
+	}
+	wg.Wait()
+	if err != nil && err != errAllReplicasFailed {
+		return "", err
+	}
+
+	return rs.collect(keys, errs)
+}
+
+// collect combines the results of a Put to each store.
+func (rs *Replicated) collect(keys []string, errs []error) (string, error) {
+	var key string
+	var firstErr error
+	for i := range rs.stores {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		if key != "" && keys[i] != key {
+			return "", ErrKeyMismatch
+		}
+		key = keys[i]
+	}
+	if firstErr != nil && (key == "" || !rs.opts.BestEffort) {
+		return "", firstErr
+	}
+	return key, nil
+}
+
+// fanWriter writes to every pipe that is still being read from, and only
+// fails once none of them are.
+type fanWriter struct {
+	pws  []*io.PipeWriter
+	dead []bool
+}
+
+func (w *fanWriter) Write(p []byte) (int, error) {
+	if w.dead == nil {
+		w.dead = make([]bool, len(w.pws))
+	}
+	alive := false
+	for i, pw := range w.pws {
+		if w.dead[i] {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			w.dead[i] = true
+			continue
+		}
+		alive = true
+	}
+	if !alive {
+		return 0, errAllReplicasFailed
+	}
+	return len(p), nil
+}
+
+// errAllReplicasFailed is returned by fanWriter once no store is reading.
+// Put reports the stores' own errors instead.
+var errAllReplicasFailed = errors.New("castore: every replica failed")
+
+// Get will return a reader for the data stored with the given key in the
+// first store that has it.  Stores that fail are skipped; if all of them do,
+// the last error is returned.
+func (rs *Replicated) Get(key string) (io.ReadCloser, error) {
+	var lastErr error
+	for _, st := range rs.stores {
+		r, err := st.Get(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r != nil {
+			return r, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// Size will return the size of the data stored with the given key in the
+// first store that has it, or -1 if none do.
+func (rs *Replicated) Size(key string) (int64, error) {
+	var lastErr error
+	for _, st := range rs.stores {
+		size, err := st.Size(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if size >= 0 {
+			return size, nil
+		}
+	}
+	if lastErr != nil {
+		return 0, lastErr
+	}
+	return -1, nil
+}
+
+// Exists will return whether any store has the given key.
+func (rs *Replicated) Exists(key string) (bool, error) {
+	var lastErr error
+	for _, st := range rs.stores {
+		exists, err := st.Exists(key)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	return false, lastErr
+}
+
+// Delete will delete the given key from every store.  Unless BestEffort is
+// set, it fails if any of the stores fails.
+func (rs *Replicated) Delete(key string) error {
+	var firstErr error
+	failed := 0
+	for _, st := range rs.stores {
+		if err := st.Delete(key); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if failed > 0 && (!rs.opts.BestEffort || failed == len(rs.stores)) {
+		return firstErr
+	}
+	return nil
+}
+
+// Walk will call fn for every object in the first store that can be walked.
+// With BestEffort, that store may be missing objects that the others have.
+func (rs *Replicated) Walk(fn WalkFunc) error {
+	var lastErr error
+	for _, st := range rs.stores {
+		started := false
+		err := st.Walk(func(key string, size int64) error {
+			started = true
+			return fn(key, size)
+		})
+		if err == nil || started {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
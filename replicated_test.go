@@ -0,0 +1,85 @@
+package castore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// brokenStore is a Store whose Puts fail without reading their input.
+type brokenStore struct{ Store }
+
+func (brokenStore) Put(r io.Reader) (string, error) {
+	return "", errors.New("disk on fire")
+}
+
+func TestReplicated(t *testing.T) {
+	var stores []*CAStore
+	for i := 0; i < 2; i++ {
+		tdir := must_s(ioutil.TempDir("", "castore-test-replicated"))
+		defer os.RemoveAll(tdir)
+		s, err := New(Options{BasePath: tdir})
+		assert.NoError(t, err)
+		stores = append(stores, s)
+	}
+	rs := NewReplicated([]Store{stores[0], stores[1]}, ReplicatedOptions{})
+
+	// Puts go to every store.
+	key, err := rs.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	for _, s := range stores {
+		exists, err := s.Exists(key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// Reads fall through to the next store.
+	assert.NoError(t, stores[0].Delete(key))
+	r, err := rs.Get(key)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+	size, err := rs.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(TEST_VALUE)), size)
+
+	// Deletes go to every store.
+	assert.NoError(t, rs.Delete(key))
+	exists, err := rs.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	size, err = rs.Size(key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), size)
+}
+
+func TestReplicatedBestEffort(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-replicated"))
+	defer os.RemoveAll(tdir)
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	stores := []Store{brokenStore{s}, s}
+
+	// By default, every store must succeed.
+	_, err = NewReplicated(stores, ReplicatedOptions{}).Put(strings.NewReader(TEST_VALUE))
+	assert.EqualError(t, err, "disk on fire")
+
+	key, err := NewReplicated(stores, ReplicatedOptions{BestEffort: true}).Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// But at least one always must.
+	_, err = NewReplicated([]Store{brokenStore{s}}, ReplicatedOptions{BestEffort: true}).Put(strings.NewReader(TEST_VALUE))
+	assert.EqualError(t, err, "disk on fire")
+}
@@ -0,0 +1,47 @@
+package castore
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrAmbiguousKey is the error returned by Resolve when more than one key
+// starts with the given prefix.
+var ErrAmbiguousKey = errors.New("castore: ambiguous key prefix")
+
+// Resolve will return the key in the store that starts with the given prefix,
+// in the same way as git's abbreviated commit hashes, so that people can refer
+// to objects by the first few characters of their keys.  If no key starts with
+// the prefix, ErrNotFound is returned, and if more than one does,
+// ErrAmbiguousKey is.  Unless the prefix is a whole key, this walks the store.
+func (s *CAStore) Resolve(prefix string) (string, error) {
+	if s.validKey(prefix) {
+		exists, err := s.Exists(prefix)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return prefix, nil
+		}
+		return "", ErrNotFound
+	}
+
+	var found string
+	err := s.Walk(func(key string, size int64) error {
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if found != "" && found != key {
+			return ErrAmbiguousKey
+		}
+		found = key
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == "" {
+		return "", ErrNotFound
+	}
+	return found, nil
+}
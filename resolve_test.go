@@ -0,0 +1,40 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-resolve"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NotEqual(t, key[:1], other[:1])
+
+	got, err := s.Resolve(key[:7])
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+	got, err = s.Resolve(key)
+	assert.NoError(t, err)
+	assert.Equal(t, key, got)
+	got, err = s.Resolve(other[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, other, got)
+
+	_, err = s.Resolve("")
+	assert.Equal(t, ErrAmbiguousKey, err)
+	_, err = s.Resolve("zz")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, s.Delete(key))
+	_, err = s.Resolve(key)
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,58 @@
+package castore
+
+import (
+	"context"
+	"io"
+)
+
+// Source is something that data can be retrieved from by key, such as another
+// CAStore.  Get should return a nil io.ReadCloser if the key does not exist.
+type Source interface {
+	Get(key string) (io.ReadCloser, error)
+}
+
+// RestoreSummary contains the results of a call to RestoreMissing.
+type RestoreSummary struct {
+	// Recovered contains the keys that were successfully reinstated.
+	Recovered []string
+
+	// Lost contains the keys that could not be recovered from the source,
+	// either because it did not have them or because its copy was also bad.
+	Lost []string
+}
+
+// RestoreMissing will attempt to reinstate every missing or corrupt object
+// listed in the given VerifyReport by fetching it from the given Source.  Data
+// fetched from the source is verified against its key before being stored, so
+// a bad copy in the source will never replace a bad copy in this store.
+//
+// An error is only returned if the context is cancelled; failures to recover
+// individual keys are recorded in the returned summary.
+func (s *CAStore) RestoreMissing(ctx context.Context, report *VerifyReport, src Source) (*RestoreSummary, error) {
+	summary := &RestoreSummary{}
+
+	keys := make([]string, 0, len(report.Missing)+len(report.Corrupt))
+	keys = append(keys, report.Missing...)
+	keys = append(keys, report.Corrupt...)
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		if s.restoreOne(key, src) {
+			summary.Recovered = append(summary.Recovered, key)
+		} else {
+			summary.Lost = append(summary.Lost, key)
+		}
+	}
+	return summary, nil
+}
+
+// restoreOne is a helper function that fetches a single key from the source
+// and stores it, returning whether it was successful.  The data will be
+// written to the correct location for the current Transform, replacing any
+// corrupt copy there.
+func (s *CAStore) restoreOne(key string, src Source) bool {
+	return s.fetchFrom(src, key) == nil
+}
@@ -0,0 +1,53 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyAndRestore(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-restore"))
+	defer os.RemoveAll(tdir)
+	rdir := must_s(ioutil.TempDir("", "castore-test-restore-replica"))
+	defer os.RemoveAll(rdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	replica, err := New(Options{BasePath: rdir})
+	assert.NoError(t, err)
+
+	otherKey, err := replica.PutString("other")
+	assert.NoError(t, err)
+	_, err = replica.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Nothing wrong yet.
+	report, err := s.Verify(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.True(t, report.OK())
+
+	// Corrupt our object, and report a missing and a lost one.
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(TEST_KEY), []byte("bad"), 0600))
+	lostKey := TEST_KEY[1:] + "0"
+
+	report, err = s.Verify(context.Background(), TEST_KEY, otherKey, lostKey)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{TEST_KEY}, report.Corrupt)
+	assert.Equal(t, []string{otherKey, lostKey}, report.Missing)
+
+	summary, err := s.RestoreMissing(context.Background(), report, replica)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{otherKey, TEST_KEY}, summary.Recovered)
+	assert.Equal(t, []string{lostKey}, summary.Lost)
+
+	report, err = s.Verify(context.Background(), TEST_KEY, otherKey)
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+}
@@ -0,0 +1,457 @@
+package castore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// defaultVirtualNodes is the number of points each member of a Router has on
+// the ring if RouterOptions.VirtualNodes is not set.
+const defaultVirtualNodes = 128
+
+var (
+	// ErrNoMembers is returned by a Router that has no members to store
+	// data in.
+	ErrNoMembers = errors.New("castore: router has no members")
+
+	// ErrMemberExists is returned by AddMember if the router already has a
+	// member with the given name.
+	ErrMemberExists = errors.New("castore: router member already exists")
+)
+
+// RouterOptions controls the behaviour of a Router.
+type RouterOptions struct {
+	// Hash and KeyEncoding are used to compute the key of data given to Put,
+	// which decides the member that it is stored in.  They must match those
+	// of the members.  If not specified, they default to crypto/sha256 and
+	// HexEncoding, as for a CAStore.
+	Hash        func() hash.Hash
+	KeyEncoding KeyEncoding
+
+	// VirtualNodes is the number of points that each member has on the hash
+	// ring; more points spread keys more evenly.  If not positive, a default
+	// of 128 is used.
+	VirtualNodes int
+
+	// TempDir is the directory in which Put stages data while computing its
+	// key.  If not specified, the system's temporary directory is used.
+	TempDir string
+}
+
+// Router is a Store that distributes objects across a set of member stores
+// using consistent hashing, so that when a member is added or removed, only
+// the keys that now belong to a different member have to move.  Objects are
+// not moved until Rebalance is called; until then, a key that isn't found in
+// the member responsible for it is looked for in all of them, so nothing
+// becomes unreachable.
+type Router struct {
+	opts RouterOptions
+
+	mu       sync.RWMutex
+	members  map[string]Store
+	draining map[string]Store
+	points   []ringPoint
+
+	// Whether every object is known to be in the member responsible for it,
+	// and a count of membership changes, so that Rebalance can tell if one
+	// happened while it was running
+	balanced   bool
+	generation int
+}
+
+var _ Store = (*Router)(nil)
+
+// ringPoint is one of a member's points on the hash ring.
+type ringPoint struct {
+	hash   uint64
+	member string
+}
+
+// RebalanceSummary contains the results of a call to Rebalance.
+type RebalanceSummary struct {
+	// Moved contains the keys that were moved to a different member.
+	Moved []string
+
+	// Bytes is the total size of the objects that were moved.
+	Bytes int64
+}
+
+// NewRouter returns a Router with no members.
+func NewRouter(opts RouterOptions) *Router {
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.KeyEncoding == nil {
+		opts.KeyEncoding = HexEncoding
+	}
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = defaultVirtualNodes
+	}
+	return &Router{
+		opts:     opts,
+		members:  make(map[string]Store),
+		draining: make(map[string]Store),
+		balanced: true,
+	}
+}
+
+// AddMember adds a store to the router under the given name, which decides
+// its position on the ring, and so must stay the same for the store to keep
+// the same keys.  New objects are stored in it immediately; Rebalance moves
+// the existing objects that now belong to it.
+func (rt *Router) AddMember(name string, st Store) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if _, ok := rt.members[name]; ok {
+		return ErrMemberExists
+	}
+	delete(rt.draining, name)
+	rt.members[name] = st
+	for i := 0; i < rt.opts.VirtualNodes; i++ {
+		rt.points = append(rt.points, ringPoint{ringHash(name + "#" + strconv.Itoa(i)), name})
+	}
+	sort.Slice(rt.points, func(i, j int) bool {
+		return rt.points[i].hash < rt.points[j].hash
+	})
+	rt.changed()
+	return nil
+}
+
+// RemoveMember removes the named member from the ring, so that no new objects
+// are stored in it.  Its objects can still be read until Rebalance has moved
+// them to the remaining members, after which the router stops using it.
+func (rt *Router) RemoveMember(name string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	st, ok := rt.members[name]
+	if !ok {
+		return
+	}
+	delete(rt.members, name)
+	rt.draining[name] = st
+	points := rt.points[:0]
+	for _, pt := range rt.points {
+		if pt.member != name {
+			points = append(points, pt)
+		}
+	}
+	rt.points = points
+	rt.changed()
+}
+
+// changed records that the membership has changed.  It must be called with
+// mu held.
+func (rt *Router) changed() {
+	rt.balanced = false
+	rt.generation++
+}
+
+// Members returns the names of the members on the ring, in sorted order.
+func (rt *Router) Members() []string {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	names := make([]string, 0, len(rt.members))
+	for name := range rt.members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ringHash returns the position of the given string on the ring.
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// ownerLocked returns the name and store of the member responsible for the
+// given key.  It must be called with mu held, and there must be a member.
+func (rt *Router) ownerLocked(key string) (string, Store) {
+	h := ringHash(key)
+	i := sort.Search(len(rt.points), func(i int) bool {
+		return rt.points[i].hash >= h
+	})
+	if i == len(rt.points) {
+		i = 0
+	}
+	name := rt.points[i].member
+	return name, rt.members[name]
+}
+
+// owner is like ownerLocked, but takes mu itself, and returns ErrNoMembers if
+// there are no members.
+func (rt *Router) owner(key string) (string, Store, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if len(rt.points) == 0 {
+		return "", nil, ErrNoMembers
+	}
+	name, st := rt.ownerLocked(key)
+	return name, st, nil
+}
+
+// route returns the member responsible for the given key, followed by every
+// other member (including those being drained) if objects may be in the wrong
+// place.
+func (rt *Router) route(key string) ([]Store, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if len(rt.points) == 0 && len(rt.draining) == 0 {
+		return nil, ErrNoMembers
+	}
+	var stores []Store
+	owner := ""
+	if len(rt.points) > 0 {
+		var st Store
+		owner, st = rt.ownerLocked(key)
+		stores = append(stores, st)
+	}
+	if !rt.balanced {
+		stores = append(stores, rt.othersLocked(owner)...)
+	}
+	return stores, nil
+}
+
+// othersLocked returns every member other than the named one, including
+// those being drained, in order of name.  It must be called with mu held.
+func (rt *Router) othersLocked(except string) []Store {
+	var names []string
+	for name := range rt.members {
+		if name != except {
+			names = append(names, name)
+		}
+	}
+	for name := range rt.draining {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stores := make([]Store, 0, len(names))
+	for _, name := range names {
+		if st, ok := rt.members[name]; ok {
+			stores = append(stores, st)
+		} else {
+			stores = append(stores, rt.draining[name])
+		}
+	}
+	return stores
+}
+
+// Put will insert the data from the given io.Reader into the member
+// responsible for it, and return its key.  As the key isn't known until all
+// of the data has been read, the data is first written to a temporary file.
+// If the member computes a different key, ErrKeyMismatch is returned.
+func (rt *Router) Put(r io.Reader) (string, error) {
+	f, err := ioutil.TempFile(rt.opts.TempDir, "castore-router-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hasher := rt.opts.Hash()
+	if _, err = io.Copy(io.MultiWriter(f, hasher), r); err != nil {
+		return "", err
+	}
+	key := rt.opts.KeyEncoding.Encode(hasher.Sum(nil))
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	_, st, err := rt.owner(key)
+	if err != nil {
+		return "", err
+	}
+	if err = putChecked(st, key, f); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Get will return a reader for the data stored with the given key, or nil if
+// it does not exist.
+func (rt *Router) Get(key string) (io.ReadCloser, error) {
+	stores, err := rt.route(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stores {
+		r, err := st.Get(key)
+		if err != nil || r != nil {
+			return r, err
+		}
+	}
+	return nil, nil
+}
+
+// Size will return the size of the data stored with the given key, or -1 if
+// it does not exist.
+func (rt *Router) Size(key string) (int64, error) {
+	stores, err := rt.route(key)
+	if err != nil {
+		return 0, err
+	}
+	for _, st := range stores {
+		size, err := st.Size(key)
+		if err != nil || size >= 0 {
+			return size, err
+		}
+	}
+	return -1, nil
+}
+
+// Exists will return whether data with the given key is stored.
+func (rt *Router) Exists(key string) (bool, error) {
+	stores, err := rt.route(key)
+	if err != nil {
+		return false, err
+	}
+	for _, st := range stores {
+		exists, err := st.Exists(key)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// Delete will remove the data stored with the given key.  Until Rebalance
+// has been called, it is removed from every member.
+func (rt *Router) Delete(key string) error {
+	stores, err := rt.route(key)
+	if err != nil {
+		return err
+	}
+	for _, st := range stores {
+		if err = st.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk will call fn for every object in every member, one member at a time.
+// Objects that are in more than one member because Rebalance hasn't finished
+// are only visited once.
+func (rt *Router) Walk(fn WalkFunc) error {
+	rt.mu.RLock()
+	stores := rt.othersLocked("")
+	balanced := rt.balanced
+	rt.mu.RUnlock()
+
+	var seen map[string]bool
+	if !balanced {
+		seen = make(map[string]bool)
+	}
+	for _, st := range stores {
+		err := st.Walk(func(key string, size int64) error {
+			if seen != nil {
+				if seen[key] {
+					return nil
+				}
+				seen[key] = true
+			}
+			return fn(key, size)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebalance will move every object that isn't in the member responsible for
+// it there, and stop using the members removed with RemoveMember once they
+// have been emptied.  Only the objects affected by membership changes are
+// moved.  If it fails or is interrupted, it can simply be called again.
+func (rt *Router) Rebalance(ctx context.Context) (*RebalanceSummary, error) {
+	rt.mu.RLock()
+	generation := rt.generation
+	if len(rt.points) == 0 {
+		rt.mu.RUnlock()
+		return nil, ErrNoMembers
+	}
+	type source struct {
+		name string
+		st   Store
+	}
+	var sources []source
+	for name, st := range rt.members {
+		sources = append(sources, source{name, st})
+	}
+	for name, st := range rt.draining {
+		sources = append(sources, source{name, st})
+	}
+	rt.mu.RUnlock()
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].name < sources[j].name
+	})
+
+	summary := &RebalanceSummary{}
+	for _, src := range sources {
+		type object struct {
+			key  string
+			size int64
+		}
+		var misplaced []object
+		err := src.st.Walk(func(key string, size int64) error {
+			owner, _, err := rt.owner(key)
+			if err != nil {
+				return err
+			}
+			if owner != src.name {
+				misplaced = append(misplaced, object{key, size})
+			}
+			return ctx.Err()
+		})
+		if err != nil {
+			return summary, err
+		}
+
+		for _, obj := range misplaced {
+			if err = ctx.Err(); err != nil {
+				return summary, err
+			}
+			_, dst, err := rt.owner(obj.key)
+			if err != nil {
+				return summary, err
+			}
+			if _, err = copyOne(dst, src.st, obj.key); errors.Is(err, ErrNotFound) {
+				// Deleted since the walk.
+				continue
+			} else if err != nil {
+				return summary, fmt.Errorf("castore: moving %s: %w", obj.key, err)
+			}
+			if err = src.st.Delete(obj.key); err != nil {
+				return summary, fmt.Errorf("castore: moving %s: %w", obj.key, err)
+			}
+			summary.Moved = append(summary.Moved, obj.key)
+			summary.Bytes += obj.size
+		}
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.generation == generation {
+		for _, src := range sources {
+			delete(rt.draining, src.name)
+		}
+		rt.balanced = true
+	}
+	return summary, nil
+}
@@ -0,0 +1,80 @@
+package castore
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-router"))
+	defer os.RemoveAll(tdir)
+
+	members := map[string]*CAStore{}
+	for _, name := range []string{"a", "b", "c"} {
+		s, err := New(Options{BasePath: filepath.Join(tdir, name)})
+		assert.NoError(t, err)
+		members[name] = s
+	}
+
+	rt := NewRouter(RouterOptions{TempDir: tdir})
+	_, err := rt.Put(strings.NewReader("nowhere"))
+	assert.Equal(t, ErrNoMembers, err)
+	assert.NoError(t, rt.AddMember("a", members["a"]))
+	assert.NoError(t, rt.AddMember("b", members["b"]))
+	assert.Equal(t, ErrMemberExists, rt.AddMember("b", members["b"]))
+
+	var keys []string
+	for i := 0; i < 60; i++ {
+		key, err := rt.Put(strings.NewReader(fmt.Sprintf("value %d", i)))
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	assert.Equal(t, 60, len(keysOf(t, members["a"]))+len(keysOf(t, members["b"])))
+
+	// Everything can still be read after a member is added, and only some
+	// of the objects move to it.
+	assert.NoError(t, rt.AddMember("c", members["c"]))
+	for i, key := range keys {
+		assert.Equal(t, fmt.Sprintf("value %d", i), readKey(t, rt, key))
+	}
+	summary, err := rt.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, summary.Moved)
+	assert.True(t, len(summary.Moved) < 40, "moved %d objects", len(summary.Moved))
+	assert.ElementsMatch(t, summary.Moved, keysOf(t, members["c"]))
+
+	summary, err = rt.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, summary.Moved)
+
+	// A removed member is emptied by Rebalance.
+	rt.RemoveMember("b")
+	for i, key := range keys {
+		assert.Equal(t, fmt.Sprintf("value %d", i), readKey(t, rt, key))
+	}
+	_, err = rt.Rebalance(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, keysOf(t, members["b"]))
+	assert.Equal(t, []string{"a", "c"}, rt.Members())
+	var walked []string
+	assert.NoError(t, rt.Walk(func(key string, size int64) error {
+		walked = append(walked, key)
+		return nil
+	}))
+	assert.ElementsMatch(t, keys, walked)
+
+	assert.NoError(t, rt.Delete(keys[0]))
+	exists, err := rt.Exists(keys[0])
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	size, err := rt.Size(keys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("value 1")), size)
+}
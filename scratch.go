@@ -0,0 +1,114 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// scratchDir is the name of the directory in the store's internal state
+// directory that is returned by Scratch.
+const scratchDir = "scratch"
+
+// Scratch returns the path of a directory in which callers can prepare data
+// before inserting it with PutFile.  Since it is on the same filesystem as the
+// rest of the store, files in it can be moved into place without being copied.
+// Callers should create uniquely-named files in it, preferably with
+// ScratchFile, and remove any that they no longer need.  Files created by
+// ScratchFile are removed by Recover once the process that created them has
+// exited; CleanScratch can be used to remove any others that have been
+// forgotten.
+func (s *CAStore) Scratch() (string, error) {
+	dir, err := s.metaPath(scratchDir)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// ScratchFile creates a new file in the Scratch directory, opened for reading
+// and writing.  Its name identifies this process, so that Recover can remove
+// it if the process exits without removing it.
+func (s *CAStore) ScratchFile() (*os.File, error) {
+	dir, err := s.Scratch()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, tempPrefix+tempOwner)
+}
+
+// PutFile will insert the data in the file at the given path into the store,
+// as with Put, and return its key.  The file itself is moved into the store,
+// so it no longer exists at the given path once PutFile returns successfully;
+// if it is on a different filesystem from the store it is copied and then
+// removed instead.  If PutFile fails, the file is left where it is.  The file
+// must not be modified while PutFile is running.
+func (s *CAStore) PutFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return s.putFrom(f, "", path)
+}
+
+// CleanScratch will remove every file in the Scratch directory that has not
+// been modified for at least maxAge, and return the number removed.
+func (s *CAStore) CleanScratch(maxAge time.Duration) (int, error) {
+	dir, err := s.metaPath(scratchDir)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, ent := range entries {
+		if ent.ModTime().After(cutoff) {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(dir, ent.Name())); err != nil {
+			return removed, err
+		}
+		s.log.Debug("removed scratch file", "name", ent.Name())
+		removed++
+	}
+	return removed, nil
+}
+
+// recoverScratch removes the files in the Scratch directory that were created
+// by ScratchFile in processes that have since exited, and returns the number
+// removed.
+func (s *CAStore) recoverScratch() (int, error) {
+	dir := filepath.Join(s.opts.BasePath, metaDir, scratchDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, ent := range entries {
+		if !orphaned(ent.Name()) {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(dir, ent.Name())); err != nil {
+			return removed, err
+		}
+		s.log.Debug("removed orphaned scratch file", "name", ent.Name())
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,146 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutFile(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-scratch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		MaxSize:  16,
+	})
+	assert.NoError(t, err)
+
+	dir, err := s.Scratch()
+	assert.NoError(t, err)
+	p := filepath.Join(dir, "build")
+	assert.NoError(t, ioutil.WriteFile(p, []byte(TEST_VALUE), 0600))
+
+	key, err := s.PutFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err), "file should have been moved")
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+
+	// Failures leave the file alone.
+	assert.NoError(t, ioutil.WriteFile(p, []byte("this is far too large"), 0600))
+	_, err = s.PutFile(p)
+	assert.Equal(t, ErrSizeExceeded, err)
+	_, err = os.Stat(p)
+	assert.NoError(t, err)
+
+	// Files from elsewhere work too.
+	other := filepath.Join(must_s(ioutil.TempDir("", "castore-test-scratch")), "other")
+	defer os.RemoveAll(filepath.Dir(other))
+	assert.NoError(t, ioutil.WriteFile(other, []byte("other"), 0600))
+	key, err = s.PutFile(other)
+	assert.NoError(t, err)
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	_, err = os.Stat(other)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCleanScratch(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-scratch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	dir, err := s.Scratch()
+	assert.NoError(t, err)
+	old := filepath.Join(dir, "old")
+	assert.NoError(t, ioutil.WriteFile(old, []byte("x"), 0600))
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(old, past, past))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "new"), []byte("x"), 0600))
+
+	removed, err := s.CleanScratch(time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, err = os.Stat(old)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+}
+
+func TestRecoverScratch(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-scratch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// A file from a process that has exited, one from this process, and one
+	// that wasn't created with ScratchFile.
+	dead := exec.Command("true")
+	if err = dead.Run(); err != nil {
+		t.Skip("cannot run a child process")
+	}
+	dir, err := s.Scratch()
+	assert.NoError(t, err)
+	deadName := tempPrefix + strconv.Itoa(dead.Process.Pid) + "-" + processStart(dead.Process.Pid) + "-1234"
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, deadName), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, deadName, "data"), []byte("x"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "unrelated"), []byte("x"), 0600))
+	f, err := s.ScratchFile()
+	assert.NoError(t, err)
+	f.Close()
+	assert.Equal(t, dir, filepath.Dir(f.Name()))
+
+	removed, err := s.Recover()
+	assert.NoError(t, err)
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		assert.Equal(t, 0, removed)
+		return
+	}
+	assert.Equal(t, 1, removed)
+
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	var names []string
+	for _, ent := range entries {
+		names = append(names, ent.Name())
+	}
+	assert.ElementsMatch(t, []string{filepath.Base(f.Name()), "unrelated"}, names)
+}
+
+func TestCopyInto(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-scratch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	src := filepath.Join(tdir, "src")
+	dest := filepath.Join(tdir, "dest")
+	assert.NoError(t, ioutil.WriteFile(src, []byte(TEST_VALUE), 0600))
+	assert.NoError(t, s.copyInto(src, dest))
+
+	data, err := ioutil.ReadFile(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(TEST_VALUE), data)
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+}
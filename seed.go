@@ -0,0 +1,104 @@
+package castore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrSeedNotListable is the error returned by NewWithSeed when the seed's
+// contents cannot be listed, so the store cannot be populated up front.
+var ErrSeedNotListable = errors.New("castore: seed cannot be listed")
+
+// errStopWalk is used internally to stop a walk once it has found what it was
+// looking for.
+var errStopWalk = errors.New("castore: stop walk")
+
+// lazySeed is the Source returned by LazySeed.
+type lazySeed struct {
+	Source
+}
+
+// LazySeed wraps a Source for use with NewWithSeed, so that data is fetched
+// into the store from src the first time it is requested, rather than all at
+// once.
+func LazySeed(src Source) Source {
+	return lazySeed{src}
+}
+
+// NewWithSeed will create a new CAStore as with New, and populate it from the
+// given seed - for example, a remote store, or another CAStore holding a
+// bundle of data - if it is empty.  The seed must be able to list its contents
+// by implementing Walk, as Store does; every object in it is fetched and
+// verified against its key before NewWithSeed returns.
+//
+// If the seed is wrapped with LazySeed, nothing is fetched up front.  Instead,
+// for as long as the store is open, a Get of a key that is not in the store
+// fetches it from the seed, verifies it, and stores it before returning it.
+// In this case, the seed is used whether or not the store was empty.
+func NewWithSeed(opts Options, seed Source) (*CAStore, error) {
+	s, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	if lazy, ok := seed.(lazySeed); ok {
+		s.seed = lazy.Source
+		return s, nil
+	}
+
+	list, ok := seed.(interface{ Walk(fn WalkFunc) error })
+	if !ok {
+		return nil, ErrSeedNotListable
+	}
+	empty, err := s.isEmpty()
+	if err != nil {
+		return nil, err
+	}
+	if !empty {
+		return s, nil
+	}
+
+	var keys []string
+	err = list.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if err = s.fetchFrom(seed, key); err != nil {
+			return nil, fmt.Errorf("castore: could not seed %s: %s", key, err)
+		}
+	}
+	s.log.Info("seeded store", "objects", len(keys))
+	return s, nil
+}
+
+// isEmpty returns whether the store contains no data.
+func (s *CAStore) isEmpty() (bool, error) {
+	err := s.walkFiles(func(key, p string, info os.FileInfo) error {
+		return errStopWalk
+	})
+	if err == errStopWalk {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// fetchFrom copies the data for a single key from src into the store,
+// verifying it against its key.  It returns ErrNotFound if src does not have
+// the key.
+func (s *CAStore) fetchFrom(src Source, key string) error {
+	r, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return ErrNotFound
+	}
+	defer r.Close()
+
+	_, err = s.put(r, key)
+	return err
+}
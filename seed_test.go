@@ -0,0 +1,95 @@
+package castore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getOnly hides everything but the Get method of a Source.
+type getOnly struct {
+	src Source
+}
+
+func (g getOnly) Get(key string) (io.ReadCloser, error) {
+	return g.src.Get(key)
+}
+
+func TestNewWithSeed(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-seed"))
+	defer os.RemoveAll(tdir)
+
+	seed, err := New(Options{BasePath: filepath.Join(tdir, "seed")})
+	assert.NoError(t, err)
+	_, err = seed.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := seed.PutString("other")
+	assert.NoError(t, err)
+
+	s, err := NewWithSeed(Options{BasePath: filepath.Join(tdir, "store")}, seed)
+	assert.NoError(t, err)
+	for _, key := range []string{TEST_KEY, other} {
+		exists, err := s.Exists(key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// A store that already has data is left alone.
+	assert.NoError(t, s.Delete(other))
+	s, err = NewWithSeed(Options{BasePath: filepath.Join(tdir, "store")}, seed)
+	assert.NoError(t, err)
+	exists, err := s.Exists(other)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, err = NewWithSeed(Options{BasePath: filepath.Join(tdir, "empty")}, getOnly{seed})
+	assert.Equal(t, ErrSeedNotListable, err)
+}
+
+func TestLazySeed(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-seed"))
+	defer os.RemoveAll(tdir)
+
+	seed, err := New(Options{BasePath: filepath.Join(tdir, "seed")})
+	assert.NoError(t, err)
+	_, err = seed.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	s, err := NewWithSeed(Options{BasePath: filepath.Join(tdir, "store")}, LazySeed(getOnly{seed}))
+	assert.NoError(t, err)
+
+	// Nothing is fetched until it is needed.
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	r, err := s.Get(TEST_KEY)
+	assert.NoError(t, err)
+	if assert.NotNil(t, r) {
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(TEST_VALUE), data)
+	}
+	exists, err = s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Keys that the seed doesn't have are still missing.
+	r, err = s.Get("0000000000000000000000000000000000000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	// Corrupt data in the seed is rejected.
+	key, err := seed.PutString("other")
+	assert.NoError(t, err)
+	p, _, err := seed.locate(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(p, []byte("tampered"), 0600))
+	_, err = s.Get(key)
+	assert.Equal(t, ErrKeyMismatch, err)
+}
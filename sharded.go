@@ -0,0 +1,206 @@
+package castore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// shardFile is the name of the file in each shard's internal state directory
+// that records its position in a Sharded store, as "<index> <count>".
+const shardFile = "shard"
+
+// ErrShardMismatch is returned by NewSharded if a directory was previously
+// used as a different shard, or as part of a store with a different number of
+// shards; opening it anyway would make existing objects unreachable.
+var ErrShardMismatch = errors.New("castore: shard configuration has changed")
+
+// Sharded is a Store that spreads its objects across several CAStores, each
+// in its own base directory, so that a single store can be larger than one
+// volume, and its IOPS are spread across several disks.  Each object is kept
+// in the shard given by the first bytes of its digest, so the number and
+// order of the shards must not change once objects have been stored; a Router
+// can be used instead for a set of stores that changes over time.
+type Sharded struct {
+	shards []*CAStore
+}
+
+var _ Store = (*Sharded)(nil)
+
+// ShardUsage describes the usage of a single shard of a Sharded store.
+type ShardUsage struct {
+	// BasePath is the shard's base directory.
+	BasePath string
+
+	// Usage is the number and total size of the objects in the shard.
+	Usage Usage
+
+	// DiskUsed and DiskTotal are the number of bytes in use and in total on
+	// the filesystem that the shard is on, or zero if they aren't known.
+	DiskUsed  int64
+	DiskTotal int64
+}
+
+// NewSharded returns a Sharded store with one shard in each of the given
+// directories.  Each shard is a CAStore created with the given options, with
+// the BasePath replaced; the options apply to each shard separately, so for
+// example a Quota limits the size of each shard rather than of the whole
+// store.
+func NewSharded(basePaths []string, opts Options) (*Sharded, error) {
+	if len(basePaths) == 0 {
+		return nil, ErrNoBasePath
+	}
+
+	ss := &Sharded{}
+	for i, p := range basePaths {
+		o := opts
+		o.BasePath = p
+		s, err := New(o)
+		if err == nil {
+			if err = s.checkShard(i, len(basePaths)); err != nil {
+				s.Close()
+			}
+		}
+		if err != nil {
+			ss.Close()
+			return nil, fmt.Errorf("castore: opening shard %s: %w", p, err)
+		}
+		ss.shards = append(ss.shards, s)
+	}
+	return ss, nil
+}
+
+// checkShard makes sure that the store hasn't been used as a different shard,
+// and records that it is the given one.
+func (s *CAStore) checkShard(index, count int) error {
+	p, err := s.metaPath(shardFile)
+	if err != nil {
+		return err
+	}
+	want := fmt.Sprintf("%d %d", index, count)
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return writeFileAtomic(p, []byte(want+"\n"))
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(data)) != want {
+		return ErrShardMismatch
+	}
+	return nil
+}
+
+// Shards returns the CAStore for each shard, in the order of the directories
+// given to NewSharded, for operations (such as Verify) that Sharded doesn't
+// provide itself.
+func (ss *Sharded) Shards() []*CAStore {
+	return append([]*CAStore(nil), ss.shards...)
+}
+
+// shardFor returns the shard that holds the object with the given key.
+// Invalid keys are looked for in the first shard, which won't have them.
+func (ss *Sharded) shardFor(key string) *CAStore {
+	first := ss.shards[0]
+	if !first.validKey(key) {
+		return first
+	}
+	digest, _ := first.opts.KeyEncoding.Decode(key)
+	var prefix [4]byte
+	copy(prefix[:], digest)
+	return ss.shards[binary.BigEndian.Uint32(prefix[:])%uint32(len(ss.shards))]
+}
+
+// Put will insert the data from the given io.Reader into the shard
+// responsible for it, and return its key.  As the key isn't known until all
+// of the data has been read, the data is first written to the first shard's
+// Scratch directory, and so is copied again if it belongs on a different
+// filesystem.
+func (ss *Sharded) Put(r io.Reader) (string, error) {
+	first := ss.shards[0]
+	f, err := first.ScratchFile()
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	hasher := first.opts.Hash()
+	_, tooLarge, err := first.copyLimited(io.MultiWriter(f, hasher), r, first.opts.MaxSize)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if tooLarge {
+		return "", ErrSizeExceeded
+	}
+
+	key := first.opts.KeyEncoding.Encode(hasher.Sum(nil))
+	return ss.shardFor(key).PutFile(f.Name())
+}
+
+// Get will return a reader for the data stored with the given key, or nil if
+// it does not exist.
+func (ss *Sharded) Get(key string) (io.ReadCloser, error) {
+	return ss.shardFor(key).Get(key)
+}
+
+// Size will return the size of the data stored with the given key, or -1 if
+// it does not exist.
+func (ss *Sharded) Size(key string) (int64, error) {
+	return ss.shardFor(key).Size(key)
+}
+
+// Exists will return whether data with the given key is stored.
+func (ss *Sharded) Exists(key string) (bool, error) {
+	return ss.shardFor(key).Exists(key)
+}
+
+// Delete will remove the data stored with the given key.
+func (ss *Sharded) Delete(key string) error {
+	return ss.shardFor(key).Delete(key)
+}
+
+// Walk will call fn for every object in every shard, one shard at a time.
+func (ss *Sharded) Walk(fn WalkFunc) error {
+	for _, s := range ss.shards {
+		if err := s.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage of each shard, in the order of the directories
+// given to NewSharded.
+func (ss *Sharded) Usage() ([]ShardUsage, error) {
+	usage := make([]ShardUsage, len(ss.shards))
+	for i, s := range ss.shards {
+		u, err := s.Usage()
+		if err != nil {
+			return nil, fmt.Errorf("castore: shard %s: %w", s.opts.BasePath, err)
+		}
+		usage[i] = ShardUsage{BasePath: s.opts.BasePath, Usage: u}
+		if used, total, ok := diskUsage(s.opts.BasePath); ok {
+			usage[i].DiskUsed = used
+			usage[i].DiskTotal = total
+		}
+	}
+	return usage, nil
+}
+
+// Close will close every shard, returning the first error.
+func (ss *Sharded) Close() error {
+	var firstErr error
+	for _, s := range ss.shards {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,80 @@
+package castore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharded(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-sharded"))
+	defer os.RemoveAll(tdir)
+
+	paths := []string{filepath.Join(tdir, "a"), filepath.Join(tdir, "b"), filepath.Join(tdir, "c")}
+	ss, err := NewSharded(paths, Options{MaxSize: 16})
+	assert.NoError(t, err)
+
+	var keys []string
+	for i := 0; i < 30; i++ {
+		key, err := ss.Put(strings.NewReader(fmt.Sprintf("value %d", i)))
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	for i, key := range keys {
+		r, err := ss.Get(key)
+		if assert.NoError(t, err) && assert.NotNil(t, r) {
+			data, _ := ioutil.ReadAll(r)
+			r.Close()
+			assert.Equal(t, fmt.Sprintf("value %d", i), string(data))
+		}
+	}
+	_, err = ss.Put(strings.NewReader("this is more than sixteen bytes"))
+	assert.Equal(t, ErrSizeExceeded, err)
+
+	// Every shard gets some of the objects, and the scratch space is cleaned
+	// up.
+	usage, err := ss.Usage()
+	assert.NoError(t, err)
+	var total int64
+	for i, u := range usage {
+		assert.Equal(t, paths[i], u.BasePath)
+		assert.True(t, u.Usage.Objects > 0, "shard %d is empty", i)
+		total += u.Usage.Objects
+	}
+	assert.Equal(t, int64(30), total)
+	scratch := must_s(ss.Shards()[0].Scratch())
+	entries, err := ioutil.ReadDir(scratch)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	var walked []string
+	assert.NoError(t, ss.Walk(func(key string, size int64) error {
+		walked = append(walked, key)
+		return nil
+	}))
+	assert.ElementsMatch(t, keys, walked)
+
+	assert.NoError(t, ss.Delete(keys[0]))
+	exists, err := ss.Exists(keys[0])
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	size, err := ss.Size(keys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("value 1")), size)
+	assert.NoError(t, ss.Close())
+
+	// The shards can't be reordered, or their number changed.
+	_, err = NewSharded([]string{paths[1], paths[0], paths[2]}, Options{})
+	assert.True(t, errors.Is(err, ErrShardMismatch))
+	_, err = NewSharded(paths[:2], Options{})
+	assert.True(t, errors.Is(err, ErrShardMismatch))
+	ss, err = NewSharded(paths, Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, ss.Close())
+}
@@ -0,0 +1,554 @@
+package castore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotsFile is the name of the file in the store's internal state
+	// directory in which the snapshot history is persisted.
+	snapshotsFile = "snapshots.json"
+
+	// atticDir is the name of the directory in the store's internal state
+	// directory in which deleted data referenced by a snapshot is kept.
+	atticDir = "attic"
+)
+
+var (
+	// ErrNoSuchSnapshot is the error returned when referring to a snapshot that
+	// does not exist.
+	ErrNoSuchSnapshot = errors.New("castore: no such snapshot")
+
+	// ErrSnapshotExists is the error returned when attempting to create a
+	// snapshot with the same name as an existing one.
+	ErrSnapshotExists = errors.New("castore: snapshot already exists")
+)
+
+// Snapshot describes a point-in-time record of the keys in the store.
+type Snapshot struct {
+	// Name is the name of the snapshot.
+	Name string `json:"name"`
+
+	// Key is the key of the manifest blob that lists the snapshot's keys.
+	Key string `json:"key"`
+
+	// Created is the time the snapshot was taken.
+	Created time.Time `json:"created"`
+
+	// Count is the number of keys in the snapshot.
+	Count int `json:"count"`
+
+	// Refs are the refs that were set when the snapshot was taken, as a map
+	// from ref name to key.
+	Refs map[string]string `json:"refs,omitempty"`
+}
+
+// Snapshot will record the set of keys currently in the store, and its refs,
+// under the given name.  If the name is empty, one will be generated from the
+// current time.  The list of keys is itself stored as a manifest blob in the
+// store.  A snapshot can be copied out of the store with Restore.
+//
+// While a snapshot is retained (see Options.SnapshotHistory), the data for the
+// keys in it is never removed - deleting such a key only hides it, and it can
+// be brought back with Undelete.
+func (s *CAStore) Snapshot(name string) (*Snapshot, error) {
+	// Snapshots are taken one at a time, but snapMu can't be held while the
+	// manifest is stored, since a Put may evict objects to fit the CacheSize,
+	// and eviction takes snapMu.  Until the snapshot is recorded, the keys in
+	// it are protected through snapPending instead.
+	s.snapTakeMu.Lock()
+	defer s.snapTakeMu.Unlock()
+
+	s.snapMu.Lock()
+	history, err := s.readSnapshots()
+	if err != nil {
+		s.snapMu.Unlock()
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if name == "" {
+		name = now.Format(time.RFC3339Nano)
+	}
+	keys, refs, err := s.snapshotKeys(name, history)
+	if err != nil {
+		s.snapMu.Unlock()
+		return nil, err
+	}
+
+	var manifest []byte
+	for _, key := range keys {
+		manifest = append(manifest, key...)
+		manifest = append(manifest, '\n')
+	}
+	hasher := s.opts.Hash()
+	hasher.Write(manifest)
+	s.snapPending = make(map[string]bool, len(keys)+1)
+	s.snapPending[s.opts.KeyEncoding.Encode(hasher.Sum(nil))] = true
+	for _, key := range keys {
+		s.snapPending[key] = true
+	}
+	s.snapMu.Unlock()
+
+	mkey, err := s.PutBytes(manifest)
+
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	s.snapPending = nil
+	if err != nil {
+		return nil, err
+	}
+
+	snap := Snapshot{
+		Name:    name,
+		Key:     mkey,
+		Created: now,
+		Count:   len(keys),
+		Refs:    refs,
+	}
+	history = append(history, snap)
+
+	// Remove old snapshots.
+	var pruned []Snapshot
+	if n := s.opts.SnapshotHistory; n > 0 && len(history) > n {
+		pruned = history[:len(history)-n]
+		history = history[len(history)-n:]
+	}
+	if err = s.writeSnapshots(history); err != nil {
+		return nil, err
+	}
+	if len(pruned) > 0 {
+		if err = s.purgeSnapshots(history, pruned); err != nil {
+			return nil, err
+		}
+	}
+	return &snap, nil
+}
+
+// snapshotKeys returns the sorted keys currently in the store, not including
+// the manifests of the given snapshot history, and the refs currently set, for
+// a new snapshot with the given name.  It must be called with snapMu held.
+func (s *CAStore) snapshotKeys(name string, history []Snapshot) ([]string, map[string]string, error) {
+	manifests := make(map[string]bool)
+	for _, snap := range history {
+		if snap.Name == name {
+			return nil, nil, ErrSnapshotExists
+		}
+		manifests[snap.Key] = true
+	}
+
+	var keys []string
+	err := s.Walk(func(key string, size int64) error {
+		if !manifests[key] {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(keys)
+	refs, err := s.ListRefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(refs) == 0 {
+		refs = nil
+	}
+	return keys, refs, nil
+}
+
+// Snapshots will return all retained snapshots, oldest first.
+func (s *CAStore) Snapshots() ([]Snapshot, error) {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	return s.readSnapshots()
+}
+
+// ListAt will return the sorted list of keys that were in the store when the
+// named snapshot was taken.
+func (s *CAStore) ListAt(name string) ([]string, error) {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	history, err := s.readSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range history {
+		if snap.Name == name {
+			return s.readManifest(snap.Key)
+		}
+	}
+	return nil, ErrNoSuchSnapshot
+}
+
+// Restore will copy every object that was in the store when the named
+// snapshot was taken, including any that have since been deleted, to dst, and
+// return the number copied.  If dst is a CAStore, the snapshot's refs are also
+// set in it.  As with Copy, objects that dst already has are skipped, so an
+// interrupted Restore can simply be run again, and it stops at the first
+// error, which names the key concerned.  The store itself may be given as dst,
+// to bring back everything that has been deleted since the snapshot.
+func (s *CAStore) Restore(name string, dst Store) (int, error) {
+	s.snapMu.Lock()
+	var snap *Snapshot
+	history, err := s.readSnapshots()
+	for i := range history {
+		if history[i].Name == name {
+			snap = &history[i]
+		}
+	}
+	var keys []string
+	if err == nil && snap == nil {
+		err = ErrNoSuchSnapshot
+	}
+	if err == nil {
+		keys, err = s.readManifest(snap.Key)
+	}
+	s.snapMu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, key := range keys {
+		ok, err := s.restoreRetained(dst, key)
+		if err != nil {
+			return restored, fmt.Errorf("castore: restoring %s: %w", key, err)
+		}
+		if ok {
+			restored++
+		}
+	}
+
+	if d, ok := dst.(*CAStore); ok {
+		names := make([]string, 0, len(snap.Refs))
+		for ref := range snap.Refs {
+			names = append(names, ref)
+		}
+		sort.Strings(names)
+		for _, ref := range names {
+			if err = d.SetRef(ref, snap.Refs[ref]); err != nil {
+				return restored, fmt.Errorf("castore: restoring ref %s: %w", ref, err)
+			}
+		}
+	}
+	return restored, nil
+}
+
+// restoreRetained copies a single object for Restore, returning false if dst
+// already had it.
+func (s *CAStore) restoreRetained(dst Store, key string) (bool, error) {
+	if exists, err := dst.Exists(key); err != nil || exists {
+		return false, err
+	}
+
+	// Once it is open, the data can be read even if it is moved into or out
+	// of the attic.
+	s.snapMu.Lock()
+	r, err := s.openRetained(key)
+	s.snapMu.Unlock()
+	if os.IsNotExist(err) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	err = putChecked(dst, key, r)
+	return err == nil, err
+}
+
+// ExistsAt returns whether the given key was in the store when the named
+// snapshot was taken.
+func (s *CAStore) ExistsAt(name, key string) (bool, error) {
+	keys, err := s.ListAt(name)
+	if err != nil {
+		return false, err
+	}
+	i := sort.SearchStrings(keys, key)
+	return i < len(keys) && keys[i] == key, nil
+}
+
+// Undelete will restore the data for a key that was in the named snapshot but
+// has since been deleted.  No error is returned if the key is currently in the
+// store.  If restoring the data would exceed the store's Quota,
+// ErrQuotaExceeded is returned and the data stays deleted.  Restoring the data
+// is reported to the Observer as an OpUndelete.
+func (s *CAStore) Undelete(name, key string) error {
+	ok, err := s.ExistsAt(name, key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotFound
+	}
+
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+	defer s.holdLedger()()
+
+	exists, err := s.Exists(key)
+	if err != nil || exists {
+		return err
+	}
+
+	start := time.Now()
+	size, err := s.undelete(key)
+	s.observe(Operation{Op: OpUndelete, Key: key, Size: size, Duration: time.Since(start), Err: err})
+	return err
+}
+
+// undelete moves the data for a deleted key from the attic back into the
+// store, and returns its size, or -1 if it could not be restored.  It must be
+// called with snapMu and the ledger held.
+func (s *CAStore) undelete(key string) (int64, error) {
+	src, err := s.atticPath(key)
+	if err != nil {
+		return -1, err
+	}
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return -1, ErrNotFound
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	// The data was released from the quota when it was deleted.
+	if err = s.reserveQuota(info.Size()); err != nil {
+		return -1, err
+	}
+	if err = s.moveInto(src, s.blobPath(key)); err != nil {
+		s.releaseQuota(info.Size())
+		if os.IsNotExist(err) {
+			return -1, ErrNotFound
+		}
+		return -1, err
+	}
+	s.bloomAdd(key)
+	s.indexAdd(key, info.Size())
+	s.ledgerAdd(info.Size())
+	return info.Size(), nil
+}
+
+// protectedKeys returns the set of keys that are referenced by any retained
+// snapshot, including the snapshots' manifests, or by a snapshot that is being
+// taken.  It must be called with snapMu held.
+func (s *CAStore) protectedKeys() (map[string]bool, error) {
+	protected, err := s.retainedKeys()
+	if err != nil || len(s.snapPending) == 0 {
+		return protected, err
+	}
+
+	merged := make(map[string]bool, len(protected)+len(s.snapPending))
+	for key := range protected {
+		merged[key] = true
+	}
+	for key := range s.snapPending {
+		merged[key] = true
+	}
+	return merged, nil
+}
+
+// retainedKeys returns the set of keys that are referenced by any retained
+// snapshot, including the snapshots' manifests.  It must be called with snapMu
+// held.
+func (s *CAStore) retainedKeys() (map[string]bool, error) {
+	history, err := s.readSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var from []string
+	for _, snap := range history {
+		from = append(from, snap.Key)
+	}
+	fromKey := strings.Join(from, ",")
+	if s.protected != nil && s.protectedFrom == fromKey {
+		return s.protected, nil
+	}
+
+	protected := make(map[string]bool)
+	for _, snap := range history {
+		keys, err := s.readManifest(snap.Key)
+		if err != nil {
+			return nil, err
+		}
+		protected[snap.Key] = true
+		for _, key := range keys {
+			protected[key] = true
+		}
+	}
+
+	s.protected = protected
+	s.protectedFrom = fromKey
+	return protected, nil
+}
+
+// purgeSnapshots removes the manifests of the given pruned snapshots, and any
+// deleted data that is no longer referenced by a retained snapshot.  It must be
+// called with snapMu held.
+func (s *CAStore) purgeSnapshots(retained, pruned []Snapshot) error {
+	defer s.holdLedger()()
+	protected, err := s.protectedKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range pruned {
+		s.log.Info("pruning snapshot", "name", snap.Name, "key", snap.Key)
+		if protected[snap.Key] {
+			continue
+		}
+		start := time.Now()
+		if err = s.rehydrateDependents(snap.Key); err != nil {
+			return err
+		}
+		p, info, err := s.locate(snap.Key)
+		if err == nil {
+			err = os.Remove(p)
+		}
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.indexRemove(snap.Key)
+		s.ledgerRemove(info.Size())
+		s.releaseQuota(info.Size())
+		s.evicted(snap.Key, info.Size(), EvictSnapshotPruned, start)
+	}
+
+	dir, err := s.metaPath(atticDir)
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, ent := range entries {
+		if protected[ent.Name()] {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, ent.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.log.Debug("removed unreferenced object from attic", "key", ent.Name())
+	}
+	return nil
+}
+
+// atticPath returns the path at which the data for a deleted key referenced
+// by a snapshot is kept.
+func (s *CAStore) atticPath(key string) (string, error) {
+	dir, err := s.metaPath(atticDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key), nil
+}
+
+// moveToAttic moves the data at the given path into the attic.
+func (s *CAStore) moveToAttic(key, p string) error {
+	dest, err := s.atticPath(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	return os.Rename(p, dest)
+}
+
+// openRetained opens the data for a key that is either in the store or
+// referenced by a snapshot, looking in the attic if it has been deleted.  It
+// must be called with snapMu held.
+func (s *CAStore) openRetained(key string) (io.ReadCloser, error) {
+	p, _, err := s.locate(key)
+	if os.IsNotExist(err) {
+		var pr *packReader
+		if pr, _, err = s.openPacked(key); err == nil {
+			return pr, nil
+		} else if os.IsNotExist(err) {
+			if r, _, derr := s.openDelta(key); !os.IsNotExist(derr) {
+				return r, derr
+			}
+			p, err = s.atticPath(key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// readManifest reads the list of keys in a snapshot manifest, looking in the
+// attic if the manifest itself has been deleted.
+func (s *CAStore) readManifest(key string) ([]string, error) {
+	f, err := s.openRetained(key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// readSnapshots loads the snapshot history.  It must be called with snapMu
+// held.
+func (s *CAStore) readSnapshots() ([]Snapshot, error) {
+	p, err := s.metaPath(snapshotsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Snapshot
+	if err = json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// writeSnapshots atomically replaces the snapshot history.  It must be called
+// with snapMu held.
+func (s *CAStore) writeSnapshots(history []Snapshot) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	p, err := s.metaPath(snapshotsFile)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data)
+}
@@ -0,0 +1,151 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotHistory(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-snapshot"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:        tdir,
+		Transform:       DepthTransformFunc(1),
+		SnapshotHistory: 2,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	_, err = s.Snapshot("tuesday")
+	assert.NoError(t, err)
+	_, err = s.Snapshot("tuesday")
+	assert.Equal(t, ErrSnapshotExists, err)
+
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(key))
+
+	snap, err := s.Snapshot("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, snap.Count)
+
+	keys, err := s.ListAt("tuesday")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key}, keys)
+
+	ok, err := s.ExistsAt(snap.Name, other)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.ExistsAt(snap.Name, key)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = s.ListAt("nonexistent")
+	assert.Equal(t, ErrNoSuchSnapshot, err)
+
+	// Deleted-but-snapshotted data is hidden, but can be restored.
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, s.Undelete("tuesday", key))
+	exists, err = s.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Pruning the oldest snapshot makes its deleted data unrecoverable.
+	assert.NoError(t, s.Delete(key))
+	_, err = s.Snapshot("thursday")
+	assert.NoError(t, err)
+
+	history, err := s.Snapshots()
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, snap.Name, history[0].Name)
+
+	_, err = s.ListAt("tuesday")
+	assert.Equal(t, ErrNoSuchSnapshot, err)
+	_, err = os.Stat(must_s(s.atticPath(key)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUndeleteQuota(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-snapshot"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:        tdir,
+		Quota:           1000,
+		SnapshotHistory: 1,
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Snapshot("tuesday")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(key))
+
+	// Fill the store so that the deleted data no longer fits.
+	used := atomic.LoadInt64(&s.used)
+	filler, err := s.PutBytes(make([]byte, 1000-used-int64(len(TEST_VALUE))+1))
+	assert.NoError(t, err)
+	used = atomic.LoadInt64(&s.used)
+
+	assert.Equal(t, ErrQuotaExceeded, s.Undelete("tuesday", key))
+	exists, err := s.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, used, atomic.LoadInt64(&s.used))
+
+	assert.NoError(t, s.Delete(filler))
+	used = atomic.LoadInt64(&s.used)
+	assert.NoError(t, s.Undelete("tuesday", key))
+	assert.Equal(t, used+int64(len(TEST_VALUE)), atomic.LoadInt64(&s.used))
+}
+
+func TestRestore(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-snapshot"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir + "/src", SnapshotHistory: 2})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+	assert.NoError(t, s.SetRef("latest", key))
+	snap, err := s.Snapshot("monday")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"latest": key}, snap.Refs)
+	assert.NoError(t, s.Delete(key))
+
+	dst, err := New(Options{BasePath: tdir + "/dst"})
+	assert.NoError(t, err)
+	_, err = dst.PutString("other")
+	assert.NoError(t, err)
+	n, err := s.Restore("monday", dst)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{key, other}, keysOf(t, dst))
+	assert.Equal(t, TEST_VALUE, readKey(t, dst, key))
+	ref, err := dst.GetRef("latest")
+	assert.NoError(t, err)
+	assert.Equal(t, key, ref)
+
+	// Restoring into the store itself brings back deleted data.
+	n, err = s.Restore("monday", s)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+
+	_, err = s.Restore("nonexistent", dst)
+	assert.Equal(t, ErrNoSuchSnapshot, err)
+}
@@ -0,0 +1,110 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tempDir is the name of the directory in the store's internal state directory
+// that is used as the default Options.TempDir.
+const tempDir = "tmp"
+
+// tempPrefix is the prefix of the names of temporary files, which is followed
+// by the ID and start time of the process that created them (see tempOwner),
+// so that Recover can tell whether they are still in use.
+const tempPrefix = "castore-"
+
+// tempOwner identifies this process in the names of temporary files.  Since
+// process IDs get reused, the process's start time is included if it can be
+// determined.
+var tempOwner = strconv.Itoa(os.Getpid()) + "-" + processStart(os.Getpid()) + "-"
+
+// tempFile is a file in which incoming data is staged before being moved into
+// place.  Where the operating system supports it, the file is anonymous: it
+// has no name, so it can never be left behind by a crash, and it must be kept
+// open until it is linked into place with linkInto.  Otherwise, it is an
+// ordinary named file, which is moved into place with moveInto.
+type tempFile struct {
+	*os.File
+	anonymous bool
+}
+
+// createTemp is a helper function that will create a new temporary file in the
+// store's TempDir, to which incoming data is written before being moved into
+// place.
+func (s *CAStore) createTemp() (*tempFile, error) {
+	if f := createAnonymousTemp(s.opts.TempDir); f != nil {
+		return &tempFile{f, true}, nil
+	}
+
+	f, err := ioutil.TempFile(s.opts.TempDir, tempPrefix+tempOwner)
+	if os.IsNotExist(err) {
+		// The directory may have been removed from underneath us.
+		if err = os.MkdirAll(s.opts.TempDir, 0700); err != nil {
+			return nil, err
+		}
+		f, err = ioutil.TempFile(s.opts.TempDir, tempPrefix+tempOwner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tempFile{f, false}, nil
+}
+
+// Recover will clean up after processes that crashed while writing to the
+// store: temporary files left behind in its TempDir, and files created with
+// ScratchFile, are removed, and transactions (see Begin) are completed if they
+// were being committed, or discarded otherwise.  It returns the number of
+// files and transactions dealt with.  It is intended to be called at startup, and only touches files
+// whose owning process is known to have exited, so it is safe to call while
+// other processes are writing to the same store.
+func (s *CAStore) Recover() (int, error) {
+	removed, err := s.recoverTxns()
+	if err != nil {
+		return removed, err
+	}
+	n, err := s.recoverScratch()
+	removed += n
+	if err != nil {
+		return removed, err
+	}
+
+	entries, err := ioutil.ReadDir(s.opts.TempDir)
+	if os.IsNotExist(err) {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, err
+	}
+
+	for _, ent := range entries {
+		name := ent.Name()
+		if ent.IsDir() || !orphaned(name) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(s.opts.TempDir, name)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		s.log.Debug("removed orphaned temporary file", "name", name)
+		removed++
+	}
+	return removed, nil
+}
+
+// orphaned returns whether the given name was created with tempPrefix and
+// tempOwner by a process that has since exited.  Anything whose owner can't be
+// identified is assumed not to be.
+func orphaned(name string) bool {
+	if !strings.HasPrefix(name, tempPrefix) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, tempPrefix), "-", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	pid, err := strconv.Atoi(parts[0])
+	return err == nil && !processRunning(pid, parts[1])
+}
@@ -0,0 +1,58 @@
+//go:build linux
+
+package castore
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// createAnonymousTemp creates an unnamed file in the given directory using
+// O_TMPFILE, or returns nil if that is not supported (e.g. by the kernel or
+// filesystem).
+func createAnonymousTemp(dir string) *os.File {
+	fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		return nil
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir, "(anonymous)"))
+}
+
+// linkInto gives the anonymous file f the name dest, creating dest's parent
+// directories as necessary.  Like moveInto, any existing file at dest is
+// replaced atomically.
+func (s *CAStore) linkInto(f *os.File, dest string) error {
+	// Linking a file by descriptor directly requires privileges, but linking
+	// its /proc entry does not.
+	src := "/proc/self/fd/" + strconv.Itoa(int(f.Fd()))
+
+	for i := 0; ; i++ {
+		if err := s.mkdirData(filepath.Dir(dest)); err != nil {
+			return err
+		}
+		err := unix.Linkat(unix.AT_FDCWD, src, unix.AT_FDCWD, dest, unix.AT_SYMLINK_FOLLOW)
+		if err == unix.EEXIST {
+			// Since links cannot replace existing files, give it a unique name
+			// alongside dest, and rename that over the top.
+			tmp := fmt.Sprintf("%s.tmp-%d", dest, rand.Int63())
+			if err = unix.Linkat(unix.AT_FDCWD, src, unix.AT_FDCWD, tmp, unix.AT_SYMLINK_FOLLOW); err == nil {
+				if err = os.Rename(tmp, dest); err != nil {
+					os.Remove(tmp)
+					return err
+				}
+				return nil
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if err != unix.ENOENT || i == moveRetries {
+			return &os.LinkError{Op: "linkat", Old: src, New: dest, Err: err}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkInto(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-staging"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	f, err := s.createTemp()
+	assert.NoError(t, err)
+	f.Close()
+	if !f.anonymous {
+		t.Skip("O_TMPFILE is not supported here")
+	}
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	info, err := os.Stat(s.blobPath(key))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// An existing copy is replaced, without leaving anything behind.
+	assert.NoError(t, ioutil.WriteFile(s.blobPath(key), []byte("corrupt"), 0600))
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(s.blobPath(key))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, string(data))
+
+	leftover, err := filepath.Glob(s.blobPath(key) + ".tmp-*")
+	assert.NoError(t, err)
+	assert.Empty(t, leftover)
+}
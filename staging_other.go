@@ -0,0 +1,19 @@
+//go:build !linux
+
+package castore
+
+import (
+	"errors"
+	"os"
+)
+
+// createAnonymousTemp returns nil, since anonymous temporary files are only
+// supported on Linux.
+func createAnonymousTemp(dir string) *os.File {
+	return nil
+}
+
+// linkInto is never called, since createAnonymousTemp never succeeds.
+func (s *CAStore) linkInto(f *os.File, dest string) error {
+	return errors.New("castore: anonymous temporary files are not supported")
+}
@@ -0,0 +1,113 @@
+package castore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTempDir(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-staging"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// Data is staged within the store while it is being written.
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := s.Put(pr)
+		done <- err
+	}()
+	_, err = pw.Write([]byte("foo"))
+	assert.NoError(t, err)
+
+	// Anonymous files don't appear in the directory at all.
+	want := 1
+	if f := createAnonymousTemp(filepath.Join(tdir, metaDir, tempDir)); f != nil {
+		f.Close()
+		want = 0
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, metaDir, tempDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, want)
+
+	_, err = pw.Write([]byte("bar"))
+	assert.NoError(t, err)
+	assert.NoError(t, pw.Close())
+	assert.NoError(t, <-done)
+
+	entries, err = ioutil.ReadDir(filepath.Join(tdir, metaDir, tempDir))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	// The directory is recreated if it goes missing.
+	assert.NoError(t, os.RemoveAll(filepath.Join(tdir, metaDir)))
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+}
+
+func TestCustomTempDir(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-staging"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: filepath.Join(tdir, "store"),
+		TempDir:  filepath.Join(tdir, "staging"),
+	})
+	assert.NoError(t, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, "staging"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestRecover(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-staging"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// A process that has exited, one that is still running (this one), and
+	// a file that wasn't created by the store.
+	staging := filepath.Join(tdir, metaDir, tempDir)
+	dead := exec.Command("true")
+	if err = dead.Run(); err != nil {
+		t.Skip("cannot run a child process")
+	}
+	deadName := tempPrefix + strconv.Itoa(dead.Process.Pid) + "-" + processStart(dead.Process.Pid) + "-1234"
+	liveName := tempPrefix + tempOwner + "5678"
+	for _, name := range []string{deadName, liveName, "unrelated"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(staging, name), []byte("x"), 0600))
+	}
+
+	removed, err := s.Recover()
+	assert.NoError(t, err)
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		assert.Equal(t, 0, removed)
+		return
+	}
+	assert.Equal(t, 1, removed)
+
+	entries, err := ioutil.ReadDir(staging)
+	assert.NoError(t, err)
+	var names []string
+	for _, ent := range entries {
+		names = append(names, ent.Name())
+	}
+	assert.ElementsMatch(t, []string{liveName, "unrelated"}, names)
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package castore
+
+import (
+	"syscall"
+)
+
+// filesystemMagic maps the filesystem types reported by statfs to the names
+// used by filesystemLimits.
+var filesystemMagic = map[uint32]string{
+	0xef53:     "ext4", // Also ext2 and ext3, which can't be told apart.
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x2fc12fc1: "zfs",
+	0x01021994: "tmpfs",
+	0x6969:     "nfs",
+	0xff534d42: "smb",
+	0xfe534d42: "smb",
+	0x65735546: "fuse",
+	0x4d44:     "vfat",
+	0x2011bab0: "exfat",
+}
+
+// filesystemType returns the name of the type of filesystem that the given
+// path is on, or an empty string if it is not known.
+func filesystemType(path string) string {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return ""
+	}
+	return filesystemMagic[uint32(st.Type)]
+}
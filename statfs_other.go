@@ -0,0 +1,10 @@
+//go:build !linux
+
+package castore
+
+// filesystemType returns the name of the type of filesystem that the given
+// path is on, or an empty string if it is not known, which is always the case
+// on this platform.
+func filesystemType(path string) string {
+	return ""
+}
@@ -0,0 +1,155 @@
+package castore
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync/atomic"
+)
+
+// counterShards is the number of shards used by each shardedCounter.
+const counterShards = 32
+
+// shardedCounter is a counter that can be updated concurrently from many
+// goroutines without them contending on a single cache line.  Updates go to a
+// random shard, and reads sum all of the shards.
+type shardedCounter struct {
+	shards [counterShards]struct {
+		n int64
+
+		// Pad each shard out to its own cache line.
+		_ [56]byte
+	}
+}
+
+func (c *shardedCounter) add(delta int64) {
+	atomic.AddInt64(&c.shards[rand.IntN(counterShards)].n, delta)
+}
+
+func (c *shardedCounter) load() int64 {
+	var total int64
+	for i := range c.shards {
+		total += atomic.LoadInt64(&c.shards[i].n)
+	}
+	return total
+}
+
+// SizeBuckets are the upper bounds, in bytes, of the buckets used for the
+// object size histogram in Stats.  Objects larger than the last bound are
+// counted in an additional, final bucket.  It must not be modified.
+var SizeBuckets = [...]int64{
+	64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10,
+	1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30,
+}
+
+// sizeBucket returns the index of the histogram bucket for the given size.
+func sizeBucket(size int64) int {
+	return sort.Search(len(SizeBuckets), func(i int) bool {
+		return size <= SizeBuckets[i]
+	})
+}
+
+// storeStats contains the counters that back Stats.
+type storeStats struct {
+	puts       shardedCounter
+	putBytes   shardedCounter
+	putErrors  shardedCounter
+	dedups     shardedCounter
+	dedupBytes shardedCounter
+	gets       shardedCounter
+	getMisses  shardedCounter
+	getErrors  shardedCounter
+	deletes    shardedCounter
+
+	// One counter for each of SizeBuckets, plus the overflow bucket.
+	putSizes [len(SizeBuckets) + 1]shardedCounter
+}
+
+// Stats contains statistics about the operations performed on a CAStore since
+// it was created.
+type Stats struct {
+	// Puts is the number of successful Put calls.
+	Puts int64
+
+	// PutBytes is the total number of bytes inserted by successful Put calls.
+	PutBytes int64
+
+	// PutErrors is the number of failed Put calls.
+	PutErrors int64
+
+	// Dedups is the number of successful Put calls whose data was already in
+	// the store, and DedupBytes is their total size: the space that was saved
+	// by not storing the data again.
+	Dedups     int64
+	DedupBytes int64
+
+	// Gets is the number of Get calls that found their key.
+	Gets int64
+
+	// GetMisses is the number of Get calls whose key did not exist.
+	GetMisses int64
+
+	// GetErrors is the number of failed Get calls.
+	GetErrors int64
+
+	// Deletes is the number of keys removed by Delete.
+	Deletes int64
+
+	// PutSizes is a histogram of the sizes of the objects inserted by
+	// successful Put calls.  Each element counts the objects whose size is
+	// no larger than the corresponding element of SizeBuckets (and larger than
+	// the previous one); the final element counts the rest.
+	PutSizes []int64
+}
+
+// Stats will return statistics about the operations performed on the store.
+// It is safe to call at any time, and does not block or slow down concurrent
+// operations; as a result, the individual values are not guaranteed to be
+// consistent with each other while operations are in progress.
+func (s *CAStore) Stats() Stats {
+	sizes := make([]int64, len(s.stats.putSizes))
+	for i := range s.stats.putSizes {
+		sizes[i] = s.stats.putSizes[i].load()
+	}
+
+	return Stats{
+		Puts:       s.stats.puts.load(),
+		PutBytes:   s.stats.putBytes.load(),
+		PutErrors:  s.stats.putErrors.load(),
+		Dedups:     s.stats.dedups.load(),
+		DedupBytes: s.stats.dedupBytes.load(),
+		Gets:       s.stats.gets.load(),
+		GetMisses:  s.stats.getMisses.load(),
+		GetErrors:  s.stats.getErrors.load(),
+		Deletes:    s.stats.deletes.load(),
+		PutSizes:   sizes,
+	}
+}
+
+// Usage describes the amount of data held in a CAStore.
+type Usage struct {
+	// Objects is the number of objects in the store.
+	Objects int64
+
+	// Bytes is the total size of all objects in the store.
+	Bytes int64
+}
+
+// Usage will return the number of objects in the store and their total size.
+// Unless Options.Ledger is set, this requires walking the entire store.
+func (s *CAStore) Usage() (Usage, error) {
+	if s.opts.Ledger {
+		return s.ledgerUsage(), nil
+	}
+	return s.walkUsage()
+}
+
+// walkUsage is the implementation of Usage that walks the entire store.
+func (s *CAStore) walkUsage() (Usage, error) {
+	var u Usage
+	err := s.Walk(func(key string, size int64) error {
+		u.Objects++
+		u.Bytes += size
+		return nil
+	})
+	return u, err
+}
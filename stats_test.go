@@ -0,0 +1,87 @@
+package castore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-stats"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath: tdir,
+		MaxSize:  16,
+	})
+	assert.NoError(t, err)
+
+	// Concurrent writers, with a concurrent reader of the stats.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.Stats()
+			}
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := s.PutString(fmt.Sprintf("%02d-%02d", i, j))
+				assert.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+
+	_, err = s.PutString("this is too large for the store")
+	assert.Equal(t, ErrSizeExceeded, err)
+
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	r.Close()
+	_, err = s.Get("nonexistent")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Delete(key))
+
+	u, err := s.Usage()
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Objects: 80, Bytes: 80 * 5}, u)
+
+	assert.Equal(t, Stats{
+		Puts:       82,
+		PutBytes:   80*5 + 2*int64(len(TEST_VALUE)),
+		PutErrors:  1,
+		Dedups:     1,
+		DedupBytes: int64(len(TEST_VALUE)),
+		Gets:       1,
+		GetMisses:  1,
+		Deletes:    1,
+		PutSizes:   []int64{82, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}, s.Stats())
+}
+
+func TestSizeBucket(t *testing.T) {
+	assert.Equal(t, 0, sizeBucket(0))
+	assert.Equal(t, 0, sizeBucket(64))
+	assert.Equal(t, 1, sizeBucket(65))
+	assert.Equal(t, 2, sizeBucket(1024))
+	assert.Equal(t, len(SizeBuckets)-1, sizeBucket(1<<30))
+	assert.Equal(t, len(SizeBuckets), sizeBucket(1<<30+1))
+}
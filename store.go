@@ -0,0 +1,20 @@
+package castore
+
+import (
+	"io"
+)
+
+// Store is the interface implemented by CAStore, and by anything else that can
+// be used in its place - for example, a client for a remote store.  The
+// semantics of each method are the same as for the corresponding CAStore
+// method.
+type Store interface {
+	Put(r io.Reader) (string, error)
+	Get(key string) (io.ReadCloser, error)
+	Size(key string) (int64, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+	Walk(fn WalkFunc) error
+}
+
+var _ Store = (*CAStore)(nil)
@@ -0,0 +1,149 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrSyncDeleteBoth is the error returned by Sync when DeleteExtraneous is
+// used with SyncBoth, which would delete everything that is not already in
+// both stores.
+var ErrSyncDeleteBoth = errors.New("castore: DeleteExtraneous requires a one-way sync")
+
+// SyncDirection determines which way Sync copies objects.
+type SyncDirection int
+
+const (
+	// SyncBoth copies objects missing from either store into it, so that
+	// both end up with the union of their contents.  This is the default.
+	SyncBoth SyncDirection = iota
+
+	// SyncPush only copies objects from this store to the other one.
+	SyncPush
+
+	// SyncPull only copies objects from the other store to this one.
+	SyncPull
+)
+
+// SyncOptions controls the behaviour of Sync.
+type SyncOptions struct {
+	Direction SyncDirection
+
+	// DeleteExtraneous makes the destination of a one-way sync an exact
+	// mirror of the source, by deleting objects that the source doesn't have.
+	DeleteExtraneous bool
+
+	// DryRun reports what would be copied and deleted without doing it.
+	DryRun bool
+}
+
+// SyncSummary contains the results of a call to Sync.  Each list is sorted.
+type SyncSummary struct {
+	// Pushed and Pulled contain the keys copied to and from the other store.
+	Pushed []string
+	Pulled []string
+
+	// Deleted contains the keys deleted by DeleteExtraneous.
+	Deleted []string
+}
+
+// Sync will make this store and other converge, by comparing the keys that
+// each has and copying only the objects that are missing (see Copy), in one
+// or both directions.  With DryRun set, the returned summary describes what
+// would have been done.
+//
+// Sync stops early if the context is cancelled or an object cannot be copied,
+// in which case the summary lists what was done before then.  Since objects
+// are never modified, running Sync again continues where it left off.
+func (s *CAStore) Sync(ctx context.Context, other Store, opts SyncOptions) (*SyncSummary, error) {
+	if opts.DeleteExtraneous && opts.Direction == SyncBoth {
+		return nil, ErrSyncDeleteBoth
+	}
+
+	local, err := keySet(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := keySet(ctx, other)
+	if err != nil {
+		return nil, err
+	}
+	onlyLocal := difference(local, remote)
+	onlyRemote := difference(remote, local)
+
+	summary := &SyncSummary{}
+	transfer := func(dst, src Store, keys []string, done *[]string) error {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !opts.DryRun {
+				if _, err := copyOne(dst, src, key); err != nil {
+					return fmt.Errorf("castore: copying %s: %w", key, err)
+				}
+			}
+			*done = append(*done, key)
+		}
+		return nil
+	}
+	remove := func(st Store, keys []string) error {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !opts.DryRun {
+				if err := st.Delete(key); err != nil {
+					return fmt.Errorf("castore: deleting %s: %w", key, err)
+				}
+			}
+			summary.Deleted = append(summary.Deleted, key)
+		}
+		return nil
+	}
+
+	if opts.Direction != SyncPull {
+		if err = transfer(other, s, onlyLocal, &summary.Pushed); err != nil {
+			return summary, err
+		}
+		if opts.DeleteExtraneous {
+			if err = remove(other, onlyRemote); err != nil {
+				return summary, err
+			}
+		}
+	}
+	if opts.Direction != SyncPush {
+		if err = transfer(s, other, onlyRemote, &summary.Pulled); err != nil {
+			return summary, err
+		}
+		if opts.DeleteExtraneous {
+			if err = remove(s, onlyLocal); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, nil
+}
+
+// keySet returns the set of keys in the given store.
+func keySet(ctx context.Context, st Store) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	err := st.Walk(func(key string, size int64) error {
+		keys[key] = true
+		return ctx.Err()
+	})
+	return keys, err
+}
+
+// difference returns the sorted keys that are in a but not in b.
+func difference(a, b map[string]bool) []string {
+	var ret []string
+	for key := range a {
+		if !b[key] {
+			ret = append(ret, key)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,90 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSyncStores(t *testing.T) (*CAStore, *CAStore, func()) {
+	adir := must_s(ioutil.TempDir("", "castore-test-sync"))
+	bdir := must_s(ioutil.TempDir("", "castore-test-sync"))
+	a, err := New(Options{BasePath: adir})
+	assert.NoError(t, err)
+	b, err := New(Options{BasePath: bdir})
+	assert.NoError(t, err)
+	return a, b, func() {
+		os.RemoveAll(adir)
+		os.RemoveAll(bdir)
+	}
+}
+
+func keysOf(t *testing.T, s *CAStore) []string {
+	var keys []string
+	assert.NoError(t, s.WalkWithOptions(WalkOptions{Sorted: true}, func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	return keys
+}
+
+func TestSync(t *testing.T) {
+	a, b, cleanup := newSyncStores(t)
+	defer cleanup()
+
+	shared, err := a.PutString("shared")
+	assert.NoError(t, err)
+	_, err = b.PutString("shared")
+	assert.NoError(t, err)
+	onlyA, err := a.PutString("only in a")
+	assert.NoError(t, err)
+	onlyB, err := b.PutString("only in b")
+	assert.NoError(t, err)
+
+	// A dry run changes nothing.
+	summary, err := a.Sync(context.Background(), b, SyncOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncSummary{Pushed: []string{onlyA}, Pulled: []string{onlyB}}, summary)
+	assert.Len(t, keysOf(t, a), 2)
+	assert.Len(t, keysOf(t, b), 2)
+
+	summary, err = a.Sync(context.Background(), b, SyncOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncSummary{Pushed: []string{onlyA}, Pulled: []string{onlyB}}, summary)
+	assert.Equal(t, keysOf(t, a), keysOf(t, b))
+	assert.Contains(t, keysOf(t, a), shared)
+
+	// Nothing left to do.
+	summary, err = a.Sync(context.Background(), b, SyncOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncSummary{}, summary)
+}
+
+func TestSyncMirror(t *testing.T) {
+	a, b, cleanup := newSyncStores(t)
+	defer cleanup()
+
+	onlyA, err := a.PutString("only in a")
+	assert.NoError(t, err)
+	onlyB, err := b.PutString("only in b")
+	assert.NoError(t, err)
+
+	_, err = a.Sync(context.Background(), b, SyncOptions{DeleteExtraneous: true})
+	assert.Equal(t, ErrSyncDeleteBoth, err)
+
+	summary, err := a.Sync(context.Background(), b, SyncOptions{Direction: SyncPush, DeleteExtraneous: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &SyncSummary{Pushed: []string{onlyA}, Deleted: []string{onlyB}}, summary)
+	assert.Equal(t, []string{onlyA}, keysOf(t, b))
+
+	// Pulling into a store that has extra objects removes them.
+	_, err = a.PutString("extra")
+	assert.NoError(t, err)
+	summary, err = a.Sync(context.Background(), b, SyncOptions{Direction: SyncPull, DeleteExtraneous: true})
+	assert.NoError(t, err)
+	assert.Len(t, summary.Deleted, 1)
+	assert.Equal(t, []string{onlyA}, keysOf(t, a))
+}
@@ -0,0 +1,120 @@
+package castore
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// ExportTar will write the objects with the given keys, or every object if
+// none are given, to w as a tar archive in which each object is a regular
+// file named by its key.  The archive is deterministic: the entries are in
+// sorted order, and have no timestamps or ownership, so exporting the same
+// objects always produces the same bytes.  Like Copy, a key that does not
+// exist is an error wrapping ErrNotFound, which names the key concerned.
+func (s *CAStore) ExportTar(w io.Writer, keys ...string) error {
+	if len(keys) == 0 {
+		err := s.Walk(func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		keys = append([]string(nil), keys...)
+	}
+	sort.Strings(keys)
+
+	tw := tar.NewWriter(w)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		if err := s.exportOne(tw, key); err != nil {
+			return fmt.Errorf("castore: exporting %s: %w", key, err)
+		}
+	}
+	return tw.Close()
+}
+
+// ImportTar will store every object in a tar archive read from r, such as one
+// written by ExportTar, and return the number stored.  Each regular file in the
+// archive must be named by the key of its contents; if it isn't, ImportTar
+// fails with an error wrapping ErrKeyMismatch, which names the file concerned.
+// Objects that the store already has are skipped, so an interrupted import can
+// simply be run again.  Other kinds of entry, such as directories, are
+// ignored.
+func (s *CAStore) ImportTar(r io.Reader) (int, error) {
+	tr := tar.NewReader(r)
+	imported := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return imported, nil
+		}
+		if err != nil {
+			return imported, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		key := path.Base(hdr.Name)
+		ok, err := s.importOne(tr, key)
+		if err != nil {
+			return imported, fmt.Errorf("castore: importing %s: %w", hdr.Name, err)
+		}
+		if ok {
+			imported++
+		}
+	}
+}
+
+// importOne stores a single object from the archive, returning false if the
+// store already had it.
+func (s *CAStore) importOne(r io.Reader, key string) (bool, error) {
+	if !s.validKey(key) {
+		return false, ErrKeyMismatch
+	}
+	if exists, err := s.Exists(key); err != nil || exists {
+		return false, err
+	}
+	_, err := s.put(r, key)
+	return err == nil, err
+}
+
+// exportOne writes a single object to the archive.
+func (s *CAStore) exportOne(tw *tar.Writer, key string) error {
+	size, err := s.Size(key)
+	if err != nil {
+		return err
+	}
+	r, err := s.Get(key)
+	if err != nil {
+		return err
+	}
+	if r == nil || size < 0 {
+		if r != nil {
+			r.Close()
+		}
+		return ErrNotFound
+	}
+	defer r.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     key,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.copyBuffer(tw, r)
+	return err
+}
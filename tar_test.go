@@ -0,0 +1,90 @@
+package castore
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportTar(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-tar"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := s.PutString("other")
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.ExportTar(&buf))
+	contents := make(map[string]string)
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(data)
+	}
+	assert.Equal(t, keysOf(t, s), names)
+	assert.Equal(t, map[string]string{key: TEST_VALUE, other: "other"}, contents)
+
+	// Exporting the same keys gives the same archive.
+	var again bytes.Buffer
+	assert.NoError(t, s.ExportTar(&again, other, key, other))
+	assert.Equal(t, buf.Bytes(), again.Bytes())
+
+	err = s.ExportTar(ioutil.Discard, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestImportTar(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-tar"))
+	defer os.RemoveAll(tdir)
+
+	src, err := New(Options{BasePath: tdir + "/src"})
+	assert.NoError(t, err)
+	key, err := src.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	other, err := src.PutString("other")
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, src.ExportTar(&buf))
+
+	dst, err := New(Options{BasePath: tdir + "/dst"})
+	assert.NoError(t, err)
+	_, err = dst.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	n, err := dst.ImportTar(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, TEST_VALUE, readKey(t, dst, key))
+	assert.Equal(t, "other", readKey(t, dst, other))
+
+	// An entry whose contents don't match its name is rejected.
+	buf.Reset()
+	tw := tar.NewWriter(&buf)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "dir/" + TEST_KEY, Size: 5, Mode: 0644}))
+	_, err = tw.Write([]byte("wrong"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+
+	dst, err = New(Options{BasePath: tdir + "/empty"})
+	assert.NoError(t, err)
+	_, err = dst.ImportTar(&buf)
+	assert.ErrorIs(t, err, ErrKeyMismatch)
+	assert.Empty(t, keysOf(t, dst))
+}
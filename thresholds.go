@@ -0,0 +1,99 @@
+package castore
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// diskCheckInterval is the minimum time between checks of the free space on
+// the store's filesystem for UsageThresholds.
+const diskCheckInterval = time.Second
+
+// UsageResource identifies what a UsageAlert is about.
+type UsageResource string
+
+const (
+	// UsageQuota is the store's Quota.
+	UsageQuota UsageResource = "quota"
+
+	// UsageDisk is the filesystem that the store is on.
+	UsageDisk UsageResource = "disk"
+)
+
+// UsageAlert describes a crossing of one of Options.UsageThresholds, as passed
+// to Hooks.OnThreshold.
+type UsageAlert struct {
+	Resource UsageResource
+
+	// Threshold is the threshold that was crossed, as a fraction.
+	Threshold float64
+
+	// Rising is true if usage has gone above the threshold, and false if it
+	// has dropped back below it.
+	Rising bool
+
+	// Used and Total are the number of bytes in use and available in total.
+	Used  int64
+	Total int64
+}
+
+// checkUsage compares the usage of the store's quota and filesystem against
+// its UsageThresholds, firing Hooks.OnThreshold for any that have been
+// crossed since the last check.
+func (s *CAStore) checkUsage() {
+	if len(s.opts.UsageThresholds) == 0 {
+		return
+	}
+
+	if s.opts.Quota > 0 {
+		s.checkThresholds(UsageQuota, atomic.LoadInt64(&s.used), s.opts.Quota)
+	}
+
+	// Free space is checked less often, since it takes a system call, but
+	// anything filling the disk is likely to take far longer than that.
+	s.thresholdMu.Lock()
+	due := time.Since(s.diskChecked) >= diskCheckInterval
+	if due {
+		s.diskChecked = time.Now()
+	}
+	s.thresholdMu.Unlock()
+	if due {
+		if used, total, ok := diskUsage(s.opts.BasePath); ok {
+			s.checkThresholds(UsageDisk, used, total)
+		}
+	}
+}
+
+// checkThresholds fires alerts for the given resource if its usage has moved
+// past any thresholds since the last check.
+func (s *CAStore) checkThresholds(res UsageResource, used, total int64) {
+	if total <= 0 {
+		return
+	}
+	frac := float64(used) / float64(total)
+
+	thresholds := s.opts.UsageThresholds
+	level := sort.SearchFloat64s(thresholds, frac)
+	for level < len(thresholds) && thresholds[level] <= frac {
+		level++
+	}
+
+	s.thresholdMu.Lock()
+	if s.thresholdLevels == nil {
+		s.thresholdLevels = make(map[UsageResource]int)
+	}
+	prev := s.thresholdLevels[res]
+	s.thresholdLevels[res] = level
+	s.thresholdMu.Unlock()
+
+	// Alerts are fired in the order in which the thresholds were crossed.
+	for i := prev; i < level; i++ {
+		s.log.Warn("usage threshold exceeded", "resource", string(res), "threshold", thresholds[i], "used", used, "total", total)
+		s.opts.Hooks.onThreshold(UsageAlert{res, thresholds[i], true, used, total})
+	}
+	for i := prev - 1; i >= level; i-- {
+		s.log.Info("usage dropped below threshold", "resource", string(res), "threshold", thresholds[i], "used", used, "total", total)
+		s.opts.Hooks.onThreshold(UsageAlert{res, thresholds[i], false, used, total})
+	}
+}
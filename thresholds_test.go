@@ -0,0 +1,83 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageThresholds(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-thresholds"))
+	defer os.RemoveAll(tdir)
+
+	var alerts []UsageAlert
+	s, err := New(Options{
+		BasePath:        tdir,
+		Quota:           10,
+		UsageThresholds: []float64{0.95, 0.5},
+		Hooks: Hooks{
+			OnThreshold: func(alert UsageAlert) {
+				if alert.Resource == UsageQuota {
+					alerts = append(alerts, alert)
+				}
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString("abcd")
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	// Crossing both thresholds at once fires both, lowest first.
+	key, err := s.PutString(strings.Repeat("x", 6))
+	assert.NoError(t, err)
+	assert.Equal(t, []UsageAlert{
+		{UsageQuota, 0.5, true, 10, 10},
+		{UsageQuota, 0.95, true, 10, 10},
+	}, alerts)
+
+	// Nothing more fires until usage changes level.
+	alerts = nil
+	_, err = s.PutString("abcd")
+	assert.NoError(t, err)
+	assert.Empty(t, alerts)
+
+	assert.NoError(t, s.Delete(key))
+	assert.Equal(t, []UsageAlert{
+		{UsageQuota, 0.95, false, 4, 10},
+		{UsageQuota, 0.5, false, 4, 10},
+	}, alerts)
+}
+
+func TestDiskUsage(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-thresholds"))
+	defer os.RemoveAll(tdir)
+
+	used, total, ok := diskUsage(tdir)
+	if !ok {
+		t.Skip("disk usage is not supported on this platform")
+	}
+	assert.True(t, total > 0)
+	assert.True(t, used >= 0 && used <= total)
+
+	// Every filesystem is at least 0% full.
+	var alerts []UsageAlert
+	s, err := New(Options{
+		BasePath:        tdir,
+		UsageThresholds: []float64{0},
+		Hooks: Hooks{
+			OnThreshold: func(alert UsageAlert) { alerts = append(alerts, alert) },
+		},
+	})
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	if assert.Len(t, alerts, 1) {
+		assert.Equal(t, UsageDisk, alerts[0].Resource)
+		assert.True(t, alerts[0].Rising)
+	}
+}
@@ -0,0 +1,122 @@
+package castore
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that limits a rate in bytes per second,
+// allowing bursts of up to a second's worth of data.
+type rateLimiter struct {
+	rate float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// wait accounts for n bytes having been transferred, sleeping for as long as
+// is needed to bring the rate back within the limit.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// Go into debt, so that later callers wait for us too.
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader reads from r no faster than every one of its limiters
+// allows.
+type throttledReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, l := range t.limiters {
+			l.wait(n)
+		}
+	}
+	return n, err
+}
+
+// throttledReadCloser is a throttledReader for a reader returned by Get.
+type throttledReadCloser struct {
+	throttledReader
+	c io.Closer
+}
+
+func (t *throttledReadCloser) Close() error {
+	return t.c.Close()
+}
+
+// throttledReadSeeker is a throttledReadCloser whose reader can also seek, so
+// that callers can still skip data without reading it.
+type throttledReadSeeker struct {
+	throttledReadCloser
+	s io.Seeker
+}
+
+func (t *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return t.s.Seek(offset, whence)
+}
+
+// limiters returns the limiters that apply to a single operation with the
+// given store-wide limiter and per-operation rate.
+func limiters(global *rateLimiter, opRate int64) []*rateLimiter {
+	var ls []*rateLimiter
+	if global != nil {
+		ls = append(ls, global)
+	}
+	if opRate > 0 {
+		ls = append(ls, newRateLimiter(opRate))
+	}
+	return ls
+}
+
+// throttleReads applies ReadRate and OpReadRate to a reader returned by Get.
+func (s *CAStore) throttleReads(rc io.ReadCloser) io.ReadCloser {
+	ls := limiters(s.readLimiter, s.opts.OpReadRate)
+	if len(ls) == 0 {
+		return rc
+	}
+	t := throttledReadCloser{throttledReader{rc, ls}, rc}
+	if seeker, ok := rc.(io.Seeker); ok {
+		return &throttledReadSeeker{t, seeker}
+	}
+	return &t
+}
+
+// throttleWrites applies WriteRate and OpWriteRate to the data for a Put.
+func (s *CAStore) throttleWrites(r io.Reader) io.Reader {
+	ls := limiters(s.writeLimiter, s.opts.OpWriteRate)
+	if len(ls) == 0 {
+		return r
+	}
+	return &throttledReader{r, ls}
+}
@@ -0,0 +1,54 @@
+package castore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+
+	// The first second's worth is allowed straight away.
+	start := time.Now()
+	l.wait(1000)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+
+	// After that, the rate applies.
+	l.wait(100)
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+}
+
+func TestThrottle(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-throttle"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:   tdir,
+		WriteRate:  100000,
+		OpReadRate: 1000,
+	})
+	assert.NoError(t, err)
+
+	data := bytes.Repeat([]byte("x"), 1100)
+	key, err := s.PutBytes(data)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	r, err := s.Get(key)
+	assert.NoError(t, err)
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+
+	// Readers can still seek.
+	_, ok := r.(io.Seeker)
+	assert.True(t, ok)
+}
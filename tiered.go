@@ -0,0 +1,234 @@
+package castore
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TieredOptions controls the behaviour of a Tiered store.
+type TieredOptions struct {
+	// MaxLocalBytes, if positive, is the maximum total size of the objects
+	// in the local tier.  Once it is exceeded, objects are evicted from the
+	// local tier (with CAStore.Evict, so the local store's EvictScore
+	// applies) until it fits again.  They remain available from the remote
+	// tier.
+	MaxLocalBytes int64
+
+	// WriteBack makes Put return as soon as the data is in the local tier.
+	// It is then uploaded to the remote tier in the background, retrying
+	// until it succeeds; the queue of pending uploads is kept on disk in the
+	// local store, so uploads survive a restart.  Objects are not evicted
+	// from the local tier until they have been uploaded.  Only one
+	// write-back Tiered store should use a given local store at a time.
+	WriteBack bool
+
+	// RetryInterval is how long to wait after a failed upload before trying
+	// again, when WriteBack is set.  If not positive, a default of five
+	// seconds is used.
+	RetryInterval time.Duration
+}
+
+// Tiered is a Store that keeps a fast local CAStore in front of a slower
+// remote one, such as a client for a store on another machine.  Reads are
+// served from the local tier when possible; on a miss, the data is fetched
+// from the remote tier and kept locally for next time.  Writes go to both
+// tiers, so the remote tier always has a complete copy - or, with WriteBack,
+// will once all pending uploads have completed.
+type Tiered struct {
+	local  *CAStore
+	remote Store
+	opts   TieredOptions
+
+	// Approximate total size of the local tier; accessed atomically
+	localBytes int64
+
+	// State for write-back mode; see writeback.go
+	queueDir  string
+	queueMu   sync.Mutex
+	queued    map[string]bool
+	idle      chan struct{}
+	kick      chan struct{}
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Store = (*Tiered)(nil)
+
+// NewTiered returns a Tiered store with the given tiers.  If MaxLocalBytes is
+// set, the local store is walked to find its current size, and changes made
+// to it other than through the Tiered store are not accounted for.  If
+// WriteBack is set, any uploads left pending by a previous Tiered store over
+// the same local store are resumed, and the Tiered store must be closed with
+// Close.
+func NewTiered(local *CAStore, remote Store, opts TieredOptions) (*Tiered, error) {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = defaultRetryInterval
+	}
+	t := &Tiered{local: local, remote: remote, opts: opts}
+	if opts.WriteBack {
+		if err := t.startWriteBack(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MaxLocalBytes > 0 {
+		u, err := local.Usage()
+		if err != nil {
+			return nil, err
+		}
+		t.localBytes = u.Bytes
+		t.enforceLimit()
+	}
+	return t, nil
+}
+
+// Put will insert the data from the given io.Reader into the local tier, then
+// copy it to the remote tier, and return its key.  The data is read only
+// once.  With WriteBack, the copy is queued rather than waited for.
+func (t *Tiered) Put(r io.Reader) (string, error) {
+	if t.opts.WriteBack && t.isClosing() {
+		return "", os.ErrClosed
+	}
+	res, err := t.local.putReserved(r, "", "", false)
+	if err != nil {
+		return "", err
+	}
+	if t.opts.WriteBack {
+		err = t.enqueue(res.key)
+	} else {
+		_, err = copyOne(t.remote, t.local, res.key)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !res.dedup {
+		t.added(res.size)
+	}
+	return res.key, nil
+}
+
+// Get will return a reader for the data stored with the given key, from the
+// local tier if it is there and otherwise from the remote tier, in which case
+// the data is also stored locally.  As with CAStore.Get, the reader is nil if
+// the key exists in neither tier.
+func (t *Tiered) Get(key string) (io.ReadCloser, error) {
+	r, err := t.local.Get(key)
+	if err != nil || r != nil {
+		return r, err
+	}
+
+	err = t.local.fetchFrom(t.remote, key)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		// The data may just be unsuitable for the local tier (e.g. too
+		// large), so serve it straight from the remote one.
+		t.local.log.Debug("could not populate local tier", "key", key, "err", err)
+		return t.remote.Get(key)
+	}
+	if t.opts.MaxLocalBytes > 0 {
+		if size, err := t.local.Size(key); err == nil {
+			t.added(size)
+		}
+	}
+	return t.local.Get(key)
+}
+
+// Size will return the size of the data stored with the given key in either
+// tier, or -1 if it does not exist.
+func (t *Tiered) Size(key string) (int64, error) {
+	size, err := t.local.Size(key)
+	if err != nil || size >= 0 {
+		return size, err
+	}
+	return t.remote.Size(key)
+}
+
+// Exists will return whether the given key exists in either tier.
+func (t *Tiered) Exists(key string) (bool, error) {
+	exists, err := t.local.Exists(key)
+	if err != nil || exists {
+		return exists, err
+	}
+	return t.remote.Exists(key)
+}
+
+// Delete will delete the given key from both tiers, cancelling its upload if
+// it is pending.
+func (t *Tiered) Delete(key string) error {
+	if t.opts.WriteBack {
+		if err := t.dequeue(key); err != nil {
+			return err
+		}
+	}
+	size, err := t.local.Size(key)
+	if err != nil {
+		return err
+	}
+	if err = t.local.Delete(key); err != nil {
+		return err
+	}
+	if size > 0 {
+		atomic.AddInt64(&t.localBytes, -size)
+	}
+	return t.remote.Delete(key)
+}
+
+// Walk will call fn for every object in the remote tier, which holds all of
+// the data in the store, followed by any that are waiting to be uploaded.
+func (t *Tiered) Walk(fn WalkFunc) error {
+	if !t.opts.WriteBack {
+		return t.remote.Walk(fn)
+	}
+
+	pending := t.pending()
+	err := t.remote.Walk(func(key string, size int64) error {
+		delete(pending, key)
+		return fn(key, size)
+	})
+	if err != nil {
+		return err
+	}
+	for key := range pending {
+		size, err := t.local.Size(key)
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			continue
+		}
+		if err = fn(key, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// added accounts for an object of the given size that is new to the local
+// tier, and evicts others if the tier has grown too large.
+func (t *Tiered) added(size int64) {
+	if t.opts.MaxLocalBytes <= 0 || size <= 0 {
+		return
+	}
+	atomic.AddInt64(&t.localBytes, size)
+	t.enforceLimit()
+}
+
+// enforceLimit evicts objects from the local tier until it is within
+// MaxLocalBytes.
+func (t *Tiered) enforceLimit() {
+	over := atomic.LoadInt64(&t.localBytes) - t.opts.MaxLocalBytes
+	if over <= 0 {
+		return
+	}
+	u, err := t.local.Evict(context.Background(), over)
+	atomic.AddInt64(&t.localBytes, -u.Bytes)
+	if err != nil {
+		t.local.log.Warn("could not evict from local tier", "err", err)
+	}
+}
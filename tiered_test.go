@@ -0,0 +1,94 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiered(t *testing.T) {
+	ldir := must_s(ioutil.TempDir("", "castore-test-tiered"))
+	defer os.RemoveAll(ldir)
+	rdir := must_s(ioutil.TempDir("", "castore-test-tiered"))
+	defer os.RemoveAll(rdir)
+
+	local, err := New(Options{BasePath: ldir})
+	assert.NoError(t, err)
+	remote, err := New(Options{BasePath: rdir})
+	assert.NoError(t, err)
+	ts, err := NewTiered(local, remote, TieredOptions{MaxLocalBytes: 10})
+	assert.NoError(t, err)
+
+	// Puts go to both tiers.
+	key, err := ts.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	for _, s := range []*CAStore{local, remote} {
+		exists, err := s.Exists(key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// Misses are fetched from the remote tier, and kept locally.
+	other, err := remote.PutString("other")
+	assert.NoError(t, err)
+	r, err := ts.Get(other)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "other", string(data))
+	exists, err := local.Exists(other)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The local tier is kept within its limit, but everything is still
+	// available.
+	big, err := ts.Put(strings.NewReader("eight by"))
+	assert.NoError(t, err)
+	u, err := local.Usage()
+	assert.NoError(t, err)
+	assert.True(t, u.Bytes <= 10, "local tier holds %d bytes", u.Bytes)
+	for _, k := range []string{key, other, big} {
+		size, err := ts.Size(k)
+		assert.NoError(t, err)
+		assert.True(t, size > 0)
+	}
+
+	r, err = ts.Get("0000000000000000000000000000000000000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	assert.NoError(t, ts.Delete(key))
+	exists, err = ts.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTieredDedup(t *testing.T) {
+	ldir := must_s(ioutil.TempDir("", "castore-test-tiered"))
+	defer os.RemoveAll(ldir)
+	rdir := must_s(ioutil.TempDir("", "castore-test-tiered"))
+	defer os.RemoveAll(rdir)
+
+	local, err := New(Options{BasePath: ldir})
+	assert.NoError(t, err)
+	remote, err := New(Options{BasePath: rdir})
+	assert.NoError(t, err)
+	ts, err := NewTiered(local, remote, TieredOptions{MaxLocalBytes: 10})
+	assert.NoError(t, err)
+
+	// Putting the same data again doesn't count it again, so nothing needs
+	// to be evicted.
+	for i := 0; i < 5; i++ {
+		_, err = ts.Put(strings.NewReader(TEST_VALUE))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(len(TEST_VALUE)), atomic.LoadInt64(&ts.localBytes))
+	exists, err := local.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
@@ -26,3 +26,12 @@ func TestDepthTransformFunc(t *testing.T) {
 		d2("abc")
 	})
 }
+
+func TestShardTransformFunc(t *testing.T) {
+	assert.Equal(t, []string{"abc", "def"}, ShardTransformFunc(2, 3)("abcdef"))
+	assert.Equal(t, []string{"a"}, ShardTransformFunc(1, 1)("abcdef"))
+	assert.Equal(t, DepthTransformFunc(2)("abcdef"), ShardTransformFunc(2, 2)("abcdef"))
+	assert.Panics(t, func() {
+		ShardTransformFunc(2, 3)("abcde")
+	})
+}
@@ -0,0 +1,301 @@
+package castore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// txnDir is the name of the directory in the store's internal state directory
+// in which transactions stage their data.
+const txnDir = "txn"
+
+// txnCommitFile is the name of the file that is written to a transaction's
+// directory once it has been committed, listing the keys to be promoted.
+const txnCommitFile = "commit"
+
+// ErrTxDone is the error returned when using a transaction that has already
+// been committed or rolled back.
+var ErrTxDone = errors.New("castore: transaction has already been committed or rolled back")
+
+// Tx is a set of Puts that are stored together, created by Begin: either all
+// of them are stored or none are.  This is not isolation, though - while a
+// transaction is being committed, other readers of the store may see some of
+// its objects before the rest.  It is safe to use from multiple goroutines.
+type Tx struct {
+	s   *CAStore
+	dir string
+
+	mu   sync.Mutex
+	keys []string
+	seen map[string]bool
+	done bool
+}
+
+// Begin starts a transaction, so that several related objects - for example,
+// the chunks of a file and the manifest that lists them - can be added to the
+// store such that either all of them or none of them are stored.  Data
+// written with Tx.Put is staged within the store, and is only moved into
+// place by Tx.Commit.
+//
+// Commit moves the objects into place one at a time, so a concurrent Get or
+// Walk may see some of them but not yet the others; callers that need to see
+// all of them at once should, for example, Put the manifest only after the
+// transaction holding the chunks has been committed.  Commit first records
+// that it is moving them, so that if it fails or the process crashes part way
+// through, Recover completes the transaction; an uncommitted transaction left
+// behind by a crash is discarded by Recover instead.
+func (s *CAStore) Begin() (*Tx, error) {
+	root, err := s.metaPath(txnDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(root, tempPrefix+tempOwner)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{s: s, dir: dir, seen: make(map[string]bool)}, nil
+}
+
+// Put will stage the data from the given io.Reader, and return the key that
+// it will have once the transaction is committed.  The same checks are made
+// as by CAStore.Put, except for the Quota, which is checked by Commit.
+func (tx *Tx) Put(r io.Reader) (string, error) {
+	if tx.isDone() {
+		return "", ErrTxDone
+	}
+	s := tx.s
+
+	tfile, err := ioutil.TempFile(tx.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	hasher := s.opts.Hash()
+	written, tooLarge, err := s.copyLimited(io.MultiWriter(tfile, hasher), s.throttleWrites(r), s.opts.MaxSize)
+	if cerr := tfile.Close(); err == nil {
+		err = cerr
+	}
+	switch {
+	case err != nil:
+	case tooLarge:
+		err = ErrSizeExceeded
+	case written == 0 && s.opts.RejectEmpty:
+		err = ErrEmpty
+	case written < s.opts.MinSize:
+		err = ErrSizeTooSmall
+	}
+	if err != nil {
+		s.removeTemp(tfile.Name())
+		return "", err
+	}
+
+	key := s.opts.KeyEncoding.Encode(hasher.Sum(nil))
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		s.removeTemp(tfile.Name())
+		return "", ErrTxDone
+	}
+	if err = os.Rename(tfile.Name(), filepath.Join(tx.dir, key)); err != nil {
+		s.removeTemp(tfile.Name())
+		return "", err
+	}
+	if !tx.seen[key] {
+		tx.seen[key] = true
+		tx.keys = append(tx.keys, key)
+	}
+	return key, nil
+}
+
+// Commit will make every object staged by the transaction visible in the
+// store.  The space for the objects is reserved against the store's Quota
+// before any are moved into place, so if they would take the store over it,
+// nothing is stored and ErrQuotaExceeded is returned.
+//
+// If Commit fails after it has started moving objects into place, the
+// transaction stays committed, and the remaining objects are moved into place
+// by Recover, which may be called by this process as soon as the cause of the
+// failure has been dealt with.
+func (tx *Tx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	s := tx.s
+
+	// Reconcile mustn't recount the store while space is reserved for
+	// objects that haven't been moved into place yet.
+	defer s.holdLedger()()
+
+	reserved := make(map[string]int64)
+	if s.trackingUsage() {
+		var needed int64
+		for _, key := range tx.keys {
+			if exists, _ := s.Exists(key); exists {
+				continue
+			}
+			if info, err := os.Stat(filepath.Join(tx.dir, key)); err == nil {
+				reserved[key] = info.Size()
+				needed += info.Size()
+			}
+		}
+		if err := s.reserveQuota(needed); err != nil {
+			os.RemoveAll(tx.dir)
+			return err
+		}
+	}
+
+	// Recover leaves this process's transactions alone until they have been
+	// committed, so nothing else can have claimed this one yet.
+	s.claimTxn(tx.dir)
+	defer s.releaseTxn(tx.dir)
+
+	// Once this has been written, the transaction will be completed even if
+	// we crash.
+	journal := strings.Join(tx.keys, "\n")
+	if err := writeFileAtomic(filepath.Join(tx.dir, txnCommitFile), []byte(journal)); err != nil {
+		for _, size := range reserved {
+			s.releaseQuota(size)
+		}
+		os.RemoveAll(tx.dir)
+		return err
+	}
+
+	err := s.promoteTxn(tx.dir, tx.keys, reserved)
+	if err != nil {
+		s.log.Warn("transaction failed part way through commit; call Recover to complete it", "dir", filepath.Base(tx.dir), "err", err)
+	}
+	return err
+}
+
+// Rollback will discard every object staged by the transaction.  It is safe
+// to call after Commit, in which case it does nothing, so it can be deferred.
+func (tx *Tx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	return os.RemoveAll(tx.dir)
+}
+
+func (tx *Tx) isDone() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.done
+}
+
+// claimTxn marks the transaction in the given directory as being promoted by
+// this process, returning false if it already is.
+func (s *CAStore) claimTxn(dir string) bool {
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+	if s.txnActive[dir] {
+		return false
+	}
+	if s.txnActive == nil {
+		s.txnActive = make(map[string]bool)
+	}
+	s.txnActive[dir] = true
+	return true
+}
+
+// releaseTxn undoes claimTxn.
+func (s *CAStore) releaseTxn(dir string) {
+	s.txnMu.Lock()
+	defer s.txnMu.Unlock()
+	delete(s.txnActive, dir)
+}
+
+// promoteTxn moves the staged objects with the given keys from the given
+// transaction directory into place, and then removes the directory.  Quota
+// has already been reserved for the objects in reserved, which is given back
+// for any that are already in the store, or that haven't been moved into
+// place when an error is returned.
+func (s *CAStore) promoteTxn(dir string, keys []string, reserved map[string]int64) error {
+	defer func() {
+		for _, size := range reserved {
+			s.releaseQuota(size)
+		}
+	}()
+
+	for _, key := range keys {
+		p := filepath.Join(dir, key)
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			// Already promoted before a crash.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, held := reserved[key]
+		res, err := s.putReserved(f, key, p, held)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if !res.dedup {
+			delete(reserved, key)
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// recoverTxns completes or discards the transactions left behind by processes
+// that have exited, and completes those of this process that failed part way
+// through being committed.  It returns the number dealt with.
+func (s *CAStore) recoverTxns() (int, error) {
+	root := filepath.Join(s.opts.BasePath, metaDir, txnDir)
+	entries, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, ent := range entries {
+		if !ent.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, ent.Name())
+		own := strings.HasPrefix(ent.Name(), tempPrefix+tempOwner)
+		if !own && !orphaned(ent.Name()) {
+			continue
+		}
+
+		journal, err := ioutil.ReadFile(filepath.Join(dir, txnCommitFile))
+		switch {
+		case os.IsNotExist(err) && own:
+			// Still being staged.
+			continue
+		case os.IsNotExist(err):
+			s.log.Info("discarding uncommitted transaction", "name", ent.Name())
+			err = os.RemoveAll(dir)
+		case err == nil && s.claimTxn(dir):
+			s.log.Info("completing committed transaction", "name", ent.Name())
+			err = s.promoteTxn(dir, strings.Fields(string(journal)), nil)
+			s.releaseTxn(dir)
+		case err == nil:
+			// Being committed by another goroutine.
+			continue
+		}
+		if err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+	return recovered, nil
+}
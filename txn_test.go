@@ -0,0 +1,272 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxCommit(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	tx, err := s.Begin()
+	assert.NoError(t, err)
+	defer tx.Rollback()
+
+	key, err := tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	other, err := tx.Put(strings.NewReader("other"))
+	assert.NoError(t, err)
+
+	// Nothing is visible until the transaction is committed.
+	for _, k := range []string{key, other} {
+		exists, err := s.Exists(k)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	assert.NoError(t, tx.Commit())
+	for _, k := range []string{key, other} {
+		exists, err := s.Exists(k)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	_, err = tx.Put(strings.NewReader(TEST_VALUE))
+	assert.Equal(t, ErrTxDone, err)
+
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, metaDir, txnDir))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestTxRollback(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, MaxSize: 8, Quota: 10})
+	assert.NoError(t, err)
+
+	tx, err := s.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader("this is too large"))
+	assert.Equal(t, ErrSizeExceeded, err)
+	assert.NoError(t, tx.Rollback())
+
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// A transaction that doesn't fit in the quota stores nothing.
+	tx, err = s.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader("other"))
+	assert.NoError(t, err)
+	assert.Equal(t, ErrQuotaExceeded, tx.Commit())
+	exists, err = s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTxCommitReservesQuota(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	// A Put made while the transaction is being committed can't take the
+	// space that the rest of the transaction needs.
+	var s *CAStore
+	var putErr error
+	var once sync.Once
+	s, err := New(Options{
+		BasePath: tdir,
+		Quota:    20,
+		Hooks: Hooks{
+			OnPut: func(key string, size int64) {
+				if key == TEST_KEY {
+					once.Do(func() { _, putErr = s.PutString("0123456789") })
+				}
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	tx, err := s.Begin()
+	assert.NoError(t, err)
+	key, err := tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	other, err := tx.Put(strings.NewReader("other"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, ErrQuotaExceeded, putErr)
+	for _, k := range []string{key, other} {
+		exists, err := s.Exists(k)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+	assert.Equal(t, int64(11), atomic.LoadInt64(&s.used))
+}
+
+func TestTxCommitReconcile(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	// A Reconcile started part way through a commit waits for it, rather
+	// than counting the space reserved for the rest of the transaction as
+	// free.
+	var s *CAStore
+	var once sync.Once
+	reconciled := make(chan error, 1)
+	s, err := New(Options{
+		BasePath: tdir,
+		Quota:    20,
+		Ledger:   true,
+		Hooks: Hooks{
+			OnPut: func(key string, size int64) {
+				if key == TEST_KEY {
+					once.Do(func() {
+						go func() {
+							_, err := s.Reconcile()
+							reconciled <- err
+						}()
+						time.Sleep(50 * time.Millisecond)
+					})
+				}
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	tx, err := s.Begin()
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	_, err = tx.Put(strings.NewReader("other"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, <-reconciled)
+	assert.Equal(t, int64(11), atomic.LoadInt64(&s.used))
+	_, err = s.PutString("0123456789")
+	assert.Equal(t, ErrQuotaExceeded, err)
+}
+
+func TestTxCommitFailure(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, Quota: 1 << 20})
+	assert.NoError(t, err)
+
+	tx, err := s.Begin()
+	assert.NoError(t, err)
+	key, err := tx.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	other, err := tx.Put(strings.NewReader("other"))
+	assert.NoError(t, err)
+
+	// Make promoting the second object fail.
+	staged := filepath.Join(tx.dir, other)
+	assert.NoError(t, os.Remove(staged))
+	assert.NoError(t, os.Mkdir(staged, 0700))
+
+	assert.Error(t, tx.Commit())
+	exists, err := s.Exists(other)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.Equal(t, int64(len(TEST_VALUE)), atomic.LoadInt64(&s.used))
+
+	// Once the cause has been dealt with, this process can complete it.
+	assert.NoError(t, os.Remove(staged))
+	assert.NoError(t, ioutil.WriteFile(staged, []byte("other"), 0600))
+	recovered, err := s.Recover()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, recovered)
+	for _, k := range []string{key, other} {
+		exists, err := s.Exists(k)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+	assert.Equal(t, int64(11), atomic.LoadInt64(&s.used))
+
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, metaDir, txnDir))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRecoverTxns(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skip("process liveness cannot be checked on this platform")
+	}
+
+	tdir := must_s(ioutil.TempDir("", "castore-test-txn"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+
+	// Simulate transactions left behind by a process that has exited, one of
+	// which had started committing.
+	root := filepath.Join(tdir, metaDir, txnDir)
+	orphan := func(name string, commit bool) {
+		tx, err := s.Begin()
+		assert.NoError(t, err)
+		_, err = tx.Put(strings.NewReader(name))
+		assert.NoError(t, err)
+		if commit {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(tx.dir, txnCommitFile), []byte(strings.Join(tx.keys, "\n")), 0600))
+		}
+		assert.NoError(t, os.Rename(tx.dir, filepath.Join(root, tempPrefix+"999999999-0-"+name)))
+	}
+	orphan("committed", true)
+	orphan("uncommitted", false)
+
+	// This process's transactions are left alone.
+	live, err := s.Begin()
+	assert.NoError(t, err)
+	_, err = live.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+
+	recovered, err := s.Recover()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, recovered)
+
+	var keys []string
+	assert.NoError(t, s.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	assert.Equal(t, []string{s.opts.KeyEncoding.Encode(sumOf(s, "committed"))}, keys)
+
+	entries, err := ioutil.ReadDir(root)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, filepath.Base(live.dir), entries[0].Name())
+	}
+	assert.NoError(t, live.Commit())
+}
+
+// sumOf returns the digest of the given string with the store's Hash.
+func sumOf(s *CAStore, data string) []byte {
+	h := s.opts.Hash()
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
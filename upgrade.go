@@ -0,0 +1,111 @@
+package castore
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// ErrUpgradeRequired is returned by New if the store's on-disk format is older
+// than the one written by this package, so it must be upgraded with Upgrade
+// before it can be used.
+var ErrUpgradeRequired = errors.New("castore: store must be upgraded")
+
+// migration converts a store from one format version to the next.
+type migration struct {
+	// from is the format version that the migration upgrades from; it
+	// leaves the store at version from+1.
+	from int
+
+	// description says what the migration changes, for the log.
+	description string
+
+	// run does the work.  It is given the options that Upgrade was called
+	// with, with the defaults applied.  If it fails part way through, it is
+	// run again by the next call to Upgrade, so it must be able to finish a
+	// partial conversion.
+	run func(ctx context.Context, opts Options) error
+}
+
+// migrations lists the migration from each format version older than
+// formatVersion.  A change to the on-disk format adds one here, and increments
+// formatVersion.
+var migrations []migration
+
+// Upgrade will convert the store in opts.BasePath to the current on-disk
+// format, in place, so that it can be opened with New.  Each migration is
+// recorded in the store's Manifest as soon as it completes, so if Upgrade is
+// interrupted, calling it again continues where it left off.  The store must
+// not be in use while it is being upgraded.  A store that doesn't need
+// upgrading, or doesn't exist yet, is left untouched.
+func Upgrade(ctx context.Context, opts Options) error {
+	if opts.BasePath == "" {
+		return ErrNoBasePath
+	}
+	p := filepath.Join(opts.BasePath, manifestFile)
+	m, err := readManifestFile(p)
+	if os.IsNotExist(err) {
+		// New creates the manifest the first time it opens a store, so
+		// there is nothing to upgrade yet.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if m.FormatVersion > formatVersion {
+		return fmt.Errorf("castore: store has format version %d, but only version %d is supported", m.FormatVersion, formatVersion)
+	}
+
+	opts = upgradeDefaults(opts)
+	for m.FormatVersion < formatVersion {
+		mig := findMigration(m.FormatVersion)
+		if mig == nil {
+			return fmt.Errorf("castore: no migration from format version %d", m.FormatVersion)
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		opts.Logger.Info("upgrading store", "from", mig.from, "to", mig.from+1, "migration", mig.description)
+		if err = mig.run(ctx, opts); err != nil {
+			return fmt.Errorf("castore: upgrading from format version %d: %w", mig.from, err)
+		}
+		m.FormatVersion = mig.from + 1
+		if err = writeManifestFile(p, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findMigration returns the migration from the given format version, if there
+// is one.
+func findMigration(from int) *migration {
+	for i := range migrations {
+		if migrations[i].from == from {
+			return &migrations[i]
+		}
+	}
+	return nil
+}
+
+// upgradeDefaults fills in the defaults for the options that a migration may
+// need, as New does.
+func upgradeDefaults(opts Options) Options {
+	if opts.Hash == nil {
+		opts.Hash = sha256.New
+	}
+	if opts.KeyEncoding == nil {
+		opts.KeyEncoding = HexEncoding
+	}
+	if opts.Transform == nil {
+		opts.Transform = FlatTransformFunc
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(discardHandler{})
+	}
+	return opts
+}
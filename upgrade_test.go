@@ -0,0 +1,67 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgrade(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-upgrade"))
+	defer os.RemoveAll(tdir)
+	defer func(version int) {
+		formatVersion = version
+		migrations = nil
+	}(formatVersion)
+
+	// A store from before manifests has the legacy format.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tdir, "old"), nil, 0600))
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	m, err := s.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, legacyFormatVersion, m.FormatVersion)
+
+	// Pretend that the format has changed since.
+	runs := 0
+	formatVersion = legacyFormatVersion + 1
+	migrations = []migration{{
+		from:        legacyFormatVersion,
+		description: "test migration",
+		run: func(ctx context.Context, opts Options) error {
+			runs++
+			if runs == 1 {
+				return errors.New("interrupted")
+			}
+			return os.Remove(filepath.Join(opts.BasePath, "old"))
+		},
+	}}
+	_, err = New(Options{BasePath: tdir})
+	assert.True(t, errors.Is(err, ErrUpgradeRequired), "got %v", err)
+
+	// A failed migration is run again.
+	assert.Error(t, Upgrade(context.Background(), Options{BasePath: tdir}))
+	assert.NoError(t, Upgrade(context.Background(), Options{BasePath: tdir}))
+	assert.Equal(t, 2, runs)
+	s, err = New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	m, err = s.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, m.FormatVersion)
+
+	// Upgrading an up-to-date store does nothing.
+	assert.NoError(t, Upgrade(context.Background(), Options{BasePath: tdir}))
+	assert.Equal(t, 2, runs)
+
+	// New stores have the current format.
+	s, err = New(Options{BasePath: filepath.Join(tdir, "new")})
+	assert.NoError(t, err)
+	m, err = s.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, formatVersion, m.FormatVersion)
+}
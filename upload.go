@@ -0,0 +1,343 @@
+package castore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sessionsDir is the name of the directory in the store's internal state
+	// directory that holds the data of upload sessions, one file per session
+	// plus one per part (see UploadPart).
+	sessionsDir = "sessions"
+
+	// maxUploadParts is the highest part number accepted by UploadPart.
+	maxUploadParts = 10000
+)
+
+var (
+	// ErrNoSuchUpload is the error returned when referring to an upload
+	// session that does not exist.
+	ErrNoSuchUpload = errors.New("castore: no such upload")
+
+	// ErrUploadOffset is the error returned by AppendUpload when the given
+	// offset is not the current size of the upload.
+	ErrUploadOffset = errors.New("castore: wrong upload offset")
+
+	// ErrInvalidPart is the error returned when a part number is out of
+	// range, or CommitParts is given a part that was never uploaded.
+	ErrInvalidPart = errors.New("castore: invalid upload part")
+)
+
+// BeginUpload starts an upload session and returns its ID.  Data can then be
+// added to the session in any number of chunks with AppendUpload, and stored
+// with CommitUpload.  Sessions are kept on disk within the store, so an upload
+// that is interrupted - even by a restart - can be resumed from UploadOffset
+// rather than starting again.  Sessions that are never committed remain until
+// they are aborted with AbortUpload or removed by PruneUploads.
+func (s *CAStore) BeginUpload() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	dir, err := s.metaPath(sessionsDir)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	name := hex.EncodeToString(id[:])
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// UploadOffset returns the number of bytes that have been added to the given
+// upload session, which is where a resumed upload should carry on from.
+func (s *CAStore) UploadOffset(id string) (int64, error) {
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return 0, ErrNoSuchUpload
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// AppendUpload adds the data from the given io.Reader to the end of the given
+// upload session, and returns the session's new size.  The offset must be the
+// session's current size, or ErrUploadOffset is returned; this stops a
+// retried chunk from being added twice.  If reading fails part way through,
+// the data read so far is kept, and the returned size reflects it.  Chunks
+// must not be appended to the same session concurrently.
+func (s *CAStore) AppendUpload(id string, offset int64, r io.Reader) (int64, error) {
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0)
+	if os.IsNotExist(err) {
+		return 0, ErrNoSuchUpload
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if offset != size {
+		return size, ErrUploadOffset
+	}
+
+	written, tooLarge, err := s.copyLimited(f, s.throttleWrites(r), s.opts.MaxSize-size)
+	if tooLarge {
+		// Don't leave a truncated object to be committed.
+		if err = f.Truncate(size); err != nil {
+			return 0, err
+		}
+		return size, ErrSizeExceeded
+	}
+	size += written
+	if err == nil && s.syncData() {
+		err = f.Sync()
+	}
+	return size, err
+}
+
+// CommitUpload stores the data added to the given upload session, and returns
+// its key.  The same checks are made as by Put; if they fail, the session is
+// kept.  Otherwise, the session is finished, and its ID can no longer be used.
+func (s *CAStore) CommitUpload(id string) (string, error) {
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return "", ErrNoSuchUpload
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// The session's file is moved into place, and if the data was already
+	// present it is left behind.
+	key, err := s.putFrom(f, "", p)
+	if err != nil {
+		return "", err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return key, nil
+}
+
+// UploadPart stores the data from the given io.Reader as the given part of an
+// upload session, replacing any data previously stored for that part, and
+// returns the part's size.  Unlike the chunks added by AppendUpload, parts
+// may be uploaded in any order and concurrently, so that a large object can
+// be sent over several connections at once; CommitParts joins them together.
+// Parts are numbered from 1 to 10000.
+func (s *CAStore) UploadPart(id string, part int, r io.Reader) (int64, error) {
+	if part < 1 || part > maxUploadParts {
+		return 0, ErrInvalidPart
+	}
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = os.Stat(p); os.IsNotExist(err) {
+		return 0, ErrNoSuchUpload
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Parts are written under a temporary name, so that a part that fails
+	// part way through doesn't replace one that was complete.
+	f, err := ioutil.TempFile(filepath.Dir(p), id+".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	written, tooLarge, err := s.copyLimited(f, s.throttleWrites(r), s.opts.MaxSize)
+	if err == nil && tooLarge {
+		err = ErrSizeExceeded
+	}
+	if err == nil && s.syncData() {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), partPath(p, part))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep the session from being pruned while its parts are arriving.
+	now := time.Now()
+	if err = os.Chtimes(p, now, now); os.IsNotExist(err) {
+		// The session was aborted while the part was being uploaded.
+		os.Remove(partPath(p, part))
+		return 0, ErrNoSuchUpload
+	}
+	return written, err
+}
+
+// CommitParts stores the data of the given parts of an upload session, joined
+// together in the order given, and returns its key.  If expectedKey is not
+// empty, the data is only stored if its key matches, as with PutVerified.
+// The same checks are made as by Put; if they fail, the session is kept.
+// Otherwise, the session is finished, and its ID can no longer be used.
+func (s *CAStore) CommitParts(id string, parts []int, expectedKey string) (string, error) {
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return "", err
+	}
+	if _, err = os.Stat(p); os.IsNotExist(err) {
+		return "", ErrNoSuchUpload
+	} else if err != nil {
+		return "", err
+	}
+	if len(parts) == 0 {
+		return "", ErrInvalidPart
+	}
+
+	readers := make([]io.Reader, len(parts))
+	for i, part := range parts {
+		if part < 1 || part > maxUploadParts {
+			return "", ErrInvalidPart
+		}
+		f, err := os.Open(partPath(p, part))
+		if os.IsNotExist(err) {
+			return "", ErrInvalidPart
+		}
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		readers[i] = f
+	}
+
+	key, err := s.put(io.MultiReader(readers...), expectedKey)
+	if err != nil {
+		return "", err
+	}
+	if err = removeSession(p); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return key, nil
+}
+
+// AbortUpload discards the given upload session.
+func (s *CAStore) AbortUpload(id string) error {
+	p, err := s.sessionPath(id)
+	if err != nil {
+		return err
+	}
+	if err = removeSession(p); os.IsNotExist(err) {
+		return ErrNoSuchUpload
+	}
+	return err
+}
+
+// PruneUploads discards upload sessions that have not had data added for at
+// least the given duration, and returns the number discarded.
+func (s *CAStore) PruneUploads(olderThan time.Duration) (int, error) {
+	dir := filepath.Join(s.opts.BasePath, metaDir, sessionsDir)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var pruned int
+	cutoff := time.Now().Add(-olderThan)
+	for _, ent := range entries {
+		if !ent.ModTime().Before(cutoff) {
+			continue
+		}
+		name := ent.Name()
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			// A part, or a part being uploaded; only remove it here if its
+			// session has gone.
+			if _, err = os.Stat(filepath.Join(dir, name[:i])); os.IsNotExist(err) {
+				err = os.Remove(filepath.Join(dir, name))
+			}
+			if err != nil && !os.IsNotExist(err) {
+				return pruned, err
+			}
+			continue
+		}
+		if err = removeSession(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		s.log.Debug("pruned upload session", "id", name, "size", ent.Size())
+		pruned++
+	}
+	return pruned, nil
+}
+
+// partPath returns the path of the file holding the given part of the upload
+// session whose data is at the given path.
+func partPath(p string, part int) string {
+	return p + "." + strconv.Itoa(part)
+}
+
+// removeSession removes the file holding the data of an upload session, and
+// those holding its parts.
+func removeSession(p string) error {
+	parts, err := filepath.Glob(p + ".*")
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if err = os.Remove(part); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(p)
+}
+
+// sessionPath returns the path of the file holding the data of the given
+// upload session.
+func (s *CAStore) sessionPath(id string) (string, error) {
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 16 {
+		return "", ErrNoSuchUpload
+	}
+	dir, err := s.metaPath(sessionsDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id), nil
+}
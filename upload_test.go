@@ -0,0 +1,134 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpload(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-upload"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	id, err := s.BeginUpload()
+	assert.NoError(t, err)
+
+	size, err := s.AppendUpload(id, 0, strings.NewReader("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	// A retried chunk isn't added twice.
+	size, err = s.AppendUpload(id, 0, strings.NewReader("foo"))
+	assert.Equal(t, ErrUploadOffset, err)
+	assert.Equal(t, int64(3), size)
+
+	// The session survives the store being reopened.
+	s, err = New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	offset, err := s.UploadOffset(id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	_, err = s.AppendUpload(id, offset, strings.NewReader("bar"))
+	assert.NoError(t, err)
+
+	key, err := s.CommitUpload(id)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+
+	_, err = s.UploadOffset(id)
+	assert.Equal(t, ErrNoSuchUpload, err)
+	_, err = s.CommitUpload("../../etc/passwd")
+	assert.Equal(t, ErrNoSuchUpload, err)
+}
+
+func TestUploadLimits(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-upload"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, MaxSize: 4})
+	assert.NoError(t, err)
+	id, err := s.BeginUpload()
+	assert.NoError(t, err)
+
+	_, err = s.AppendUpload(id, 0, strings.NewReader("foo"))
+	assert.NoError(t, err)
+	size, err := s.AppendUpload(id, 3, strings.NewReader("bar"))
+	assert.Equal(t, ErrSizeExceeded, err)
+	assert.Equal(t, int64(3), size)
+
+	// Abandoned sessions can be pruned.
+	other, err := s.BeginUpload()
+	assert.NoError(t, err)
+	n, err := s.PruneUploads(time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = s.PruneUploads(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, ErrNoSuchUpload, s.AbortUpload(other))
+}
+
+func TestUploadParts(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-upload"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	id, err := s.BeginUpload()
+	assert.NoError(t, err)
+
+	// Parts can arrive in any order, and be replaced.
+	size, err := s.UploadPart(id, 2, strings.NewReader("bar"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	_, err = s.UploadPart(id, 1, strings.NewReader("xxx"))
+	assert.NoError(t, err)
+	_, err = s.UploadPart(id, 1, strings.NewReader("foo"))
+	assert.NoError(t, err)
+	_, err = s.UploadPart(id, 0, strings.NewReader("foo"))
+	assert.Equal(t, ErrInvalidPart, err)
+	_, err = s.UploadPart("0123456789abcdef0123456789abcdef", 1, strings.NewReader("foo"))
+	assert.Equal(t, ErrNoSuchUpload, err)
+
+	_, err = s.CommitParts(id, []int{1, 3}, "")
+	assert.Equal(t, ErrInvalidPart, err)
+	_, err = s.CommitParts(id, []int{2, 1}, TEST_KEY)
+	assert.Equal(t, ErrKeyMismatch, err)
+	key, err := s.CommitParts(id, []int{1, 2}, TEST_KEY)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_KEY, key)
+	assert.Equal(t, TEST_VALUE, readKey(t, s, key))
+
+	// Nothing is left behind.
+	_, err = s.CommitParts(id, []int{1, 2}, "")
+	assert.Equal(t, ErrNoSuchUpload, err)
+	entries, err := ioutil.ReadDir(filepath.Join(tdir, metaDir, sessionsDir))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Aborting or pruning a session removes its parts too.
+	for _, abort := range []bool{true, false} {
+		id, err = s.BeginUpload()
+		assert.NoError(t, err)
+		_, err = s.UploadPart(id, 1, strings.NewReader("foo"))
+		assert.NoError(t, err)
+		if abort {
+			assert.NoError(t, s.AbortUpload(id))
+		} else {
+			n, err := s.PruneUploads(0)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+		}
+		entries, err = ioutil.ReadDir(filepath.Join(tdir, metaDir, sessionsDir))
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	}
+}
@@ -0,0 +1,142 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// VerifyReport contains the results of a call to Verify.
+type VerifyReport struct {
+	// Checked is the number of objects that were read and re-hashed.
+	Checked int
+
+	// Corrupt contains the keys of all objects whose data no longer matches
+	// their key.
+	Corrupt []string
+
+	// Missing contains the keys that were expected to be in the store, but
+	// which could not be found.
+	Missing []string
+}
+
+// OK returns whether the report contains no problems.
+func (r *VerifyReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0
+}
+
+// Verify will read every object in the store and ensure that its data still
+// hashes to its key.  Any keys given in expected that are not present in the
+// store will be reported as missing.  Verify will stop early and return an
+// error if the context is cancelled or if an object cannot be read.
+func (s *CAStore) Verify(ctx context.Context, expected ...string) (*VerifyReport, error) {
+	report := &VerifyReport{}
+	seen := make(map[string]bool)
+
+	err := s.walkFiles(func(key, p string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ok, err := s.verifyFile(key, p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		seen[key] = true
+		report.Checked++
+		if !ok {
+			s.log.Warn("object is corrupt", "key", key, "path", p)
+			report.Corrupt = append(report.Corrupt, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for key := range s.packedObjects() {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		if seen[key] {
+			continue
+		}
+		r, _, err := s.openPacked(key)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		ok, err := s.verifyReader(key, r)
+		r.Close()
+		if err != nil {
+			return report, err
+		}
+
+		seen[key] = true
+		report.Checked++
+		if !ok {
+			s.log.Warn("packed object is corrupt", "key", key)
+			report.Corrupt = append(report.Corrupt, key)
+		}
+	}
+
+	for key := range s.deltaObjects() {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+		if seen[key] {
+			continue
+		}
+		_, err := s.readDelta(key)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrCorrupt) {
+			return report, err
+		}
+
+		seen[key] = true
+		report.Checked++
+		if err != nil {
+			report.Corrupt = append(report.Corrupt, key)
+		}
+	}
+
+	for _, key := range expected {
+		if !seen[key] {
+			report.Missing = append(report.Missing, key)
+		}
+	}
+	return report, nil
+}
+
+// verifyFile is a helper function that will re-hash the file at the given path
+// and return whether it matches the given key.
+func (s *CAStore) verifyFile(key, p string) (bool, error) {
+	f, err := s.openFile(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return s.verifyReader(key, f)
+}
+
+// verifyReader is a helper function that will hash the data read from r and
+// return whether it matches the given key.
+func (s *CAStore) verifyReader(key string, r io.Reader) (bool, error) {
+	hasher := s.opts.Hash()
+	if s.readLimiter != nil {
+		r = &throttledReader{r, []*rateLimiter{s.readLimiter}}
+	}
+	if _, err := s.copyBuffer(hasher, r); err != nil {
+		return false, err
+	}
+	return s.opts.KeyEncoding.Encode(hasher.Sum(nil)) == key, nil
+}
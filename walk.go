@@ -0,0 +1,358 @@
+package castore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WalkFunc is the type of function called by Walk for each object in the
+// store.  If it returns an error, the walk is stopped and the error returned.
+type WalkFunc func(key string, size int64) error
+
+// WalkOptions controls the behaviour of WalkWithOptions.
+type WalkOptions struct {
+	// Parallelism is the number of top-level shard directories that will be
+	// walked concurrently.  If this is greater than 1, the WalkFunc may be
+	// called from multiple goroutines at once.  The default, 0, walks the
+	// store sequentially.
+	Parallelism int
+
+	// Sorted causes objects to be visited in ascending order of key, which
+	// makes the walk reproducible (e.g. for exports).  All keys are gathered
+	// before the WalkFunc is first called, and it is never called
+	// concurrently.
+	Sorted bool
+
+	// Prefix, if not empty, limits the walk to keys that begin with it.
+	Prefix string
+
+	// MinSize and MaxSize, if positive, limit the walk to objects that are at
+	// least and at most that many bytes long.
+	MinSize int64
+	MaxSize int64
+
+	// NewerThan and OlderThan, if not zero, limit the walk to objects that
+	// were written after and before the given times.  With Options.Index set,
+	// these are evaluated against the index without touching the disk.
+	NewerThan time.Time
+	OlderThan time.Time
+
+	// NotAccessedSince, if not zero, limits the walk to objects that haven't
+	// been retrieved or written since the given time, which finds the objects
+	// that have gone cold.  Retrievals are only known about if Options.Index
+	// and Options.AccessTimes are set; otherwise, this is like OlderThan.
+	NotAccessedSince time.Time
+}
+
+// matchObject reports whether an object with the given key and size passes
+// the filters in opts other than the times.
+func (opts *WalkOptions) matchObject(key string, size int64) bool {
+	if !strings.HasPrefix(key, opts.Prefix) {
+		return false
+	}
+	if opts.MinSize > 0 && size < opts.MinSize {
+		return false
+	}
+	if opts.MaxSize > 0 && size > opts.MaxSize {
+		return false
+	}
+	return true
+}
+
+// timed reports whether opts filters objects by when they were written.
+func (opts *WalkOptions) timed() bool {
+	return !opts.NewerThan.IsZero() || !opts.OlderThan.IsZero() || !opts.NotAccessedSince.IsZero()
+}
+
+// matchTime reports whether an object written and last accessed at the given
+// times passes the time filters in opts.
+func (opts *WalkOptions) matchTime(mtime, accessed time.Time) bool {
+	if !opts.NewerThan.IsZero() && !mtime.After(opts.NewerThan) {
+		return false
+	}
+	if !opts.OlderThan.IsZero() && !mtime.Before(opts.OlderThan) {
+		return false
+	}
+	if !opts.NotAccessedSince.IsZero() && !accessed.Before(opts.NotAccessedSince) {
+		return false
+	}
+	return true
+}
+
+// errWalkStopped is used internally to stop the other goroutines of a
+// parallel walk once one of them has failed.
+var errWalkStopped = errors.New("castore: walk stopped")
+
+// Walk will call fn for every object in the store, in no particular order.
+// Objects that are inserted or deleted while the walk is in progress may or
+// may not be visited.
+func (s *CAStore) Walk(fn WalkFunc) error {
+	return s.WalkWithOptions(WalkOptions{}, fn)
+}
+
+// WalkWithOptions is like Walk, but can walk the store in parallel, or in
+// sorted order, and can skip objects that don't match the given filters
+// before fn is called.
+func (s *CAStore) WalkWithOptions(opts WalkOptions, fn WalkFunc) error {
+	// Packed objects, and those stored as deltas, are visited after the loose
+	// ones, skipping any that are also loose because a Repack was interrupted.
+	packed := s.packedObjects()
+	for key, size := range s.deltaObjects() {
+		packed[key] = size
+	}
+	if s.opts.Index {
+		indexed := s.indexedMatching(&opts, packed)
+		if err := s.filterRetained(&opts, packed); err != nil {
+			return err
+		}
+		return walkIndexed(indexed, packed, opts.Sorted, fn)
+	}
+	havePacked := len(packed) > 0
+	var mu sync.Mutex
+	both := func(key string) {
+		if havePacked {
+			mu.Lock()
+			delete(packed, key)
+			mu.Unlock()
+		}
+	}
+
+	match := func(key string, info os.FileInfo) bool {
+		return opts.matchObject(key, info.Size()) && opts.matchTime(info.ModTime(), info.ModTime())
+	}
+
+	if !opts.Sorted {
+		err := s.walkParallel(opts.Parallelism, func(key, p string, info os.FileInfo) error {
+			both(key)
+			if !match(key, info) {
+				return nil
+			}
+			return fn(key, info.Size())
+		})
+		if err != nil {
+			return err
+		}
+		if err = s.filterRetained(&opts, packed); err != nil {
+			return err
+		}
+		for key, size := range packed {
+			if err = fn(key, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type object struct {
+		key  string
+		size int64
+	}
+	var objects []object
+	err := s.walkParallel(opts.Parallelism, func(key, p string, info os.FileInfo) error {
+		both(key)
+		if !match(key, info) {
+			return nil
+		}
+		mu.Lock()
+		objects = append(objects, object{key, info.Size()})
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.filterRetained(&opts, packed); err != nil {
+		return err
+	}
+	for key, size := range packed {
+		objects = append(objects, object{key, size})
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].key < objects[j].key
+	})
+	for _, obj := range objects {
+		if err = fn(obj.key, obj.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkIndexed is the implementation of WalkWithOptions when Options.Index is
+// set, which visits the objects in the index rather than on disk.
+func walkIndexed(indexed, packed map[string]int64, sorted bool, fn WalkFunc) error {
+	for key, size := range packed {
+		if _, ok := indexed[key]; !ok {
+			indexed[key] = size
+		}
+	}
+	if !sorted {
+		for key, size := range indexed {
+			if err := fn(key, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	keys := make([]string, 0, len(indexed))
+	for key := range indexed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn(key, indexed[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterRetained removes the packed objects, and those stored as deltas, that
+// don't match opts from the given map.  As these don't have files of their
+// own, the time they were written is taken to be that of the pack or delta
+// file.
+func (s *CAStore) filterRetained(opts *WalkOptions, objects map[string]int64) error {
+	for key, size := range objects {
+		if !opts.matchObject(key, size) {
+			delete(objects, key)
+			continue
+		}
+		if !opts.timed() {
+			continue
+		}
+		info, err := s.retainedInfo(key)
+		if os.IsNotExist(err) {
+			// Deleted, or rehydrated, since the store was listed.
+			delete(objects, key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !opts.matchTime(info.ModTime(), info.ModTime()) {
+			delete(objects, key)
+		}
+	}
+	return nil
+}
+
+// retainedInfo returns the information for the pack file holding the given
+// packed object, or the file holding it as a delta.
+func (s *CAStore) retainedInfo(key string) (os.FileInfo, error) {
+	return os.Stat(s.retainedPath(key))
+}
+
+// retainedPath returns the path of the pack file holding the given packed
+// object, or the file holding it as a delta.
+func (s *CAStore) retainedPath(key string) string {
+	if ent, ok := s.packedEntry(key); ok {
+		return filepath.Join(s.opts.BasePath, metaDir, packsDir, ent.pack+packExt)
+	}
+	return filepath.Join(s.opts.BasePath, metaDir, deltasDir, key)
+}
+
+// walkFiles is a helper function that will call fn for every file under the
+// store's BasePath whose name is a valid key, along with its on-disk path.
+func (s *CAStore) walkFiles(fn func(key, path string, info os.FileInfo) error) error {
+	return s.walkTree(s.opts.BasePath, fn)
+}
+
+// walkTree is like walkFiles, but only visits the files under dir.
+func (s *CAStore) walkTree(dir string, fn func(key, path string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can disappear from underneath us while walking.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() && p == filepath.Join(s.opts.BasePath, metaDir) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !s.validKey(info.Name()) {
+			return nil
+		}
+		return fn(info.Name(), p, info)
+	})
+}
+
+// walkParallel is like walkFiles, but walks up to n of the top-level
+// directories under BasePath concurrently.
+func (s *CAStore) walkParallel(n int, fn func(key, path string, info os.FileInfo) error) error {
+	if n <= 1 {
+		return s.walkFiles(fn)
+	}
+
+	entries, err := ioutil.ReadDir(s.opts.BasePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	visit := func(key, p string, info os.FileInfo) error {
+		if failed() {
+			return errWalkStopped
+		}
+		return fn(key, p, info)
+	}
+
+	dirs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range dirs {
+				if err := s.walkTree(dir, visit); err != nil && err != errWalkStopped {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for _, ent := range entries {
+		if failed() {
+			break
+		}
+		p := filepath.Join(s.opts.BasePath, ent.Name())
+		if ent.IsDir() {
+			if ent.Name() != metaDir {
+				dirs <- p
+			}
+		} else if s.validKey(ent.Name()) {
+			if err := visit(ent.Name(), p, ent); err != nil && err != errWalkStopped {
+				fail(err)
+			}
+		}
+	}
+	close(dirs)
+	wg.Wait()
+	return firstErr
+}
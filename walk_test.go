@@ -0,0 +1,192 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalk(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-walk"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(1),
+	})
+	assert.NoError(t, err)
+
+	expected := map[string]int64{}
+	for _, v := range []string{"a", "bb", "ccc"} {
+		key, err := s.PutString(v)
+		assert.NoError(t, err)
+		expected[key] = int64(len(v))
+	}
+
+	// Not a key, so it should be skipped.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tdir, "junk"), []byte("x"), 0600))
+
+	found := map[string]int64{}
+	err = s.Walk(func(key string, size int64) error {
+		found[key] = size
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, found)
+
+	// Errors stop the walk.
+	stop := errors.New("stop")
+	var keys []string
+	err = s.Walk(func(key string, size int64) error {
+		keys = append(keys, key)
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Len(t, keys, 1)
+}
+
+func TestWalkWithOptions(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-walk"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(1),
+	})
+	assert.NoError(t, err)
+
+	expected := map[string]int64{}
+	for i := 0; i < 50; i++ {
+		v := strings.Repeat("x", i+1)
+		key, err := s.PutString(v)
+		assert.NoError(t, err)
+		expected[key] = int64(len(v))
+	}
+
+	var mu sync.Mutex
+	found := map[string]int64{}
+	err = s.WalkWithOptions(WalkOptions{Parallelism: 4}, func(key string, size int64) error {
+		mu.Lock()
+		found[key] = size
+		mu.Unlock()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, found)
+
+	// Sorted walks visit keys in order, even in parallel.
+	for _, n := range []int{0, 4} {
+		var keys []string
+		err = s.WalkWithOptions(WalkOptions{Parallelism: n, Sorted: true}, func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Len(t, keys, len(expected))
+		assert.True(t, sort.StringsAreSorted(keys))
+	}
+
+	// Errors stop a parallel walk.
+	stop := errors.New("stop")
+	err = s.WalkWithOptions(WalkOptions{Parallelism: 4}, func(key string, size int64) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+}
+
+func TestWalkFilters(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-walk"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	sizes := map[string]int64{}
+	for i := 1; i <= 10; i++ {
+		key, err := s.PutString(strings.Repeat("x", i))
+		assert.NoError(t, err)
+		sizes[key] = int64(i)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	var stale []string
+	for key, size := range sizes {
+		if size <= 3 {
+			assert.NoError(t, os.Chtimes(s.blobPath(key), old, old))
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+
+	for _, index := range []bool{false, true} {
+		s, err = New(Options{BasePath: tdir, Index: index})
+		assert.NoError(t, err)
+		walk := func(opts WalkOptions) []string {
+			opts.Sorted = true
+			var keys []string
+			err := s.WalkWithOptions(opts, func(key string, size int64) error {
+				keys = append(keys, key)
+				return nil
+			})
+			assert.NoError(t, err)
+			return keys
+		}
+
+		assert.Len(t, walk(WalkOptions{MinSize: 4, MaxSize: 6}), 3)
+		assert.Len(t, walk(WalkOptions{MaxSize: 2}), 2)
+		cutoff := time.Now().Add(-time.Hour)
+		assert.Equal(t, stale, walk(WalkOptions{OlderThan: cutoff}), "index=%v", index)
+		assert.Len(t, walk(WalkOptions{NewerThan: cutoff}), 7)
+		assert.Empty(t, walk(WalkOptions{OlderThan: cutoff, MinSize: 4}))
+		assert.Equal(t, stale[:1], walk(WalkOptions{Prefix: stale[0]}))
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestWalkRewritten(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-walk"))
+	defer os.RemoveAll(tdir)
+
+	// Objects that are Put again count as newly written, even if they aren't
+	// moved into place afresh.
+	opts := Options{BasePath: tdir, Index: true, WriteOnce: true, PackMaxSize: 8}
+	s, err := New(opts)
+	assert.NoError(t, err)
+	loose, err := s.PutString("loose data")
+	assert.NoError(t, err)
+	packed, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	_, err = s.Repack(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, s.isPacked(packed))
+
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(s.blobPath(loose), old, old))
+	assert.NoError(t, os.Chtimes(s.retainedPath(packed), old, old))
+	s, err = New(opts)
+	assert.NoError(t, err)
+
+	stale := func() []string {
+		var keys []string
+		err := s.WalkWithOptions(WalkOptions{Sorted: true, OlderThan: time.Now().Add(-time.Hour)}, func(key string, size int64) error {
+			keys = append(keys, key)
+			return nil
+		})
+		assert.NoError(t, err)
+		return keys
+	}
+	assert.Len(t, stale(), 2)
+
+	_, err = s.PutString("loose data")
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Empty(t, stale())
+}
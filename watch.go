@@ -0,0 +1,250 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Event describes a change to the contents of a store, as reported by Watch.
+type Event struct {
+	// Op is either OpPut or OpDelete.
+	Op Op
+
+	// Key is the key that was added or removed.
+	Key string
+}
+
+// Watch will return a channel that receives an Event whenever an object is
+// added to or removed from the store, until the context is cancelled.  Since
+// changes are detected using filesystem notifications, this includes changes
+// made by other processes (or other CAStores) sharing the same BasePath.
+//
+// Events are delivered in the order they are detected, and the channel is
+// closed after the context is cancelled.  A receiver that does not keep up
+// may cause events to be dropped by the operating system; these are logged,
+// but not otherwise reported.  A Put of data that was already present may or
+// may not produce an event, and a newly-added object may occasionally be
+// reported more than once.
+func (s *CAStore) Watch(ctx context.Context) (<-chan Event, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event, 64)
+	ws := &watchState{s: s, w: w, ctx: ctx, ch: ch}
+	if err = ws.addTree(s.opts.BasePath, false); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	go ws.run()
+	return ch, nil
+}
+
+// watchState contains the state of a single call to Watch.
+type watchState struct {
+	s   *CAStore
+	w   *fsnotify.Watcher
+	ctx context.Context
+	ch  chan Event
+}
+
+// run processes filesystem notifications until the context is cancelled.
+func (ws *watchState) run() {
+	defer close(ws.ch)
+	defer ws.w.Close()
+
+	for {
+		select {
+		case ev := <-ws.w.Events:
+			if !ws.handle(ev) {
+				return
+			}
+
+		case err := <-ws.w.Errors:
+			ws.s.log.Warn("error watching store", "err", err)
+
+		case <-ws.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle processes a single notification.  It returns false if the context
+// was cancelled while sending events.
+func (ws *watchState) handle(ev fsnotify.Event) bool {
+	name := filepath.Base(ev.Name)
+
+	switch {
+	case ev.Has(fsnotify.Create):
+		info, err := os.Lstat(ev.Name)
+		if err != nil {
+			return true
+		}
+		if info.IsDir() {
+			// Objects may have been written into the new directory before we
+			// started watching it, so report everything that is already there.
+			if ev.Name == filepath.Join(ws.s.opts.BasePath, metaDir) {
+				return true
+			}
+			if err = ws.addTree(ev.Name, true); err != nil {
+				ws.s.log.Warn("error watching directory", "path", ev.Name, "err", err)
+			}
+			return ws.ctx.Err() == nil
+		}
+		if ws.s.validKey(name) {
+			return ws.send(Event{OpPut, name})
+		}
+
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		if ws.s.validKey(name) {
+			return ws.send(Event{OpDelete, name})
+		}
+	}
+	return true
+}
+
+// addTree adds watches for the given directory and all directories beneath
+// it, other than the store's metadata directory.  If report is set, an event is
+// sent for every object that is found.
+func (ws *watchState) addTree(dir string, report bool) error {
+	if err := ws.w.Add(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, ent := range entries {
+		p := filepath.Join(dir, ent.Name())
+		if ent.IsDir() {
+			if p == filepath.Join(ws.s.opts.BasePath, metaDir) {
+				continue
+			}
+			if err = ws.addTree(p, report); err != nil {
+				return err
+			}
+		} else if report && ws.s.validKey(ent.Name()) {
+			if !ws.send(Event{OpPut, ent.Name()}) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// send delivers an event, returning false if the context was cancelled first.
+func (ws *watchState) send(ev Event) bool {
+	select {
+	case ws.ch <- ev:
+		return true
+	case <-ws.ctx.Done():
+		return false
+	}
+}
+
+// startWatch starts watching the store for objects added or deleted by other
+// processes, for Options.WatchExternal.  The events must be passed to
+// watchLoop once the Bloom filter has been loaded.
+func (s *CAStore) startWatch() (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := s.Watch(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.watchCancel = cancel
+	return events, nil
+}
+
+// watchLoop applies every change seen by the watch to the Bloom filter, the
+// index and any caches wrapping the store, until the watch is stopped.  It
+// closes watchDone, which must be set before it is started.
+func (s *CAStore) watchLoop(events <-chan Event) {
+	defer close(s.watchDone)
+
+	for ev := range events {
+		switch ev.Op {
+		case OpPut:
+			// Most Puts were made through this store, and are already there.
+			if !s.mayExist(ev.Key) {
+				s.bloomAdd(ev.Key)
+			}
+		case OpDelete:
+			// Objects are also renamed when they're packed or snapshotted,
+			// so only drop ones that have really gone.
+			if _, _, err := s.locate(ev.Key); os.IsNotExist(err) {
+				s.indexRemove(ev.Key)
+			}
+		}
+		s.forgetCached(ev.Key)
+	}
+}
+
+// stopWatch stops watching for Options.WatchExternal, if the store is, and
+// waits for watchLoop to finish if it was started.
+func (s *CAStore) stopWatch() {
+	if s.watchCancel == nil {
+		return
+	}
+	s.watchCancel()
+	if s.watchDone != nil {
+		<-s.watchDone
+	}
+}
+
+// forgetOnChange registers a cache wrapping the store, which will be told to
+// forget every key that is added or deleted through the store or - if
+// Options.WatchExternal is set - by another process.
+// NewCache and NewExistsCache call it when given a CAStore; the cache stays
+// registered for as long as the store exists.
+func (s *CAStore) forgetOnChange(f Forgetter) {
+	s.forgetMu.Lock()
+	s.forgetters = append(s.forgetters, f)
+	s.forgetMu.Unlock()
+}
+
+// forgetCached tells every cache registered with forgetOnChange to forget the
+// given key.
+func (s *CAStore) forgetCached(key string) {
+	s.forgetMu.Lock()
+	forgetters := s.forgetters
+	s.forgetMu.Unlock()
+
+	for _, f := range forgetters {
+		f.Forget(key)
+	}
+}
+
+// Forgetter is implemented by caches that can be told to drop what they know
+// about a key, such as Cache and ExistsCache.
+type Forgetter interface {
+	Forget(key string)
+}
+
+// Invalidate calls Forget on each of the given caches for the key of every
+// event received, until the channel is closed.  Given the channel returned by
+// Watch on the store that the caches wrap, it stops them from serving objects
+// that other processes have deleted, or reporting as missing objects that
+// other processes have added.  It is typically run in its own goroutine.
+// Caches that directly wrap a CAStore opened with Options.WatchExternal don't
+// need it, since the store does this for them.
+func Invalidate(events <-chan Event, caches ...Forgetter) {
+	for ev := range events {
+		for _, c := range caches {
+			c.Forget(ev.Key)
+		}
+	}
+}
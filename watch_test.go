@@ -0,0 +1,160 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-watch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Watch(ctx)
+	assert.NoError(t, err)
+
+	next := func() Event {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return Event{}
+		}
+	}
+
+	// Changes made through another store are seen too.
+	other, err := New(Options{
+		BasePath:  tdir,
+		Transform: DepthTransformFunc(2),
+	})
+	assert.NoError(t, err)
+
+	_, err = other.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, Event{OpPut, TEST_KEY}, next())
+
+	assert.NoError(t, s.Delete(TEST_KEY))
+	assert.Equal(t, Event{OpDelete, TEST_KEY}, next())
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-watch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	cache := NewCache(s, CacheOptions{})
+	exists := NewExistsCache(s, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := s.Watch(ctx)
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		Invalidate(ch, cache, exists)
+		close(done)
+	}()
+
+	// Both caches remember that the object exists.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, cache, TEST_KEY))
+	found, err := exists.Exists(TEST_KEY, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	// Once another store deletes it, neither does.
+	other, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	assert.NoError(t, other.Delete(TEST_KEY))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		found, err = exists.Exists(TEST_KEY, time.Hour)
+		assert.NoError(t, err)
+		if !found {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, found)
+	found, err = cache.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	cancel()
+	<-done
+}
+
+func TestWatchExternal(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-watch"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, Index: true, WatchExternal: true})
+	assert.NoError(t, err)
+	defer s.Close()
+	cache := NewCache(s, CacheOptions{})
+	exists := NewExistsCache(s, 0)
+
+	// Deletes made through the store are seen by the caches straight away.
+	key, err := s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, cache, key))
+	found, err := exists.Exists(key, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.NoError(t, s.Delete(key))
+	found, err = exists.Exists(key, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	r, err := cache.Get(key)
+	assert.NoError(t, err)
+	assert.Nil(t, r)
+
+	// Those made by another store are seen once the watcher notices them,
+	// and are removed from the index too.
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	assert.Equal(t, TEST_VALUE, readKey(t, cache, key))
+	found, err = exists.Exists(key, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	other, err := New(Options{BasePath: tdir})
+	assert.NoError(t, err)
+	assert.NoError(t, other.Delete(key))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := s.indexLookup(key); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, ok := s.indexLookup(key)
+	assert.False(t, ok)
+	found, err = exists.Exists(key, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	found, err = cache.Exists(key)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,212 @@
+package castore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// uploadsDir is the name of the directory in the local store's internal
+	// state directory that holds the queue of a write-back Tiered store.
+	// Each pending upload is an empty file named after its key.
+	uploadsDir = "uploads"
+
+	// defaultRetryInterval is the default TieredOptions.RetryInterval.
+	defaultRetryInterval = 5 * time.Second
+)
+
+// startWriteBack loads the queue of pending uploads and starts uploading
+// them.
+func (t *Tiered) startWriteBack() error {
+	dir, err := t.local.metaPath(uploadsDir)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	t.queueDir = dir
+	t.queued = make(map[string]bool)
+	t.idle = make(chan struct{})
+	t.kick = make(chan struct{}, 1)
+	t.closing = make(chan struct{})
+	t.done = make(chan struct{})
+	for _, ent := range entries {
+		key := ent.Name()
+		if _, err := t.local.ParseKey(key); err != nil {
+			// Probably a leftover temporary file.
+			continue
+		}
+		t.queued[key] = true
+		t.local.pin(key)
+	}
+	if len(t.queued) == 0 {
+		close(t.idle)
+	} else {
+		t.local.log.Info("resuming uploads", "count", len(t.queued))
+	}
+
+	go t.uploader()
+	return nil
+}
+
+// enqueue records that the given key must be uploaded, and wakes the
+// uploader.  Once it returns, the upload will be retried even if the process
+// exits.
+func (t *Tiered) enqueue(key string) error {
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+
+	// Checked with queueMu held, so that nothing is queued once the
+	// uploader has seen that the queue is empty and exited.
+	if t.isClosing() {
+		return os.ErrClosed
+	}
+	if t.queued[key] {
+		return nil
+	}
+	if err := writeFileAtomic(filepath.Join(t.queueDir, key), nil); err != nil {
+		return err
+	}
+	if len(t.queued) == 0 {
+		t.idle = make(chan struct{})
+	}
+	t.queued[key] = true
+	t.local.pin(key)
+
+	select {
+	case t.kick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// dequeue removes the given key from the queue, whether or not it has been
+// uploaded.
+func (t *Tiered) dequeue(key string) error {
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+
+	if !t.queued[key] {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(t.queueDir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(t.queued, key)
+	t.local.unpin(key)
+	if len(t.queued) == 0 {
+		close(t.idle)
+	}
+	return nil
+}
+
+// pending returns the set of keys waiting to be uploaded.
+func (t *Tiered) pending() map[string]bool {
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+
+	keys := make(map[string]bool, len(t.queued))
+	for key := range t.queued {
+		keys[key] = true
+	}
+	return keys
+}
+
+// Pending returns the number of objects waiting to be uploaded to the remote
+// tier.  It is always zero unless WriteBack is set.
+func (t *Tiered) Pending() int {
+	if !t.opts.WriteBack {
+		return 0
+	}
+	t.queueMu.Lock()
+	defer t.queueMu.Unlock()
+	return len(t.queued)
+}
+
+// uploader runs in the background, uploading queued objects until the store
+// is closed and the queue is empty.
+func (t *Tiered) uploader() {
+	defer close(t.done)
+
+	for {
+		failed := false
+		for key := range t.pending() {
+			if _, err := copyOne(t.remote, t.local, key); err != nil && err != ErrNotFound {
+				t.local.log.Warn("could not upload to remote tier", "key", key, "err", err)
+				failed = true
+				continue
+			}
+
+			// An object that has since been deleted locally can't be
+			// uploaded, and no longer needs to be.
+			if err := t.dequeue(key); err != nil {
+				t.local.log.Warn("could not update upload queue", "key", key, "err", err)
+				failed = true
+			}
+		}
+
+		if failed {
+			time.Sleep(t.opts.RetryInterval)
+			continue
+		}
+		select {
+		case <-t.kick:
+		case <-t.closing:
+			if t.Pending() == 0 {
+				return
+			}
+		}
+	}
+}
+
+// Flush will wait until every pending upload has completed, or the context is
+// cancelled.
+func (t *Tiered) Flush(ctx context.Context) error {
+	if !t.opts.WriteBack {
+		return nil
+	}
+	t.queueMu.Lock()
+	idle := t.idle
+	t.queueMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close will stop the Tiered store accepting Puts, and wait until every
+// pending upload has completed - which, if the remote tier is unavailable,
+// may be indefinitely; call Flush with a deadline first to bound this.  It
+// does not close either tier.
+func (t *Tiered) Close() error {
+	if !t.opts.WriteBack {
+		return nil
+	}
+	t.closeOnce.Do(func() {
+		close(t.closing)
+	})
+	<-t.done
+	return nil
+}
+
+// isClosing returns whether Close has been called.
+func (t *Tiered) isClosing() bool {
+	select {
+	case <-t.closing:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,89 @@
+package castore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyStore is a Store whose Puts fail until it is repaired.
+type flakyStore struct {
+	Store
+
+	mu     sync.Mutex
+	broken bool
+}
+
+func (s *flakyStore) Put(r io.Reader) (string, error) {
+	s.mu.Lock()
+	broken := s.broken
+	s.mu.Unlock()
+	if broken {
+		return "", errors.New("network unreachable")
+	}
+	return s.Store.Put(r)
+}
+
+func (s *flakyStore) setBroken(broken bool) {
+	s.mu.Lock()
+	s.broken = broken
+	s.mu.Unlock()
+}
+
+func TestTieredWriteBack(t *testing.T) {
+	ldir := must_s(ioutil.TempDir("", "castore-test-writeback"))
+	defer os.RemoveAll(ldir)
+	rdir := must_s(ioutil.TempDir("", "castore-test-writeback"))
+	defer os.RemoveAll(rdir)
+
+	local, err := New(Options{BasePath: ldir})
+	assert.NoError(t, err)
+	rs, err := New(Options{BasePath: rdir})
+	assert.NoError(t, err)
+	remote := &flakyStore{Store: rs, broken: true}
+	opts := TieredOptions{WriteBack: true, RetryInterval: 10 * time.Millisecond}
+	ts, err := NewTiered(local, remote, opts)
+	assert.NoError(t, err)
+
+	// Puts succeed while the remote tier is down, and are visible.
+	key, err := ts.Put(strings.NewReader(TEST_VALUE))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ts.Pending())
+	var walked []string
+	assert.NoError(t, ts.Walk(func(key string, size int64) error {
+		walked = append(walked, key)
+		return nil
+	}))
+	assert.Equal(t, []string{key}, walked)
+
+	// Pending uploads aren't evicted.
+	u, err := local.Evict(context.Background(), 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), u.Objects)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, ts.Flush(ctx))
+	cancel()
+
+	// The queue survives a restart, and drains once the remote tier is back.
+	ts, err = NewTiered(local, remote, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ts.Pending())
+	remote.setBroken(false)
+	assert.NoError(t, ts.Close())
+	assert.Equal(t, 0, ts.Pending())
+	exists, err := rs.Exists(key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = ts.Put(strings.NewReader("other"))
+	assert.Equal(t, os.ErrClosed, err)
+}
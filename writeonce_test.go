@@ -0,0 +1,59 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteOnce(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-writeonce"))
+	defer os.RemoveAll(tdir)
+
+	var ops []Operation
+	s, err := New(Options{
+		BasePath:  tdir,
+		WriteOnce: true,
+		Observer: func(op Operation) {
+			op.Duration = 0
+			ops = append(ops, op)
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+
+	// Re-inserting the data doesn't replace the existing file, but does
+	// record that it was written again.
+	p := s.blobPath(TEST_KEY)
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(p, old, old))
+	before, err := os.Stat(p)
+	assert.NoError(t, err)
+	_, err = s.PutString(TEST_VALUE)
+	assert.NoError(t, err)
+	info, err := os.Stat(p)
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(before, info))
+	assert.True(t, info.ModTime().After(old))
+
+	// Deletes are refused, and reported.
+	assert.Equal(t, ErrWriteOnce, s.Delete(TEST_KEY))
+	exists, err := s.Exists(TEST_KEY)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Nonexistent keys are still a no-op.
+	assert.NoError(t, s.Delete("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+
+	size := int64(len(TEST_VALUE))
+	assert.Equal(t, []Operation{
+		{Op: OpPut, Key: TEST_KEY, Size: size},
+		{Op: OpPut, Key: TEST_KEY, Size: size, Dedup: true},
+		{Op: OpDelete, Key: TEST_KEY, Size: -1, Err: ErrWriteOnce},
+	}, ops)
+}
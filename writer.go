@@ -0,0 +1,98 @@
+package castore
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrWriterAborted is returned by a Writer that has been aborted with Abort.
+var ErrWriterAborted = errors.New("castore: writer was aborted")
+
+// Writer is an io.WriteCloser that stores the data written to it as a single
+// object, for producers that generate their output incrementally rather than
+// being able to provide an io.Reader.  It is created by NewWriter.  The data
+// is hashed and staged as it is written, exactly as by Put, and the object is
+// stored when the Writer is committed.  A Writer is not safe for concurrent
+// use.
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	// Set by the goroutine that stores the data before done is closed
+	key string
+	err error
+
+	finished bool
+}
+
+var _ io.WriteCloser = (*Writer)(nil)
+
+// NewWriter returns a Writer that stores the data written to it in the store.
+// The same checks are made as by Put, so for example a Write that takes the
+// data beyond the MaxSize fails with ErrSizeExceeded.  Every Writer must be
+// finished with Commit, Close or Abort; until then, it holds a slot for a
+// concurrent Put.
+func (s *CAStore) NewWriter() (*Writer, error) {
+	pr, pw := io.Pipe()
+	w := &Writer{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		w.key, w.err = s.Put(pr)
+
+		// Any further writes fail with the same error.
+		if w.err != nil {
+			pr.CloseWithError(w.err)
+		} else {
+			pr.Close()
+		}
+	}()
+	return w, nil
+}
+
+// Write adds the data in p to the object.  If storing the data has already
+// failed, that error is returned.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.finished {
+		return 0, io.ErrClosedPipe
+	}
+	return w.pw.Write(p)
+}
+
+// Commit finishes writing, stores the object, and returns its key.  Calling it
+// again returns the same result.
+func (w *Writer) Commit() (string, error) {
+	if !w.finished {
+		w.finished = true
+		w.pw.Close()
+	}
+	<-w.done
+	return w.key, w.err
+}
+
+// Close is like Commit, and is provided for use as an io.Closer.  The key of
+// the stored object can then be retrieved with Key.
+func (w *Writer) Close() error {
+	_, err := w.Commit()
+	return err
+}
+
+// Key returns the key of the stored object, or an empty string if the Writer
+// hasn't been committed, or storing the object failed.
+func (w *Writer) Key() string {
+	if !w.finished {
+		return ""
+	}
+	<-w.done
+	return w.key
+}
+
+// Abort discards the data written so far without storing it.  It has no
+// effect once the Writer has been committed.
+func (w *Writer) Abort() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	w.pw.CloseWithError(ErrWriterAborted)
+	<-w.done
+}
@@ -0,0 +1,52 @@
+package castore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	tdir := must_s(ioutil.TempDir("", "castore-test-writer"))
+	defer os.RemoveAll(tdir)
+
+	s, err := New(Options{BasePath: tdir, MaxSize: 64})
+	assert.NoError(t, err)
+
+	w, err := s.NewWriter()
+	assert.NoError(t, err)
+	for _, chunk := range []string{"hello", ", ", "world"} {
+		n, err := w.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "", w.Key())
+	key, err := w.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello, world", readKey(t, s, key))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, key, w.Key())
+	_, err = w.Write([]byte("more"))
+	assert.Error(t, err)
+
+	// The same checks are made as by Put.
+	w, err = s.NewWriter()
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(strings.Repeat("A", 100)))
+	assert.Equal(t, ErrSizeExceeded, err)
+	assert.Equal(t, ErrSizeExceeded, w.Close())
+	assert.Equal(t, "", w.Key())
+
+	// Aborted data is not stored.
+	w, err = s.NewWriter()
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(TEST_VALUE))
+	assert.NoError(t, err)
+	w.Abort()
+	_, err = w.Commit()
+	assert.Equal(t, ErrWriterAborted, err)
+	assert.Equal(t, []string{key}, keysOf(t, s))
+}